@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/batch"
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(fs, &cfg)
+	commitsFile := fs.String("commits-file", "", "File listing targets, one \"repo commit\" per line (or \"commit\" with --repo), or a JSON array of {repo, commit}")
+	maxRetries := fs.Int("max-retries", 1, "Retries for failed targets when the batch is resumed")
+	dedupe := fs.Bool("dedupe", true, "Skip empty diffs, cherry-picks, and reverts of earlier targets")
+	adaptive := fs.Bool("adaptive-budget", true, "Scale iterations and coder runs per target by estimated difficulty")
+	totalCoderRuns := fs.Int("total-coder-runs", 0, "Global coder-run budget across the batch (0 = unlimited)")
+	maxCoderRunsPerIter := fs.Int("max-coder-runs-per-iter", 0, "Upper bound for adaptive coder runs per iteration (0 = candidates-per-iter)")
+	shareStyles := fs.Bool("share-styles", true, "Pass how each SpecWriter style did on finished targets on to later targets of the same repository")
+	metricsAddr := fs.String("metrics-addr", "", "Serve Prometheus metrics on this address under /metrics, e.g. :9090 (empty disables)")
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+	if *commitsFile == "" {
+		fmt.Fprintln(os.Stderr, "error: --commits-file is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if cfg.CommitRange != "" {
+		log.Printf("invalid flags: --commit-range is not supported in batch mode")
+		os.Exit(2)
+	}
+	if cfg.Step {
+		log.Printf("invalid flags: --step is not supported in batch mode")
+		os.Exit(2)
+	}
+	if *totalCoderRuns < 0 || *maxCoderRunsPerIter < 0 || *maxRetries < 0 {
+		log.Printf("invalid flags: max-retries, total-coder-runs, and max-coder-runs-per-iter must be >= 0")
+		os.Exit(2)
+	}
+
+	targets, err := readTargets(*commitsFile, cfg.Repo)
+	if err != nil {
+		log.Printf("invalid commits file: %v", err)
+		os.Exit(2)
+	}
+
+	absWorkdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatalf("resolve workdir: %v", err)
+	}
+	cfg.Workdir = absWorkdir
+	cfg.Settings = effectiveSettings(fs)
+	if err := cfg.Validate(); err != nil {
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	startMetrics(*metricsAddr, &cfg)
+
+	ctx, stop := signalContext()
+	defer stop()
+	summary, err := run.ExecuteBatch(ctx, run.BatchOptions{
+		Targets:             targets,
+		Base:                cfg,
+		MaxRetries:          *maxRetries,
+		Dedupe:              *dedupe,
+		AdaptiveBudget:      *adaptive,
+		TotalCoderRuns:      *totalCoderRuns,
+		MaxCoderRunsPerIter: *maxCoderRunsPerIter,
+		ShareStyles:         *shareStyles,
+	})
+	if err != nil {
+		log.Printf("batch failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+
+	fmt.Printf("targets: %d (done %d, failed %d, skipped %d)\n", summary.Targets, summary.Done, summary.Failed, summary.Skipped)
+	fmt.Printf("mean final score: %.4f\n", summary.MeanFinalScore)
+	fmt.Printf("summary: %s\n", filepath.Join(cfg.Workdir, "batch_summary.json"))
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readTargets(path, defaultRepo string) ([]batch.Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []batch.Target
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		for i := range targets {
+			if targets[i].Repo == "" {
+				targets[i].Repo = defaultRepo
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			switch len(fields) {
+			case 1:
+				targets = append(targets, batch.Target{Repo: defaultRepo, Commit: fields[0]})
+			case 2:
+				targets = append(targets, batch.Target{Repo: fields[0], Commit: fields[1]})
+			default:
+				return nil, fmt.Errorf("%s:%d: expected \"repo commit\" or \"commit\"", path, n)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for i, t := range targets {
+		if t.Repo == "" || t.Commit == "" {
+			return nil, fmt.Errorf("target %d is missing a repo or commit (set --repo for commit-only lines)", i+1)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s lists no targets", path)
+	}
+	return targets, nil
+}
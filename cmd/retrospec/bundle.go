@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runBundle(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	workdir := fs.String("workdir", "./work", "Working directory of the run to package")
+	out := fs.String("out", "", "Archive to write (default <workdir>/bundle.tar.gz)")
+	_ = fs.Parse(args)
+
+	path := *out
+	if path == "" {
+		path = filepath.Join(*workdir, "bundle.tar.gz")
+	}
+	manifest, err := run.Bundle(*workdir, path)
+	if err != nil {
+		log.Printf("bundle failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+	for _, w := range manifest.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	fmt.Printf("files: %d\n", len(manifest.Files))
+	fmt.Printf("bundle: %s\n", path)
+}
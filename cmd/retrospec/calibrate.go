@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/run"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+func runCalibrate(args []string) {
+	fset := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	corpus := fset.String("corpus", "", "Corpus of real requests: a directory of .md/.txt files, or a .jsonl or .json file of objects with title and body (or text)")
+	out := fset.String("out", "realism_profile.json", "Where to write the calibration profile")
+	language := fset.String("language", scoring.DefaultLanguage, "Language of the corpus and of the realism keywords")
+	maxLength := fset.Int("max-length", 0, "The --max-length runs will use (0 = unlimited)")
+	target := fset.Float64("target", 1, "Mean heuristic realism the corpus should score")
+	_ = fset.Parse(args)
+
+	if *corpus == "" {
+		fmt.Fprintln(os.Stderr, "error: --corpus is required")
+		fset.Usage()
+		os.Exit(2)
+	}
+	if !scoring.IsSupportedLanguage(*language) {
+		log.Printf("invalid flags: language must be one of %s", strings.Join(scoring.SupportedLanguages(), ", "))
+		os.Exit(2)
+	}
+	if *target <= 0 || *target > 1 {
+		log.Printf("invalid flags: target must be in (0,1]")
+		os.Exit(2)
+	}
+
+	texts, err := readCorpus(*corpus)
+	if err != nil {
+		log.Printf("read corpus: %v", err)
+		os.Exit(2)
+	}
+	// The built-in limits of a run are only used to report the score
+	// before calibration.
+	defaults := run.DefaultConfig()
+	cfg := scoring.RealismConfig{Language: *language, MaxLength: *maxLength, MaxPathRefs: defaults.MaxPathRefs, MaxIdentifiers: defaults.MaxIdentifiers}
+	profile, err := scoring.CalibrateRealism(texts, cfg, *target)
+	if err != nil {
+		log.Printf("calibrate: %v", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		log.Fatalf("encode profile: %v", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		log.Fatalf("write profile: %v", err)
+	}
+	fmt.Printf("calibrated on %d texts: mean realism %.3f -> %.3f\n", profile.Texts, profile.MeanBefore, profile.MeanAfter)
+	if profile.MeanAfter < *target-0.01 {
+		fmt.Printf("target %.2f not reached: keyword weights are at their cap, and texts meeting no keyword rule or over a limit stay lower\n", *target)
+	}
+	fmt.Printf("profile: %s (use with --realism-profile)\n", *out)
+}
+
+// corpusEntry is a request in a JSON corpus, like a GitHub issue export.
+type corpusEntry struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Text  string `json:"text"`
+}
+
+func (e corpusEntry) text() string {
+	body := e.Body
+	if body == "" {
+		body = e.Text
+	}
+	if e.Title == "" {
+		return body
+	}
+	return e.Title + "\n\n" + body
+}
+
+// readCorpus returns the texts of a corpus directory or file.
+func readCorpus(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		var files []string
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := strings.ToLower(filepath.Ext(p)); !d.IsDir() && (ext == ".md" || ext == ".txt") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		var texts []string
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			texts = append(texts, string(data))
+		}
+		return texts, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		var texts []string
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			var e corpusEntry
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			texts = append(texts, e.text())
+		}
+		return texts, sc.Err()
+	case ".json":
+		var entries []corpusEntry
+		if err := json.NewDecoder(f).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		texts := make([]string, 0, len(entries))
+		for _, e := range entries {
+			texts = append(texts, e.text())
+		}
+		return texts, nil
+	default:
+		return nil, fmt.Errorf("%s: corpus file must be .jsonl or .json", path)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+// applyConfigFile sets every flag named in the config file that was not given
+// explicitly on the command line, so flags always override the file.
+//
+// The file is a flat list of settings keyed by flag name, written either as
+// YAML ("key: value") or TOML ("key = value"). Comments, quoted strings, and
+// inline lists (joined with commas) are accepted; nested structures, TOML
+// tables included, are not.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	values, err := parseConfigFile(data, strings.ToLower(filepath.Ext(path)) == ".toml")
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for _, kv := range values {
+		if kv.key == "config" {
+			return fmt.Errorf("config %s: line %d: config files cannot include other config files", path, kv.line)
+		}
+		if fs.Lookup(kv.key) == nil {
+			return fmt.Errorf("config %s: line %d: unknown setting %q", path, kv.line, kv.key)
+		}
+		if explicit[kv.key] {
+			continue
+		}
+		if err := fs.Set(kv.key, kv.value); err != nil {
+			return fmt.Errorf("config %s: line %d: %s: %w", path, kv.line, kv.key, err)
+		}
+	}
+	return nil
+}
+
+// secretFlags are left out of the recorded settings.
+var secretFlags = map[string]bool{
+	"config":            true,
+	"openai-api-key":    true,
+	"anthropic-api-key": true,
+	"github-token":      true,
+}
+
+// effectiveSettings returns the value of every run flag after the config
+// file was applied, so a run can be repeated from its recorded settings.
+// Flags of subcommands other than the run flags are skipped.
+func effectiveSettings(fs *flag.FlagSet) map[string]string {
+	known := flag.NewFlagSet("", flag.ContinueOnError)
+	registerRunFlags(known, &run.Config{})
+	settings := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if known.Lookup(f.Name) != nil && !secretFlags[f.Name] {
+			settings[f.Name] = f.Value.String()
+		}
+	})
+	return settings
+}
+
+type configValue struct {
+	key   string
+	value string
+	line  int
+}
+
+func parseConfigFile(data []byte, toml bool) ([]configValue, error) {
+	sep := ":"
+	if toml {
+		sep = "="
+	}
+	var out []configValue
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		if toml && strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: table %s is not supported, settings must be top-level flag names", n, line)
+		}
+		key, value, ok := strings.Cut(line, sep)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key%svalue", n, sep)
+		}
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", n)
+		}
+		if prev, ok := seen[key]; ok {
+			return nil, fmt.Errorf("line %d: %q already set on line %d", n, key, prev)
+		}
+		seen[key] = n
+		v, err := parseConfigScalar(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		out = append(out, configValue{key: key, value: v, line: n})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func parseConfigScalar(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("missing value (nested settings are not supported)")
+	}
+	if strings.HasPrefix(v, "[") {
+		if !strings.HasSuffix(v, "]") {
+			return "", fmt.Errorf("unterminated list")
+		}
+		var items []string
+		for _, item := range strings.Split(v[1:len(v)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			s, err := parseConfigScalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	if q := v[0]; q == '"' || q == '\'' {
+		if len(v) < 2 || v[len(v)-1] != q {
+			return "", fmt.Errorf("unterminated string")
+		}
+		s := v[1 : len(v)-1]
+		if q == '"' {
+			s = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+		}
+		return s, nil
+	}
+	return v, nil
+}
+
+// stripComment removes a trailing # comment that is not inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runGolden(args []string) {
+	fs := flag.NewFlagSet("golden", flag.ExitOnError)
+	golden := fs.String("golden", "", "Working directory of the reference run")
+	workdir := fs.String("workdir", "./work", "Working directory of the run to check")
+	scoreTolerance := fs.Float64("score-tolerance", 0.05, "Largest absolute difference allowed in each best score")
+	iterTolerance := fs.Int("iteration-tolerance", 0, "Largest difference allowed in the number of iterations")
+	out := fs.String("out", "", "Also write the report as JSON to this file")
+	_ = fs.Parse(args)
+
+	if *golden == "" {
+		fmt.Fprintln(os.Stderr, "usage: retrospec golden --golden DIR [--workdir DIR] [--score-tolerance X] [--iteration-tolerance N]")
+		os.Exit(2)
+	}
+	if *scoreTolerance < 0 || *iterTolerance < 0 {
+		log.Printf("invalid flags: tolerances must not be negative")
+		os.Exit(2)
+	}
+
+	report, err := run.CompareGolden(*golden, *workdir, run.GoldenOptions{ScoreTolerance: *scoreTolerance, IterationTolerance: *iterTolerance})
+	if err != nil {
+		log.Printf("golden check failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+	for _, w := range report.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	for _, c := range report.Checks {
+		status := "ok"
+		if !c.OK {
+			status = "DEVIATION"
+		}
+		fmt.Printf("%-9s %s: golden %q, run %q\n", status, c.Name, c.Golden, c.Actual)
+	}
+	if *out != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("encode report: %v", err)
+		}
+		if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+			log.Fatalf("write report: %v", err)
+		}
+	}
+	fmt.Printf("deviations: %d\n", report.Deviations)
+	if report.Deviations > 0 {
+		os.Exit(1)
+	}
+}
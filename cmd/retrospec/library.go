@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/library"
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends v, split at commas as config files join lists.
+func (s *stringsFlag) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
+
+func runLibrary(args []string) {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprintln(os.Stderr, "usage: retrospec library export --workdir DIR [--workdir DIR...] --out FILE")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("library export", flag.ExitOnError)
+	var workdirs stringsFlag
+	fs.Var(&workdirs, "workdir", "Run workdir to export prompts from (repeatable)")
+	out := fs.String("out", "prompt_library.json", "Library file to write; existing entries are kept and merged")
+	_ = fs.Parse(args[1:])
+
+	if len(workdirs) == 0 {
+		fmt.Fprintln(os.Stderr, "error: at least one --workdir is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	exported, err := run.ExportLibrary(workdirs)
+	if err != nil {
+		log.Fatalf("export library: %v", err)
+	}
+
+	lib := library.Library{Version: library.Version}
+	if _, err := os.Stat(*out); err == nil {
+		lib, err = library.Load(*out)
+		if err != nil {
+			log.Fatalf("load existing library: %v", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("stat library: %v", err)
+	}
+	lib.Add(exported.Entries...)
+	if versions := lib.ScoringVersions(); len(versions) > 1 {
+		fmt.Printf("warning: library mixes scores from scoring versions %v; rescore older runs with retrospec rescore before comparing them\n", versions)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*out), 0o755); err != nil {
+		log.Fatalf("create library dir: %v", err)
+	}
+	if err := lib.Save(*out); err != nil {
+		log.Fatalf("write library: %v", err)
+	}
+	fmt.Printf("exported %d prompts, library now has %d entries: %s\n", len(exported.Entries), len(lib.Entries), *out)
+}
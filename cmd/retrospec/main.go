@@ -1,7 +1,7 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -13,27 +13,61 @@ import (
 )
 
 func main() {
-	var cfg run.Config
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "library":
+			runLibrary(os.Args[2:])
+			return
+		case "batch":
+			runBatch(os.Args[2:])
+			return
+		case "tail":
+			runTail(os.Args[2:])
+			return
+		case "rerun-attempt":
+			runRerunAttempt(os.Args[2:])
+			return
+		case "score":
+			runScore(os.Args[2:])
+			return
+		case "scored":
+			runScored(os.Args[2:])
+			return
+		case "rescore":
+			runRescore(os.Args[2:])
+			return
+		case "bundle":
+			runBundle(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "calibrate":
+			runCalibrate(os.Args[2:])
+			return
+		case "golden":
+			runGolden(os.Args[2:])
+			return
+		}
+	}
 
-	flag.StringVar(&cfg.Repo, "repo", "", "Git repository URL or local path")
-	flag.StringVar(&cfg.Commit, "commit", "", "Target commit SHA")
-	flag.StringVar(&cfg.Workdir, "workdir", "./work", "Working directory for clones, runs, and artifacts")
-	flag.IntVar(&cfg.MaxIters, "max-iters", 8, "Maximum optimization iterations")
-	flag.Float64Var(&cfg.Threshold, "threshold", 0.9, "Stop when final score reaches this threshold")
-	flag.IntVar(&cfg.TimeoutSeconds, "timeout-seconds", 600, "Per-iteration timeout for Copilot coder run")
-	flag.BoolVar(&cfg.KeepRuns, "keep-runs", false, "Keep per-iteration worktrees")
-	flag.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose logs")
-	flag.Float64Var(&cfg.Alpha, "alpha", 0.75, "Weight on technical similarity vs realism")
-	flag.IntVar(&cfg.MaxPathRefs, "max-path-refs", 3, "Max path references encouraged in spec prompt")
-	flag.IntVar(&cfg.MaxIdentifiers, "max-identifiers", 25, "Heuristic threshold for identifier density in candidate prompt")
-	flag.IntVar(&cfg.MaxLength, "max-length", 0, "Maximum candidate prompt length (0 = unlimited)")
-	flag.IntVar(&cfg.CandidatesPerIter, "candidates-per-iter", 3, "How many spec candidates to generate each iteration")
-	flag.IntVar(&cfg.CoderRunsPerIter, "coder-runs-per-iter", 2, "How many top candidates to execute with coder each iteration")
-	flag.StringVar(&cfg.Model, "model", "", "Optional Copilot model override for all sessions (otherwise COPILOT_MODEL/env default)")
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(flag.CommandLine, &cfg)
+	progress := flag.Bool("progress", true, "Print live progress events to stderr")
+	output := flag.String("output", "text", "Output on stdout: text (the final scores) or jsonl (every event as it happens, then a result line)")
+	tuiMode := flag.Bool("tui", false, "Full-screen terminal view of the run with keys to abort an attempt or stop the run keeping the best")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address under /metrics, e.g. :9090 (empty disables)")
 	flag.Parse()
 
-	if cfg.Repo == "" || cfg.Commit == "" {
-		fmt.Fprintln(os.Stderr, "error: --repo and --commit are required")
+	if *configPath != "" {
+		if err := applyConfigFile(flag.CommandLine, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	if cfg.Repo == "" || (cfg.Commit == "" && cfg.CommitRange == "") {
+		fmt.Fprintln(os.Stderr, "error: --repo and --commit (or --commit-range) are required")
 		flag.Usage()
 		os.Exit(2)
 	}
@@ -43,22 +77,234 @@ func main() {
 		log.Fatalf("resolve workdir: %v", err)
 	}
 	cfg.Workdir = absWorkdir
+	cfg.Settings = effectiveSettings(flag.CommandLine)
 
 	if err := cfg.Validate(); err != nil {
-		log.Fatalf("invalid flags: %v", err)
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	if *output != "text" && *output != "jsonl" {
+		log.Printf("invalid flags: output must be text or jsonl")
+		os.Exit(2)
+	}
+	if *tuiMode && *output != "text" {
+		log.Printf("invalid flags: tui writes to stdout and needs output text")
+		os.Exit(2)
+	}
+	if *tuiMode && cfg.Review == run.ReviewTerminal {
+		log.Printf("invalid flags: review terminal needs the terminal the tui takes; use review file")
+		os.Exit(2)
+	}
+	jsonl := json.NewEncoder(os.Stdout)
+	if *output == "jsonl" {
+		cfg.EventSinks = append(cfg.EventSinks, run.EventFunc(func(e run.Event) {
+			_ = jsonl.Encode(e)
+		}))
 	}
+	// Everything that can exit on a bad flag comes before the tui takes the
+	// terminal, which only ui.close gives back.
+	startMetrics(*metricsAddr, &cfg)
 
-	ctx := context.Background()
+	var ui *tui
+	if *tuiMode {
+		if ui, err = newTUI(cfg.MaxIters); err != nil {
+			log.Printf("invalid flags: %v", err)
+			os.Exit(2)
+		}
+		cfg.EventSinks = append(cfg.EventSinks, ui)
+		cfg.Logger = cfg.LoggerTo(ui)
+	} else if *progress {
+		cfg.EventSinks = append(cfg.EventSinks, run.EventFunc(func(e run.Event) {
+			// Tool activity is too chatty for progress lines; tail and
+			// the TUI show it.
+			if e.Type != run.EventCoderTool {
+				fmt.Fprintln(os.Stderr, formatEvent(e))
+			}
+		}))
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
 	runner := run.NewRunner(cfg)
+	if ui != nil {
+		ui.start(runner, stop)
+	}
 	result, err := runner.Execute(ctx)
+	if ui != nil {
+		ui.close()
+	}
+	if *output == "jsonl" {
+		out := resultLine{
+			Time:          time.Now(),
+			Type:          "result",
+			BestTitle:     result.BestTitle,
+			BestIteration: result.BestIteration,
+			Tech:          result.BestTechSimilarity,
+			Realism:       result.BestRealism,
+			Final:         result.BestFinalScore,
+			Artifacts:     filepath.Join(cfg.Workdir, "artifacts"),
+			Paused:        result.Paused,
+		}
+		if err != nil {
+			out.Type, out.FailureCategory, out.Error = "error", run.ErrorCategoryOf(err), err.Error()
+		}
+		_ = jsonl.Encode(out)
+	}
 	if err != nil {
-		log.Fatalf("run failed: %v", err)
+		log.Printf("run failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+	if *output == "jsonl" {
+		return
 	}
 
+	if result.BestTitle != "" {
+		fmt.Printf("best title: %s\n", result.BestTitle)
+	}
 	fmt.Printf("best iteration: %d\n", result.BestIteration)
 	fmt.Printf("tech similarity: %.4f\n", result.BestTechSimilarity)
 	fmt.Printf("realism score: %.4f\n", result.BestRealism)
 	fmt.Printf("final score: %.4f\n", result.BestFinalScore)
 	fmt.Printf("artifacts: %s\n", filepath.Join(cfg.Workdir, "artifacts"))
+	if result.Paused {
+		fmt.Println("step done: run again with the same flags to continue")
+	}
 	fmt.Printf("completed at: %s\n", time.Now().Format(time.RFC3339))
 }
+
+// resultLine is the last line of --output jsonl: the run's result, or its
+// failure.
+type resultLine struct {
+	Time            time.Time         `json:"time"`
+	Type            string            `json:"type"`
+	BestTitle       string            `json:"bestTitle,omitempty"`
+	BestIteration   int               `json:"bestIteration"`
+	Tech            float64           `json:"tech"`
+	Realism         float64           `json:"realism"`
+	Final           float64           `json:"final"`
+	Artifacts       string            `json:"artifacts"`
+	FailureCategory run.ErrorCategory `json:"failureCategory,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	// Paused is set by --step when the run continues at the next invocation.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// registerRunFlags binds the run settings shared by the single-commit and
+// batch commands. It returns the path of the optional config file.
+func registerRunFlags(fs *flag.FlagSet, cfg *run.Config) *string {
+	var configPath string
+	fs.StringVar(&configPath, "config", "", "Optional YAML or TOML file with flag values; command-line flags take precedence")
+	fs.StringVar(&cfg.Repo, "repo", cfg.Repo, "Git repository URL or local path")
+	fs.StringVar(&cfg.Commit, "commit", cfg.Commit, "Target commit SHA (merge commits are diffed against their first parent, or --parent)")
+	fs.StringVar(&cfg.CommitRange, "commit-range", cfg.CommitRange, "Target commit range base..head, e.g. a whole pull request (instead of --commit)")
+	fs.IntVar(&cfg.Parent, "parent", cfg.Parent, "Parent number (1-based) a merge --commit is diffed against, like git's -m (0 = first parent)")
+	fs.StringVar(&cfg.Workdir, "workdir", cfg.Workdir, "Working directory for clones, runs, and artifacts")
+	fs.IntVar(&cfg.CloneDepth, "clone-depth", cfg.CloneDepth, "Clone only this many commits of history, deepened as the target needs (0 = full clone)")
+	fs.StringVar(&cfg.CloneFilter, "clone-filter", cfg.CloneFilter, "Partial clone object filter, e.g. blob:none, whose objects are fetched when needed (empty = all objects)")
+	fs.IntVar(&cfg.MaxIters, "max-iters", cfg.MaxIters, "Maximum optimization iterations")
+	fs.Float64Var(&cfg.Threshold, "threshold", cfg.Threshold, "Stop when final score reaches this threshold")
+	fs.IntVar(&cfg.Patience, "patience", cfg.Patience, "Stop after this many iterations without improvement (0 = never)")
+	fs.Float64Var(&cfg.MinDelta, "min-delta", cfg.MinDelta, "Smallest rise of the best final score that counts as an improvement for --patience")
+	fs.DurationVar(&cfg.MaxDuration, "max-duration", cfg.MaxDuration, "Stop after the iteration that takes the run past this wall-clock time, e.g. 30m (0 = no limit)")
+	fs.IntVar(&cfg.MaxTokens, "max-tokens", cfg.MaxTokens, "Stop after the iteration that takes the run past this many approximate model tokens (0 = no limit)")
+	fs.StringVar(&cfg.Smoothing, "smoothing", cfg.Smoothing, "Smooth iteration scores before the improvement check used by patience: off, ema, or window")
+	fs.Float64Var(&cfg.SmoothingFactor, "smoothing-factor", cfg.SmoothingFactor, "Weight of the latest iteration for --smoothing ema")
+	fs.IntVar(&cfg.SmoothingWindow, "smoothing-window", cfg.SmoothingWindow, "Iterations averaged for --smoothing window")
+	fs.BoolVar(&cfg.Step, "step", cfg.Step, "Run one iteration per invocation, continuing from the state saved in the workdir, and exit")
+	fs.StringVar(&cfg.StopFile, "stop-file", cfg.StopFile, "Stop after the current iteration when this file exists (relative to the workdir; empty disables)")
+	fs.IntVar(&cfg.TimeoutSeconds, "timeout-seconds", cfg.TimeoutSeconds, "Per-iteration timeout for Copilot coder run")
+	fs.StringVar(&cfg.TestCommand, "test-command", cfg.TestCommand, "Shell command that tests an attempt, run at the repository root (default: detect each project's test runner)")
+	fs.StringVar(&cfg.TestScope, "test-scope", cfg.TestScope, "Go tests run per attempt: changed (packages the patch touches), dependents (and their importers), or all")
+	fs.BoolVar(&cfg.KeepRuns, "keep-runs", cfg.KeepRuns, "Keep per-iteration worktrees")
+	fs.StringVar(&cfg.Workspace, "workspace", cfg.Workspace, "How each coder run gets its checkout: worktree, shared (a clone sharing the sealed repository's objects), or copy (a copy-on-write copy of one checkout)")
+	fs.BoolVar(&cfg.Submodules, "submodules", cfg.Submodules, "Check submodules out in every coder checkout (false leaves their directories empty)")
+	fs.BoolVar(&cfg.LFS, "lfs", cfg.LFS, "Fetch git-lfs files for coder checkouts (false leaves their pointer files)")
+	fs.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose, "Enable verbose logs (same as --log-level debug)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level: debug, info, warn, or error (default warn, or debug with --verbose)")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format on stderr: text or json")
+	fs.Float64Var(&cfg.Alpha, "alpha", cfg.Alpha, "Weight on technical similarity vs realism")
+	fs.StringVar(&cfg.AlphaSchedule, "alpha-schedule", cfg.AlphaSchedule, "How alpha moves over the run: static, anneal (linearly from --alpha-start to --alpha), or plateau (from --alpha-start towards --alpha by --alpha-step after each iteration without improvement)")
+	fs.Float64Var(&cfg.AlphaStart, "alpha-start", cfg.AlphaStart, "Alpha of the first iteration with an alpha schedule")
+	fs.Float64Var(&cfg.AlphaStep, "alpha-step", cfg.AlphaStep, "How far the plateau alpha schedule moves alpha after an iteration without improvement")
+	fs.IntVar(&cfg.MaxPathRefs, "max-path-refs", cfg.MaxPathRefs, "Max path references encouraged in spec prompt")
+	fs.IntVar(&cfg.MaxIdentifiers, "max-identifiers", cfg.MaxIdentifiers, "Heuristic threshold for identifier density in candidate prompt")
+	fs.IntVar(&cfg.MaxLength, "max-length", cfg.MaxLength, "Maximum candidate prompt length (0 = unlimited)")
+	fs.BoolVar(&cfg.ShortenOverlength, "shorten-overlength", cfg.ShortenOverlength, "Condense over-length candidates section by section instead of rejecting them")
+	fs.IntVar(&cfg.CandidatesPerIter, "candidates-per-iter", cfg.CandidatesPerIter, "How many spec candidates to generate each iteration")
+	fs.IntVar(&cfg.CoderRunsPerIter, "coder-runs-per-iter", cfg.CoderRunsPerIter, "How many top candidates to execute with coder each iteration")
+	fs.StringVar(&cfg.Novelty, "novelty", cfg.Novelty, "Novelty metric against earlier prompts: jaccard, ngram, or embedding")
+	fs.Float64Var(&cfg.NoveltyWeight, "novelty-weight", cfg.NoveltyWeight, "Weight of novelty against heuristic realism in the candidate pre-score")
+	fs.StringVar(&cfg.EmbeddingModel, "embedding-model", cfg.EmbeddingModel, "Embeddings model for --novelty embedding (default text-embedding-3-small on openai, nomic-embed-text on ollama)")
+	fs.Float64Var(&cfg.DedupeSimilarity, "dedupe-similarity", cfg.DedupeSimilarity, "Share one coder run between prompts at least this similar by word-pair Jaccard (0 = identical prompts only)")
+	fs.BoolVar(&cfg.ReuseAttempts, "reuse-attempts", cfg.ReuseAttempts, "Reuse the coder result and scores of a prompt already run in an earlier iteration instead of running it again")
+	fs.IntVar(&cfg.ClusterMinFiles, "cluster-min-files", cfg.ClusterMinFiles, "Split targets changing at least this many files into sub-changes by directory and symbol affinity, with one extra candidate per cluster each iteration (0 = disabled)")
+	fs.IntVar(&cfg.MaxClusters, "max-clusters", cfg.MaxClusters, "Maximum clusters for --cluster-min-files")
+	fs.IntVar(&cfg.CoderSamples, "coder-samples", cfg.CoderSamples, "Coder runs per attempt; the median run is scored and metrics.json gets bootstrap confidence intervals")
+	fs.IntVar(&cfg.BeamWidth, "beam-width", cfg.BeamWidth, "Prompt lineages kept between iterations, each with its own feedback and SpecWriter session (multiplies the per-iteration budget)")
+	fs.IntVar(&cfg.GapBatch, "gap-batch", cfg.GapBatch, "Summarize the intent gaps of up to this many top attempts in one gap call (1 = one call per lineage, max 4)")
+	fs.IntVar(&cfg.ParallelCoders, "parallel-coders", cfg.ParallelCoders, "How many coder attempts to run concurrently, each in its own worktree")
+	fs.BoolVar(&cfg.IncludeGenerated, "include-generated", cfg.IncludeGenerated, "Score and show generated, minified, and snapshot files like hand-written code")
+	fs.BoolVar(&cfg.GoASTScoring, "go-ast-scoring", cfg.GoASTScoring, "Blend a Go AST comparison of declarations, signatures, and calls into technical similarity")
+	fs.Var((*stringsFlag)(&cfg.SeedPrompts), "seed-prompt", "Markdown file with a candidate prompt of your own to add to the first iteration's pool (repeatable)")
+	fs.BoolVar(&cfg.TestHint, "test-hint", cfg.TestHint, "When the target adds or changes tests, remind the coder to verify the change with the project's existing test framework")
+	fs.BoolVar(&cfg.TargetTests, "target-tests", cfg.TargetTests, "Run the target commit's added or changed tests against each produced change and blend the outcome into technical similarity")
+	fs.StringVar(&cfg.RenameMatch, "rename-match", cfg.RenameMatch, "How renamed files are matched in technical similarity: exact (same path only), source (same original file), path (same original file, credited by path similarity)")
+	fs.StringVar(&cfg.ScoreIgnore, "score-ignore", cfg.ScoreIgnore, "Comma-separated path globs left out of technical similarity (e.g. vendor/,*.lock,docs/*.md)")
+	fs.StringVar(&cfg.ScoreWeights, "score-weights", cfg.ScoreWeights, "Comma-separated pattern=weight pairs scaling the lines of matching files in technical similarity (e.g. *_test.go=0.5,testdata/=0.1)")
+	fs.StringVar(&cfg.GeneratedPatterns, "generated-patterns", cfg.GeneratedPatterns, "Comma-separated path globs always treated as generated (e.g. api/*.go,*.pb.ts)")
+	fs.StringVar(&cfg.Model, "model", cfg.Model, "Optional Copilot model override for all sessions (otherwise COPILOT_MODEL/env default)")
+	fs.StringVar(&cfg.SpecModel, "spec-model", cfg.SpecModel, "Model for the SpecWriter and gap roles (defaults to the provider model)")
+	fs.StringVar(&cfg.JudgeModel, "judge-model", cfg.JudgeModel, "Model for the realism judge role (defaults to the provider model)")
+	fs.StringVar(&cfg.CoderModel, "coder-model", cfg.CoderModel, "Model for coder runs (defaults to the provider model)")
+	fs.StringVar(&cfg.SpecReasoningEffort, "spec-reasoning-effort", cfg.SpecReasoningEffort, "Reasoning effort for the SpecWriter and gap roles: low, medium, high")
+	fs.StringVar(&cfg.JudgeReasoningEffort, "judge-reasoning-effort", cfg.JudgeReasoningEffort, "Reasoning effort for the realism judge role: low, medium, high")
+	fs.StringVar(&cfg.CoderReasoningEffort, "coder-reasoning-effort", cfg.CoderReasoningEffort, "Reasoning effort for coder runs: low, medium, high")
+	fs.StringVar(&cfg.Review, "review", cfg.Review, "Pause each iteration for the selected candidates to be approved, edited, or rejected before coder runs: off, terminal, or file (under <workdir>/review)")
+	fs.StringVar(&cfg.FreezePolicy, "freeze-policy", cfg.FreezePolicy, "Freeze incumbent prompt sections between iterations: off, incumbent, adaptive")
+	fs.StringVar(&cfg.FreezeSections, "freeze-sections", cfg.FreezeSections, "Comma-separated sections to freeze (context, outcomes, constraints, acceptance)")
+	fs.StringVar(&cfg.PromptLibrary, "prompt-library", cfg.PromptLibrary, "Optional prompt library file used as few-shot exemplars for the SpecWriter")
+	fs.IntVar(&cfg.Exemplars, "exemplars", cfg.Exemplars, "Exemplar prompts shown to the SpecWriter per candidate (0 = disabled)")
+	fs.IntVar(&cfg.ExemplarTokenBudget, "exemplar-token-budget", cfg.ExemplarTokenBudget, "Approximate token budget for exemplars per candidate (0 = unlimited)")
+	fs.StringVar(&cfg.RealismProfile, "realism-profile", cfg.RealismProfile, "Calibration profile from the calibrate command replacing the heuristic realism limits and weights")
+	fs.StringVar(&cfg.SpecLanguage, "spec-language", cfg.SpecLanguage, "Language of generated specs and realism keyword set (en, es, fr, de, pt, it)")
+	fs.StringVar(&cfg.TemplatesDir, "templates-dir", cfg.TemplatesDir, "Directory of prompt templates (specwriter.tmpl, judge.tmpl, coder.tmpl, chat-coder.tmpl) overriding the embedded defaults")
+	fs.StringVar(&cfg.Provider, "provider", cfg.Provider, "Model backend for the coder and for roles without their own provider: copilot, openai, anthropic, ollama")
+	fs.StringVar(&cfg.SpecProvider, "spec-provider", cfg.SpecProvider, "Chat provider for the SpecWriter role (defaults to --provider)")
+	fs.StringVar(&cfg.JudgeProvider, "judge-provider", cfg.JudgeProvider, "Chat provider for the realism judge role (defaults to --provider)")
+	fs.StringVar(&cfg.GapProvider, "gap-provider", cfg.GapProvider, "Chat provider for the intent-gap summarizer role (defaults to --provider)")
+	fs.StringVar(&cfg.OpenAIEndpoint, "openai-endpoint", cfg.OpenAIEndpoint, "Base URL of an OpenAI-compatible API (e.g. http://localhost:11434/v1)")
+	fs.StringVar(&cfg.OpenAIModel, "openai-model", cfg.OpenAIModel, "Model name for the openai provider")
+	fs.StringVar(&cfg.OpenAIAPIKey, "openai-api-key", os.Getenv("OPENAI_API_KEY"), "API key for the openai provider (defaults to $OPENAI_API_KEY)")
+	fs.StringVar(&cfg.AnthropicModel, "anthropic-model", cfg.AnthropicModel, "Model name for the anthropic provider")
+	fs.StringVar(&cfg.AnthropicAPIKey, "anthropic-api-key", os.Getenv("ANTHROPIC_API_KEY"), "API key for the anthropic provider (defaults to $ANTHROPIC_API_KEY)")
+	fs.StringVar(&cfg.OllamaEndpoint, "ollama-endpoint", cfg.OllamaEndpoint, "OpenAI-compatible base URL of the Ollama server")
+	fs.StringVar(&cfg.OllamaModel, "ollama-model", cfg.OllamaModel, "Model name for the ollama provider")
+	fs.IntVar(&cfg.ProviderRetries, "provider-retries", cfg.ProviderRetries, "Retries for failed spec, judge, and gap provider calls")
+	fs.IntVar(&cfg.JudgeMaxFailures, "judge-max-failures", cfg.JudgeMaxFailures, "Disable the LLM realism judge for the rest of the run after this many consecutive failures (0 never disables)")
+	fs.IntVar(&cfg.JudgeSamples, "judge-samples", cfg.JudgeSamples, "Judge calls per prompt; realism uses their mean and the run log their standard deviation")
+	fs.StringVar(&cfg.JudgeModels, "judge-models", cfg.JudgeModels, "Comma-separated additional judge models that judge samples rotate through after --judge-model")
+	fs.BoolVar(&cfg.JudgeCache, "judge-cache", cfg.JudgeCache, "Reuse judge scores cached under <workdir>/cache for prompts judged before")
+	fs.Float64Var(&cfg.JudgeCacheSimilarity, "judge-cache-similarity", cfg.JudgeCacheSimilarity, "Also reuse the cached judge score of a prompt at least this similar by word-pair Jaccard (0 = identical prompts only)")
+	fs.IntVar(&cfg.JudgeTopN, "judge-top-n", cfg.JudgeTopN, "Judge only the N attempts per iteration with the highest heuristic score (0 = all)")
+	fs.IntVar(&cfg.JudgeEvery, "judge-every", cfg.JudgeEvery, "Judge only every K-th iteration, starting with the first")
+	fs.StringVar(&cfg.JudgeNormalization, "judge-normalization", cfg.JudgeNormalization, "How to rank attempts when the judge scored only some of them: off, rejudge, or heuristic")
+	fs.StringVar(&cfg.SelfRefine, "self-refine", cfg.SelfRefine, "Candidate styles that get one self-critique and revision turn: all, or comma-separated keywords (balanced, minimal, acceptance, resilience, test)")
+	fs.StringVar(&cfg.CriticProvider, "critic-provider", cfg.CriticProvider, "Chat provider for the optional critic role that reviews the best candidate each iteration (empty disables)")
+	fs.BoolVar(&cfg.Traceability, "traceability", cfg.Traceability, "Link each sentence and criterion of the best prompt to the hunks of best.patch that address it")
+	fs.StringVar(&cfg.Sandbox, "sandbox", cfg.Sandbox, "Where coder commands and tests run: host or docker")
+	fs.StringVar(&cfg.SandboxImage, "sandbox-image", cfg.SandboxImage, "Container image for --sandbox docker; it needs the repository's toolchain")
+	fs.StringVar(&cfg.SandboxCPUs, "sandbox-cpus", cfg.SandboxCPUs, "CPU limit for sandbox containers (empty for no limit)")
+	fs.StringVar(&cfg.SandboxMemory, "sandbox-memory", cfg.SandboxMemory, "Memory limit for sandbox containers (empty for no limit)")
+	fs.StringVar(&cfg.SandboxNetwork, "sandbox-network", cfg.SandboxNetwork, "Docker network for sandbox containers")
+	fs.BoolVar(&cfg.Explain, "explain", cfg.Explain, "Write a readable score breakdown per attempt (matched files and lines, realism rubric) next to its patch")
+	fs.BoolVar(&cfg.CandidateCache, "candidate-cache", cfg.CandidateCache, "Reuse SpecWriter replies cached under <workdir>/cache when a retried or resumed run sends the same prompt")
+	fs.BoolVar(&cfg.Report, "report", cfg.Report, "Write artifacts/report.html with iterations, scores, trend chart, and target vs best patch diffs")
+	fs.StringVar(&cfg.Anonymize, "anonymize", cfg.Anonymize, "Hide the upstream project from coders: off, history (single neutral commit), or identifiers (also rename the Go module path and GitHub owner/name)")
+	fs.BoolVar(&cfg.ContaminationCheck, "contamination-check", cfg.ContaminationCheck, "Ask the coder model what it remembers of the target commit before the run and record whether it may have trained on it")
+	fs.BoolVar(&cfg.ReviewComparison, "review-comparison", cfg.ReviewComparison, "Compare the best prompt with the review discussion of the target's GitHub pull request")
+	fs.BoolVar(&cfg.Intents, "intents", cfg.Intents, "Write artifacts/intents.json decomposing the target into sub-intents with their files and a confidence (uses the gap provider)")
+	fs.BoolVar(&cfg.GitHubContext, "github-context", cfg.GitHubContext, "Add a sanitized summary of the target's GitHub pull request, linked issues, and review comments to the first iteration's feedback")
+	fs.StringVar(&cfg.GitHubToken, "github-token", os.Getenv("GITHUB_TOKEN"), "GitHub API token (defaults to $GITHUB_TOKEN)")
+	return &configPath
+}
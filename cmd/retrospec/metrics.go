@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/igolaizola/retrospec/internal/metrics"
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+// startMetrics serves Prometheus metrics on addr and hooks them into cfg.
+// An empty addr disables them.
+func startMetrics(addr string, cfg *run.Config) {
+	if addr == "" {
+		return
+	}
+	reg := metrics.New()
+	if _, err := metrics.Start(addr, reg); err != nil {
+		log.Printf("invalid flags: metrics-addr: %v", err)
+		os.Exit(2)
+	}
+	cfg.EventSinks = append(cfg.EventSinks, reg)
+	cfg.Middleware = append(cfg.Middleware, reg.Middleware())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runRerunAttempt(args []string) {
+	fs := flag.NewFlagSet("rerun-attempt", flag.ExitOnError)
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(fs, &cfg)
+	iteration := fs.Int("iteration", 0, "Iteration of the attempt to replay")
+	candidate := fs.Int("candidate", -1, "Draft index of the candidate to replay, as recorded in run_log.json")
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+	if *iteration < 1 || *candidate < 0 {
+		fmt.Fprintln(os.Stderr, "error: --iteration (>= 1) and --candidate (>= 0) are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	absWorkdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatalf("resolve workdir: %v", err)
+	}
+	cfg.Workdir = absWorkdir
+	if err := cfg.Validate(); err != nil {
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	res, err := run.NewRunner(cfg).RerunAttempt(context.Background(), *iteration, *candidate)
+	if err != nil {
+		log.Printf("rerun failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+
+	a := res.Attempt
+	if a.CoderError != "" {
+		fmt.Printf("coder error: %s\n", a.CoderError)
+	}
+	fmt.Printf("tech similarity: %.4f\n", a.Tech.Score)
+	fmt.Printf("realism score: %.4f\n", a.Realism.Score)
+	fmt.Printf("final score: %.4f\n", a.FinalScore)
+	if res.Original != nil && res.OriginalScoringVersion != res.ScoringVersion {
+		fmt.Printf("warning: the original attempt was scored with scoring version %d and the replay with %d\n", res.OriginalScoringVersion, res.ScoringVersion)
+	}
+	if res.Original != nil {
+		fmt.Printf("original final score: %.4f (tech %.4f, realism %.4f)\n", res.Original.FinalScore, res.Original.Tech.Score, res.Original.Realism.Score)
+	}
+	fmt.Printf("patch: %s\n", a.ProducedPatchPath)
+}
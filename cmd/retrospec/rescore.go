@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runRescore(args []string) {
+	fs := flag.NewFlagSet("rescore", flag.ExitOnError)
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(fs, &cfg)
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	absWorkdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatalf("resolve workdir: %v", err)
+	}
+	cfg.Workdir = absWorkdir
+	if err := cfg.Validate(); err != nil {
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	res, err := run.NewRunner(cfg).Rescore()
+	if err != nil {
+		log.Printf("rescore failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+	for _, w := range res.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	failed := 0
+	for _, a := range res.Attempts {
+		if a.Error != "" {
+			failed++
+		}
+	}
+	fmt.Printf("attempts: %d (%d without a readable patch)\n", len(res.Attempts), failed)
+	fmt.Printf("best iteration: %d (was %d)\n", res.Metrics.BestIteration, res.PreviousBest.BestIteration)
+	fmt.Printf("tech similarity: %.4f (was %.4f)\n", res.Metrics.TechSimilarity, res.PreviousBest.TechSimilarity)
+	fmt.Printf("realism score: %.4f (was %.4f)\n", res.Metrics.RealismScore, res.PreviousBest.RealismScore)
+	fmt.Printf("final score: %.4f (was %.4f)\n", res.Metrics.FinalScore, res.PreviousBest.FinalScore)
+	fmt.Printf("rescore: %s\n", filepath.Join(cfg.Workdir, "artifacts", fmt.Sprintf("rescore-%03d.json", res.Version)))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	validate := fs.String("validate", "", "run_log.json to validate instead of printing the schema")
+	migrate := fs.Bool("migrate", false, "With --validate, rewrite the run log in the current schema version")
+	_ = fs.Parse(args)
+
+	if *validate == "" {
+		if *migrate {
+			fmt.Fprintln(os.Stderr, "error: --migrate needs --validate")
+			fs.Usage()
+			os.Exit(2)
+		}
+		schema, err := run.RunLogSchema()
+		if err != nil {
+			log.Fatalf("generate schema: %v", err)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	data, err := os.ReadFile(*validate)
+	if err != nil {
+		log.Printf("read run log: %v", err)
+		os.Exit(2)
+	}
+	runLog, err := run.DecodeRunLog(data)
+	if err != nil {
+		log.Printf("invalid run log %s: %v", *validate, err)
+		os.Exit(1)
+	}
+	if *migrate {
+		out, err := json.MarshalIndent(runLog, "", "  ")
+		if err != nil {
+			log.Fatalf("encode run log: %v", err)
+		}
+		if err := os.WriteFile(*validate, append(out, '\n'), 0o644); err != nil {
+			log.Fatalf("write run log: %v", err)
+		}
+	}
+	fmt.Printf("valid: %s (schema version %d)\n", *validate, runLog.SchemaVersion)
+}
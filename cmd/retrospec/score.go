@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runScore(args []string) {
+	fs := flag.NewFlagSet("score", flag.ExitOnError)
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(fs, &cfg)
+	targetPath := fs.String("target", "", "Target patch file (git diff format)")
+	producedPath := fs.String("produced", "", "Produced patch file to compare against the target")
+	promptPath := fs.String("prompt", "", "Prompt file to score for realism")
+	judge := fs.Bool("judge", false, "Also score the prompt with the realism judge")
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+	if *targetPath == "" || *producedPath == "" || *promptPath == "" {
+		fmt.Fprintln(os.Stderr, "error: --target, --produced, and --prompt are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	absWorkdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatalf("resolve workdir: %v", err)
+	}
+	cfg.Workdir = absWorkdir
+	if err := cfg.Validate(); err != nil {
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("read input: %v", err)
+			os.Exit(2)
+		}
+		return string(data)
+	}
+	target, produced, prompt := read(*targetPath), read(*producedPath), read(*promptPath)
+
+	res, err := run.NewRunner(cfg).Score(context.Background(), target, produced, prompt, *judge)
+	if err != nil {
+		log.Printf("score failed (%s): %v", run.ErrorCategoryOf(err), err)
+		os.Exit(run.ExitCode(err))
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		log.Fatalf("encode result: %v", err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/run"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// scoreRequest is the body of POST /score: two patches in git diff format.
+type scoreRequest struct {
+	Target   string `json:"target"`
+	Produced string `json:"produced"`
+}
+
+// runScored serves the technical similarity scorer over HTTP, so tools that
+// are not written in Go can compare patches the way the loop does.
+func runScored(args []string) {
+	fs := flag.NewFlagSet("scored", flag.ExitOnError)
+	cfg := run.DefaultConfig()
+	configPath := registerRunFlags(fs, &cfg)
+	listen := fs.String("listen", ":8080", "Address to serve on")
+	maxBody := fs.Int64("max-body-bytes", 32<<20, "Largest request body accepted, in bytes")
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if err := applyConfigFile(fs, *configPath); err != nil {
+			log.Printf("invalid config: %v", err)
+			os.Exit(2)
+		}
+	}
+	if *maxBody <= 0 {
+		fmt.Fprintln(os.Stderr, "error: --max-body-bytes must be positive")
+		os.Exit(2)
+	}
+
+	absWorkdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatalf("resolve workdir: %v", err)
+	}
+	cfg.Workdir = absWorkdir
+	if err := cfg.Validate(); err != nil {
+		log.Printf("invalid flags: %v", err)
+		os.Exit(2)
+	}
+
+	runner := run.NewRunner(cfg)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /score", func(w http.ResponseWriter, req *http.Request) {
+		var in scoreRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, *maxBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&in); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeScoredError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			writeScoredError(w, http.StatusBadRequest, fmt.Sprintf("decode request: %v", err))
+			return
+		}
+		if in.Target == "" {
+			writeScoredError(w, http.StatusBadRequest, "target is required")
+			return
+		}
+		tech, _ := runner.TechScore(in.Target, in.Produced)
+		writeScoredJSON(w, http.StatusOK, tech)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeScoredJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// In-flight requests finish before the process exits.
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving patch scores on %s (scoring version %d)", *listen, scoring.Version)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("serve: %v", err)
+	}
+	<-drained
+}
+
+// writeScoredJSON writes v with the scoring version in a header, so clients
+// can tell when scores stop being comparable.
+func writeScoredJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Scoring-Version", strconv.Itoa(scoring.Version))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeScoredError(w http.ResponseWriter, status int, msg string) {
+	writeScoredJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// signalContext is canceled on SIGINT or SIGTERM so the run stops and writes
+// its partial artifacts. A second signal exits immediately.
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+		signal.Stop(ch)
+		fmt.Fprintln(os.Stderr, "interrupted: writing partial artifacts (interrupt again to exit now)")
+		cancel()
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+func runTail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	workdir := fs.String("workdir", "./work", "Working directory of the run to follow")
+	follow := fs.Bool("follow", true, "Keep waiting for new events until the run ends")
+	poll := fs.Duration("poll", time.Second, "How often to check for new events")
+	_ = fs.Parse(args)
+
+	if *poll <= 0 {
+		log.Printf("invalid flags: poll must be > 0")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run.TailEvents(ctx, *workdir, *follow, *poll, func(e run.Event) {
+		fmt.Println(formatEvent(e))
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("tail failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+func formatEvent(e run.Event) string {
+	ts := e.Time.Local().Format("15:04:05")
+	switch e.Type {
+	case run.EventRunStart:
+		return fmt.Sprintf("%s run started: %s", ts, e.Message)
+	case run.EventIterationStart:
+		return fmt.Sprintf("%s [iter %d] started", ts, e.Iteration)
+	case run.EventCandidate:
+		if e.Message != "" {
+			return fmt.Sprintf("%s [iter %d] cand %d dropped: %s", ts, e.Iteration, e.Candidate, e.Message)
+		}
+		return fmt.Sprintf("%s [iter %d] cand %d drafted %q", ts, e.Iteration, e.Candidate, e.Title)
+	case run.EventCandidates:
+		return fmt.Sprintf("%s [iter %d] %s", ts, e.Iteration, e.Message)
+	case run.EventReview:
+		return fmt.Sprintf("%s [iter %d] review: %s", ts, e.Iteration, e.Message)
+	case run.EventCoderStart:
+		return fmt.Sprintf("%s [iter %d] coder started %s", ts, e.Iteration, e.Attempt)
+	case run.EventCoderTool:
+		return fmt.Sprintf("%s [iter %d] %s: %s", ts, e.Iteration, e.Attempt, e.Message)
+	case run.EventCoderDone:
+		line := fmt.Sprintf("%s [iter %d] coder finished %s in %s", ts, e.Iteration, e.Attempt, time.Duration(e.DurationMs)*time.Millisecond)
+		if e.Message != "" {
+			line += " (error: " + e.Message + ")"
+		}
+		return line
+	case run.EventAttempt:
+		line := fmt.Sprintf("%s [iter %d] cand %d final=%.4f tech=%.4f realism=%.4f %q", ts, e.Iteration, e.Candidate, e.Final, e.Tech, e.Realism, e.Title)
+		if e.Message != "" {
+			line += " (coder error: " + e.Message + ")"
+		}
+		return line
+	case run.EventBest:
+		return fmt.Sprintf("%s [iter %d] new best cand %d final=%.4f %q", ts, e.Iteration, e.Candidate, e.Final, e.Title)
+	case run.EventIterationEnd:
+		return fmt.Sprintf("%s [iter %d] best cand %d final=%.4f, run best %.4f", ts, e.Iteration, e.Candidate, e.Final, e.BestFinal)
+	case run.EventRunEnd:
+		if e.Iteration == 0 {
+			return fmt.Sprintf("%s run ended: %s", ts, e.Message)
+		}
+		line := fmt.Sprintf("%s run ended: %s; best final=%.4f at iter %d", ts, e.Message, e.BestFinal, e.Iteration)
+		if e.Title != "" {
+			line += fmt.Sprintf(" %q", e.Title)
+		}
+		return line
+	default:
+		return fmt.Sprintf("%s %s %s", ts, e.Type, e.Message)
+	}
+}
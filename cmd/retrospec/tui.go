@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+const (
+	tuiFrame       = 250 * time.Millisecond
+	tuiSizeEvery   = 4
+	tuiRecentLines = 8
+	tuiLogLines    = 4
+	tuiStatusTTL   = 5 * time.Second
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// tui is the full-screen view of --tui. It is an event sink and a log
+// writer: both only update its state, and a ticker redraws the screen, so
+// the run is never held up by the terminal.
+type tui struct {
+	runner *run.Runner
+	// stop cancels the run like an interrupt, keeping the best so far.
+	stop     func()
+	maxIters int
+	tty      *os.File
+	sttyMode string
+
+	mu        sync.Mutex
+	started   time.Time
+	target    string
+	iteration int
+	phase     string
+	best      run.Event
+	history   []float64
+	running   []*tuiAttempt
+	selected  int
+	prompt    string
+	promptOf  string
+	recent    []string
+	logs      []string
+	status    string
+	statusAt  time.Time
+	finishing bool
+	rows      int
+	cols      int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type tuiAttempt struct {
+	name    string
+	started time.Time
+	tool    string
+	prompt  string
+}
+
+// newTUI takes over the terminal: alternate screen, no echo, and keys
+// delivered as they are pressed. Signals still work, so Ctrl-C stops the run
+// as usual.
+func newTUI(maxIters int) (*tui, error) {
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("tui needs stdout to be a terminal")
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("tui needs a terminal: %w", err)
+	}
+	mode, err := stty(tty, "-g")
+	if err != nil {
+		_ = tty.Close()
+		return nil, fmt.Errorf("tui needs stty: %w", err)
+	}
+	if _, err := stty(tty, "-icanon", "-echo", "min", "1", "time", "0"); err != nil {
+		_ = tty.Close()
+		return nil, fmt.Errorf("tui needs stty: %w", err)
+	}
+	t := &tui{
+		maxIters: maxIters,
+		tty:      tty,
+		sttyMode: strings.TrimSpace(mode),
+		started:  time.Now(),
+		phase:    "preparing",
+		rows:     24,
+		cols:     80,
+		done:     make(chan struct{}),
+	}
+	t.readSize()
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	return t, nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// start begins drawing and reading keys for the given run.
+func (t *tui) start(runner *run.Runner, stop func()) {
+	t.runner, t.stop = runner, stop
+	t.wg.Add(1)
+	go t.loop()
+	go t.readKeys()
+}
+
+// close restores the terminal. The key reader may stay blocked on the
+// terminal until the process exits.
+func (t *tui) close() {
+	close(t.done)
+	t.wg.Wait()
+	fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+	_, _ = stty(t.tty, t.sttyMode)
+}
+
+func (t *tui) loop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(tuiFrame)
+	defer ticker.Stop()
+	for frame := 1; ; frame++ {
+		t.draw()
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		if frame%tuiSizeEvery == 0 {
+			t.readSize()
+		}
+	}
+}
+
+func (t *tui) readSize() {
+	out, err := stty(t.tty, "size")
+	if err != nil {
+		return
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(out, &rows, &cols); err != nil || rows <= 0 || cols <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.rows, t.cols = rows, cols
+	t.mu.Unlock()
+}
+
+func (t *tui) readKeys() {
+	r := bufio.NewReader(t.tty)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '\t', 'j':
+			t.move(1)
+		case 'k':
+			t.move(-1)
+		case 0x1b:
+			// Arrow keys arrive as ESC [ A and ESC [ B.
+			if next, _ := r.ReadByte(); next != '[' {
+				continue
+			}
+			switch arrow, _ := r.ReadByte(); arrow {
+			case 'A':
+				t.move(-1)
+			case 'B':
+				t.move(1)
+			}
+		case 'a':
+			t.abortSelected()
+		case 'f':
+			t.runner.Finish()
+			t.mu.Lock()
+			t.finishing = true
+			t.setStatus("finishing after this iteration")
+			t.mu.Unlock()
+		case 's', 'q':
+			t.mu.Lock()
+			t.setStatus("stopping: writing the best so far")
+			t.mu.Unlock()
+			t.stop()
+		}
+	}
+}
+
+func (t *tui) move(delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.running); n > 0 {
+		t.selected = ((t.selected+delta)%n + n) % n
+	}
+}
+
+func (t *tui) abortSelected() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.selected >= len(t.running) {
+		t.setStatus("no coder running")
+		return
+	}
+	name := t.running[t.selected].name
+	if t.runner.AbortAttempt(name) {
+		t.setStatus("aborted " + name)
+	} else {
+		t.setStatus(name + " already finished")
+	}
+}
+
+// setStatus must be called with mu held.
+func (t *tui) setStatus(s string) {
+	t.status, t.statusAt = s, time.Now()
+}
+
+// Event implements run.EventSink.
+func (t *tui) Event(e run.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e.Type {
+	case run.EventRunStart:
+		t.target = e.Message
+	case run.EventIterationStart:
+		t.iteration = e.Iteration
+		t.phase = "drafting candidates"
+	case run.EventCandidates:
+		t.phase = "running coders"
+	case run.EventCoderStart:
+		t.running = append(t.running, &tuiAttempt{name: e.Attempt, started: e.Time, prompt: e.Prompt})
+		t.prompt, t.promptOf = e.Prompt, e.Attempt
+	case run.EventCoderTool:
+		for _, a := range t.running {
+			if a.name == e.Attempt {
+				a.tool = e.Message
+			}
+		}
+		return
+	case run.EventCoderDone:
+		for i, a := range t.running {
+			if a.name != e.Attempt {
+				continue
+			}
+			t.running = append(t.running[:i], t.running[i+1:]...)
+			if t.selected > i || (t.selected >= len(t.running) && t.selected > 0) {
+				t.selected--
+			}
+			break
+		}
+		if len(t.running) == 0 {
+			t.phase = "scoring attempts"
+		}
+	case run.EventBest:
+		t.best = e
+	case run.EventIterationEnd:
+		t.history = append(t.history, e.BestFinal)
+		t.phase = "between iterations"
+	case run.EventRunEnd:
+		t.phase = "finished: " + e.Message
+	}
+	t.recent = appendTail(t.recent, formatEvent(e), tuiRecentLines)
+}
+
+// Write implements io.Writer for the run's logger.
+func (t *tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logs = appendTail(t.logs, line, tuiLogLines)
+	}
+	return len(p), nil
+}
+
+func appendTail(lines []string, line string, max int) []string {
+	lines = append(lines, line)
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
+
+func (t *tui) draw() {
+	t.mu.Lock()
+	lines := t.render(time.Now())
+	rows, cols := t.rows, t.cols
+	t.mu.Unlock()
+
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		buf.WriteString(clip(line, cols))
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	_, _ = os.Stdout.Write(buf.Bytes())
+}
+
+// render lays out the screen; mu must be held. The prompt gets whatever
+// rows the other sections leave.
+func (t *tui) render(now time.Time) []string {
+	head := []string{
+		fmt.Sprintf("retrospec %s   elapsed %s", t.target, now.Sub(t.started).Round(time.Second)),
+		fmt.Sprintf("iteration %d/%d · %s", t.iteration, t.maxIters, t.phase),
+	}
+	if t.best.Type != "" {
+		head = append(head, fmt.Sprintf("best final %.4f at iter %d %q", t.best.Final, t.best.Iteration, t.best.Title))
+	} else {
+		head = append(head, "best final -")
+	}
+	head = append(head, "best so far "+sparkline(t.history, t.cols-len("best so far ")-8), "")
+
+	head = append(head, fmt.Sprintf("coders running (%d)", len(t.running)))
+	prompt, promptOf := t.prompt, t.promptOf
+	for i, a := range t.running {
+		marker := "  "
+		if i == t.selected {
+			marker = "> "
+			prompt, promptOf = a.prompt, a.name
+		}
+		head = append(head, fmt.Sprintf("%s%s  %s  %s", marker, a.name, now.Sub(a.started).Round(time.Second), a.tool))
+	}
+	head = append(head, "")
+
+	tail := []string{"", "recent"}
+	for _, line := range t.recent {
+		tail = append(tail, "  "+line)
+	}
+	if len(t.logs) > 0 {
+		tail = append(tail, "", "logs")
+		for _, line := range t.logs {
+			tail = append(tail, "  "+line)
+		}
+	}
+	help := "tab/↑↓ select · a abort attempt · f finish after iteration · s stop now, keeping the best"
+	if t.finishing {
+		help = "tab/↑↓ select · a abort attempt · s stop now, keeping the best · finishing after this iteration"
+	}
+	if t.status != "" && now.Sub(t.statusAt) < tuiStatusTTL {
+		help = t.status
+	}
+	tail = append(tail, "", help)
+
+	space := t.rows - len(head) - len(tail) - 1
+	out := head
+	if promptOf != "" && space > 0 {
+		out = append(out, "prompt of "+promptOf)
+		body := wrap(prompt, t.cols-2)
+		if len(body) > space {
+			body = append(body[:space-1], "…")
+		}
+		for _, line := range body {
+			out = append(out, "  "+line)
+		}
+	}
+	return append(out, tail...)
+}
+
+// sparkline draws the last width values, each between 0 and 1.
+func sparkline(values []float64, width int) string {
+	if width < 1 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := int(v*float64(len(sparkBlocks)-1) + 0.5)
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(sparkBlocks) {
+			i = len(sparkBlocks) - 1
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	if len(values) > 0 {
+		b.WriteString(" " + strconv.FormatFloat(values[len(values)-1], 'f', 4, 64))
+	}
+	return b.String()
+}
+
+// wrap breaks text into lines of at most width runes at spaces.
+func wrap(text string, width int) []string {
+	if width < 10 {
+		width = 10
+	}
+	var out []string
+	for _, para := range strings.Split(strings.TrimSpace(text), "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) > width:
+				out = append(out, line)
+				line = word
+			default:
+				line += " " + word
+			}
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func clip(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", " ")
+	if r := []rune(s); len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
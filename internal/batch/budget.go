@@ -0,0 +1,83 @@
+package batch
+
+import (
+	"math"
+
+	"github.com/igolaizola/retrospec/internal/difficulty"
+)
+
+type Budget struct {
+	MaxIters         int `json:"maxIters"`
+	CoderRunsPerIter int `json:"coderRunsPerIter"`
+}
+
+func (b Budget) CoderRuns() int {
+	return b.MaxIters * b.CoderRunsPerIter
+}
+
+// Allocator hands out per-commit budgets scaled by estimated difficulty while
+// keeping the batch within an optional global coder-run budget.
+type Allocator struct {
+	Base                Budget
+	MaxCoderRunsPerIter int
+	TotalCoderRuns      int
+	used                int
+}
+
+var bucketWeights = map[string]float64{
+	difficulty.BucketEasy:   0.5,
+	difficulty.BucketMedium: 1.0,
+	difficulty.BucketHard:   1.5,
+}
+
+func (a *Allocator) Allocate(est difficulty.Estimate, remainingTargets int) Budget {
+	weight, ok := bucketWeights[est.Bucket]
+	if !ok {
+		weight = 1
+	}
+
+	b := Budget{
+		MaxIters:         maxInt(1, int(math.Round(float64(a.Base.MaxIters)*weight))),
+		CoderRunsPerIter: a.Base.CoderRunsPerIter,
+	}
+	switch est.Bucket {
+	case difficulty.BucketEasy:
+		b.CoderRunsPerIter = maxInt(1, b.CoderRunsPerIter-1)
+	case difficulty.BucketHard:
+		b.CoderRunsPerIter++
+	}
+	if a.MaxCoderRunsPerIter > 0 && b.CoderRunsPerIter > a.MaxCoderRunsPerIter {
+		b.CoderRunsPerIter = a.MaxCoderRunsPerIter
+	}
+
+	if a.TotalCoderRuns > 0 {
+		remaining := a.TotalCoderRuns - a.used
+		share := float64(remaining) / float64(maxInt(1, remainingTargets)) * weight
+		limit := maxInt(1, int(math.Floor(share)))
+		for b.CoderRuns() > limit && b.MaxIters > 1 {
+			b.MaxIters--
+		}
+		for b.CoderRuns() > limit && b.CoderRunsPerIter > 1 {
+			b.CoderRunsPerIter--
+		}
+	}
+	return b
+}
+
+func (a *Allocator) Consume(coderRuns int) {
+	a.used += coderRuns
+}
+
+func (a *Allocator) Remaining() int {
+	if a.TotalCoderRuns <= 0 {
+		return -1
+	}
+	return maxInt(0, a.TotalCoderRuns-a.used)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+package batch
+
+type Target struct {
+	Repo   string `json:"repo"`
+	Commit string `json:"commit"`
+}
+
+type Fingerprinted struct {
+	Target
+	Fingerprint       string `json:"fingerprint"`
+	RevertFingerprint string `json:"revertFingerprint"`
+}
+
+const (
+	SkipReasonEmpty     = "empty diff"
+	SkipReasonDuplicate = "duplicate of an earlier commit (cherry-pick or repeated change)"
+	SkipReasonRevert    = "reverts an earlier commit in the batch"
+)
+
+type SkipRecord struct {
+	Target      Target  `json:"target"`
+	Reason      string  `json:"reason"`
+	DuplicateOf *Target `json:"duplicateOf,omitempty"`
+	Fingerprint string  `json:"fingerprint,omitempty"`
+}
+
+// Dedupe keeps the first occurrence of each distinct change within a
+// repository and reports why the others were skipped. Reverts are matched
+// against earlier commits by comparing inverse fingerprints.
+func Dedupe(items []Fingerprinted) ([]Fingerprinted, []SkipRecord) {
+	type key struct {
+		repo string
+		fp   string
+	}
+	seen := map[key]Target{}
+	keep := make([]Fingerprinted, 0, len(items))
+	skipped := []SkipRecord{}
+
+	for _, it := range items {
+		if it.Fingerprint == "" {
+			skipped = append(skipped, SkipRecord{Target: it.Target, Reason: SkipReasonEmpty})
+			continue
+		}
+		if prev, ok := seen[key{it.Repo, it.Fingerprint}]; ok {
+			prev := prev
+			skipped = append(skipped, SkipRecord{Target: it.Target, Reason: SkipReasonDuplicate, DuplicateOf: &prev, Fingerprint: it.Fingerprint})
+			continue
+		}
+		if prev, ok := seen[key{it.Repo, it.RevertFingerprint}]; ok && it.RevertFingerprint != "" {
+			prev := prev
+			skipped = append(skipped, SkipRecord{Target: it.Target, Reason: SkipReasonRevert, DuplicateOf: &prev, Fingerprint: it.Fingerprint})
+			continue
+		}
+		seen[key{it.Repo, it.Fingerprint}] = it.Target
+		keep = append(keep, it)
+	}
+	return keep, skipped
+}
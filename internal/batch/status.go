@@ -0,0 +1,127 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+type StatusEntry struct {
+	Target    Target    `json:"target"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	Workdir   string    `json:"workdir,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Status persists per-commit progress of a batch so an interrupted batch can
+// be resumed without redoing completed commits.
+type Status struct {
+	path    string
+	entries map[Target]*StatusEntry
+}
+
+func LoadStatus(path string) (*Status, error) {
+	s := &Status{path: path, entries: map[Target]*StatusEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read batch status: %w", err)
+	}
+	var list []StatusEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse batch status %s: %w", path, err)
+	}
+	for i := range list {
+		e := list[i]
+		s.entries[e.Target] = &e
+	}
+	return s, nil
+}
+
+func (s *Status) Get(t Target) StatusEntry {
+	if e, ok := s.entries[t]; ok {
+		return *e
+	}
+	return StatusEntry{Target: t, Status: StatusPending}
+}
+
+// ShouldRun reports whether the target still needs work. Entries left in
+// running state belong to an interrupted invocation and are retried.
+func (s *Status) ShouldRun(t Target, maxRetries int) bool {
+	e := s.Get(t)
+	switch e.Status {
+	case StatusDone, StatusSkipped:
+		return false
+	case StatusFailed:
+		return e.Attempts <= maxRetries
+	default:
+		return true
+	}
+}
+
+func (s *Status) Mark(t Target, status, workdir string, runErr error) error {
+	e, ok := s.entries[t]
+	if !ok {
+		e = &StatusEntry{Target: t}
+		s.entries[t] = e
+	}
+	e.Status = status
+	if workdir != "" {
+		e.Workdir = workdir
+	}
+	if status == StatusRunning {
+		e.Attempts++
+	}
+	e.Error = ""
+	if runErr != nil {
+		e.Error = runErr.Error()
+	}
+	e.UpdatedAt = time.Now()
+	return s.save()
+}
+
+func (s *Status) Entries() []StatusEntry {
+	out := make([]StatusEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Target.Repo != out[j].Target.Repo {
+			return out[i].Target.Repo < out[j].Target.Repo
+		}
+		return out[i].Target.Commit < out[j].Target.Commit
+	})
+	return out
+}
+
+func (s *Status) save() error {
+	data, err := json.MarshalIndent(s.Entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create batch status dir: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write batch status: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
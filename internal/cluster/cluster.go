@@ -0,0 +1,217 @@
+// Package cluster splits a diff into coherent sub-changes so commits that
+// bundle several unrelated intents can be described one intent at a time.
+package cluster
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const maxSymbols = 8
+
+// declRe matches declarations in the common languages; the name must be at
+// least four characters so short helpers do not link unrelated files.
+var (
+	declRe  = regexp.MustCompile(`\b(?:func|type|class|def|interface|struct|enum|fn|trait|impl)\s+(?:\([^)]*\)\s*)?([A-Za-z_][A-Za-z0-9_]{3,})`)
+	identRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]{3,}`)
+)
+
+// Cluster is a sub-change of a diff: files with directory or symbol
+// affinity.
+type Cluster struct {
+	Files []string `json:"files"`
+	Dirs  []string `json:"dirs"`
+	// Symbols are declarations changed in the cluster that link its files.
+	Symbols []string `json:"symbols,omitempty"`
+	Lines   int      `json:"lines"`
+}
+
+type fileInfo struct {
+	path     string
+	dir      string
+	declared map[string]bool
+	used     map[string]bool
+	lines    int
+}
+
+// Split groups the changed files of target into at most max clusters,
+// largest first. Files in the same directory belong together, and so do
+// files where one changes a declaration the other's changed lines use. When
+// there are more groups than max, the smallest ones are merged into one.
+func Split(target git.DiffSnapshot, max int) []Cluster {
+	files := map[string]*fileInfo{}
+	var order []string
+	for _, f := range generated.SplitPatch(target.Patch) {
+		if f.Header == "" || f.Path == "" {
+			continue
+		}
+		info := &fileInfo{path: f.Path, dir: path.Dir(f.Path), declared: map[string]bool{}, used: map[string]bool{}}
+		for _, l := range f.Lines {
+			if !changedLine(l) {
+				continue
+			}
+			info.lines++
+			for _, m := range declRe.FindAllStringSubmatch(l, -1) {
+				info.declared[m[1]] = true
+			}
+			for _, id := range identRe.FindAllString(l[1:], -1) {
+				info.used[id] = true
+			}
+		}
+		files[f.Path] = info
+		order = append(order, f.Path)
+	}
+	// Files without a diff section, such as renames or binaries, still
+	// belong to their directory.
+	for _, p := range target.ChangedFiles {
+		if _, ok := files[p]; !ok {
+			files[p] = &fileInfo{path: p, dir: path.Dir(p), declared: map[string]bool{}, used: map[string]bool{}}
+			order = append(order, p)
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(p string) string {
+		if parent[p] == "" || parent[p] == p {
+			return p
+		}
+		parent[p] = find(parent[p])
+		return parent[p]
+	}
+	union := func(a, b string) {
+		if ra, rb := find(a), find(b); ra != rb {
+			parent[rb] = ra
+		}
+	}
+
+	byDir := map[string]string{}
+	for _, p := range order {
+		d := files[p].dir
+		if first, ok := byDir[d]; ok {
+			union(first, p)
+		} else {
+			byDir[d] = p
+		}
+	}
+	declaredIn := map[string][]string{}
+	for _, p := range order {
+		for sym := range files[p].declared {
+			declaredIn[sym] = append(declaredIn[sym], p)
+		}
+	}
+	// links holds the declarations of each file used by other files.
+	links := map[string]map[string]bool{}
+	for _, p := range order {
+		for sym := range files[p].used {
+			for _, q := range declaredIn[sym] {
+				if q == p {
+					continue
+				}
+				union(p, q)
+				if links[q] == nil {
+					links[q] = map[string]bool{}
+				}
+				links[q][sym] = true
+			}
+		}
+	}
+
+	groups := map[string][]string{}
+	var roots []string
+	for _, p := range order {
+		r := find(p)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], p)
+	}
+	var out []Cluster
+	for _, r := range roots {
+		out = append(out, build(groups[r], files, links))
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Lines > out[j].Lines })
+
+	if max > 0 && len(out) > max {
+		var rest []string
+		for _, c := range out[max-1:] {
+			rest = append(rest, c.Files...)
+		}
+		out = append(out[:max-1], build(rest, files, links))
+	}
+	return out
+}
+
+func build(paths []string, files map[string]*fileInfo, links map[string]map[string]bool) Cluster {
+	c := Cluster{}
+	dirs := map[string]bool{}
+	syms := map[string]bool{}
+	for _, p := range paths {
+		f := files[p]
+		c.Files = append(c.Files, p)
+		c.Lines += f.lines
+		dirs[f.dir] = true
+		for s := range links[p] {
+			syms[s] = true
+		}
+	}
+	sort.Strings(c.Files)
+	for d := range dirs {
+		c.Dirs = append(c.Dirs, d)
+	}
+	sort.Strings(c.Dirs)
+	for s := range syms {
+		c.Symbols = append(c.Symbols, s)
+	}
+	sort.Strings(c.Symbols)
+	if len(c.Symbols) > maxSymbols {
+		c.Symbols = c.Symbols[:maxSymbols]
+	}
+	return c
+}
+
+func changedLine(l string) bool {
+	if strings.HasPrefix(l, "+++") || strings.HasPrefix(l, "---") {
+		return false
+	}
+	return strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-")
+}
+
+// Snapshot returns the part of target that touches the files of c.
+func Snapshot(target git.DiffSnapshot, c Cluster) git.DiffSnapshot {
+	in := map[string]bool{}
+	for _, f := range c.Files {
+		in[f] = true
+	}
+	out := git.DiffSnapshot{FileStats: map[string]git.FileStat{}}
+	var b strings.Builder
+	for _, f := range generated.SplitPatch(target.Patch) {
+		if f.Header == "" || !in[f.Path] {
+			continue
+		}
+		b.WriteString(f.Header)
+		b.WriteString("\n")
+		for _, l := range f.Lines {
+			b.WriteString(l)
+			b.WriteString("\n")
+		}
+	}
+	out.Patch = b.String()
+	for _, p := range target.ChangedFiles {
+		if in[p] {
+			out.ChangedFiles = append(out.ChangedFiles, p)
+			if s, ok := target.FileStats[p]; ok {
+				out.FileStats[p] = s
+			}
+		}
+	}
+	return out
+}
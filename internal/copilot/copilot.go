@@ -2,60 +2,57 @@ package copilot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"math"
+	"log/slog"
 	"os"
 	"strings"
+	"sync"
 
 	sdk "github.com/github/copilot-sdk/go"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/sandbox"
 )
 
 const (
 	defaultModel           = "gpt-5.3-codex"
 	defaultReasoningEffort = "medium"
+	maxToolDetail          = 80
 )
 
 type Manager struct {
-	client  *sdk.Client
-	model   string
-	verbose bool
+	client      *sdk.Client
+	model       string
+	coderModel  string
+	coderEffort string
+	sandbox     sandbox.Sandbox
+	templates   *llm.Templates
+	log         *slog.Logger
 }
 
 type Options struct {
-	Model   string
-	Verbose bool
+	// Model is the default for every session.
+	Model string
+	// CoderModel and CoderReasoningEffort override the default for coder
+	// sessions.
+	CoderModel           string
+	CoderReasoningEffort string
+	// Sandbox, when not the host, replaces the coder's built-in shell with a
+	// tool that runs commands through it.
+	Sandbox sandbox.Sandbox
+	// Templates render the coder, SpecWriter, and judge prompts; nil uses
+	// the embedded defaults.
+	Templates *llm.Templates
+	// Logger receives coder tool activity at debug level; nil discards it.
+	Logger *slog.Logger
 }
 
-type SpecCandidate struct {
-	CandidatePrompt string   `json:"candidatePrompt"`
-	Rationale       string   `json:"rationale"`
-	ScopeHints      []string `json:"scopeHints"`
+// SessionOptions overrides the manager defaults for a chat session.
+type SessionOptions struct {
+	Model           string
+	ReasoningEffort string
 }
 
-type JudgeResult struct {
-	Score         float64 `json:"score"`
-	Justification string  `json:"justification"`
-}
-
-type IntentGapResult struct {
-	Gaps []string `json:"gaps"`
-}
-
-type CoderResult struct {
-	FinalMessage string `json:"finalMessage"`
-}
-
-type GenerateSpecRequest struct {
-	Iteration       int
-	FeedbackText    string
-	MaxPathRefs     int
-	MaxLength       int
-	Style           string
-	PreviousPrompt  string
-	PreviousOutcome string
-	ViolationReason string
-}
+type CoderResult = llm.CoderResult
 
 func NewManager(ctx context.Context, cwd string, opts Options) (*Manager, error) {
 	model := strings.TrimSpace(opts.Model)
@@ -66,15 +63,24 @@ func NewManager(ctx context.Context, cwd string, opts Options) (*Manager, error)
 		model = defaultModel
 	}
 
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
 	client := sdk.NewClient(&sdk.ClientOptions{Cwd: cwd})
 	if err := client.Start(ctx); err != nil {
-		return nil, fmt.Errorf("start copilot sdk client: %w", err)
+		return nil, &llm.CallError{Op: "start copilot sdk client", Err: err}
 	}
 
 	return &Manager{
-		client:  client,
-		model:   model,
-		verbose: opts.Verbose,
+		client:      client,
+		model:       model,
+		coderModel:  orDefault(opts.CoderModel, model),
+		coderEffort: orDefault(opts.CoderReasoningEffort, defaultReasoningEffort),
+		sandbox:     opts.Sandbox,
+		templates:   opts.Templates,
+		log:         logger,
 	}, nil
 }
 
@@ -85,190 +91,100 @@ func (m *Manager) Close() error {
 	return m.client.Stop()
 }
 
-func (m *Manager) CreateSpecWriterSession(ctx context.Context, workingDir string) (*sdk.Session, error) {
-	config := &sdk.SessionConfig{
-		Model:            m.model,
-		ReasoningEffort:  defaultReasoningEffort,
-		WorkingDirectory: workingDir,
-		InfiniteSessions: &sdk.InfiniteSessionConfig{Enabled: sdk.Bool(false)},
-	}
-	s, err := m.client.CreateSession(ctx, config)
-	if err != nil {
-		return nil, fmt.Errorf("create specwriter session: %w", err)
-	}
-	return s, nil
+// ChatSession adapts a Copilot session to the llm.ChatProvider interface so
+// it can serve the SpecWriter, judge, and gap roles.
+type ChatSession struct {
+	// mu serializes sends; a session is a single conversation and roles may
+	// call it from concurrent coder attempts.
+	mu      sync.Mutex
+	session *sdk.Session
 }
 
-func (m *Manager) GenerateSpecCandidate(ctx context.Context, specSession *sdk.Session, req GenerateSpecRequest) (SpecCandidate, string, error) {
-	prompt := buildSpecWriterPrompt(req)
-	resp, err := specSession.SendAndWait(ctx, sdk.MessageOptions{Prompt: prompt})
+func (s *ChatSession) Chat(ctx context.Context, prompt string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp, err := s.session.SendAndWait(ctx, sdk.MessageOptions{Prompt: prompt})
 	if err != nil {
-		return SpecCandidate{}, "", fmt.Errorf("specwriter send: %w", err)
+		return "", err
 	}
-
 	text := ""
 	if resp != nil && resp.Data.Content != nil {
 		text = strings.TrimSpace(*resp.Data.Content)
 	}
-
-	parsed, err := parseSpecCandidateJSON(text)
-	if err != nil {
-		return SpecCandidate{}, text, err
-	}
-	return parsed, text, nil
+	return text, nil
 }
 
-func (m *Manager) JudgeRealism(ctx context.Context, specSession *sdk.Session, candidatePrompt string) (JudgeResult, error) {
-	judgeReq := strings.TrimSpace(`You are rating prompt realism.
-Return STRICT JSON with keys:
-{
-  "score": number between 0 and 1,
-  "justification": "one short sentence"
+func (s *ChatSession) Destroy() error {
+	return s.session.Destroy()
 }
-Scoring rubric:
-- High score means this looks like a real high-level engineering design/spec request.
-- Penalize overfitting language that looks like diff instructions.
-- Do not include code, snippets, commands, logs, or markdown.
-`) + "\n\nCandidate prompt:\n" + candidatePrompt
-
-	resp, err := specSession.SendAndWait(ctx, sdk.MessageOptions{Prompt: judgeReq})
-	if err != nil {
-		return JudgeResult{}, err
-	}
 
-	text := ""
-	if resp != nil && resp.Data.Content != nil {
-		text = strings.TrimSpace(*resp.Data.Content)
+func (m *Manager) CreateChatSession(ctx context.Context, workingDir string, opts SessionOptions) (*ChatSession, error) {
+	config := &sdk.SessionConfig{
+		Model:            orDefault(opts.Model, m.model),
+		ReasoningEffort:  orDefault(opts.ReasoningEffort, defaultReasoningEffort),
+		WorkingDirectory: workingDir,
+		InfiniteSessions: &sdk.InfiniteSessionConfig{Enabled: sdk.Bool(false)},
 	}
-	jsonBlob, err := extractJSONObject(text)
+	s, err := m.client.CreateSession(ctx, config)
 	if err != nil {
-		return JudgeResult{}, err
-	}
-	var result JudgeResult
-	if err := json.Unmarshal([]byte(jsonBlob), &result); err != nil {
-		return JudgeResult{}, err
-	}
-	if math.IsNaN(result.Score) || math.IsInf(result.Score, 0) {
-		result.Score = 0
-	}
-	if result.Score < 0 {
-		result.Score = 0
-	}
-	if result.Score > 1 {
-		result.Score = 1
-	}
-	return result, nil
-}
-
-func (m *Manager) SummarizeIntentGap(ctx context.Context, specSession *sdk.Session, targetPatch, producedPatch string, maxItems int) (IntentGapResult, error) {
-	if maxItems < 1 {
-		maxItems = 1
+		return nil, &llm.CallError{Op: "create chat session", Err: err}
 	}
-	if maxItems > 8 {
-		maxItems = 8
-	}
-
-	limitPatch := func(p string) string {
-		p = strings.TrimSpace(p)
-		if len(p) <= 12000 {
-			return p
-		}
-		return p[:12000]
-	}
-
-	req := fmt.Sprintf(`Summarize behavioral intent differences between two internal change sets.
-Return STRICT JSON only:
-{
-  "gaps": ["short abstract sentence", "..."]
+	return &ChatSession{session: s}, nil
 }
-Rules:
-- No code snippets.
-- No diff lines.
-- No command lines.
-- No stack traces.
-- Do not quote exact source lines.
-- Use high-level behavioral categories only.
-- Maximum %d items.
-`, maxItems)
-
-	req += "\nTarget patch (internal use only):\n" + limitPatch(targetPatch)
-	req += "\n\nProduced patch (internal use only):\n" + limitPatch(producedPatch)
 
-	resp, err := specSession.SendAndWait(ctx, sdk.MessageOptions{Prompt: req})
-	if err != nil {
-		return IntentGapResult{}, err
-	}
-
-	text := ""
-	if resp != nil && resp.Data.Content != nil {
-		text = strings.TrimSpace(*resp.Data.Content)
+func (m *Manager) RunCoder(ctx context.Context, workingDir, candidatePrompt string) (CoderResult, error) {
+	sandboxed := m.sandbox != nil && m.sandbox.Kind() != sandbox.KindHost
+	guard := newFSGuard(workingDir, sandboxed, m.log)
+	data := llm.CoderTemplateData{Request: candidatePrompt}
+	if sandboxed {
+		data.SandboxTool = sandboxToolName
 	}
-	jsonBlob, err := extractJSONObject(text)
+	prompt, err := m.templates.Coder(data)
 	if err != nil {
-		return IntentGapResult{}, err
-	}
-	var out IntentGapResult
-	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
-		return IntentGapResult{}, err
-	}
-
-	filtered := make([]string, 0, len(out.Gaps))
-	for _, g := range out.Gaps {
-		g = strings.TrimSpace(g)
-		if g == "" {
-			continue
-		}
-		if strings.Contains(g, "```") || strings.Contains(g, "`") {
-			continue
-		}
-		filtered = append(filtered, g)
-		if len(filtered) >= maxItems {
-			break
-		}
-	}
-	out.Gaps = filtered
-	return out, nil
-}
-
-func (m *Manager) RunCoder(ctx context.Context, workingDir, candidatePrompt string) (CoderResult, error) {
-	permissionHandler := func(request sdk.PermissionRequest, invocation sdk.PermissionInvocation) (sdk.PermissionRequestResult, error) {
-		return sdk.PermissionRequestResult{Kind: "approved"}, nil
+		return CoderResult{}, err
 	}
 
 	config := &sdk.SessionConfig{
-		Model:               m.model,
-		ReasoningEffort:     defaultReasoningEffort,
-		OnPermissionRequest: permissionHandler,
+		Model:               m.coderModel,
+		ReasoningEffort:     m.coderEffort,
+		OnPermissionRequest: guard.handle,
 		WorkingDirectory:    workingDir,
 		InfiniteSessions:    &sdk.InfiniteSessionConfig{Enabled: sdk.Bool(false)},
 	}
+	if sandboxed {
+		config.ExcludedTools = hostShellTools
+		config.Tools = []sdk.Tool{sandboxCommandTool(ctx, m.sandbox, workingDir)}
+	}
 
 	session, err := m.client.CreateSession(ctx, config)
 	if err != nil {
-		return CoderResult{}, fmt.Errorf("create coder session: %w", err)
+		return CoderResult{}, &llm.CallError{Op: "create coder session", Err: err}
 	}
 	defer func() {
-		if err := session.Destroy(); err != nil && m.verbose {
-			fmt.Printf("warning: failed to destroy coder session: %v\n", err)
+		if err := session.Destroy(); err != nil {
+			m.log.Warn("failed to destroy coder session", "error", err)
 		}
 	}()
 
-	if m.verbose {
+	if m.log.Enabled(ctx, slog.LevelDebug) {
 		session.On(func(event sdk.SessionEvent) {
 			if event.Type == sdk.ToolExecutionComplete && event.Data.ToolName != nil {
-				fmt.Printf("[coder] tool finished: %s\n", *event.Data.ToolName)
+				m.log.Debug("coder tool finished", "tool", *event.Data.ToolName)
 			}
 		})
 	}
 
-	prompt := strings.TrimSpace(`You are implementing a design/spec request in this repository checked out at a parent commit.
-Apply only the requested behavior with minimal unrelated edits.
-Use best effort to run relevant tests before finishing.
-`) + "\n\n" + candidatePrompt
+	if llm.HasToolObserver(ctx) {
+		session.On(func(event sdk.SessionEvent) {
+			if event.Type == sdk.ToolExecutionStart && event.Data.ToolName != nil {
+				llm.ReportTool(ctx, *event.Data.ToolName, toolDetail(event.Data.Arguments))
+			}
+		})
+	}
 
 	resp, err := session.SendAndWait(ctx, sdk.MessageOptions{Prompt: prompt})
 	if err != nil {
-		return CoderResult{}, fmt.Errorf("coder send: %w", err)
+		return CoderResult{}, &llm.CallError{Op: "coder send", Err: err}
 	}
 
 	final := ""
@@ -279,154 +195,69 @@ Use best effort to run relevant tests before finishing.
 	return CoderResult{FinalMessage: final}, nil
 }
 
-func buildSpecWriterPrompt(req GenerateSpecRequest) string {
-	b := strings.Builder{}
-	b.WriteString("You are SpecWriter. Produce ONE high-level design/spec request that could plausibly lead to the target commit.\n")
-	b.WriteString("Output STRICT JSON only with keys candidatePrompt, rationale, scopeHints.\n")
-	b.WriteString("Return plain JSON object only. No markdown wrappers.\n")
-	b.WriteString("candidatePrompt must be plain English prose, no code or command-like content.\n")
-	b.WriteString("Hard prohibitions for candidatePrompt: no code blocks, no inline code, no diffs, no shell commands, no stack traces, no compiler logs.\n")
-	b.WriteString("Do not mention issue numbers, PR numbers, tickets, or references like #123.\n")
-	b.WriteString("It must include: problem context, desired behavior, constraints/non-goals, and acceptance criteria.\n")
-	b.WriteString("Format candidatePrompt as markdown with exactly these top-level sections in order:\n")
-	b.WriteString("# Context\n# Desired Outcomes\n# Constraints and Non-Goals\n# Acceptance Criteria\n")
-	b.WriteString("Keep it concise and human-like. Avoid long enumerations of tiny edits.\n")
-	if strings.TrimSpace(req.Style) != "" {
-		b.WriteString("Style focus: ")
-		b.WriteString(strings.TrimSpace(req.Style))
-		b.WriteString(".\n")
-	}
-	if req.MaxLength > 0 {
-		b.WriteString(fmt.Sprintf("Keep prompt length <= %d characters.\n", req.MaxLength))
-	}
-	b.WriteString("Prefer concise language and avoid over-specifying micro-steps.\n")
-	b.WriteString(fmt.Sprintf("Use at most %d natural file-path references.\n", req.MaxPathRefs))
-	b.WriteString("scopeHints must be a JSON array of short strings.\n")
-	b.WriteString("Avoid low-level step-by-step micro-edit instructions.\n")
-	b.WriteString("\nContext packet:\n")
-	b.WriteString(req.FeedbackText)
-	b.WriteString("\n")
-
-	if req.PreviousPrompt != "" {
-		b.WriteString("Previous candidate prompt summary: present. Improve realism and technical alignment without becoming diff-like.\n")
-	}
-	if req.PreviousOutcome != "" {
-		b.WriteString("Previous outcome: ")
-		b.WriteString(req.PreviousOutcome)
-		b.WriteString("\n")
-	}
-	if req.ViolationReason != "" {
-		b.WriteString("Validation failure to fix: ")
-		b.WriteString(req.ViolationReason)
-		b.WriteString("\n")
+// toolDetail picks the path or command out of a tool call's arguments, on
+// one line.
+func toolDetail(args any) string {
+	m, ok := args.(map[string]any)
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"path", "file_path", "command", "pattern", "url"} {
+		if v, ok := m[key].(string); ok && strings.TrimSpace(v) != "" {
+			v, _, _ = strings.Cut(strings.TrimSpace(v), "\n")
+			if r := []rune(v); len(r) > maxToolDetail {
+				v = string(r[:maxToolDetail]) + "..."
+			}
+			return v
+		}
 	}
-
-	b.WriteString("\nReturn only valid JSON.\n")
-	return b.String()
+	return ""
 }
 
-func parseSpecCandidateJSON(raw string) (SpecCandidate, error) {
-	jsonBlob, err := extractJSONObject(raw)
-	if err != nil {
-		return SpecCandidate{}, fmt.Errorf("extract specwriter json: %w", err)
-	}
-
-	type candidateStrict struct {
-		CandidatePrompt string          `json:"candidatePrompt"`
-		Rationale       string          `json:"rationale"`
-		ScopeHints      json.RawMessage `json:"scopeHints"`
-	}
-	var strict candidateStrict
-	if err := json.Unmarshal([]byte(jsonBlob), &strict); err != nil {
-		return SpecCandidate{}, fmt.Errorf("parse specwriter json: %w", err)
+func orDefault(v, def string) string {
+	if v = strings.TrimSpace(v); v != "" {
+		return v
 	}
+	return def
+}
 
-	out := SpecCandidate{
-		CandidatePrompt: strict.CandidatePrompt,
-		Rationale:       strict.Rationale,
-	}
+// Backend implements llm.Provider with Copilot: roles go through Chat and the
+// coder runs as a native Copilot agent session.
+type Backend struct {
+	*Manager
+	Chat llm.ChatProvider
+}
 
-	if len(strict.ScopeHints) > 0 && string(strict.ScopeHints) != "null" {
-		var arr []string
-		if err := json.Unmarshal(strict.ScopeHints, &arr); err == nil {
-			out.ScopeHints = arr
-		} else {
-			var single string
-			if err := json.Unmarshal(strict.ScopeHints, &single); err == nil {
-				single = strings.TrimSpace(single)
-				if single != "" {
-					if strings.Contains(single, ",") {
-						for _, part := range strings.Split(single, ",") {
-							part = strings.TrimSpace(part)
-							if part != "" {
-								out.ScopeHints = append(out.ScopeHints, part)
-							}
-						}
-					} else {
-						out.ScopeHints = []string{single}
-					}
-				}
-			}
-		}
+func (b *Backend) chat() (llm.ChatProvider, error) {
+	if b.Chat == nil {
+		return nil, fmt.Errorf("copilot backend has no chat session")
 	}
+	return b.Chat, nil
+}
 
-	out.CandidatePrompt = strings.TrimSpace(out.CandidatePrompt)
-	out.Rationale = strings.TrimSpace(out.Rationale)
-	if out.CandidatePrompt == "" {
-		return SpecCandidate{}, fmt.Errorf("candidatePrompt is empty")
-	}
-	if out.Rationale == "" {
-		out.Rationale = "Prompt focuses on behavioral outcomes and acceptance criteria."
+func (b *Backend) GenerateSpec(ctx context.Context, req llm.GenerateSpecRequest) (llm.SpecCandidate, string, error) {
+	chat, err := b.chat()
+	if err != nil {
+		return llm.SpecCandidate{}, "", err
 	}
-	if out.ScopeHints == nil {
-		out.ScopeHints = []string{}
+	if req.Templates == nil {
+		req.Templates = b.templates
 	}
-	return out, nil
+	return llm.GenerateSpecCandidate(ctx, chat, req)
 }
 
-func extractJSONObject(raw string) (string, error) {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return "", fmt.Errorf("empty response")
-	}
-
-	start := strings.Index(raw, "{")
-	if start < 0 {
-		return "", fmt.Errorf("no json object start found")
+func (b *Backend) Judge(ctx context.Context, candidatePrompt string) (llm.JudgeResult, error) {
+	chat, err := b.chat()
+	if err != nil {
+		return llm.JudgeResult{}, err
 	}
+	return llm.JudgeRealism(ctx, chat, b.templates, candidatePrompt)
+}
 
-	inString := false
-	escape := false
-	depth := 0
-	for i := start; i < len(raw); i++ {
-		ch := raw[i]
-		if inString {
-			if escape {
-				escape = false
-				continue
-			}
-			if ch == '\\' {
-				escape = true
-				continue
-			}
-			if ch == '"' {
-				inString = false
-			}
-			continue
-		}
-
-		switch ch {
-		case '"':
-			inString = true
-		case '{':
-			depth++
-		case '}':
-			depth--
-			if depth == 0 {
-				return raw[start : i+1], nil
-			}
-		}
+func (b *Backend) SummarizeGap(ctx context.Context, targetPatch, producedPatch string, maxItems int) (llm.IntentGapResult, error) {
+	chat, err := b.chat()
+	if err != nil {
+		return llm.IntentGapResult{}, err
 	}
-
-	return "", fmt.Errorf("unterminated json object")
+	return llm.SummarizeIntentGap(ctx, chat, targetPatch, producedPatch, maxItems)
 }
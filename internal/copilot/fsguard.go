@@ -0,0 +1,147 @@
+package copilot
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sdk "github.com/github/copilot-sdk/go"
+)
+
+const (
+	permissionApproved = "approved"
+	permissionDenied   = "denied-by-rules"
+)
+
+// fsGuard answers the coder's permission requests. File writes that name a
+// path outside the worktree or inside its .git are denied, as are writes
+// that name no path at all, and other out-of-tree access is logged. With a
+// sandbox, the host shell is denied as well. Without one, shell commands run
+// on the host and can write anywhere the user can: the guard cannot tell what
+// a command touches, so every one is approved and logged as possible
+// out-of-tree access.
+type fsGuard struct {
+	root      string
+	sandboxed bool
+	log       *slog.Logger
+}
+
+func newFSGuard(workingDir string, sandboxed bool, log *slog.Logger) fsGuard {
+	root, err := filepath.Abs(workingDir)
+	if err == nil {
+		root = resolveExisting(root)
+	}
+	return fsGuard{root: root, sandboxed: sandboxed, log: log}
+}
+
+func (g fsGuard) handle(request sdk.PermissionRequest, _ sdk.PermissionInvocation) (sdk.PermissionRequestResult, error) {
+	if request.Kind == "shell" {
+		if g.sandboxed {
+			return sdk.PermissionRequestResult{Kind: permissionDenied}, nil
+		}
+		g.log.Warn("coder shell command on the host, possibly outside worktree", "command", shellCommand(request.Extra), "worktree", g.root)
+		return sdk.PermissionRequestResult{Kind: permissionApproved}, nil
+	}
+	if request.Kind == "write" && len(requestPaths(request.Extra)) == 0 {
+		g.log.Warn("coder write without a path denied", "kind", request.Kind, "worktree", g.root)
+		return sdk.PermissionRequestResult{Kind: permissionDenied}, nil
+	}
+	outside, inGit := g.check(request.Extra)
+	if request.Kind == "write" && (len(outside) > 0 || len(inGit) > 0) {
+		g.log.Warn("coder write outside worktree denied", "kind", request.Kind, "paths", append(outside, inGit...), "worktree", g.root)
+		return sdk.PermissionRequestResult{Kind: permissionDenied}, nil
+	}
+	if len(outside) > 0 {
+		g.log.Info("coder access outside worktree", "kind", request.Kind, "paths", outside, "worktree", g.root)
+	}
+	return sdk.PermissionRequestResult{Kind: permissionApproved}, nil
+}
+
+// check returns the paths of a request that resolve outside the worktree and
+// those inside its .git. Requests name paths in kind-specific fields, so
+// every string field whose name mentions a path or file is checked.
+func (g fsGuard) check(extra map[string]any) (outside, inGit []string) {
+	for _, p := range requestPaths(extra) {
+		full := p
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(g.root, full)
+		}
+		rel, err := filepath.Rel(g.root, resolveExisting(filepath.Clean(full)))
+		switch {
+		case err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)):
+			outside = append(outside, p)
+		case rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)):
+			inGit = append(inGit, p)
+		}
+	}
+	return outside, inGit
+}
+
+func requestPaths(extra map[string]any) []string {
+	var out []string
+	for k, v := range extra {
+		key := strings.ToLower(k)
+		if !strings.Contains(key, "path") && !strings.Contains(key, "file") {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			if strings.TrimSpace(v) != "" {
+				out = append(out, v)
+			}
+		case []any:
+			for _, item := range v {
+				if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+					out = append(out, s)
+				}
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// shellCommand is the command text of a shell request, whose field name
+// varies between Copilot CLI versions.
+func shellCommand(extra map[string]any) string {
+	for _, key := range []string{"fullCommandText", "command", "commands"} {
+		switch v := extra[key].(type) {
+		case string:
+			if strings.TrimSpace(v) != "" {
+				return v
+			}
+		case []any:
+			var parts []string
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					parts = append(parts, s)
+				}
+			}
+			if len(parts) > 0 {
+				return strings.Join(parts, "; ")
+			}
+		}
+	}
+	return ""
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of path,
+// so a link inside the worktree cannot point a write outside it.
+func resolveExisting(path string) string {
+	rest := ""
+	for cur := path; ; cur = filepath.Dir(cur) {
+		if _, err := os.Lstat(cur); err == nil {
+			if resolved, err := filepath.EvalSymlinks(cur); err == nil {
+				return filepath.Join(resolved, rest)
+			}
+			return path
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(cur), rest)
+	}
+}
@@ -0,0 +1,52 @@
+package copilot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	sdk "github.com/github/copilot-sdk/go"
+	"github.com/igolaizola/retrospec/internal/sandbox"
+)
+
+const (
+	sandboxToolName       = "run_command"
+	sandboxCommandTimeout = 10 * time.Minute
+	sandboxMaxOutput      = 30000
+)
+
+// hostShellTools are the built-in CLI tools that run commands on the host.
+var hostShellTools = []string{"bash", "read_bash", "write_bash", "stop_bash", "list_bash", "powershell", "read_powershell", "write_powershell", "stop_powershell", "list_powershell", "shell"}
+
+type sandboxCommandParams struct {
+	Command string `json:"command" jsonschema:"shell command to run from the repository root"`
+}
+
+// sandboxCommandTool runs coder shell commands through sb with the worktree
+// as the working directory, and returns their combined output.
+func sandboxCommandTool(ctx context.Context, sb sandbox.Sandbox, workingDir string) sdk.Tool {
+	return sdk.DefineTool(sandboxToolName, "Run a shell command in an isolated container with the repository mounted as the working directory. Returns the exit status and combined output.",
+		func(p sandboxCommandParams, _ sdk.ToolInvocation) (string, error) {
+			cctx, cancel := context.WithTimeout(ctx, sandboxCommandTimeout)
+			defer cancel()
+			cmd := sb.Command(cctx, workingDir, "sh", "-c", p.Command)
+			var out bytes.Buffer
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			err := cmd.Run()
+
+			text := out.String()
+			if len(text) > sandboxMaxOutput {
+				text = "[output truncated]\n" + text[len(text)-sandboxMaxOutput:]
+			}
+			status := "exit 0"
+			switch {
+			case cctx.Err() == context.DeadlineExceeded:
+				status = fmt.Sprintf("timed out after %s", sandboxCommandTimeout)
+			case err != nil:
+				status = err.Error()
+			}
+			return status + "\n" + text, nil
+		})
+}
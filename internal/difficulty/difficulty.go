@@ -0,0 +1,108 @@
+package difficulty
+
+import (
+	"math"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	BucketEasy   = "easy"
+	BucketMedium = "medium"
+	BucketHard   = "hard"
+)
+
+type Estimate struct {
+	FilesTouched int      `json:"filesTouched"`
+	LinesChanged int      `json:"linesChanged"`
+	Languages    []string `json:"languages,omitempty"`
+	Entropy      float64  `json:"entropy"`
+	HasTests     bool     `json:"hasTests"`
+	Score        float64  `json:"score"`
+	Bucket       string   `json:"bucket"`
+}
+
+// Assess computes a cheap, LLM-free difficulty estimate for reproducing the
+// target change. Score is in [0,1]; larger, more spread out, multi-language
+// changes that also carry tests are harder to reconstruct.
+func Assess(target git.DiffSnapshot) Estimate {
+	est := Estimate{
+		FilesTouched: len(target.ChangedFiles),
+		Languages:    feedback.DetectLanguages(target),
+	}
+
+	perFile := make([]int, 0, len(target.FileStats))
+	for _, st := range target.FileStats {
+		n := st.Added + st.Removed
+		est.LinesChanged += n
+		perFile = append(perFile, n)
+	}
+	est.Entropy = normalizedEntropy(perFile)
+
+	for _, p := range target.ChangedFiles {
+		lp := strings.ToLower(p)
+		if strings.Contains(lp, "_test.") || strings.Contains(lp, "/test") || strings.HasPrefix(lp, "test") || strings.Contains(lp, ".spec.") {
+			est.HasTests = true
+			break
+		}
+	}
+
+	files := math.Min(1, math.Log2(1+float64(est.FilesTouched))/math.Log2(31))
+	lines := math.Min(1, math.Log10(1+float64(est.LinesChanged))/3)
+	langs := math.Min(1, float64(maxInt(0, len(est.Languages)-1))/3)
+	tests := 0.0
+	if est.HasTests {
+		tests = 1
+	}
+	est.Score = clamp01(0.3*files + 0.3*lines + 0.15*est.Entropy + 0.15*langs + 0.1*tests)
+	est.Bucket = BucketFor(est.Score)
+	return est
+}
+
+func BucketFor(score float64) string {
+	switch {
+	case score < 0.33:
+		return BucketEasy
+	case score < 0.66:
+		return BucketMedium
+	default:
+		return BucketHard
+	}
+}
+
+// normalizedEntropy measures how evenly changed lines are spread across files,
+// from 0 (single file) to 1 (perfectly even spread).
+func normalizedEntropy(counts []int) float64 {
+	if len(counts) < 2 {
+		return 0
+	}
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	h := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return clamp01(h / math.Log2(float64(len(counts))))
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+package feedback
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+// maxDependencyNotes caps the dependency changes and gaps in a packet.
+const maxDependencyNotes = 10
+
+var (
+	goModRequireRe = regexp.MustCompile(`^(?:require\s+)?([^\s()]+)\s+(v[^\s]+)`)
+	jsonDepRe      = regexp.MustCompile(`^"([^"]+)"\s*:\s*"([^"]*)"\s*,?$`)
+	tomlDepRe      = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*("[^"]*"|\{.*\})$`)
+)
+
+// Keys of package.json and Cargo.toml that hold package metadata rather than
+// dependencies.
+var manifestMetaKeys = map[string]bool{
+	"name": true, "version": true, "description": true, "license": true, "main": true,
+	"module": true, "types": true, "type": true, "private": true, "homepage": true,
+	"repository": true, "author": true, "authors": true, "edition": true, "readme": true,
+	"rust-version": true, "publish": true, "documentation": true, "build": true,
+	"resolver": true, "members": true, "keywords": true, "categories": true,
+}
+
+// DependencyChange is a dependency added, removed, or updated in a manifest.
+type DependencyChange struct {
+	Manifest string `json:"manifest"`
+	Name     string `json:"name"`
+	Op       string `json:"op"`
+}
+
+func (d DependencyChange) String() string {
+	return fmt.Sprintf("%s %s (%s)", d.Op, d.Name, d.Manifest)
+}
+
+func isManifest(p string) bool {
+	switch path.Base(p) {
+	case "go.mod", "package.json", "Cargo.toml":
+		return true
+	}
+	return false
+}
+
+// DependencyChanges lists the dependencies changed in the go.mod,
+// package.json, and Cargo.toml files of a snapshot. Lines are matched
+// without parsing the manifests, so package.json and Cargo.toml entries
+// outside dependency sections can slip in unless they are known metadata.
+func DependencyChanges(snapshot git.DiffSnapshot) []DependencyChange {
+	type key struct{ manifest, name string }
+	added := map[key]bool{}
+	removed := map[key]bool{}
+
+	current := ""
+	for _, raw := range strings.Split(snapshot.Patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		if strings.HasPrefix(line, "diff --git ") {
+			current = git.DiffHeaderPath(line)
+			continue
+		}
+		if current == "" || !isManifest(current) || strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		if !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-") {
+			continue
+		}
+		name := dependencyName(path.Base(current), strings.TrimSpace(line[1:]))
+		if name == "" {
+			continue
+		}
+		k := key{current, name}
+		if line[0] == '+' {
+			added[k] = true
+		} else {
+			removed[k] = true
+		}
+	}
+
+	var out []DependencyChange
+	for k := range added {
+		op := "added"
+		if removed[k] {
+			op = "updated"
+		}
+		out = append(out, DependencyChange{Manifest: k.manifest, Name: k.name, Op: op})
+	}
+	for k := range removed {
+		if !added[k] {
+			out = append(out, DependencyChange{Manifest: k.manifest, Name: k.name, Op: "removed"})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Manifest != out[j].Manifest {
+			return out[i].Manifest < out[j].Manifest
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func dependencyName(manifest, line string) string {
+	switch manifest {
+	case "go.mod":
+		if strings.HasPrefix(line, "module ") || strings.HasPrefix(line, "go ") || strings.HasPrefix(line, "toolchain ") {
+			return ""
+		}
+		if m := goModRequireRe.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	case "package.json":
+		if m := jsonDepRe.FindStringSubmatch(line); m != nil && !manifestMetaKeys[m[1]] {
+			return m[1]
+		}
+	case "Cargo.toml":
+		if m := tomlDepRe.FindStringSubmatch(line); m != nil && !manifestMetaKeys[m[1]] {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// dependencyGaps lists the dependency changes of the target the produced
+// change does not make, and the ones it makes that the target does not. An
+// update counts for an update only, so a version bump instead of an addition
+// is still reported.
+func dependencyGaps(target, produced []DependencyChange) []string {
+	tset := map[DependencyChange]bool{}
+	for _, d := range target {
+		tset[d] = true
+	}
+	pset := map[DependencyChange]bool{}
+	for _, d := range produced {
+		pset[d] = true
+	}
+	var out []string
+	for _, d := range target {
+		if !pset[d] {
+			out = append(out, fmt.Sprintf("target %s dependency %s in %s but produced change does not", d.Op, d.Name, d.Manifest))
+		}
+	}
+	for _, d := range produced {
+		if !tset[d] {
+			out = append(out, fmt.Sprintf("produced change %s dependency %s in %s, which the target does not", d.Op, d.Name, d.Manifest))
+		}
+	}
+	return out
+}
+
+func limitDependencyNotes(notes []string) []string {
+	if n := len(notes) - maxDependencyNotes; n > 0 {
+		notes = append(notes[:maxDependencyNotes:maxDependencyNotes], fmt.Sprintf("and %d more", n))
+	}
+	return notes
+}
+
+func dependencyStrings(deps []DependencyChange) []string {
+	out := make([]string, 0, len(deps))
+	for _, d := range deps {
+		out = append(out, d.String())
+	}
+	return out
+}
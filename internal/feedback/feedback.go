@@ -10,22 +10,44 @@ import (
 	"github.com/igolaizola/retrospec/internal/scoring"
 )
 
+var (
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	trackerURLRe  = regexp.MustCompile(`https?://\S*(?:/issues/|/pull/|/browse/|jira)\S*|\b[\w.-]+/[\w.-]+#\d+\b`)
+)
+
 var issueRefCleanupRe = regexp.MustCompile(`(?i)(?:^|\s)(?:#\d+|(?:issue|issues|pr|pull request|pull requests)\s*#?\d+)\b`) //nolint:lll
 
 type Packet struct {
-	Iteration             int      `json:"iteration"`
-	TargetFilesChanged    int      `json:"targetFilesChanged"`
-	ProducedFilesChanged  int      `json:"producedFilesChanged"`
-	RepresentativePaths   []string `json:"representativePaths,omitempty"`
-	LineCountSummaries    []string `json:"lineCountSummaries,omitempty"`
-	MissingFiles          []string `json:"missingFiles,omitempty"`
-	UnexpectedFiles       []string `json:"unexpectedFiles,omitempty"`
-	IntentGaps            []string `json:"intentGaps,omitempty"`
+	Iteration            int      `json:"iteration"`
+	TargetFilesChanged   int      `json:"targetFilesChanged"`
+	ProducedFilesChanged int      `json:"producedFilesChanged"`
+	RepresentativePaths  []string `json:"representativePaths,omitempty"`
+	LineCountSummaries   []string `json:"lineCountSummaries,omitempty"`
+	MissingFiles         []string `json:"missingFiles,omitempty"`
+	UnexpectedFiles      []string `json:"unexpectedFiles,omitempty"`
+	// The More counts are entries left out of the lists above.
+	LineCountMore       int `json:"lineCountMore,omitempty"`
+	MissingFilesMore    int `json:"missingFilesMore,omitempty"`
+	UnexpectedFilesMore int `json:"unexpectedFilesMore,omitempty"`
+	// ChangeSize flags a produced change far larger or smaller than the
+	// target.
+	ChangeSize string   `json:"changeSize,omitempty"`
+	IntentGaps []string `json:"intentGaps,omitempty"`
+	// SharedGaps are intent gaps every attempt summarized with this one has,
+	// so the request rather than the coder likely misses them.
+	SharedGaps []string `json:"sharedGaps,omitempty"`
+	// DependencyChanges are the target's manifest dependency changes and
+	// DependencyGaps how the produced change differs from them.
+	DependencyChanges     []string `json:"dependencyChanges,omitempty"`
+	DependencyGaps        []string `json:"dependencyGaps,omitempty"`
 	TargetIntentSignals   []string `json:"targetIntentSignals,omitempty"`
 	ProducedIntentSignals []string `json:"producedIntentSignals,omitempty"`
 	TestCategory          string   `json:"testCategory,omitempty"`
 	TechSummary           string   `json:"techSummary,omitempty"`
 	ExtraNotes            []string `json:"extraNotes,omitempty"`
+	// Background is sanitized context from outside the repository, such as
+	// the pull request description and linked issues.
+	Background []string `json:"background,omitempty"`
 }
 
 func BuildInitialPacket(iteration int, target git.DiffSnapshot, commitMessage string, maxPathRefs int) Packet {
@@ -41,6 +63,7 @@ func BuildInitialPacket(iteration int, target git.DiffSnapshot, commitMessage st
 		TargetFilesChanged:  len(target.ChangedFiles),
 		RepresentativePaths: reps,
 		TargetIntentSignals: intents,
+		DependencyChanges:   limitDependencyNotes(dependencyStrings(DependencyChanges(target))),
 		ExtraNotes:          notes,
 	}
 }
@@ -52,23 +75,33 @@ func BuildIterationPacket(iteration int, target, produced git.DiffSnapshot, tech
 	tIntents := InferIntents(target)
 	pIntents := InferIntents(produced)
 	gaps := summarizeIntentGap(tIntents, pIntents)
+	tDeps := DependencyChanges(target)
+	depGaps := dependencyGaps(tDeps, DependencyChanges(produced))
 
 	techSummary := fmt.Sprintf(
-		"file overlap %.2f, diff similarity %.2f, line F1 %.2f",
+		"file overlap %.2f, diff similarity %.2f, hunk alignment %.2f, line F1 %.2f",
 		tech.FileJaccard,
 		tech.DiffSimilarity,
+		tech.HunkSimilarity,
 		tech.LineF1,
 	)
 
+	lineCounts := buildLineCountSummaries(tech.PerFile, maxPaths*2)
 	return Packet{
 		Iteration:             iteration,
 		TargetFilesChanged:    len(target.ChangedFiles),
 		ProducedFilesChanged:  len(produced.ChangedFiles),
 		RepresentativePaths:   limitSorted(target.ChangedFiles, maxPaths),
-		LineCountSummaries:    buildLineCountSummaries(tech.PerFile, maxPaths*2),
+		LineCountSummaries:    lineCounts,
+		LineCountMore:         len(tech.PerFile) + tech.PerFileOmitted - len(lineCounts),
 		MissingFiles:          limitSorted(missing, maxPaths*2),
+		MissingFilesMore:      more(missing, maxPaths*2),
 		UnexpectedFiles:       limitSorted(extra, maxPaths*2),
+		UnexpectedFilesMore:   more(extra, maxPaths*2),
+		ChangeSize:            changeSize(tech),
 		IntentGaps:            gaps,
+		DependencyChanges:     limitDependencyNotes(dependencyStrings(tDeps)),
+		DependencyGaps:        limitDependencyNotes(depGaps),
 		TargetIntentSignals:   tIntents,
 		ProducedIntentSignals: pIntents,
 		TestCategory:          testCategory,
@@ -76,6 +109,22 @@ func BuildIterationPacket(iteration int, target, produced git.DiffSnapshot, tech
 	}
 }
 
+// changeSize compares the produced change with the target by files and
+// changed lines. A factor of 3 either way, beyond a few files or lines of
+// slack, is reported so the SpecWriter can widen or narrow the scope.
+func changeSize(tech scoring.TechScore) string {
+	tLines := tech.TargetTotalAdds + tech.TargetTotalDels
+	pLines := tech.ProducedTotalAdds + tech.ProducedTotalDels
+	sizes := fmt.Sprintf("%d files, %d lines vs %d files, %d lines in the target", tech.ProducedFiles, pLines, tech.TargetFiles, tLines)
+	switch {
+	case tech.ProducedFiles > 3*tech.TargetFiles+5 || pLines > 3*tLines+50:
+		return "produced change is much larger than the target (" + sizes + "); the request may invite unrelated edits"
+	case tech.ProducedFiles > 0 && (3*tech.ProducedFiles+5 < tech.TargetFiles || 3*pLines+50 < tLines):
+		return "produced change is much smaller than the target (" + sizes + "); the request may leave out parts of the change"
+	}
+	return ""
+}
+
 func PacketText(p Packet) string {
 	var b strings.Builder
 
@@ -90,14 +139,17 @@ func PacketText(p Packet) string {
 	if p.TechSummary != "" {
 		fmt.Fprintf(&b, "Similarity summary: %s\n", p.TechSummary)
 	}
+	if p.ChangeSize != "" {
+		fmt.Fprintf(&b, "Change size: %s\n", p.ChangeSize)
+	}
 	if len(p.LineCountSummaries) > 0 {
-		fmt.Fprintf(&b, "Line count summary by path: %s\n", strings.Join(p.LineCountSummaries, " | "))
+		fmt.Fprintf(&b, "Line count summary by path: %s%s\n", strings.Join(p.LineCountSummaries, " | "), andMore(p.LineCountMore))
 	}
 	if len(p.MissingFiles) > 0 {
-		fmt.Fprintf(&b, "Missing paths in produced change: %s\n", strings.Join(p.MissingFiles, ", "))
+		fmt.Fprintf(&b, "Missing paths in produced change: %s%s\n", strings.Join(p.MissingFiles, ", "), andMore(p.MissingFilesMore))
 	}
 	if len(p.UnexpectedFiles) > 0 {
-		fmt.Fprintf(&b, "Unexpected produced paths: %s\n", strings.Join(p.UnexpectedFiles, ", "))
+		fmt.Fprintf(&b, "Unexpected produced paths: %s%s\n", strings.Join(p.UnexpectedFiles, ", "), andMore(p.UnexpectedFilesMore))
 	}
 	if len(p.TargetIntentSignals) > 0 {
 		fmt.Fprintf(&b, "Target intent signals: %s\n", strings.Join(p.TargetIntentSignals, "; "))
@@ -108,12 +160,24 @@ func PacketText(p Packet) string {
 	if len(p.IntentGaps) > 0 {
 		fmt.Fprintf(&b, "Intent gaps: %s\n", strings.Join(p.IntentGaps, "; "))
 	}
+	if len(p.SharedGaps) > 0 {
+		fmt.Fprintf(&b, "Intent gaps shared by the top attempts (the request likely never conveys them): %s\n", strings.Join(p.SharedGaps, "; "))
+	}
+	if len(p.DependencyChanges) > 0 {
+		fmt.Fprintf(&b, "Target dependency changes: %s\n", strings.Join(p.DependencyChanges, "; "))
+	}
+	if len(p.DependencyGaps) > 0 {
+		fmt.Fprintf(&b, "Dependency gaps: %s\n", strings.Join(p.DependencyGaps, "; "))
+	}
 	if p.TestCategory != "" {
 		fmt.Fprintf(&b, "Tests status category: %s\n", p.TestCategory)
 	}
 	for _, note := range p.ExtraNotes {
 		fmt.Fprintf(&b, "Note: %s\n", note)
 	}
+	for _, note := range p.Background {
+		fmt.Fprintf(&b, "Background: %s\n", note)
+	}
 
 	return strings.TrimSpace(b.String())
 }
@@ -137,6 +201,35 @@ func InferIntents(snapshot git.DiffSnapshot) []string {
 		}
 	}
 
+	for _, m := range snapshot.ModeChanges {
+		switch m.Executable() {
+		case 1:
+			intent["script made executable"] = true
+		case -1:
+			intent["executable bit removed"] = true
+		default:
+			intent["file mode changed"] = true
+		}
+	}
+	for _, l := range snapshot.Symlinks {
+		if l.New != "" {
+			intent["symlink added or retargeted"] = true
+		} else if l.Old != "" {
+			intent["symlink removed"] = true
+		}
+	}
+
+	for _, d := range DependencyChanges(snapshot) {
+		switch d.Op {
+		case "added":
+			intent["dependencies added"] = true
+		case "removed":
+			intent["dependencies removed"] = true
+		default:
+			intent["dependency versions updated"] = true
+		}
+	}
+
 	patch := strings.ToLower(snapshot.Patch)
 	if strings.Contains(patch, "new file mode") || strings.Contains(patch, "--- /dev/null") {
 		intent["new component introduced"] = true
@@ -236,6 +329,18 @@ func limitSorted(items []string, max int) []string {
 	return copyItems
 }
 
+// more is how many items limitSorted leaves out.
+func more(items []string, limit int) int {
+	return max(len(items)-max(limit, 0), 0)
+}
+
+func andMore(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", and %d more", n)
+}
+
 func sanitizeOneLine(s string) string {
 	line := strings.ReplaceAll(s, "\n", " ")
 	line = stripIssueRefs(line)
@@ -246,6 +351,19 @@ func sanitizeOneLine(s string) string {
 	return line
 }
 
+// SanitizeBackground flattens external text to one line of at most max
+// bytes, without HTML comments, links to trackers, or issue references.
+func SanitizeBackground(s string, max int) string {
+	s = htmlCommentRe.ReplaceAllString(s, " ")
+	s = trackerURLRe.ReplaceAllString(s, " ")
+	s = stripIssueRefs(strings.ReplaceAll(s, "\n", " "))
+	s = strings.Join(strings.Fields(s), " ")
+	if max > 0 && len(s) > max {
+		s = strings.ToValidUTF8(s[:max], "")
+	}
+	return s
+}
+
 func stripIssueRefs(s string) string {
 	return issueRefCleanupRe.ReplaceAllString(s, "")
 }
@@ -273,3 +391,50 @@ func minInt(a, b int) int {
 	}
 	return b
 }
+
+var languageByExt = map[string]string{
+	".go":    "go",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".py":    "python",
+	".rs":    "rust",
+	".java":  "java",
+	".kt":    "kotlin",
+	".rb":    "ruby",
+	".php":   "php",
+	".cs":    "csharp",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".swift": "swift",
+	".scala": "scala",
+	".sh":    "shell",
+}
+
+func DetectLanguages(snapshot git.DiffSnapshot) []string {
+	seen := map[string]struct{}{}
+	for _, path := range snapshot.ChangedFiles {
+		if lang := LanguageForPath(path); lang != "" {
+			seen[lang] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for lang := range seen {
+		out = append(out, lang)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func LanguageForPath(path string) string {
+	lp := strings.ToLower(path)
+	if idx := strings.LastIndex(lp, "."); idx >= 0 && !strings.Contains(lp[idx:], "/") {
+		return languageByExt[lp[idx:]]
+	}
+	return ""
+}
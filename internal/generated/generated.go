@@ -0,0 +1,214 @@
+// Package generated detects files in a diff whose content is produced by
+// tools rather than written by hand: lock files, vendored dependencies,
+// minified bundles, generated protobuf or API client code, and test
+// snapshots.
+package generated
+
+import (
+	"path"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	ReasonBinary    = "binary file"
+	ReasonGenerated = "generated file"
+	ReasonHeader    = "generation header"
+	ReasonMinified  = "minified content"
+	ReasonSnapshot  = "test snapshot"
+	ReasonPattern   = "matches generated pattern"
+
+	maxLineLength = 500
+	headerLines   = 20
+)
+
+var (
+	lockFiles = map[string]struct{}{
+		"package-lock.json": {}, "yarn.lock": {}, "pnpm-lock.yaml": {}, "go.sum": {}, "Cargo.lock": {},
+		"composer.lock": {}, "Gemfile.lock": {}, "poetry.lock": {},
+	}
+	generatedDirs     = []string{"vendor/", "node_modules/", "dist/", "build/"}
+	generatedSuffixes = []string{
+		".min.js", ".min.css", ".map", ".pb.go", ".pb.gw.go", "_pb2.py", "_pb2_grpc.py", ".pb.cc", ".pb.h",
+		"_grpc.pb.go", ".gen.go", "_gen.go", "_generated.go", ".generated.ts", ".g.dart",
+	}
+	snapshotMarkers = []string{"__snapshots__/", ".snap", "testdata/snapshots/"}
+	headerMarkers   = []string{
+		"code generated", "do not edit", "@generated", "auto-generated", "autogenerated",
+		"this file was generated", "generated by swagger", "swagger-codegen", "openapi-generator", "generated by protoc",
+	}
+)
+
+// Detector decides which files to treat as generated. Include disables
+// detection; Patterns adds path globs (matched against the full path and the
+// base name) that are always treated as generated.
+type Detector struct {
+	Include  bool
+	Patterns []string
+}
+
+// Reason returns why the file is considered generated, or "" if it is not.
+// lines are the diff lines of the file, without its "diff --git" header.
+// Binary files are always reported since their diff carries no text.
+func (d Detector) Reason(p string, lines []string) string {
+	for _, l := range lines {
+		if strings.HasPrefix(l, "GIT binary patch") || strings.HasPrefix(l, "Binary files ") {
+			return ReasonBinary
+		}
+	}
+	if d.Include {
+		return ""
+	}
+	for _, pattern := range d.Patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return ReasonPattern
+		}
+		if ok, _ := path.Match(pattern, path.Base(p)); ok {
+			return ReasonPattern
+		}
+	}
+	switch {
+	case IsSnapshotPath(p):
+		return ReasonSnapshot
+	case IsGeneratedPath(p):
+		return ReasonGenerated
+	case HasGenerationHeader(lines):
+		return ReasonHeader
+	case IsMinified(lines):
+		return ReasonMinified
+	}
+	return ""
+}
+
+// ParsePatterns splits a comma-separated list of path globs.
+func ParsePatterns(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func IsGeneratedPath(p string) bool {
+	if _, ok := lockFiles[path.Base(p)]; ok {
+		return true
+	}
+	for _, d := range generatedDirs {
+		if strings.HasPrefix(p, d) || strings.Contains(p, "/"+d) {
+			return true
+		}
+	}
+	for _, s := range generatedSuffixes {
+		if strings.HasSuffix(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func IsSnapshotPath(p string) bool {
+	for _, m := range snapshotMarkers {
+		if strings.Contains(p, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasGenerationHeader looks for a generator notice near the top of the
+// added content.
+func HasGenerationHeader(lines []string) bool {
+	seen := 0
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "+") || strings.HasPrefix(l, "+++") {
+			continue
+		}
+		lower := strings.ToLower(l)
+		for _, m := range headerMarkers {
+			if strings.Contains(lower, m) {
+				return true
+			}
+		}
+		seen++
+		if seen >= headerLines {
+			break
+		}
+	}
+	return false
+}
+
+// IsMinified reports whether most added lines are very long.
+func IsMinified(lines []string) bool {
+	long, total := 0, 0
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "+") || strings.HasPrefix(l, "+++") {
+			continue
+		}
+		total++
+		if len(l) > maxLineLength {
+			long++
+		}
+	}
+	return total > 0 && long*2 >= total
+}
+
+// FileDiff is the part of a unified diff belonging to one file.
+type FileDiff struct {
+	Path   string
+	Header string
+	Lines  []string
+}
+
+// SplitPatch splits a unified diff into per-file parts. Text before the first
+// file header is returned as a part with an empty header.
+func SplitPatch(patch string) []FileDiff {
+	var out []FileDiff
+	current := FileDiff{}
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			if current.Header != "" || len(current.Lines) > 0 {
+				out = append(out, current)
+			}
+			current = FileDiff{Header: line, Path: git.DiffHeaderPath(line)}
+			continue
+		}
+		current.Lines = append(current.Lines, line)
+	}
+	if current.Header != "" || len(current.Lines) > 0 {
+		out = append(out, current)
+	}
+	return out
+}
+
+// Strip removes the line content of generated files from the snapshot patch
+// so they do not weigh on line-level scoring. Changed files and stats are
+// kept, so touching the file still counts at file level.
+func (d Detector) Strip(snap git.DiffSnapshot) (git.DiffSnapshot, []string) {
+	if strings.TrimSpace(snap.Patch) == "" {
+		return snap, nil
+	}
+	var excluded []string
+	var b strings.Builder
+	for _, f := range SplitPatch(snap.Patch) {
+		if f.Header != "" && d.Reason(f.Path, f.Lines) != "" {
+			excluded = append(excluded, f.Path)
+			continue
+		}
+		if f.Header != "" {
+			b.WriteString(f.Header)
+			b.WriteString("\n")
+		}
+		for _, l := range f.Lines {
+			b.WriteString(l)
+			b.WriteString("\n")
+		}
+	}
+	if len(excluded) == 0 {
+		return snap, nil
+	}
+	snap.Patch = b.String()
+	return snap, excluded
+}
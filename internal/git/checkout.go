@@ -0,0 +1,343 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// skipSmudge keeps git-lfs from downloading file contents while git checks
+// a tree out. Checkouts of repositories without a remote would fail, and the
+// base clone would download the contents of every LFS file; FillCheckout
+// fills them in from local objects instead.
+var skipSmudge = []string{"GIT_LFS_SKIP_SMUDGE=1"}
+
+// maxSubmoduleDepth bounds how deep submodules of submodules are followed.
+const maxSubmoduleDepth = 8
+
+// submodule is a submodule of a commit, as declared in its .gitmodules, and
+// the commit its gitlink pins.
+type submodule struct {
+	Name   string
+	Path   string
+	URL    string
+	Commit string
+}
+
+// SubmoduleCheckout is a submodule as checkouts get it: the commit its
+// gitlink pins, the local mirror that commit is read from, and its own
+// submodules.
+type SubmoduleCheckout struct {
+	Path   string
+	Commit string
+	Mirror string
+	Nested []SubmoduleCheckout
+}
+
+// CheckoutExtras are the parts of a checkout that git leaves out of
+// worktrees and clones. Submodules are checked out as repositories holding
+// only the history up to their pinned commits, or with PlainSubmodules as
+// files with no repository at all. LFSStorage is the directory git-lfs
+// objects are read from; an empty one leaves pointer files.
+type CheckoutExtras struct {
+	Submodules      []SubmoduleCheckout
+	PlainSubmodules bool
+	LFSStorage      string
+}
+
+// Empty reports whether a checkout needs nothing filled in.
+func (e CheckoutExtras) Empty() bool {
+	return len(e.Submodules) == 0 && e.LFSStorage == ""
+}
+
+// MirrorSubmodules mirrors the submodules of commit under cacheDir, nested
+// ones included, and returns how checkouts of commit get them. Every pinned
+// commit must be in its mirror.
+func MirrorSubmodules(ctx context.Context, repoPath, commit, cacheDir string) ([]SubmoduleCheckout, error) {
+	return mirrorSubmodules(ctx, repoPath, commit, cacheDir, 1)
+}
+
+func mirrorSubmodules(ctx context.Context, repoPath, commit, cacheDir string, depth int) ([]SubmoduleCheckout, error) {
+	subs, err := submodules(ctx, repoPath, commit)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	if depth > maxSubmoduleDepth {
+		return nil, fmt.Errorf("submodules are nested more than %d levels deep", maxSubmoduleDepth)
+	}
+	var out []SubmoduleCheckout
+	for _, s := range subs {
+		mirror, err := CacheSubmodule(ctx, cacheDir, s.URL)
+		if err != nil {
+			return nil, fmt.Errorf("mirror submodule %s: %w", s.Path, err)
+		}
+		if _, err := runCmd(ctx, mirror, "git", "cat-file", "-e", s.Commit+"^{commit}"); err != nil {
+			return nil, fmt.Errorf("submodule %s: commit %s is not in %s", s.Path, s.Commit, s.URL)
+		}
+		nested, err := mirrorSubmodules(ctx, mirror, s.Commit, cacheDir, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %w", s.Path, err)
+		}
+		out = append(out, SubmoduleCheckout{Path: s.Path, Commit: s.Commit, Mirror: mirror, Nested: nested})
+	}
+	return out, nil
+}
+
+// submodules lists the submodules of commit whose paths hold a gitlink,
+// sorted by name. Relative URLs are resolved against the origin of the
+// repository, since checkouts made from it have no remote of their own.
+func submodules(ctx context.Context, repoPath, commit string) ([]submodule, error) {
+	decl, err := runCmd(ctx, repoPath, "git", "config", "--blob", commit+":.gitmodules", "-z", "--get-regexp", `^submodule\..*\.(path|url)$`)
+	if err != nil {
+		// No .gitmodules, or one without submodules.
+		return nil, nil
+	}
+	byName := map[string]*submodule{}
+	for _, entry := range strings.Split(decl, "\x00") {
+		key, value, ok := strings.Cut(entry, "\n")
+		if !ok {
+			continue
+		}
+		key = strings.TrimPrefix(key, "submodule.")
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			continue
+		}
+		name := key[:i]
+		s := byName[name]
+		if s == nil {
+			s = &submodule{Name: name}
+			byName[name] = s
+		}
+		if key[i+1:] == "path" {
+			s.Path = value
+		} else {
+			s.URL = value
+		}
+	}
+	var paths []string
+	for _, s := range byName {
+		if s.Path != "" && s.URL != "" {
+			paths = append(paths, s.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	tree, err := runCmd(ctx, repoPath, "git", append([]string{"ls-tree", "-z", commit, "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	gitlinks := map[string]string{}
+	for _, entry := range strings.Split(tree, "\x00") {
+		meta, path, ok := strings.Cut(entry, "\t")
+		if fields := strings.Fields(meta); ok && len(fields) == 3 && fields[0] == "160000" {
+			gitlinks[path] = fields[2]
+		}
+	}
+	origin, _ := OriginURL(ctx, repoPath)
+	var out []submodule
+	for _, s := range byName {
+		if gitlinks[s.Path] == "" {
+			continue
+		}
+		s.URL = resolveSubmoduleURL(origin, s.URL)
+		s.Commit = gitlinks[s.Path]
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// resolveSubmoduleURL resolves a submodule URL starting with ./ or ../
+// against the URL of the superproject, as git does: each ../ drops one
+// path component of it.
+func resolveSubmoduleURL(remote, url string) string {
+	if remote == "" || !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+	remote = strings.TrimSuffix(remote, "/")
+	sep := "/"
+	for {
+		switch {
+		case strings.HasPrefix(url, "./"):
+			url = url[2:]
+		case strings.HasPrefix(url, "../"):
+			url = url[3:]
+			i := strings.LastIndexAny(remote, "/:")
+			if i < 0 {
+				return url
+			}
+			sep = "/"
+			if remote[i] == ':' {
+				// The host of an scp-like URL such as git@host:owner/repo.
+				sep = ":"
+			}
+			remote = remote[:i]
+		default:
+			return remote + sep + url
+		}
+	}
+}
+
+// CacheSubmodule mirrors the repository at url into a directory under
+// cacheDir, or updates the mirror made by an earlier run, and returns its
+// path. Checkouts fetch the pinned commits of submodules from the mirror
+// instead of downloading them each time. The mirror holds every branch of
+// the submodule, so it is never handed to a coder as a remote.
+func CacheSubmodule(ctx context.Context, cacheDir, url string) (string, error) {
+	sum := sha256.Sum256([]byte(url))
+	path := filepath.Join(cacheDir, hex.EncodeToString(sum[:8]))
+	if _, err := os.Stat(path); err == nil {
+		if _, err := runCmd(ctx, path, "git", "fetch", "-q", "--prune", "origin"); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", fmt.Errorf("create submodule cache: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", fmt.Errorf("clean submodule cache: %w", err)
+	}
+	if _, err := runCmdEnv(ctx, "", skipSmudge, "git", "clone", "-q", "--mirror", url, tmp); err != nil {
+		return "", err
+	}
+	// Checkouts fetch a pinned commit by its ID, not by a ref.
+	if _, err := runCmd(ctx, tmp, "git", "config", "uploadpack.allowAnySHA1InWant", "true"); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("store submodule cache: %w", err)
+	}
+	return path, nil
+}
+
+// UsesLFS reports whether any .gitattributes file at commit routes files
+// through git-lfs.
+func UsesLFS(ctx context.Context, repoPath, commit string) bool {
+	_, err := runCmd(ctx, repoPath, "git", "grep", "-q", "filter=lfs", commit, "--", ".gitattributes", "**/.gitattributes")
+	return err == nil
+}
+
+// LFSAvailable reports whether git-lfs is installed.
+func LFSAvailable(ctx context.Context) bool {
+	_, err := runCmd(ctx, "", "git", "lfs", "version")
+	return err == nil
+}
+
+// FetchLFS downloads the git-lfs objects of the files at commit into the
+// repository at repoPath, from its origin, and returns the directory they
+// are stored in.
+func FetchLFS(ctx context.Context, repoPath, commit string) (string, error) {
+	if _, err := runCmd(ctx, repoPath, "git", "lfs", "fetch", "origin", commit); err != nil {
+		return "", err
+	}
+	out, err := runCmd(ctx, repoPath, "git", "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(strings.TrimSpace(out), "lfs"), nil
+}
+
+// FillCheckout completes the checkout at path with what extras holds: the
+// submodules at their pinned commits, nested ones included, and then the
+// contents of git-lfs pointer files.
+func FillCheckout(ctx context.Context, path string, extras CheckoutExtras) error {
+	if err := checkoutSubmodules(ctx, path, extras.Submodules, extras.PlainSubmodules); err != nil {
+		return err
+	}
+	if extras.LFSStorage != "" {
+		if _, err := runCmd(ctx, path, "git", "-c", "lfs.storage="+extras.LFSStorage, "lfs", "checkout"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutSubmodules checks each submodule out under dir. A repository gets
+// only the pinned commit and its history, fetched by ID from the mirror so
+// that it has no remote and no later commit of the submodule can be read. A
+// plain checkout writes the files of the pinned commit and nothing else.
+func checkoutSubmodules(ctx context.Context, dir string, subs []SubmoduleCheckout, plain bool) error {
+	for _, s := range subs {
+		path := filepath.Join(dir, s.Path)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return fmt.Errorf("create submodule %s: %w", s.Path, err)
+		}
+		if plain {
+			if err := checkoutFiles(ctx, s.Mirror, s.Commit, path); err != nil {
+				return fmt.Errorf("check out submodule %s: %w", s.Path, err)
+			}
+		} else {
+			steps := [][]string{
+				{"init", "-q"},
+				{"fetch", "-q", "--no-tags", s.Mirror, s.Commit},
+				{"checkout", "-q", "--detach", s.Commit},
+			}
+			for _, args := range steps {
+				if _, err := runCmdEnv(ctx, path, skipSmudge, "git", args...); err != nil {
+					return fmt.Errorf("check out submodule %s: %w", s.Path, err)
+				}
+			}
+			_ = os.Remove(filepath.Join(path, ".git", "FETCH_HEAD"))
+		}
+		if err := checkoutSubmodules(ctx, path, s.Nested, plain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutFiles writes the files of commit in repoPath to dir through an
+// index of its own, so checkouts made in parallel do not share one.
+func checkoutFiles(ctx context.Context, repoPath, commit, dir string) error {
+	tmp, err := os.MkdirTemp("", "retrospec-index-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	env := append([]string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}, skipSmudge...)
+	_, err = runCmdEnv(ctx, "", env, "git", "--git-dir="+repoPath, "--work-tree="+dir, "checkout", "-q", "-f", commit, "--", ".")
+	return err
+}
+
+// CheckSubmoduleIsolation verifies that the submodule repositories checked
+// out at path cannot expose anything made after the commits they pin: each
+// must be at its pinned commit, have no remote, and hold no commit outside
+// the history of that commit, which rules out a later one the target moves
+// it to. Plain submodule checkouts have no repository and pass.
+func CheckSubmoduleIsolation(ctx context.Context, path string, subs []SubmoduleCheckout) error {
+	for _, s := range subs {
+		sub := filepath.Join(path, s.Path)
+		if _, err := os.Stat(filepath.Join(sub, ".git")); err == nil {
+			head, err := runCmd(ctx, sub, "git", "rev-parse", "HEAD")
+			if err != nil {
+				return err
+			}
+			if h := strings.TrimSpace(head); h != s.Commit {
+				return fmt.Errorf("submodule %s is at %s, not at the pinned commit %s", s.Path, h, s.Commit)
+			}
+			if out, err := runCmd(ctx, sub, "git", "remote"); err == nil && strings.TrimSpace(out) != "" {
+				return fmt.Errorf("submodule %s has remotes (%s)", s.Path, strings.Join(strings.Fields(out), ", "))
+			}
+			later, err := runCmd(ctx, sub, "git", "rev-list", "--all", "--reflog", "--not", s.Commit)
+			if err != nil {
+				return err
+			}
+			if commits := strings.Fields(later); len(commits) > 0 {
+				return fmt.Errorf("submodule %s holds %d commits outside the history of %s", s.Path, len(commits), s.Commit)
+			}
+		}
+		if err := CheckSubmoduleIsolation(ctx, sub, s.Nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// PatchFingerprint hashes the normalized content of a patch so that commits
+// introducing the same change (cherry-picks, repeated bot updates) share a
+// fingerprint regardless of index lines, hunk offsets, or whitespace.
+func PatchFingerprint(patch string) string {
+	return fingerprint(patch, false)
+}
+
+// RevertFingerprint returns the fingerprint the patch would have if it were
+// reverted. A commit whose PatchFingerprint equals another commit's
+// RevertFingerprint undoes that commit.
+func RevertFingerprint(patch string) string {
+	return fingerprint(patch, true)
+}
+
+func fingerprint(patch string, invert bool) string {
+	files := map[string][]string{}
+	current := ""
+	for _, raw := range strings.Split(patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		if strings.HasPrefix(line, "diff --git ") {
+			oldPath, newPath := ParseDiffHeader(line)
+			current = newPath
+			if invert {
+				current = oldPath
+			}
+			if _, ok := files[current]; !ok {
+				files[current] = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		sign := ""
+		switch {
+		case strings.HasPrefix(line, "+"):
+			sign = "+"
+		case strings.HasPrefix(line, "-"):
+			sign = "-"
+		default:
+			continue
+		}
+		content := strings.Join(strings.Fields(line[1:]), " ")
+		if content == "" {
+			continue
+		}
+		if invert {
+			if sign == "+" {
+				sign = "-"
+			} else {
+				sign = "+"
+			}
+		}
+		files[current] = append(files[current], sign+content)
+	}
+	if len(files) == 0 {
+		return ""
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		lines := files[p]
+		sort.Strings(lines)
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+		for _, l := range lines {
+			h.Write([]byte(l))
+			h.Write([]byte{'\n'})
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -9,9 +9,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -29,15 +31,94 @@ type DiffSnapshot struct {
 	Patch        string              `json:"patch"`
 	ChangedFiles []string            `json:"changedFiles"`
 	FileStats    map[string]FileStat `json:"fileStats"`
+	// Renames maps the new path of every renamed file to its old path.
+	Renames map[string]string `json:"renames,omitempty"`
+	// ModeChanges maps every file whose mode changed, like a script made
+	// executable, to its old and new mode.
+	ModeChanges map[string]ModeChange `json:"modeChanges,omitempty"`
+	// Symlinks maps every symbolic link added, removed, or retargeted to its
+	// targets. The target of a link is not file content, so line scoring and
+	// model prompts treat these files apart.
+	Symlinks map[string]SymlinkChange `json:"symlinks,omitempty"`
+}
+
+// SymlinkMode is the git mode of a symbolic link.
+const SymlinkMode = "120000"
+
+// SymlinkChange is the target of a symbolic link before and after a change;
+// a side is empty when the path is not a link there.
+type SymlinkChange struct {
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+func (s SymlinkChange) String() string {
+	switch {
+	case s.Old == "" && s.New == "":
+		return "symlink"
+	case s.Old == "":
+		return "symlink to " + s.New
+	case s.New == "":
+		return "symlink to " + s.Old + " removed"
+	}
+	return "symlink retargeted from " + s.Old + " to " + s.New
+}
+
+// ModeChange is the old and new git mode of a file, like 100644 and 100755.
+type ModeChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+func (m ModeChange) String() string {
+	return m.Old + " => " + m.New
+}
+
+// Executable reports whether the change sets or clears the executable bit:
+// 1 when a regular file became executable, -1 when it stopped being, and 0
+// otherwise.
+func (m ModeChange) Executable() int {
+	switch {
+	case m.Old == "100644" && m.New == "100755":
+		return 1
+	case m.Old == "100755" && m.New == "100644":
+		return -1
+	}
+	return 0
 }
 
 type CommitInfo struct {
 	TargetSHA     string `json:"targetSHA"`
 	ParentSHA     string `json:"parentSHA"`
 	CommitMessage string `json:"commitMessage"`
+	// Commits lists the non-merge commits between ParentSHA and TargetSHA,
+	// oldest first, when the target spans more than one commit.
+	Commits []string `json:"commits,omitempty"`
+	// Parents lists every parent of a merge target, and Mainline is the
+	// 1-based number of the one in ParentSHA.
+	Parents  []string `json:"parents,omitempty"`
+	Mainline int      `json:"mainline,omitempty"`
+}
+
+// CloneOptions limit what PrepareBaseRepo downloads of a large repository.
+// Depth keeps that many commits of history (0 clones all of it), deepened
+// later where a target needs more, and Filter is a git object filter such as
+// blob:none, whose objects are fetched when first needed.
+type CloneOptions struct {
+	Depth  int
+	Filter string
 }
 
-func PrepareBaseRepo(ctx context.Context, repoArg, workdir string) (string, error) {
+// Partial reports whether a clone leaves out history or objects.
+func (o CloneOptions) Partial() bool {
+	return o.Depth > 0 || o.Filter != ""
+}
+
+// upstreamKey records the origin of a local source in base clones whose own
+// origin stays the local path, which partial clones fetch from later.
+const upstreamKey = "retrospec.upstream"
+
+func PrepareBaseRepo(ctx context.Context, repoArg, workdir string, opts CloneOptions) (string, error) {
 	if err := os.MkdirAll(workdir, 0o755); err != nil {
 		return "", fmt.Errorf("create workdir: %w", err)
 	}
@@ -54,13 +135,30 @@ func PrepareBaseRepo(ctx context.Context, repoArg, workdir string) (string, erro
 		return "", err
 	}
 
-	if _, err := runCmd(ctx, "", "git", "clone", "--no-hardlinks", cloneSource, base); err != nil {
+	args := []string{"clone", "--no-hardlinks"}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Filter != "" {
+		args = append(args, "--filter="+opts.Filter)
+	}
+	if localSourcePath != "" && opts.Partial() {
+		// Local clones ignore --depth and --filter unless made over file://,
+		// and a local source rarely allows filters itself.
+		cloneSource = "file://" + filepath.ToSlash(cloneSource)
+		args = append([]string{"-c", "uploadpack.allowFilter=true"}, args...)
+	}
+	if _, err := runCmdEnv(ctx, "", skipSmudge, "git", append(args, cloneSource, base)...); err != nil {
 		return "", err
 	}
 
 	if localSourcePath != "" {
 		if upstreamURL, err := readOriginRemoteURL(ctx, localSourcePath); err == nil && strings.TrimSpace(upstreamURL) != "" {
-			_, _ = runCmd(ctx, base, "git", "remote", "set-url", "origin", strings.TrimSpace(upstreamURL))
+			if opts.Partial() {
+				_, _ = runCmd(ctx, base, "git", "config", upstreamKey, strings.TrimSpace(upstreamURL))
+			} else {
+				_, _ = runCmd(ctx, base, "git", "remote", "set-url", "origin", strings.TrimSpace(upstreamURL))
+			}
 		}
 	}
 
@@ -84,6 +182,15 @@ func detectLocalSourcePath(repoArg string) string {
 	return ""
 }
 
+// OriginURL returns the URL of the origin remote of a repository, or for a
+// partial base clone of a local source, the origin of that source.
+func OriginURL(ctx context.Context, repoPath string) (string, error) {
+	if out, err := runCmd(ctx, repoPath, "git", "config", "--get", upstreamKey); err == nil && strings.TrimSpace(out) != "" {
+		return strings.TrimSpace(out), nil
+	}
+	return readOriginRemoteURL(ctx, repoPath)
+}
+
 func readOriginRemoteURL(ctx context.Context, repoPath string) (string, error) {
 	out, err := runCmd(ctx, repoPath, "git", "remote", "get-url", "origin")
 	if err != nil {
@@ -142,32 +249,150 @@ func existingLocalPath(path string) (string, bool) {
 	return abs, true
 }
 
-func ResolveCommitInfo(ctx context.Context, repoPath, targetCommit string) (CommitInfo, error) {
+// ResolveCommitInfo resolves the target commit and the parent it is diffed
+// against. A merge commit is diffed against its parent number mainline
+// (1-based, like git's -m option), or its first parent when mainline is 0,
+// so the target covers everything the merge brought into that parent.
+func ResolveCommitInfo(ctx context.Context, repoPath, targetCommit string, mainline int) (CommitInfo, error) {
 	if err := EnsureCommitAvailable(ctx, repoPath, targetCommit); err != nil {
 		return CommitInfo{}, err
 	}
 
-	target, err := runCmd(ctx, repoPath, "git", "rev-parse", strings.TrimSpace(targetCommit))
+	target, err := runCmd(ctx, repoPath, "git", "rev-parse", strings.TrimSpace(targetCommit)+"^{commit}")
 	if err != nil {
 		return CommitInfo{}, err
 	}
-	parent, err := runCmd(ctx, repoPath, "git", "rev-parse", strings.TrimSpace(targetCommit)+"^")
-	if err != nil {
-		return CommitInfo{}, fmt.Errorf("resolve parent commit (target must have a parent): %w", err)
-	}
 	msg, err := runCmd(ctx, repoPath, "git", "show", "-s", "--format=%s%n%b", strings.TrimSpace(targetCommit))
 	if err != nil {
 		return CommitInfo{}, err
 	}
-
-	return CommitInfo{
+	info := CommitInfo{
 		TargetSHA:     strings.TrimSpace(target),
-		ParentSHA:     strings.TrimSpace(parent),
 		CommitMessage: strings.TrimSpace(msg),
-	}, nil
+	}
+
+	out, err := runCmd(ctx, repoPath, "git", "rev-list", "--parents", "-n", "1", info.TargetSHA)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	parents := strings.Fields(out)
+	if len(parents) > 0 {
+		parents = parents[1:]
+	}
+	if mainline == 0 {
+		mainline = 1
+	}
+	switch {
+	case len(parents) == 0:
+		return CommitInfo{}, fmt.Errorf("resolve parent commit: %s has no parent", info.TargetSHA)
+	case mainline < 1 || mainline > len(parents):
+		return CommitInfo{}, fmt.Errorf("resolve parent commit: %s has %d parent(s), parent %d requested", info.TargetSHA, len(parents), mainline)
+	}
+	info.ParentSHA = parents[mainline-1]
+
+	if len(parents) > 1 {
+		info.Parents = parents
+		info.Mainline = mainline
+		shas, msgs, err := rangeCommits(ctx, repoPath, info.ParentSHA, info.TargetSHA)
+		if err != nil {
+			return CommitInfo{}, err
+		}
+		info.Commits = shas
+		info.CommitMessage = joinMessages(append([]string{info.CommitMessage}, msgs...))
+	}
+	return info, nil
+}
+
+// ResolveCommitRange resolves a "base..head" range. The change spans from base
+// to head, and the commit message combines every commit in the range.
+func ResolveCommitRange(ctx context.Context, repoPath, commitRange string) (CommitInfo, error) {
+	base, head, ok := strings.Cut(strings.TrimSpace(commitRange), "..")
+	base, head = strings.TrimSpace(base), strings.TrimSpace(head)
+	if !ok || base == "" || head == "" || strings.HasPrefix(head, ".") {
+		return CommitInfo{}, fmt.Errorf("invalid commit range %q (expected base..head)", commitRange)
+	}
+	for _, c := range []string{base, head} {
+		if err := EnsureCommitAvailable(ctx, repoPath, c); err != nil {
+			return CommitInfo{}, err
+		}
+	}
+
+	baseSHA, err := runCmd(ctx, repoPath, "git", "rev-parse", base+"^{commit}")
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	headSHA, err := runCmd(ctx, repoPath, "git", "rev-parse", head+"^{commit}")
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	info := CommitInfo{TargetSHA: strings.TrimSpace(headSHA), ParentSHA: strings.TrimSpace(baseSHA)}
+	if info.TargetSHA == info.ParentSHA {
+		return CommitInfo{}, fmt.Errorf("commit range %q is empty", commitRange)
+	}
+	isAncestor := func() bool {
+		_, err := runCmd(ctx, repoPath, "git", "merge-base", "--is-ancestor", info.ParentSHA, info.TargetSHA)
+		return err == nil
+	}
+	// A shallow clone may cut the range short.
+	deepen(ctx, repoPath, info.TargetSHA, 64, isAncestor)
+	if !isAncestor() {
+		return CommitInfo{}, fmt.Errorf("commit range %q: %s is not an ancestor of %s", commitRange, base, head)
+	}
+
+	shas, msgs, err := rangeCommits(ctx, repoPath, info.ParentSHA, info.TargetSHA)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	info.Commits = shas
+	info.CommitMessage = joinMessages(msgs)
+	return info, nil
+}
+
+// rangeCommits returns the SHAs and messages of the non-merge commits in
+// from..to, oldest first.
+func rangeCommits(ctx context.Context, repoPath, from, to string) ([]string, []string, error) {
+	out, err := runCmd(ctx, repoPath, "git", "log", "--reverse", "--no-merges", "--format=%H%x1f%s%n%b%x1e", from+".."+to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list commits in range: %w", err)
+	}
+	var shas, msgs []string
+	for _, rec := range strings.Split(out, "\x1e") {
+		sha, msg, ok := strings.Cut(strings.TrimSpace(rec), "\x1f")
+		if !ok {
+			continue
+		}
+		shas = append(shas, strings.TrimSpace(sha))
+		msgs = append(msgs, strings.TrimSpace(msg))
+	}
+	return shas, msgs, nil
+}
+
+func joinMessages(msgs []string) string {
+	out := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		if m = strings.TrimSpace(m); m != "" {
+			out = append(out, m)
+		}
+	}
+	return strings.Join(out, "\n\n")
 }
 
 func EnsureCommitAvailable(ctx context.Context, repoPath, commit string) error {
+	if err := fetchCommit(ctx, repoPath, commit); err != nil {
+		return err
+	}
+	// Commits at the boundary of a shallow clone look like root commits, so
+	// the history is deepened until the parents of commit are in it.
+	sha, err := runCmd(ctx, repoPath, "git", "rev-parse", strings.TrimSpace(commit)+"^{commit}")
+	if err != nil {
+		return err
+	}
+	sha = strings.TrimSpace(sha)
+	deepen(ctx, repoPath, sha, 2, func() bool { return !isShallowBoundary(ctx, repoPath, sha) })
+	return nil
+}
+
+func fetchCommit(ctx context.Context, repoPath, commit string) error {
 	commit = strings.TrimSpace(commit)
 	if commit == "" {
 		return fmt.Errorf("empty commit")
@@ -201,6 +426,49 @@ func EnsureCommitAvailable(ctx context.Context, repoPath, commit string) error {
 	return nil
 }
 
+// maxDeepen is the deepest history deepen fetches before it fetches all of
+// it.
+const maxDeepen = 4096
+
+// deepen fetches more history of commit into a shallow clone until ok holds,
+// doubling the depth from depth, and in the end the whole history.
+func deepen(ctx context.Context, repoPath, commit string, depth int, ok func() bool) {
+	for ; depth <= maxDeepen; depth *= 2 {
+		if shallow, _ := isShallowRepo(ctx, repoPath); !shallow || ok() {
+			return
+		}
+		if _, err := runCmd(ctx, repoPath, "git", "fetch", "-q", "--no-tags", "--depth="+strconv.Itoa(depth), "origin", commit); err != nil {
+			break
+		}
+	}
+	if shallow, _ := isShallowRepo(ctx, repoPath); shallow && !ok() {
+		_, _ = runCmd(ctx, repoPath, "git", "fetch", "-q", "--no-tags", "--unshallow", "origin")
+	}
+}
+
+// isShallowBoundary reports whether the parents of sha were cut off by a
+// shallow clone.
+func isShallowBoundary(ctx context.Context, repoPath, sha string) bool {
+	path, err := runCmd(ctx, repoPath, "git", "rev-parse", "--git-path", "shallow")
+	if err != nil {
+		return false
+	}
+	path = strings.TrimSpace(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Fields(string(data)) {
+		if line == sha {
+			return true
+		}
+	}
+	return false
+}
+
 func isShallowRepo(ctx context.Context, repoPath string) (bool, error) {
 	out, err := runCmd(ctx, repoPath, "git", "rev-parse", "--is-shallow-repository")
 	if err != nil {
@@ -210,15 +478,15 @@ func isShallowRepo(ctx context.Context, repoPath string) (bool, error) {
 }
 
 func SnapshotBetween(ctx context.Context, repoPath, fromRev, toRev string) (DiffSnapshot, error) {
-	patch, err := runCmd(ctx, repoPath, "git", "diff", "--no-color", "--find-renames", fromRev, toRev)
+	patch, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--no-color", "--find-renames", fromRev, toRev)
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
-	filesOut, err := runCmd(ctx, repoPath, "git", "diff", "--name-only", fromRev, toRev)
+	filesOut, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--name-only", fromRev, toRev)
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
-	numstatOut, err := runCmd(ctx, repoPath, "git", "diff", "--numstat", fromRev, toRev)
+	numstatOut, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--numstat", fromRev, toRev)
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
@@ -227,19 +495,25 @@ func SnapshotBetween(ctx context.Context, repoPath, fromRev, toRev string) (Diff
 		Patch:        patch,
 		ChangedFiles: parseLines(filesOut),
 		FileStats:    parseNumstat(numstatOut),
+		Renames:      parseRenames(patch),
+		ModeChanges:  parseModeChanges(patch),
+		Symlinks:     ParseSymlinks(patch),
 	}, nil
 }
 
+// SnapshotWorktree is the diff of the checkout at repoPath against its HEAD.
+// A submodule only counts as changed when it is at another commit, not when
+// files inside it were edited, which no commit of the superproject records.
 func SnapshotWorktree(ctx context.Context, repoPath string) (DiffSnapshot, error) {
-	patch, err := runCmd(ctx, repoPath, "git", "diff", "--no-color", "--find-renames")
+	patch, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--no-color", "--find-renames", "--ignore-submodules=dirty")
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
-	filesOut, err := runCmd(ctx, repoPath, "git", "diff", "--name-only")
+	filesOut, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--name-only", "--ignore-submodules=dirty")
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
-	numstatOut, err := runCmd(ctx, repoPath, "git", "diff", "--numstat")
+	numstatOut, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "diff", "--numstat", "--ignore-submodules=dirty")
 	if err != nil {
 		return DiffSnapshot{}, err
 	}
@@ -248,9 +522,199 @@ func SnapshotWorktree(ctx context.Context, repoPath string) (DiffSnapshot, error
 		Patch:        patch,
 		ChangedFiles: parseLines(filesOut),
 		FileStats:    parseNumstat(numstatOut),
+		Renames:      parseRenames(patch),
+		ModeChanges:  parseModeChanges(patch),
+		Symlinks:     ParseSymlinks(patch),
 	}, nil
 }
 
+// ParseSnapshot builds a snapshot from a unified diff produced by git diff,
+// for patches that are not backed by a repository. Binary files are listed
+// as changed with no line stats, like git diff --numstat.
+func ParseSnapshot(patch string) DiffSnapshot {
+	snap := DiffSnapshot{Patch: patch, FileStats: map[string]FileStat{}}
+	current := ""
+	inHunk := false
+	for _, raw := range strings.Split(patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		if strings.HasPrefix(line, "diff --git ") {
+			current, inHunk = DiffHeaderPath(line), false
+			if current != "" {
+				if _, ok := snap.FileStats[current]; !ok {
+					snap.ChangedFiles = append(snap.ChangedFiles, current)
+					snap.FileStats[current] = FileStat{Path: current}
+				}
+			}
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "@@") {
+			inHunk = true
+			continue
+		}
+		if !inHunk {
+			continue
+		}
+		stat := snap.FileStats[current]
+		switch {
+		case strings.HasPrefix(line, "+"):
+			stat.Added++
+		case strings.HasPrefix(line, "-"):
+			stat.Removed++
+		}
+		snap.FileStats[current] = stat
+	}
+	sort.Strings(snap.ChangedFiles)
+	snap.Renames = parseRenames(patch)
+	snap.ModeChanges = parseModeChanges(patch)
+	snap.Symlinks = ParseSymlinks(patch)
+	return snap
+}
+
+// parseRenames reads the rename headers of a patch made with
+// --find-renames.
+func parseRenames(patch string) map[string]string {
+	var out map[string]string
+	from := ""
+	for _, raw := range strings.Split(patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			from = ""
+		case strings.HasPrefix(line, "rename from "):
+			from = UnquotePath(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to ") && from != "":
+			if out == nil {
+				out = map[string]string{}
+			}
+			out[UnquotePath(strings.TrimPrefix(line, "rename to "))] = from
+			from = ""
+		}
+	}
+	return out
+}
+
+// parseModeChanges reads the old mode and new mode headers of a patch.
+func parseModeChanges(patch string) map[string]ModeChange {
+	var out map[string]ModeChange
+	current, old := "", ""
+	for _, raw := range strings.Split(patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current, old = DiffHeaderPath(line), ""
+		case strings.HasPrefix(line, "old mode "):
+			old = strings.TrimPrefix(line, "old mode ")
+		case strings.HasPrefix(line, "new mode ") && old != "" && current != "":
+			if out == nil {
+				out = map[string]ModeChange{}
+			}
+			out[current] = ModeChange{Old: old, New: strings.TrimPrefix(line, "new mode ")}
+			old = ""
+		}
+	}
+	return out
+}
+
+// HeaderModes returns the modes an extended header line of a file diff
+// gives the old and new side of the file, empty for a side it does not set.
+func HeaderModes(line string) (oldMode, newMode string) {
+	switch {
+	case strings.HasPrefix(line, "new file mode "):
+		return "", strings.TrimPrefix(line, "new file mode ")
+	case strings.HasPrefix(line, "deleted file mode "):
+		return strings.TrimPrefix(line, "deleted file mode "), ""
+	case strings.HasPrefix(line, "old mode "):
+		return strings.TrimPrefix(line, "old mode "), ""
+	case strings.HasPrefix(line, "new mode "):
+		return "", strings.TrimPrefix(line, "new mode ")
+	case strings.HasPrefix(line, "index "):
+		if f := strings.Fields(line); len(f) == 3 {
+			return f[2], f[2]
+		}
+	}
+	return "", ""
+}
+
+// ParseSymlinks reads the symbolic links of a patch and their targets, which
+// git writes as the single line of the link's content.
+func ParseSymlinks(patch string) map[string]SymlinkChange {
+	var out map[string]SymlinkChange
+	current := ""
+	oldLink, newLink, inHunk := false, false, false
+	for _, raw := range strings.Split(patch, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		if strings.HasPrefix(line, "diff --git ") {
+			current = DiffHeaderPath(line)
+			oldLink, newLink, inHunk = false, false, false
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		if !inHunk {
+			if strings.HasPrefix(line, "@@") {
+				inHunk = true
+				continue
+			}
+			o, n := HeaderModes(line)
+			if o == "" && n == "" {
+				continue
+			}
+			if o != "" {
+				oldLink = o == SymlinkMode
+			}
+			if n != "" {
+				newLink = n == SymlinkMode
+			}
+			if oldLink || newLink {
+				if out == nil {
+					out = map[string]SymlinkChange{}
+				}
+				if _, ok := out[current]; !ok {
+					out[current] = SymlinkChange{}
+				}
+			}
+			continue
+		}
+		ch := out[current]
+		switch {
+		case oldLink && strings.HasPrefix(line, "-"):
+			ch.Old = line[1:]
+		case newLink && strings.HasPrefix(line, "+"):
+			ch.New = line[1:]
+		default:
+			continue
+		}
+		out[current] = ch
+	}
+	return out
+}
+
+// ShowFile returns the content of path at rev, or nil if it does not exist
+// there.
+func ShowFile(ctx context.Context, repoPath, rev, path string) ([]byte, error) {
+	if _, err := runCmd(ctx, repoPath, "git", "cat-file", "-e", rev+":"+path); err != nil {
+		return nil, nil
+	}
+	out, err := runCmd(ctx, repoPath, "git", "show", rev+":"+path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// ListFiles returns the tracked file paths at the given revision.
+func ListFiles(ctx context.Context, repoPath, rev string) ([]string, error) {
+	out, err := runCmd(ctx, repoPath, "git", "-c", "core.quotepath=false", "ls-tree", "-r", "--name-only", rev)
+	if err != nil {
+		return nil, err
+	}
+	return parseLines(out), nil
+}
+
 func CreateWorktree(ctx context.Context, baseRepoPath, runPath, commit string) error {
 	// Best-effort cleanup for stale registrations from previous runs.
 	_, _ = runCmd(ctx, baseRepoPath, "git", "worktree", "remove", "--force", runPath)
@@ -262,10 +726,222 @@ func CreateWorktree(ctx context.Context, baseRepoPath, runPath, commit string) e
 	if err := os.MkdirAll(filepath.Dir(runPath), 0o755); err != nil {
 		return fmt.Errorf("create runs dir: %w", err)
 	}
-	_, err := runCmd(ctx, baseRepoPath, "git", "worktree", "add", "--detach", runPath, commit)
+	_, err := runCmdEnv(ctx, baseRepoPath, skipSmudge, "git", "worktree", "add", "--detach", runPath, commit)
 	return err
 }
 
+// CloneShared checks commit out at path in a clone of repoPath that borrows
+// its objects instead of copying them, and has no remote. Unlike a worktree,
+// it leaves no registration in repoPath, so clones can be made in parallel.
+func CloneShared(ctx context.Context, repoPath, path, commit string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("clean clone path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create clone dir: %w", err)
+	}
+	absRepo, err := filepath.Abs(repoPath)
+	if err != nil {
+		return err
+	}
+	if _, err := runCmd(ctx, "", "git", "clone", "-q", "--shared", "--no-checkout", absRepo, path); err != nil {
+		return err
+	}
+	if _, err := runCmd(ctx, path, "git", "remote", "remove", "origin"); err != nil {
+		return err
+	}
+	_, err = runCmdEnv(ctx, path, skipSmudge, "git", "checkout", "-q", "--detach", commit)
+	return err
+}
+
+// CloneTemplate is CloneShared for a checkout that CopyCheckout copies. Its
+// index only compares file sizes and modification times, which a copy
+// keeps, so copies need no index refresh that would reread every file.
+func CloneTemplate(ctx context.Context, repoPath, path, commit string) error {
+	if err := CloneShared(ctx, repoPath, path, commit); err != nil {
+		return err
+	}
+	if _, err := runCmd(ctx, path, "git", "config", "core.checkStat", "minimal"); err != nil {
+		return err
+	}
+	_, err := runCmd(ctx, path, "git", "config", "core.trustctime", "false")
+	return err
+}
+
+// CopyCheckout copies the checkout at src to path, as copy-on-write clones
+// of its files where the file system supports them.
+func CopyCheckout(ctx context.Context, src, path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("clean copy path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create copy dir: %w", err)
+	}
+	args := []string{"-a", "--reflink=auto", src, path}
+	if runtime.GOOS == "darwin" {
+		// APFS clones files with -c.
+		args = []string{"-c", "-R", "-p", src, path}
+	}
+	_, err := runCmd(ctx, "", "cp", args...)
+	return err
+}
+
+// sealedRef keeps the parent commit, and so its history, in a sealed repo.
+const sealedRef = "refs/heads/retrospec-parent"
+
+// SealRepo creates at path a repository holding only the history up to
+// parent, fetched from baseRepoPath, with no remote. Worktrees of it cannot
+// reach the target commit or anything else made after parent, unlike those
+// of the base clone, whose refs and objects include the target. A depth above
+// 0 keeps only that many commits of the history. An existing sealed repo at
+// parent is reused.
+func SealRepo(ctx context.Context, baseRepoPath, path, parent string, depth int) error {
+	if out, err := runCmd(ctx, path, "git", "rev-parse", "--verify", "-q", sealedRef); err == nil && strings.TrimSpace(out) == parent {
+		return nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("clean sealed repo: %w", err)
+	}
+	if _, err := runCmd(ctx, "", "git", "init", "-q", path); err != nil {
+		return err
+	}
+	// Only advertised refs can be fetched from a local repo, so parent gets
+	// a temporary one in the base clone.
+	const tmpRef = "refs/retrospec/seal"
+	if _, err := runCmd(ctx, baseRepoPath, "git", "update-ref", tmpRef, parent); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = runCmd(context.WithoutCancel(ctx), baseRepoPath, "git", "update-ref", "-d", tmpRef)
+	}()
+	absBase, err := filepath.Abs(baseRepoPath)
+	if err != nil {
+		return err
+	}
+	args := []string{"fetch", "-q", "--no-tags"}
+	if depth > 0 {
+		args = append(args, "--depth="+strconv.Itoa(depth))
+	}
+	_, err = runCmd(ctx, path, "git", append(args, absBase, tmpRef+":"+sealedRef)...)
+	return err
+}
+
+// anonymousIdentity is the author, committer, and date of the single commit
+// of an anonymized repo. The date is fixed so that the same snapshot always
+// gets the same commit.
+var anonymousIdentity = []string{
+	"GIT_AUTHOR_NAME=retrospec", "GIT_AUTHOR_EMAIL=retrospec@localhost", "GIT_AUTHOR_DATE=2000-01-01T00:00:00Z",
+	"GIT_COMMITTER_NAME=retrospec", "GIT_COMMITTER_EMAIL=retrospec@localhost", "GIT_COMMITTER_DATE=2000-01-01T00:00:00Z",
+}
+
+// AnonymizeRepo creates at path a repository whose only commit holds the
+// tree of parent in the sealed repo at sealedPath, after rewrite, when set,
+// has edited the checked out files. The commit has a neutral author, date,
+// and message, and no object of the sealed history is kept, so neither
+// upstream commit IDs nor messages can be looked up from it. It returns the
+// new commit.
+func AnonymizeRepo(ctx context.Context, sealedPath, path, parent string, rewrite func(dir string) error) (string, error) {
+	if err := os.RemoveAll(path); err != nil {
+		return "", fmt.Errorf("clean anonymized repo: %w", err)
+	}
+	if _, err := runCmd(ctx, "", "git", "init", "-q", path); err != nil {
+		return "", err
+	}
+	absSealed, err := filepath.Abs(sealedPath)
+	if err != nil {
+		return "", err
+	}
+	const tmpRef = "refs/retrospec/anonymize"
+	if _, err := runCmd(ctx, path, "git", "fetch", "-q", "--no-tags", absSealed, sealedRef+":"+tmpRef); err != nil {
+		return "", err
+	}
+	if _, err := runCmdEnv(ctx, path, skipSmudge, "git", "checkout", "-q", "--detach", parent); err != nil {
+		return "", err
+	}
+	if rewrite != nil {
+		if err := rewrite(path); err != nil {
+			return "", err
+		}
+	}
+	// An orphan branch keeps the index and files but starts a new history.
+	steps := [][]string{
+		{"checkout", "-q", "--orphan", "retrospec-base"},
+		{"add", "-A"},
+		{"commit", "-q", "--allow-empty", "--no-verify", "-m", "Initial snapshot"},
+		{"update-ref", "-d", tmpRef},
+		{"reflog", "expire", "--expire=now", "--all"},
+		{"gc", "-q", "--prune=now"},
+	}
+	for _, args := range steps {
+		if _, err := runCmdEnv(ctx, path, anonymousIdentity, "git", args...); err != nil {
+			return "", err
+		}
+	}
+	out, err := runCmd(ctx, path, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// CheckIsolation verifies that the repository at path, a sealed repo or one
+// of its worktrees, does not expose target: HEAD must be at parent when
+// wantHead is set, it may have no remote, and neither the target commit nor
+// its tree may be readable, which also rules out any ref, reflog, or stash
+// reaching it. A tree is only checked when targetTree is set, and passes
+// when some commit up to parent already had it, as a revert can.
+func CheckIsolation(ctx context.Context, path, parent, target, targetTree string, wantHead bool) error {
+	if wantHead {
+		head, err := runCmd(ctx, path, "git", "rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
+		if h := strings.TrimSpace(head); h != parent {
+			return fmt.Errorf("%s is at %s, not at the parent commit %s", path, h, parent)
+		}
+	}
+	if _, err := runCmd(ctx, path, "git", "cat-file", "-e", target); err == nil {
+		return fmt.Errorf("the target commit %s is readable from %s", target, path)
+	}
+	if targetTree != "" {
+		if _, err := runCmd(ctx, path, "git", "cat-file", "-e", targetTree); err == nil {
+			trees, err := runCmd(ctx, path, "git", "log", "--format=%T", parent)
+			if err != nil {
+				return err
+			}
+			seen := false
+			for _, t := range strings.Fields(trees) {
+				seen = seen || t == targetTree
+			}
+			if !seen {
+				return fmt.Errorf("the tree %s of the target commit is readable from %s", targetTree, path)
+			}
+		}
+	}
+	if out, err := runCmd(ctx, path, "git", "remote"); err == nil && strings.TrimSpace(out) != "" {
+		return fmt.Errorf("%s has remotes (%s) the target could be fetched from", path, strings.Join(strings.Fields(out), ", "))
+	}
+	return nil
+}
+
+// TreeOf returns the tree of a commit.
+func TreeOf(ctx context.Context, repoPath, commit string) (string, error) {
+	out, err := runCmd(ctx, repoPath, "git", "rev-parse", commit+"^{tree}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// CommitTime returns the committer date of a commit.
+func CommitTime(ctx context.Context, repoPath, commit string) (time.Time, error) {
+	out, err := runCmd(ctx, repoPath, "git", "show", "-s", "--format=%cI", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(out))
+}
+
 func RemoveWorktree(ctx context.Context, baseRepoPath, runPath string) error {
 	_, err := runCmd(ctx, baseRepoPath, "git", "worktree", "remove", "--force", runPath)
 	if err != nil {
@@ -286,7 +962,7 @@ func parseLines(s string) []string {
 		if p == "" {
 			continue
 		}
-		out = append(out, p)
+		out = append(out, UnquotePath(p))
 	}
 	sort.Strings(out)
 	return out
@@ -305,12 +981,29 @@ func parseNumstat(s string) map[string]FileStat {
 		}
 		added := parseNum(parts[0])
 		removed := parseNum(parts[1])
-		path := strings.Join(parts[2:], "\t")
+		path := numstatPath(strings.Join(parts[2:], "\t"))
 		stats[path] = FileStat{Path: path, Added: added, Removed: removed}
 	}
 	return stats
 }
 
+// numstatPath returns the new path of a numstat entry, which git writes as
+// "old => new" or "dir/{old => new}/file" for renames, with either side
+// quoted when needed.
+func numstatPath(p string) string {
+	if !strings.Contains(p, " => ") {
+		return UnquotePath(p)
+	}
+	if open, end := strings.Index(p, "{"), strings.Index(p, "}"); open >= 0 && end > open {
+		inner := p[open+1 : end]
+		if i := strings.Index(inner, " => "); i >= 0 {
+			joined := UnquotePath(p[:open] + inner[i+len(" => "):] + p[end+1:])
+			return strings.TrimPrefix(strings.ReplaceAll(joined, "//", "/"), "/")
+		}
+	}
+	return UnquotePath(p[strings.Index(p, " => ")+len(" => "):])
+}
+
 func parseNum(s string) int {
 	if s == "-" {
 		return 0
@@ -327,10 +1020,18 @@ func isLikelyURL(s string) bool {
 }
 
 func runCmd(ctx context.Context, dir, bin string, args ...string) (string, error) {
+	return runCmdEnv(ctx, dir, nil, bin, args...)
+}
+
+// runCmdEnv is runCmd with env added to the process environment.
+func runCmdEnv(ctx context.Context, dir string, env []string, bin string, args ...string) (string, error) {
 	cmd := exec.CommandContext(ctx, bin, args...)
 	if dir != "" {
 		cmd.Dir = dir
 	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// UnquotePath decodes a path git wrote in C-style quotes, as it does for
+// paths with control characters, quotes, or, unless core.quotepath is off,
+// non-ASCII bytes. Octal escapes are UTF-8 bytes, so "d\303\251j\303\240.go"
+// becomes "déjà.go". Unquoted paths are returned unchanged.
+func UnquotePath(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	body := s[1 : len(s)-1]
+	if !strings.Contains(body, `\`) {
+		return body
+	}
+	b := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' || i+1 == len(body) {
+			b = append(b, c)
+			continue
+		}
+		i++
+		switch e := body[i]; e {
+		case 'a':
+			b = append(b, '\a')
+		case 'b':
+			b = append(b, '\b')
+		case 't':
+			b = append(b, '\t')
+		case 'n':
+			b = append(b, '\n')
+		case 'v':
+			b = append(b, '\v')
+		case 'f':
+			b = append(b, '\f')
+		case 'r':
+			b = append(b, '\r')
+		case '0', '1', '2', '3':
+			if i+2 < len(body) && isOctal(body[i+1]) && isOctal(body[i+2]) {
+				b = append(b, (e-'0')<<6|(body[i+1]-'0')<<3|(body[i+2]-'0'))
+				i += 2
+			} else {
+				b = append(b, '\\', e)
+			}
+		default:
+			b = append(b, e)
+		}
+	}
+	if !utf8.Valid(b) {
+		// Not UTF-8 (e.g. a Latin-1 file name): keep the escapes so the key
+		// stays stable and printable.
+		return body
+	}
+	return string(b)
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// ParseDiffHeader returns the old and new paths of a "diff --git" line,
+// without their a/ and b/ prefixes. Quoted paths are decoded. Unquoted paths
+// may contain spaces: when both sides are the same path it is found exactly,
+// otherwise the line is split at the first " b/".
+func ParseDiffHeader(line string) (oldPath, newPath string) {
+	rest, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "diff --git ")
+	if !ok {
+		return "", ""
+	}
+	var a, b string
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		end := quotedEnd(rest)
+		if end < 0 {
+			return "", ""
+		}
+		a, b = rest[:end+1], strings.TrimPrefix(rest[end+1:], " ")
+	case strings.HasSuffix(rest, `"`) && strings.Contains(rest, ` "`):
+		i := strings.LastIndex(rest, ` "b/`)
+		if i < 0 {
+			i = strings.LastIndex(rest, ` "`)
+		}
+		a, b = rest[:i], rest[i+1:]
+	default:
+		if n := len(rest); n%2 == 1 && rest[:n/2] != "" && strings.TrimPrefix(rest[:n/2], "a/") == strings.TrimPrefix(rest[n/2+1:], "b/") {
+			a, b = rest[:n/2], rest[n/2+1:]
+		} else if i := strings.Index(rest, " b/"); i >= 0 {
+			a, b = rest[:i], rest[i+1:]
+		} else if i := strings.Index(rest, " "); i >= 0 {
+			a, b = rest[:i], rest[i+1:]
+		} else {
+			return "", ""
+		}
+	}
+	return strings.TrimPrefix(UnquotePath(a), "a/"), strings.TrimPrefix(UnquotePath(b), "b/")
+}
+
+// quotedEnd returns the index of the quote closing the quoted string s
+// starts with, or -1.
+func quotedEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// DiffHeaderPath returns the new path of a "diff --git" line, the key files
+// are scored and reported by.
+func DiffHeaderPath(line string) string {
+	_, p := ParseDiffHeader(line)
+	return p
+}
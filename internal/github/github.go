@@ -0,0 +1,183 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const defaultBaseURL = "https://api.github.com"
+
+var repoURLRe = regexp.MustCompile(`^(?:https?://|ssh://git@|git@)github\.com[:/]([A-Za-z0-9_.-]+)/([A-Za-z0-9_.-]+?)(?:\.git)?/?$`)
+
+// Client is a minimal GitHub REST client. Token is optional but public
+// requests are heavily rate limited without it.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+type PullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	URL    string `json:"html_url"`
+}
+
+// Issue is an issue or pull request as returned by the issues API.
+type Issue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+var closingRefRe = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\s*:?\s+#(\d+)\b`)
+
+// Comment is a piece of review discussion on a pull request: an inline
+// review comment, a review summary, or a conversation comment.
+type Comment struct {
+	Kind   string `json:"kind"`
+	Author string `json:"author,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Body   string `json:"body"`
+}
+
+const (
+	CommentInline       = "inline"
+	CommentReview       = "review"
+	CommentConversation = "conversation"
+)
+
+// ParseRepo extracts owner and name from a GitHub remote URL.
+func ParseRepo(remote string) (owner, name string, ok bool) {
+	m := repoURLRe.FindStringSubmatch(strings.TrimSpace(remote))
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// PullsForCommit returns the pull requests associated with a commit, merged
+// ones first as listed by GitHub.
+func (c *Client) PullsForCommit(ctx context.Context, owner, repo, sha string) ([]PullRequest, error) {
+	var pulls []PullRequest
+	if err := c.get(ctx, fmt.Sprintf("/repos/%s/%s/commits/%s/pulls", owner, repo, sha), &pulls); err != nil {
+		return nil, err
+	}
+	return pulls, nil
+}
+
+// LinkedIssueNumbers returns the issues a pull request body closes with
+// keywords such as "fixes #12", in order of appearance.
+func LinkedIssueNumbers(body string) []int {
+	var out []int
+	seen := map[int]bool{}
+	for _, m := range closingRefRe.FindAllStringSubmatch(body, -1) {
+		var n int
+		if _, err := fmt.Sscan(m[1], &n); err == nil && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Issue fetches an issue of the repository.
+func (c *Client) Issue(ctx context.Context, owner, repo string, number int) (Issue, error) {
+	var issue Issue
+	if err := c.get(ctx, fmt.Sprintf("/repos/%s/%s/issues/%d", owner, repo, number), &issue); err != nil {
+		return Issue{}, err
+	}
+	return issue, nil
+}
+
+// ReviewDiscussion collects inline review comments, review summaries, and
+// conversation comments of a pull request. Empty bodies are skipped.
+func (c *Client) ReviewDiscussion(ctx context.Context, owner, repo string, number int) ([]Comment, error) {
+	type user struct {
+		Login string `json:"login"`
+	}
+	var inline []struct {
+		User user   `json:"user"`
+		Path string `json:"path"`
+		Body string `json:"body"`
+	}
+	var reviews []struct {
+		User user   `json:"user"`
+		Body string `json:"body"`
+	}
+	var conversation []struct {
+		User user   `json:"user"`
+		Body string `json:"body"`
+	}
+	if err := c.get(ctx, fmt.Sprintf("/repos/%s/%s/pulls/%d/comments?per_page=100", owner, repo, number), &inline); err != nil {
+		return nil, err
+	}
+	if err := c.get(ctx, fmt.Sprintf("/repos/%s/%s/pulls/%d/reviews?per_page=100", owner, repo, number), &reviews); err != nil {
+		return nil, err
+	}
+	if err := c.get(ctx, fmt.Sprintf("/repos/%s/%s/issues/%d/comments?per_page=100", owner, repo, number), &conversation); err != nil {
+		return nil, err
+	}
+
+	var out []Comment
+	add := func(kind, author, path, body string) {
+		if body = strings.TrimSpace(body); body != "" {
+			out = append(out, Comment{Kind: kind, Author: author, Path: path, Body: body})
+		}
+	}
+	for _, r := range reviews {
+		add(CommentReview, r.User.Login, "", r.Body)
+	}
+	for _, c := range inline {
+		add(CommentInline, c.User.Login, c.Path, c.Body)
+	}
+	for _, c := range conversation {
+		add(CommentConversation, c.User.Login, "", c.Body)
+	}
+	return out, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	base := strings.TrimRight(c.BaseURL, "/")
+	if base == "" {
+		base = defaultBaseURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("github %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("github %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data))
+		if len(msg) > 300 {
+			msg = msg[:300] + "..."
+		}
+		return fmt.Errorf("github %s: status %d: %s", path, resp.StatusCode, msg)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("github %s: parse response: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package library
+
+import (
+	_ "embed"
+	"encoding/json"
+)
+
+//go:embed defaults.json
+var defaultsJSON []byte
+
+// Defaults returns the exemplar library shipped with retrospec. It is used
+// when no prompt library is configured.
+func Defaults() Library {
+	var lib Library
+	if err := json.Unmarshal(defaultsJSON, &lib); err != nil {
+		panic("invalid embedded default library: " + err.Error())
+	}
+	return lib
+}
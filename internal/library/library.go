@@ -0,0 +1,169 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const Version = 1
+
+type Entry struct {
+	Title      string   `json:"title,omitempty"`
+	Prompt     string   `json:"prompt"`
+	Repo       string   `json:"repo,omitempty"`
+	Commit     string   `json:"commit,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Style      string   `json:"style,omitempty"`
+	Iteration  int      `json:"iteration,omitempty"`
+	Executed   bool     `json:"executed"`
+	Tech       float64  `json:"tech,omitempty"`
+	Realism    float64  `json:"realism,omitempty"`
+	FinalScore float64  `json:"finalScore,omitempty"`
+	// ScoringVersion is the scoring version of Tech, Realism, and
+	// FinalScore.
+	ScoringVersion int `json:"scoringVersion,omitempty"`
+}
+
+type Library struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+func Load(path string) (Library, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Library{}, fmt.Errorf("read prompt library: %w", err)
+	}
+	var lib Library
+	if err := json.Unmarshal(data, &lib); err != nil {
+		return Library{}, fmt.Errorf("parse prompt library %s: %w", path, err)
+	}
+	if lib.Version > Version {
+		return Library{}, fmt.Errorf("prompt library %s has unsupported version %d", path, lib.Version)
+	}
+	return lib, nil
+}
+
+func (l Library) Save(path string) error {
+	l.Version = Version
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	// Write through a temporary file so an interrupted save keeps the old
+	// library intact.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ScoringVersions returns the distinct scoring versions of the executed
+// entries in ascending order. Scores from different versions are not
+// comparable, which also affects which duplicate Add keeps.
+func (l Library) ScoringVersions() []int {
+	seen := map[int]bool{}
+	var out []int
+	for _, e := range l.Entries {
+		if e.Executed && !seen[e.ScoringVersion] {
+			seen[e.ScoringVersion] = true
+			out = append(out, e.ScoringVersion)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// Add appends entries, keeping the best scored copy of duplicated prompts.
+func (l *Library) Add(entries ...Entry) {
+	index := map[string]int{}
+	for i, e := range l.Entries {
+		index[normalizePrompt(e.Prompt)] = i
+	}
+	for _, e := range entries {
+		key := normalizePrompt(e.Prompt)
+		if key == "" {
+			continue
+		}
+		if i, ok := index[key]; ok {
+			if better(e, l.Entries[i]) {
+				l.Entries[i] = e
+			}
+			continue
+		}
+		index[key] = len(l.Entries)
+		l.Entries = append(l.Entries, e)
+	}
+}
+
+// Select returns up to k entries ordered by language similarity to the given
+// languages and then by score.
+func (l Library) Select(languages []string, k int) []Entry {
+	if k <= 0 || len(l.Entries) == 0 {
+		return nil
+	}
+	type ranked struct {
+		entry Entry
+		sim   float64
+	}
+	items := make([]ranked, 0, len(l.Entries))
+	for _, e := range l.Entries {
+		items = append(items, ranked{entry: e, sim: LanguageSimilarity(languages, e.Languages)})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].sim != items[j].sim {
+			return items[i].sim > items[j].sim
+		}
+		return better(items[i].entry, items[j].entry)
+	})
+	if len(items) > k {
+		items = items[:k]
+	}
+	out := make([]Entry, 0, len(items))
+	for _, it := range items {
+		out = append(out, it.entry)
+	}
+	return out
+}
+
+func LanguageSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := map[string]struct{}{}
+	for _, l := range a {
+		set[strings.ToLower(l)] = struct{}{}
+	}
+	inter := 0
+	union := len(set)
+	seen := map[string]struct{}{}
+	for _, l := range b {
+		l = strings.ToLower(l)
+		if _, ok := seen[l]; ok {
+			continue
+		}
+		seen[l] = struct{}{}
+		if _, ok := set[l]; ok {
+			inter++
+		} else {
+			union++
+		}
+	}
+	return float64(inter) / float64(union)
+}
+
+func better(a, b Entry) bool {
+	if a.Executed != b.Executed {
+		return a.Executed
+	}
+	return a.FinalScore > b.FinalScore
+}
+
+func normalizePrompt(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
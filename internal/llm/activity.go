@@ -0,0 +1,29 @@
+package llm
+
+import "context"
+
+type toolObserverKey struct{}
+
+// ToolObserver is told about each tool a coder uses, with a short detail
+// such as the path or command, as it starts.
+type ToolObserver func(tool, detail string)
+
+// WithToolObserver returns a context whose coder runs report their tool use
+// to fn.
+func WithToolObserver(ctx context.Context, fn ToolObserver) context.Context {
+	return context.WithValue(ctx, toolObserverKey{}, fn)
+}
+
+// ReportTool passes a coder's tool use to the observer of ctx, if any.
+func ReportTool(ctx context.Context, tool, detail string) {
+	if fn, ok := ctx.Value(toolObserverKey{}).(ToolObserver); ok && fn != nil {
+		fn(tool, detail)
+	}
+}
+
+// HasToolObserver reports whether ctx has a tool observer, so coders can
+// skip collecting activity nobody reads.
+func HasToolObserver(ctx context.Context) bool {
+	fn, ok := ctx.Value(toolObserverKey{}).(ToolObserver)
+	return ok && fn != nil
+}
@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicEndpoint  = "https://api.anthropic.com"
+	defaultAnthropicMaxTokens = 8192
+	anthropicVersion          = "2023-06-01"
+)
+
+// AnthropicProvider talks to the Anthropic Messages API. Each call is
+// stateless.
+type AnthropicProvider struct {
+	// Endpoint defaults to the public API.
+	Endpoint   string
+	Model      string
+	APIKey     string
+	MaxTokens  int
+	HTTPClient *http.Client
+}
+
+type anthropicRequest struct {
+	Model     string          `json:"model"`
+	MaxTokens int             `json:"max_tokens"`
+	Messages  []openAIMessage `json:"messages"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (p *AnthropicProvider) Chat(ctx context.Context, prompt string) (string, error) {
+	maxTokens := p.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultAnthropicMaxTokens
+	}
+	body, err := json.Marshal(anthropicRequest{
+		Model:     p.Model,
+		MaxTokens: maxTokens,
+		Messages:  []openAIMessage{{Role: "user", Content: prompt}},
+	})
+	if err != nil {
+		return "", err
+	}
+	endpoint := strings.TrimRight(p.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultAnthropicEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return "", &CallError{Op: "anthropic request", Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", p.APIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", &CallError{Op: "anthropic request", Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", &CallError{Op: "anthropic response", Err: err}
+	}
+
+	var parsed anthropicResponse
+	jsonErr := json.Unmarshal(data, &parsed)
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data))
+		if jsonErr == nil && parsed.Error != nil && parsed.Error.Message != "" {
+			msg = parsed.Error.Message
+		}
+		return "", &CallError{Op: "anthropic request", Err: fmt.Errorf("status %d: %s", resp.StatusCode, truncate(msg, 300))}
+	}
+	if jsonErr != nil {
+		return "", &CallError{Op: "anthropic response", Err: fmt.Errorf("parse response: %w", jsonErr)}
+	}
+	var text strings.Builder
+	for _, c := range parsed.Content {
+		if c.Type == "text" {
+			text.WriteString(c.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", &CallError{Op: "anthropic response", Err: fmt.Errorf("no text content returned")}
+	}
+	return text.String(), nil
+}
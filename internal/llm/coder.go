@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultCoderTurns   = 20
+	coderMaxListedFiles = 1500
+	coderMaxReadBytes   = 20000
+	coderMaxTranscript  = 60000
+)
+
+type coderAction struct {
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+	Content string `json:"content,omitempty"`
+}
+
+type coderReply struct {
+	Actions []coderAction `json:"actions"`
+	Done    bool          `json:"done"`
+	Message string        `json:"message"`
+}
+
+// RunCoder asks the model for file actions turn by turn and applies them to
+// workingDir until the model reports it is done or the turn budget runs out.
+func (b *ChatBackend) RunCoder(ctx context.Context, workingDir, candidatePrompt string) (CoderResult, error) {
+	turns := b.MaxTurns
+	if turns <= 0 {
+		turns = defaultCoderTurns
+	}
+
+	var transcript []string
+	for turn := 1; turn <= turns; turn++ {
+		files, err := listTree(workingDir)
+		if err != nil {
+			return CoderResult{}, fmt.Errorf("list working tree: %w", err)
+		}
+		prompt, err := b.Templates.render(TemplateChatCoder, ChatCoderTemplateData{
+			Request:    candidatePrompt,
+			Files:      files,
+			Transcript: transcript,
+			Turn:       turn,
+			Turns:      turns,
+		})
+		if err != nil {
+			return CoderResult{}, err
+		}
+		text, err := b.Chat(ctx, prompt)
+		if err != nil {
+			return CoderResult{}, wrapCallError("coder send", err)
+		}
+
+		var reply coderReply
+		blob, err := extractJSONObject(strings.TrimSpace(text))
+		if err == nil {
+			err = json.Unmarshal([]byte(blob), &reply)
+		}
+		if err != nil {
+			transcript = appendTranscript(transcript, fmt.Sprintf("Turn %d: reply was not a valid JSON object (%v). Reply with the JSON object only.", turn, err))
+			continue
+		}
+
+		results := make([]string, 0, len(reply.Actions))
+		for _, a := range reply.Actions {
+			ReportTool(ctx, a.Type, a.Path)
+			results = append(results, applyCoderAction(workingDir, a))
+		}
+		if reply.Done {
+			return CoderResult{FinalMessage: strings.TrimSpace(reply.Message)}, nil
+		}
+		transcript = appendTranscript(transcript, fmt.Sprintf("Turn %d results:\n%s", turn, strings.Join(results, "\n")))
+	}
+	return CoderResult{FinalMessage: fmt.Sprintf("stopped after %d turns", turns)}, nil
+}
+
+// appendTranscript keeps the transcript under coderMaxTranscript bytes by
+// dropping the oldest turns.
+func appendTranscript(transcript []string, entry string) []string {
+	transcript = append(transcript, entry)
+	total := 0
+	for _, t := range transcript {
+		total += len(t)
+	}
+	for len(transcript) > 1 && total > coderMaxTranscript {
+		total -= len(transcript[0])
+		transcript = transcript[1:]
+	}
+	return transcript
+}
+
+func applyCoderAction(root string, a coderAction) string {
+	full, err := resolveInTree(root, a.Path)
+	if err != nil {
+		return fmt.Sprintf("%s %s: %v", a.Type, a.Path, err)
+	}
+	switch a.Type {
+	case "read":
+		data, err := os.ReadFile(full)
+		if err != nil {
+			return fmt.Sprintf("read %s: %v", a.Path, err)
+		}
+		return fmt.Sprintf("read %s:\n%s", a.Path, truncate(string(data), coderMaxReadBytes))
+	case "write":
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			return fmt.Sprintf("write %s: %v", a.Path, err)
+		}
+		if err := os.WriteFile(full, []byte(a.Content), 0o644); err != nil {
+			return fmt.Sprintf("write %s: %v", a.Path, err)
+		}
+		return fmt.Sprintf("write %s: ok", a.Path)
+	case "delete":
+		if err := os.Remove(full); err != nil {
+			return fmt.Sprintf("delete %s: %v", a.Path, err)
+		}
+		return fmt.Sprintf("delete %s: ok", a.Path)
+	default:
+		return fmt.Sprintf("%s %s: unknown action type", a.Type, a.Path)
+	}
+}
+
+// resolveInTree maps a model-supplied relative path into root, rejecting
+// paths that escape it or touch the git directory.
+func resolveInTree(root, p string) (string, error) {
+	p = filepath.Clean(filepath.FromSlash(strings.TrimSpace(p)))
+	if p == "." || filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path must be relative to the repository")
+	}
+	if first := strings.Split(p, string(filepath.Separator))[0]; first == ".git" {
+		return "", fmt.Errorf("path is inside .git")
+	}
+	return filepath.Join(root, p), nil
+}
+
+func listTree(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(files) >= coderMaxListedFiles {
+			return filepath.SkipAll
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
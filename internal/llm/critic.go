@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type CritiqueResult struct {
+	Summary    string   `json:"summary"`
+	Directives []string `json:"directives"`
+}
+
+// CritiqueCandidate reviews the iteration's best candidate against the
+// feedback packet and returns revision directives for the next generation.
+func CritiqueCandidate(ctx context.Context, p ChatProvider, candidatePrompt, feedbackText string, maxItems int) (CritiqueResult, error) {
+	if maxItems < 1 {
+		maxItems = 1
+	}
+	if maxItems > 8 {
+		maxItems = 8
+	}
+
+	req := fmt.Sprintf(`You are a critic reviewing a retro-spec: a human-style feature request written to reproduce an existing change.
+You did not write it. Compare it with the feedback packet, which describes how the change implemented from this request differed from the real one.
+Return STRICT JSON only:
+{
+  "summary": "one sentence overall assessment",
+  "directives": ["short imperative revision instruction", "..."]
+}
+Rules:
+- Directives tell the writer what to change in the request, not in the code.
+- No code snippets, diff lines, command lines, or file contents.
+- Do not ask for step-by-step implementation instructions.
+- Maximum %d directives, most important first.
+`, maxItems)
+	req += "\nCandidate request:\n" + strings.TrimSpace(candidatePrompt)
+	req += "\n\nFeedback packet:\n" + strings.TrimSpace(feedbackText)
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return CritiqueResult{}, wrapCallError("critic send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return CritiqueResult{}, err
+	}
+	var out CritiqueResult
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return CritiqueResult{}, err
+	}
+
+	out.Summary = strings.TrimSpace(out.Summary)
+	filtered := make([]string, 0, len(out.Directives))
+	for _, d := range out.Directives {
+		d = strings.TrimSpace(d)
+		if d == "" || strings.Contains(d, "`") {
+			continue
+		}
+		filtered = append(filtered, d)
+		if len(filtered) >= maxItems {
+			break
+		}
+	}
+	out.Directives = filtered
+	return out, nil
+}
@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type IntentGapResult struct {
+	Gaps []string `json:"gaps"`
+}
+
+// IntentGapBatch holds the gaps of several produced patches summarized in
+// one call. Attempts is in the order of the patches; Shared are the gaps
+// every patch has, which the request itself likely fails to convey.
+type IntentGapBatch struct {
+	Shared   []string          `json:"shared"`
+	Attempts []IntentGapResult `json:"attempts"`
+}
+
+func clampGapItems(maxItems int) int {
+	if maxItems < 1 {
+		return 1
+	}
+	if maxItems > 8 {
+		return 8
+	}
+	return maxItems
+}
+
+func limitGapPatch(p string, limit int) string {
+	p = strings.TrimSpace(p)
+	if len(p) <= limit {
+		return p
+	}
+	return p[:limit]
+}
+
+// filterGaps drops empty gaps and gaps quoting code.
+func filterGaps(gaps []string, maxItems int) []string {
+	filtered := make([]string, 0, len(gaps))
+	for _, g := range gaps {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		if strings.Contains(g, "```") || strings.Contains(g, "`") {
+			continue
+		}
+		filtered = append(filtered, g)
+		if len(filtered) >= maxItems {
+			break
+		}
+	}
+	return filtered
+}
+
+func SummarizeIntentGap(ctx context.Context, p ChatProvider, targetPatch, producedPatch string, maxItems int) (IntentGapResult, error) {
+	maxItems = clampGapItems(maxItems)
+	limitPatch := func(p string) string { return limitGapPatch(p, 12000) }
+
+	req := fmt.Sprintf(`Summarize behavioral intent differences between two internal change sets.
+Return STRICT JSON only:
+{
+  "gaps": ["short abstract sentence", "..."]
+}
+Rules:
+- No code snippets.
+- No diff lines.
+- No command lines.
+- No stack traces.
+- Do not quote exact source lines.
+- Use high-level behavioral categories only.
+- Maximum %d items.
+`, maxItems)
+
+	req += "\nTarget patch (internal use only):\n" + limitPatch(targetPatch)
+	req += "\n\nProduced patch (internal use only):\n" + limitPatch(producedPatch)
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return IntentGapResult{}, wrapCallError("gap send", err)
+	}
+	text = strings.TrimSpace(text)
+	jsonBlob, err := extractJSONObject(text)
+	if err != nil {
+		return IntentGapResult{}, err
+	}
+	var out IntentGapResult
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return IntentGapResult{}, err
+	}
+
+	out.Gaps = filterGaps(out.Gaps, maxItems)
+	return out, nil
+}
+
+// SummarizeIntentGaps compares several produced patches with the target in a
+// single call. Seeing the attempts side by side lets the model tell the gaps
+// of one attempt from those they all share. The patches share the budget of
+// the single-patch call.
+func SummarizeIntentGaps(ctx context.Context, p ChatProvider, targetPatch string, producedPatches []string, maxItems int) (IntentGapBatch, error) {
+	maxItems = clampGapItems(maxItems)
+	if len(producedPatches) == 0 {
+		return IntentGapBatch{}, fmt.Errorf("no produced patches")
+	}
+
+	req := fmt.Sprintf(`Summarize behavioral intent differences between a target change set and %d attempts at it.
+Return STRICT JSON only:
+{
+  "shared": ["gap every attempt has", "..."],
+  "attempts": [
+    {"attempt": 1, "gaps": ["short abstract sentence", "..."]}
+  ]
+}
+Rules:
+- One entry per attempt, numbered as below.
+- Put a gap under shared only when every attempt has it; list the rest per attempt.
+- No code snippets.
+- No diff lines.
+- No command lines.
+- No stack traces.
+- Do not quote exact source lines.
+- Use high-level behavioral categories only.
+- Maximum %d items per list.
+`, len(producedPatches), maxItems)
+
+	limit := 12000 / len(producedPatches)
+	if limit < 2000 {
+		limit = 2000
+	}
+	req += "\nTarget patch (internal use only):\n" + limitGapPatch(targetPatch, 12000)
+	for i, patch := range producedPatches {
+		req += fmt.Sprintf("\n\nAttempt %d patch (internal use only):\n", i+1) + limitGapPatch(patch, limit)
+	}
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return IntentGapBatch{}, wrapCallError("gap send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return IntentGapBatch{}, err
+	}
+	var raw struct {
+		Shared   []string `json:"shared"`
+		Attempts []struct {
+			Attempt int      `json:"attempt"`
+			Gaps    []string `json:"gaps"`
+		} `json:"attempts"`
+	}
+	if err := json.Unmarshal([]byte(jsonBlob), &raw); err != nil {
+		return IntentGapBatch{}, err
+	}
+
+	out := IntentGapBatch{
+		Shared:   filterGaps(raw.Shared, maxItems),
+		Attempts: make([]IntentGapResult, len(producedPatches)),
+	}
+	for i, a := range raw.Attempts {
+		n := a.Attempt
+		if n < 1 || n > len(producedPatches) {
+			// Unnumbered entries are taken in order.
+			n = i + 1
+		}
+		if n <= len(producedPatches) {
+			out.Attempts[n-1].Gaps = filterGaps(a.Gaps, maxItems)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IntentGroup is a heuristic grouping of changed files given to the model
+// as a starting point for the decomposition.
+type IntentGroup struct {
+	Files   []string
+	Intents []string
+}
+
+type SubIntent struct {
+	Intent     string   `json:"intent"`
+	Files      []string `json:"files"`
+	Confidence float64  `json:"confidence"`
+}
+
+type IntentDecomposition struct {
+	Summary string      `json:"summary"`
+	Intents []SubIntent `json:"intents"`
+}
+
+// DecomposeIntents asks the model which independent sub-intents a change
+// bundles and which of its files serve each one. Files not in the change are
+// dropped, and confidences are clamped to [0,1].
+func DecomposeIntents(ctx context.Context, p ChatProvider, patch string, changedFiles []string, groups []IntentGroup, maxItems int) (IntentDecomposition, error) {
+	if maxItems < 1 {
+		maxItems = 1
+	}
+	if maxItems > 8 {
+		maxItems = 8
+	}
+
+	patch = strings.TrimSpace(patch)
+	if len(patch) > 16000 {
+		patch = patch[:16000]
+	}
+
+	req := fmt.Sprintf(`You explain what a code change is for. Decompose it into the independent intents it bundles,
+for example a feature, a refactor it needs, a bug fix on the side, or test and documentation updates.
+Return STRICT JSON only:
+{
+  "summary": "one sentence on the overall change",
+  "intents": [
+    {"intent": "short behavioral description", "files": ["path/of/a/changed/file"], "confidence": 0.0}
+  ]
+}
+Rules:
+- Each changed file belongs to at least one intent.
+- Use the exact changed file paths listed below.
+- Confidence in [0,1] is how sure you are that the intent is a distinct goal of the change.
+- No code snippets.
+- Maximum %d intents, most significant first.
+`, maxItems)
+
+	if len(groups) > 0 {
+		req += "\nHeuristic file groups (may be wrong):\n"
+		for i, g := range groups {
+			req += fmt.Sprintf("%d. %s", i+1, strings.Join(g.Files, ", "))
+			if len(g.Intents) > 0 {
+				req += " (" + strings.Join(g.Intents, "; ") + ")"
+			}
+			req += "\n"
+		}
+	}
+	req += "\nChanged files:\n" + strings.Join(changedFiles, "\n")
+	req += "\n\nPatch:\n" + patch
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return IntentDecomposition{}, wrapCallError("intents send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return IntentDecomposition{}, err
+	}
+	var out IntentDecomposition
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return IntentDecomposition{}, err
+	}
+
+	known := map[string]bool{}
+	for _, f := range changedFiles {
+		known[f] = true
+	}
+	out.Summary = strings.TrimSpace(out.Summary)
+	filtered := make([]SubIntent, 0, len(out.Intents))
+	for _, in := range out.Intents {
+		in.Intent = strings.TrimSpace(in.Intent)
+		if in.Intent == "" {
+			continue
+		}
+		files := make([]string, 0, len(in.Files))
+		for _, f := range in.Files {
+			if f = strings.TrimSpace(f); known[f] {
+				files = append(files, f)
+			}
+		}
+		in.Files = files
+		if in.Confidence < 0 {
+			in.Confidence = 0
+		}
+		if in.Confidence > 1 {
+			in.Confidence = 1
+		}
+		filtered = append(filtered, in)
+		if len(filtered) >= maxItems {
+			break
+		}
+	}
+	out.Intents = filtered
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+type JudgeResult struct {
+	Score         float64 `json:"score"`
+	Justification string  `json:"justification"`
+}
+
+// JudgeRealism rates how realistic a candidate prompt is with the judge
+// template of t.
+func JudgeRealism(ctx context.Context, p ChatProvider, t *Templates, candidatePrompt string) (JudgeResult, error) {
+	judgeReq, err := t.Judge(candidatePrompt)
+	if err != nil {
+		return JudgeResult{}, err
+	}
+
+	text, err := p.Chat(ctx, judgeReq)
+	if err != nil {
+		return JudgeResult{}, wrapCallError("judge send", err)
+	}
+	text = strings.TrimSpace(text)
+	jsonBlob, err := extractJSONObject(text)
+	if err != nil {
+		return JudgeResult{}, err
+	}
+	var result JudgeResult
+	if err := json.Unmarshal([]byte(jsonBlob), &result); err != nil {
+		return JudgeResult{}, err
+	}
+	if math.IsNaN(result.Score) || math.IsInf(result.Score, 0) {
+		result.Score = 0
+	}
+	if result.Score < 0 {
+		result.Score = 0
+	}
+	if result.Score > 1 {
+		result.Score = 1
+	}
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"context"
+	"errors"
+)
+
+type Role string
+
+const (
+	RoleSpecWriter Role = "specwriter"
+	RoleJudge      Role = "judge"
+	RoleGap        Role = "gap"
+	RoleCritic     Role = "critic"
+	RoleCoder      Role = "coder"
+	RoleRecall     Role = "recall"
+)
+
+// ChatProvider sends a single prompt to a model and returns its text reply.
+// Implementations may keep conversation state between calls.
+type ChatProvider interface {
+	Chat(ctx context.Context, prompt string) (string, error)
+}
+
+// Embedder returns a vector embedding for each input text.
+type Embedder interface {
+	Embed(ctx context.Context, inputs []string) ([][]float64, error)
+}
+
+// CallError reports a failure talking to the model provider, as opposed to a
+// malformed or invalid response.
+type CallError struct {
+	Op  string
+	Err error
+}
+
+func (e *CallError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+func wrapCallError(op string, err error) error {
+	var callErr *CallError
+	if errors.As(err, &callErr) {
+		return err
+	}
+	return &CallError{Op: op, Err: err}
+}
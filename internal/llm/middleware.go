@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ChatFunc adapts a function to the ChatProvider interface.
+type ChatFunc func(ctx context.Context, prompt string) (string, error)
+
+func (f ChatFunc) Chat(ctx context.Context, prompt string) (string, error) {
+	return f(ctx, prompt)
+}
+
+// Middleware wraps a provider for a given role. Middlewares are applied in
+// order, so the first one is the outermost.
+type Middleware func(role Role, next ChatProvider) ChatProvider
+
+func Chain(role Role, p ChatProvider, mws ...Middleware) ChatProvider {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			p = mws[i](role, p)
+		}
+	}
+	return p
+}
+
+// Logging reports every call with its duration and outcome at debug level.
+func Logging(log *slog.Logger) Middleware {
+	return func(role Role, next ChatProvider) ChatProvider {
+		return ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			start := time.Now()
+			text, err := next.Chat(ctx, prompt)
+			ms := time.Since(start).Milliseconds()
+			if err != nil {
+				log.DebugContext(ctx, "llm call failed", "role", role, "charsIn", len(prompt), "durationMs", ms, "error", err)
+			} else {
+				log.DebugContext(ctx, "llm call", "role", role, "charsIn", len(prompt), "charsOut", len(text), "durationMs", ms)
+			}
+			return text, err
+		})
+	}
+}
+
+// Redact rewrites prompts before they leave the process.
+func Redact(fn func(string) string) Middleware {
+	return func(_ Role, next ChatProvider) ChatProvider {
+		return ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			return next.Chat(ctx, fn(prompt))
+		})
+	}
+}
+
+// Retry re-sends a prompt after provider failures. Context cancellation and
+// deadline errors are not retried.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(_ Role, next ChatProvider) ChatProvider {
+		return ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			var err error
+			for i := 0; i <= attempts; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return "", err
+					case <-time.After(backoff * time.Duration(i)):
+					}
+				}
+				var text string
+				text, err = next.Chat(ctx, prompt)
+				if err == nil {
+					return text, nil
+				}
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+					return "", err
+				}
+			}
+			return "", err
+		})
+	}
+}
+
+// Cache returns stored replies for prompts already seen. Only use it with
+// stateless providers, since a cached reply skips the underlying session.
+func Cache() Middleware {
+	var mu sync.Mutex
+	entries := map[string]string{}
+	return func(role Role, next ChatProvider) ChatProvider {
+		return ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			key := string(role) + "\x00" + prompt
+			mu.Lock()
+			text, ok := entries[key]
+			mu.Unlock()
+			if ok {
+				return text, nil
+			}
+			text, err := next.Chat(ctx, prompt)
+			if err != nil {
+				return "", err
+			}
+			mu.Lock()
+			entries[key] = text
+			mu.Unlock()
+			return text, nil
+		})
+	}
+}
+
+type Usage struct {
+	Calls        int `json:"calls"`
+	Errors       int `json:"errors"`
+	PromptTokens int `json:"promptTokens"`
+	ReplyTokens  int `json:"replyTokens"`
+}
+
+// TokenCounter accumulates approximate token usage per role.
+type TokenCounter struct {
+	mu    sync.Mutex
+	usage map[Role]Usage
+}
+
+func NewTokenCounter() *TokenCounter {
+	return &TokenCounter{usage: map[Role]Usage{}}
+}
+
+func (t *TokenCounter) Middleware() Middleware {
+	return func(role Role, next ChatProvider) ChatProvider {
+		return ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			text, err := next.Chat(ctx, prompt)
+			t.mu.Lock()
+			u := t.usage[role]
+			u.Calls++
+			u.PromptTokens += approxTokens(prompt)
+			if err != nil {
+				u.Errors++
+			} else {
+				u.ReplyTokens += approxTokens(text)
+			}
+			t.usage[role] = u
+			t.mu.Unlock()
+			return text, err
+		})
+	}
+}
+
+func (t *TokenCounter) Snapshot() map[Role]Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[Role]Usage, len(t.usage))
+	for k, v := range t.usage {
+		out[k] = v
+	}
+	return out
+}
+
+// Total sums the prompt and reply tokens of every role.
+func (t *TokenCounter) Total() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := 0
+	for _, u := range t.usage {
+		total += u.PromptTokens + u.ReplyTokens
+	}
+	return total
+}
+
+func approxTokens(s string) int {
+	return (len(s) + 3) / 4
+}
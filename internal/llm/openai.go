@@ -0,0 +1,170 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAIProvider talks to any server implementing the OpenAI chat completions
+// API (OpenAI, vLLM, Ollama, llama.cpp server). Each call is stateless.
+type OpenAIProvider struct {
+	Endpoint string
+	Model    string
+	APIKey   string
+	// ReasoningEffort is sent to reasoning models when set.
+	ReasoningEffort string
+	HTTPClient      *http.Client
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model           string          `json:"model"`
+	Messages        []openAIMessage `json:"messages"`
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+}
+
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+type openAIErrorResponse struct {
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, prompt string) (string, error) {
+	data, err := p.post(ctx, chatCompletionsURL(p.Endpoint), openAIRequest{
+		Model:           p.Model,
+		Messages:        []openAIMessage{{Role: "user", Content: prompt}},
+		ReasoningEffort: p.ReasoningEffort,
+	})
+	if err != nil {
+		return "", err
+	}
+	var parsed openAIResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", &CallError{Op: "openai response", Err: fmt.Errorf("parse response: %w", err)}
+	}
+	if len(parsed.Choices) == 0 {
+		return "", &CallError{Op: "openai response", Err: fmt.Errorf("no choices returned")}
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// Embed returns the embedding of each input, in order, from the embeddings
+// API of the endpoint.
+func (p *OpenAIProvider) Embed(ctx context.Context, inputs []string) ([][]float64, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	data, err := p.post(ctx, embeddingsURL(p.Endpoint), openAIEmbeddingRequest{Model: p.Model, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+	var parsed openAIEmbeddingResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, &CallError{Op: "openai response", Err: fmt.Errorf("parse response: %w", err)}
+	}
+	out := make([][]float64, len(inputs))
+	for _, d := range parsed.Data {
+		if d.Index >= 0 && d.Index < len(out) {
+			out[d.Index] = d.Embedding
+		}
+	}
+	for i, e := range out {
+		if len(e) == 0 {
+			return nil, &CallError{Op: "openai response", Err: fmt.Errorf("no embedding returned for input %d", i)}
+		}
+	}
+	return out, nil
+}
+
+// post sends a JSON request and returns the body of a successful response.
+func (p *OpenAIProvider) post(ctx context.Context, url string, payload any) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &CallError{Op: "openai request", Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &CallError{Op: "openai request", Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &CallError{Op: "openai response", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data))
+		var parsed openAIErrorResponse
+		if json.Unmarshal(data, &parsed) == nil && parsed.Error != nil && parsed.Error.Message != "" {
+			msg = parsed.Error.Message
+		}
+		return nil, &CallError{Op: "openai request", Err: fmt.Errorf("status %d: %s", resp.StatusCode, truncate(msg, 300))}
+	}
+	return data, nil
+}
+
+// chatCompletionsURL accepts either a base URL (".../v1") or the full
+// chat completions URL.
+func chatCompletionsURL(endpoint string) string {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if strings.HasSuffix(endpoint, "/chat/completions") {
+		return endpoint
+	}
+	return endpoint + "/chat/completions"
+}
+
+// embeddingsURL accepts a base URL, or a chat completions URL whose base is
+// used.
+func embeddingsURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(strings.TrimRight(endpoint, "/"), "/chat/completions")
+	if strings.HasSuffix(endpoint, "/embeddings") {
+		return endpoint
+	}
+	return endpoint + "/embeddings"
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,44 @@
+package llm
+
+import "context"
+
+// CoderResult is the outcome of a coder run. The change itself is left in the
+// working tree.
+type CoderResult struct {
+	FinalMessage string `json:"finalMessage"`
+}
+
+// Provider is a complete model backend: it can serve the spec, judge, and gap
+// roles and run the coder agent on a working tree.
+type Provider interface {
+	GenerateSpec(ctx context.Context, req GenerateSpecRequest) (SpecCandidate, string, error)
+	Judge(ctx context.Context, candidatePrompt string) (JudgeResult, error)
+	SummarizeGap(ctx context.Context, targetPatch, producedPatch string, maxItems int) (IntentGapResult, error)
+	RunCoder(ctx context.Context, workingDir, candidatePrompt string) (CoderResult, error)
+}
+
+// ChatBackend implements Provider on top of a plain chat model. The coder is
+// driven by JSON file actions, so models without native tool support can
+// still edit the working tree.
+type ChatBackend struct {
+	ChatProvider
+	// MaxTurns bounds the coder loop; zero uses a default.
+	MaxTurns int
+	// Templates render the prompts; nil uses the embedded defaults.
+	Templates *Templates
+}
+
+func (b *ChatBackend) GenerateSpec(ctx context.Context, req GenerateSpecRequest) (SpecCandidate, string, error) {
+	if req.Templates == nil {
+		req.Templates = b.Templates
+	}
+	return GenerateSpecCandidate(ctx, b.ChatProvider, req)
+}
+
+func (b *ChatBackend) Judge(ctx context.Context, candidatePrompt string) (JudgeResult, error) {
+	return JudgeRealism(ctx, b.ChatProvider, b.Templates, candidatePrompt)
+}
+
+func (b *ChatBackend) SummarizeGap(ctx context.Context, targetPatch, producedPatch string, maxItems int) (IntentGapResult, error) {
+	return SummarizeIntentGap(ctx, b.ChatProvider, targetPatch, producedPatch, maxItems)
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CommitRecall is what a model says it remembers of a commit when asked
+// about it by name alone, before it has seen any request for the change.
+type CommitRecall struct {
+	KnowsRepo   bool     `json:"knowsRepo"`
+	KnowsCommit bool     `json:"knowsCommit"`
+	Description string   `json:"description,omitempty"`
+	Files       []string `json:"files,omitempty"`
+	// KnowledgeCutoff is the training data cutoff the model states, as
+	// YYYY-MM or YYYY when it gives one.
+	KnowledgeCutoff string `json:"knowledgeCutoff,omitempty"`
+}
+
+const maxRecallFiles = 20
+
+// RecallCommit asks a model to describe a commit of a repository from
+// memory. A public commit the model describes correctly was likely in its
+// training data.
+func RecallCommit(ctx context.Context, p ChatProvider, repo, commit string) (CommitRecall, error) {
+	req := fmt.Sprintf(`Answer from memory only; you have no tools and must not guess from the names alone.
+Repository: %s
+Commit: %s
+
+Do you know this repository, and this specific commit in it? If you remember the commit, describe what it changed
+and which files it touched. Also state your training data cutoff.
+Return STRICT JSON only:
+{
+  "knowsRepo": true,
+  "knowsCommit": false,
+  "description": "what the commit changed, empty if you do not remember it",
+  "files": ["paths the commit touched, empty if you do not remember them"],
+  "knowledgeCutoff": "YYYY-MM"
+}
+Rules:
+- Say you do not know rather than inventing a description.
+- Maximum %d files.
+`, repo, commit, maxRecallFiles)
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return CommitRecall{}, wrapCallError("recall send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return CommitRecall{}, err
+	}
+	var out CommitRecall
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return CommitRecall{}, err
+	}
+
+	out.Description = strings.TrimSpace(out.Description)
+	out.KnowledgeCutoff = strings.TrimSpace(out.KnowledgeCutoff)
+	files := make([]string, 0, len(out.Files))
+	for _, f := range out.Files {
+		f = strings.Trim(strings.TrimSpace(f), "`'\"")
+		f = strings.TrimPrefix(f, "./")
+		if f == "" {
+			continue
+		}
+		files = append(files, f)
+		if len(files) >= maxRecallFiles {
+			break
+		}
+	}
+	out.Files = files
+	return out, nil
+}
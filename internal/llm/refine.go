@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type RefineSpecRequest struct {
+	Draft          SpecCandidate
+	Style          string
+	MaxPathRefs    int
+	MaxLength      int
+	FrozenSections []FrozenSection
+	Language       string
+}
+
+type RefinedSpec struct {
+	Candidate SpecCandidate
+	Critique  string
+}
+
+// RefineSpecCandidate asks the SpecWriter to critique its own draft once and
+// return a revised version of it.
+func RefineSpecCandidate(ctx context.Context, p ChatProvider, req RefineSpecRequest) (RefinedSpec, string, error) {
+	prompt := buildRefinePrompt(req)
+	text, err := p.Chat(ctx, prompt)
+	if err != nil {
+		return RefinedSpec{}, "", wrapCallError("specwriter refine", err)
+	}
+	text = strings.TrimSpace(text)
+
+	candidate, err := parseSpecCandidateJSON(text)
+	if err != nil {
+		return RefinedSpec{}, text, err
+	}
+	out := RefinedSpec{Candidate: candidate}
+	if blob, err := extractJSONObject(text); err == nil {
+		var extra struct {
+			Critique json.RawMessage `json:"critique"`
+		}
+		if json.Unmarshal([]byte(blob), &extra) == nil {
+			out.Critique = parseCritique(extra.Critique)
+		}
+	}
+	return out, text, nil
+}
+
+func buildRefinePrompt(req RefineSpecRequest) string {
+	draft, _ := json.Marshal(struct {
+		Title           string   `json:"title"`
+		CandidatePrompt string   `json:"candidatePrompt"`
+		Rationale       string   `json:"rationale"`
+		ScopeHints      []string `json:"scopeHints"`
+	}{req.Draft.Title, req.Draft.CandidatePrompt, req.Draft.Rationale, req.Draft.ScopeHints})
+
+	b := strings.Builder{}
+	b.WriteString("You are SpecWriter reviewing your own draft retro-spec before it is scored.\n")
+	b.WriteString("First critique the draft in at most four short points: is it realistic for a human requester, does it state the problem and intended behavior clearly, are the acceptance criteria verifiable, and is anything over-specified like a diff?\n")
+	b.WriteString("Then revise the draft to address the critique.\n")
+	b.WriteString("Output STRICT JSON only with keys critique, title, candidatePrompt, rationale, scopeHints.\n")
+	b.WriteString("critique must be a JSON array of short strings.\n")
+	b.WriteString("Keep the same rules as the draft: plain English prose, no code, no inline code, no diffs, no shell commands, no issue or PR references.\n")
+	b.WriteString("Keep exactly these top-level sections in order:\n")
+	b.WriteString("# Context\n# Desired Outcomes\n# Constraints and Non-Goals\n# Acceptance Criteria\n")
+	if lang := strings.TrimSpace(req.Language); lang != "" && !strings.EqualFold(lang, "en") {
+		b.WriteString(fmt.Sprintf("Keep the section bodies in the language with ISO code %q and the headings in English.\n", lang))
+	}
+	if strings.TrimSpace(req.Style) != "" {
+		b.WriteString("Style focus: ")
+		b.WriteString(strings.TrimSpace(req.Style))
+		b.WriteString(".\n")
+	}
+	if req.MaxLength > 0 {
+		b.WriteString(fmt.Sprintf("Keep prompt length <= %d characters.\n", req.MaxLength))
+	}
+	b.WriteString(fmt.Sprintf("Use at most %d natural file-path references.\n", req.MaxPathRefs))
+	if len(req.FrozenSections) > 0 {
+		b.WriteString("\nThe following sections are frozen. Copy them verbatim:\n")
+		for _, fs := range req.FrozenSections {
+			b.WriteString(fs.Heading)
+			b.WriteString("\n")
+			b.WriteString(fs.Body)
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\nDraft:\n")
+	b.Write(draft)
+	b.WriteString("\n\nReturn only valid JSON.\n")
+	return b.String()
+}
+
+func parseCritique(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var items []string
+	if err := json.Unmarshal(raw, &items); err == nil {
+		out := make([]string, 0, len(items))
+		for _, it := range items {
+			if it = strings.TrimSpace(it); it != "" {
+				out = append(out, it)
+			}
+		}
+		return strings.Join(out, "\n")
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return strings.TrimSpace(single)
+	}
+	return ""
+}
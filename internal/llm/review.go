@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type ReviewConcern struct {
+	Concern   string `json:"concern"`
+	Category  string `json:"category"`
+	Reflected string `json:"reflected"`
+	Evidence  string `json:"evidence,omitempty"`
+}
+
+type ReviewComparison struct {
+	Summary  string          `json:"summary"`
+	Concerns []ReviewConcern `json:"concerns"`
+}
+
+const (
+	ReflectedYes     = "yes"
+	ReflectedPartial = "partial"
+	ReflectedNo      = "no"
+)
+
+// CompareReview extracts the concerns raised in a change's review discussion
+// and judges whether each is covered by the request's constraints and
+// acceptance criteria.
+func CompareReview(ctx context.Context, p ChatProvider, candidatePrompt string, comments []string, maxItems int) (ReviewComparison, error) {
+	if maxItems < 1 {
+		maxItems = 1
+	}
+	if maxItems > 12 {
+		maxItems = 12
+	}
+
+	discussion := strings.Join(comments, "\n---\n")
+	if len(discussion) > 12000 {
+		discussion = discussion[:12000]
+	}
+
+	req := fmt.Sprintf(`You compare a feature request with the code review discussion of the change that implemented it.
+Extract the concerns reviewers raised (edge cases, naming, tests, error handling, compatibility, and so on) and decide
+for each whether the request already anticipates it in its constraints or acceptance criteria.
+Return STRICT JSON only:
+{
+  "summary": "one or two sentences on how well the request anticipates the review",
+  "concerns": [
+    {"concern": "short description", "category": "edge-cases|naming|tests|errors|compatibility|performance|docs|other", "reflected": "yes|partial|no", "evidence": "the part of the request that covers it, if any"}
+  ]
+}
+Rules:
+- Ignore approvals, thanks, and purely social comments.
+- Merge duplicate concerns.
+- No code snippets.
+- Maximum %d concerns, most significant first.
+`, maxItems)
+	req += "\nRequest:\n" + strings.TrimSpace(candidatePrompt)
+	req += "\n\nReview discussion:\n" + discussion
+
+	text, err := p.Chat(ctx, req)
+	if err != nil {
+		return ReviewComparison{}, wrapCallError("review send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return ReviewComparison{}, err
+	}
+	var out ReviewComparison
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return ReviewComparison{}, err
+	}
+
+	out.Summary = strings.TrimSpace(out.Summary)
+	filtered := make([]ReviewConcern, 0, len(out.Concerns))
+	for _, c := range out.Concerns {
+		c.Concern = strings.TrimSpace(c.Concern)
+		if c.Concern == "" {
+			continue
+		}
+		c.Category = strings.ToLower(strings.TrimSpace(c.Category))
+		switch r := strings.ToLower(strings.TrimSpace(c.Reflected)); r {
+		case ReflectedYes, ReflectedPartial:
+			c.Reflected = r
+		default:
+			c.Reflected = ReflectedNo
+		}
+		filtered = append(filtered, c)
+		if len(filtered) >= maxItems {
+			break
+		}
+	}
+	out.Concerns = filtered
+	return out, nil
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type SpecCandidate struct {
+	Title           string   `json:"title"`
+	CandidatePrompt string   `json:"candidatePrompt"`
+	Rationale       string   `json:"rationale"`
+	ScopeHints      []string `json:"scopeHints"`
+}
+
+type GenerateSpecRequest struct {
+	Iteration       int
+	FeedbackText    string
+	MaxPathRefs     int
+	MaxLength       int
+	Style           string
+	PreviousPrompt  string
+	PreviousOutcome string
+	ViolationReason string
+	FrozenSections  []FrozenSection
+	Exemplars       []string
+	Language        string
+	// Directives are revision instructions from the critic role.
+	Directives []string
+	// Templates render the prompt; nil uses the embedded defaults.
+	Templates *Templates
+}
+
+type FrozenSection struct {
+	Heading string
+	Body    string
+}
+
+func GenerateSpecCandidate(ctx context.Context, p ChatProvider, req GenerateSpecRequest) (SpecCandidate, string, error) {
+	prompt, err := req.Templates.SpecWriter(req)
+	if err != nil {
+		return SpecCandidate{}, "", err
+	}
+	text, err := p.Chat(ctx, prompt)
+	if err != nil {
+		return SpecCandidate{}, "", wrapCallError("specwriter send", err)
+	}
+	text = strings.TrimSpace(text)
+
+	parsed, err := parseSpecCandidateJSON(text)
+	if err != nil {
+		return SpecCandidate{}, text, err
+	}
+	return parsed, text, nil
+}
+
+func parseSpecCandidateJSON(raw string) (SpecCandidate, error) {
+	jsonBlob, err := extractJSONObject(raw)
+	if err != nil {
+		return SpecCandidate{}, fmt.Errorf("extract specwriter json: %w", err)
+	}
+
+	type candidateStrict struct {
+		Title           string          `json:"title"`
+		CandidatePrompt string          `json:"candidatePrompt"`
+		Rationale       string          `json:"rationale"`
+		ScopeHints      json.RawMessage `json:"scopeHints"`
+	}
+	var strict candidateStrict
+	if err := json.Unmarshal([]byte(jsonBlob), &strict); err != nil {
+		return SpecCandidate{}, fmt.Errorf("parse specwriter json: %w", err)
+	}
+
+	out := SpecCandidate{
+		Title:           strings.TrimSpace(strict.Title),
+		CandidatePrompt: strict.CandidatePrompt,
+		Rationale:       strict.Rationale,
+	}
+
+	if len(strict.ScopeHints) > 0 && string(strict.ScopeHints) != "null" {
+		var arr []string
+		if err := json.Unmarshal(strict.ScopeHints, &arr); err == nil {
+			out.ScopeHints = arr
+		} else {
+			var single string
+			if err := json.Unmarshal(strict.ScopeHints, &single); err == nil {
+				single = strings.TrimSpace(single)
+				if single != "" {
+					if strings.Contains(single, ",") {
+						for _, part := range strings.Split(single, ",") {
+							part = strings.TrimSpace(part)
+							if part != "" {
+								out.ScopeHints = append(out.ScopeHints, part)
+							}
+						}
+					} else {
+						out.ScopeHints = []string{single}
+					}
+				}
+			}
+		}
+	}
+
+	out.CandidatePrompt = strings.TrimSpace(out.CandidatePrompt)
+	out.Rationale = strings.TrimSpace(out.Rationale)
+	if out.CandidatePrompt == "" {
+		return SpecCandidate{}, fmt.Errorf("candidatePrompt is empty")
+	}
+	if out.Rationale == "" {
+		out.Rationale = "Prompt focuses on behavioral outcomes and acceptance criteria."
+	}
+	if out.ScopeHints == nil {
+		out.ScopeHints = []string{}
+	}
+	return out, nil
+}
+
+func extractJSONObject(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("empty response")
+	}
+
+	start := strings.Index(raw, "{")
+	if start < 0 {
+		return "", fmt.Errorf("no json object start found")
+	}
+
+	inString := false
+	escape := false
+	depth := 0
+	for i := start; i < len(raw); i++ {
+		ch := raw[i]
+		if inString {
+			if escape {
+				escape = false
+				continue
+			}
+			if ch == '\\' {
+				escape = true
+				continue
+			}
+			if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return raw[start : i+1], nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unterminated json object")
+}
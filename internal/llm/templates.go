@@ -0,0 +1,174 @@
+package llm
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Template files, one per prompt. A templates directory may override any of
+// them; the rest keep the embedded defaults.
+const (
+	TemplateSpecWriter = "specwriter.tmpl"
+	TemplateJudge      = "judge.tmpl"
+	TemplateCoder      = "coder.tmpl"
+	TemplateChatCoder  = "chat-coder.tmpl"
+)
+
+var templateNames = []string{TemplateSpecWriter, TemplateJudge, TemplateCoder, TemplateChatCoder}
+
+// templateData are zero values of the data of each template, rendered when
+// loading so a misspelled field fails before the run starts.
+var templateData = map[string]any{
+	TemplateSpecWriter: GenerateSpecRequest{},
+	TemplateJudge:      JudgeTemplateData{},
+	TemplateCoder:      CoderTemplateData{},
+	TemplateChatCoder:  ChatCoderTemplateData{},
+}
+
+//go:embed templates/*.tmpl
+var defaultTemplateFS embed.FS
+
+var templateFuncs = template.FuncMap{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"add":   func(a, b int) int { return a + b },
+}
+
+// JudgeTemplateData is the data of the judge template.
+type JudgeTemplateData struct {
+	CandidatePrompt string
+}
+
+// CoderTemplateData is the data of the Copilot coder template. SandboxTool is
+// the name of the tool that runs commands in the sandbox, empty on the host.
+type CoderTemplateData struct {
+	Request     string
+	SandboxTool string
+}
+
+// ChatCoderTemplateData is the data of the chat coder template, rendered on
+// every turn.
+type ChatCoderTemplateData struct {
+	Request    string
+	Files      []string
+	Transcript []string
+	Turn       int
+	Turns      int
+}
+
+// Templates are the prompts of the SpecWriter, judge, and coder roles as Go
+// text/template files. The SpecWriter template is rendered with the
+// GenerateSpecRequest. A nil *Templates uses the embedded defaults.
+type Templates struct {
+	set map[string]*template.Template
+	// Sources maps every template overridden from a directory to its path.
+	Sources map[string]string
+}
+
+var defaultTemplates = sync.OnceValue(func() *Templates {
+	t := &Templates{set: map[string]*template.Template{}}
+	for _, name := range templateNames {
+		src, err := defaultTemplateFS.ReadFile("templates/" + name)
+		if err != nil {
+			panic(err)
+		}
+		t.set[name] = template.Must(parseTemplate(name, string(src)))
+	}
+	return t
+})
+
+// DefaultTemplates returns the embedded templates.
+func DefaultTemplates() *Templates {
+	return defaultTemplates()
+}
+
+// LoadTemplates reads the templates found in dir over the embedded defaults.
+// An empty dir returns the defaults. Unknown .tmpl files are rejected so a
+// misspelled name does not silently leave the default in place.
+func LoadTemplates(dir string) (*Templates, error) {
+	if strings.TrimSpace(dir) == "" {
+		return DefaultTemplates(), nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read templates dir: %w", err)
+	}
+	def := DefaultTemplates()
+	t := &Templates{set: map[string]*template.Template{}, Sources: map[string]string{}}
+	for name, tmpl := range def.set {
+		t.set[name] = tmpl
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".tmpl" {
+			continue
+		}
+		name := e.Name()
+		if _, ok := def.set[name]; !ok {
+			return nil, fmt.Errorf("unknown template %q (expected one of %s)", name, strings.Join(templateNames, ", "))
+		}
+		path := filepath.Join(dir, name)
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read template: %w", err)
+		}
+		tmpl, err := parseTemplate(name, string(src))
+		if err != nil {
+			return nil, err
+		}
+		if err := tmpl.Execute(io.Discard, templateData[name]); err != nil {
+			return nil, fmt.Errorf("render template %s: %w", name, err)
+		}
+		t.set[name] = tmpl
+		t.Sources[name] = path
+	}
+	return t, nil
+}
+
+func parseTemplate(name, src string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func (t *Templates) render(name string, data any) (string, error) {
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	tmpl, ok := t.set[name]
+	if !ok {
+		return "", fmt.Errorf("template %s not loaded", name)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render template %s: %w", name, err)
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", fmt.Errorf("template %s rendered an empty prompt", name)
+	}
+	return b.String(), nil
+}
+
+// SpecWriter renders the SpecWriter prompt of a request.
+func (t *Templates) SpecWriter(req GenerateSpecRequest) (string, error) {
+	return t.render(TemplateSpecWriter, req)
+}
+
+// Judge renders the realism judge prompt of a candidate prompt.
+func (t *Templates) Judge(candidatePrompt string) (string, error) {
+	return t.render(TemplateJudge, JudgeTemplateData{CandidatePrompt: candidatePrompt})
+}
+
+// Coder renders the Copilot coder prompt.
+func (t *Templates) Coder(data CoderTemplateData) (string, error) {
+	return t.render(TemplateCoder, data)
+}
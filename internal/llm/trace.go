@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type TraceLink struct {
+	Item  int    `json:"item"`
+	Hunks []int  `json:"hunks"`
+	Note  string `json:"note"`
+}
+
+type TraceResult struct {
+	Links []TraceLink `json:"links"`
+}
+
+// TraceSpec links numbered request items to the numbered hunks of the change
+// that address them. Item and hunk numbers are 1-based as shown to the model;
+// links to unknown numbers are dropped.
+func TraceSpec(ctx context.Context, p ChatProvider, items, hunks []string) (TraceResult, error) {
+	var b strings.Builder
+	b.WriteString(`You audit how a code change implements a feature request.
+For each numbered request item, list the numbered hunks of the change that address it.
+Return STRICT JSON only:
+{
+  "links": [
+    {"item": 1, "hunks": [2, 3], "note": "one short abstract sentence on how the hunks address the item"}
+  ]
+}
+Rules:
+- Include every item, with an empty hunk list when nothing addresses it.
+- Notes describe behavior abstractly: no code snippets, identifiers in backticks, or quoted source lines.
+`)
+	b.WriteString("\nRequest items:\n")
+	for i, it := range items {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, it)
+	}
+	b.WriteString("\nChange hunks (internal use only):\n")
+	for i, h := range hunks {
+		fmt.Fprintf(&b, "[hunk %d]\n%s\n", i+1, h)
+	}
+
+	text, err := p.Chat(ctx, b.String())
+	if err != nil {
+		return TraceResult{}, wrapCallError("trace send", err)
+	}
+	jsonBlob, err := extractJSONObject(strings.TrimSpace(text))
+	if err != nil {
+		return TraceResult{}, err
+	}
+	var out TraceResult
+	if err := json.Unmarshal([]byte(jsonBlob), &out); err != nil {
+		return TraceResult{}, err
+	}
+
+	filtered := make([]TraceLink, 0, len(out.Links))
+	for _, l := range out.Links {
+		if l.Item < 1 || l.Item > len(items) {
+			continue
+		}
+		valid := make([]int, 0, len(l.Hunks))
+		for _, h := range l.Hunks {
+			if h >= 1 && h <= len(hunks) {
+				valid = append(valid, h)
+			}
+		}
+		l.Hunks = valid
+		l.Note = strings.TrimSpace(l.Note)
+		if strings.Contains(l.Note, "`") {
+			l.Note = ""
+		}
+		filtered = append(filtered, l)
+	}
+	out.Links = filtered
+	return out, nil
+}
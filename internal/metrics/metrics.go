@@ -0,0 +1,171 @@
+// Package metrics exposes the progress of runs in the Prometheus text format
+// so long batches can be monitored on a dashboard.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/run"
+)
+
+// coderBuckets are the upper bounds in seconds of the coder duration
+// histogram.
+var coderBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800}
+
+// Registry collects metrics from run events and provider calls. It is a
+// run.EventSink and an http.Handler serving the metrics.
+type Registry struct {
+	mu             sync.Mutex
+	runs           int
+	iteration      int
+	bestFinal      float64
+	attempts       int
+	coderCounts    []int
+	coderSum       float64
+	coderCount     int
+	coderFailures  int
+	llmCalls       map[string]int
+	llmFailures    map[string]int
+	testCategories map[string]int
+}
+
+func New() *Registry {
+	return &Registry{
+		coderCounts:    make([]int, len(coderBuckets)),
+		llmCalls:       map[string]int{},
+		llmFailures:    map[string]int{},
+		testCategories: map[string]int{},
+	}
+}
+
+func (r *Registry) Event(e run.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e.Type {
+	case run.EventRunStart:
+		r.runs++
+		r.iteration = 0
+		r.bestFinal = 0
+	case run.EventIterationStart:
+		r.iteration = e.Iteration
+	case run.EventCoderDone:
+		secs := float64(e.DurationMs) / 1000
+		for i, b := range coderBuckets {
+			if secs <= b {
+				r.coderCounts[i]++
+			}
+		}
+		r.coderSum += secs
+		r.coderCount++
+		if e.Message != "" {
+			r.coderFailures++
+		}
+	case run.EventAttempt:
+		r.attempts++
+		if e.TestCategory != "" {
+			r.testCategories[e.TestCategory]++
+		}
+	case run.EventBest:
+		r.bestFinal = e.BestFinal
+	}
+}
+
+// Middleware counts the calls and failures of the spec, judge, gap, and
+// critic roles. Canceled calls are not failures.
+func (r *Registry) Middleware() llm.Middleware {
+	return func(role llm.Role, next llm.ChatProvider) llm.ChatProvider {
+		return llm.ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			text, err := next.Chat(ctx, prompt)
+			r.mu.Lock()
+			r.llmCalls[string(role)]++
+			if err != nil && ctx.Err() == nil {
+				r.llmFailures[string(role)]++
+			}
+			r.mu.Unlock()
+			return text, err
+		})
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metric(w, "retrospec_runs_total", "counter", "Runs started by this process.")
+	fmt.Fprintf(w, "retrospec_runs_total %d\n", r.runs)
+	metric(w, "retrospec_iteration", "gauge", "Current iteration of the active run.")
+	fmt.Fprintf(w, "retrospec_iteration %d\n", r.iteration)
+	metric(w, "retrospec_best_final_score", "gauge", "Best final score of the active run.")
+	fmt.Fprintf(w, "retrospec_best_final_score %s\n", formatFloat(r.bestFinal))
+	metric(w, "retrospec_attempts_total", "counter", "Scored coder attempts.")
+	fmt.Fprintf(w, "retrospec_attempts_total %d\n", r.attempts)
+
+	metric(w, "retrospec_coder_duration_seconds", "histogram", "Duration of coder runs.")
+	for i, b := range coderBuckets {
+		fmt.Fprintf(w, "retrospec_coder_duration_seconds_bucket{le=%q} %d\n", formatFloat(b), r.coderCounts[i])
+	}
+	fmt.Fprintf(w, "retrospec_coder_duration_seconds_bucket{le=\"+Inf\"} %d\n", r.coderCount)
+	fmt.Fprintf(w, "retrospec_coder_duration_seconds_sum %s\n", formatFloat(r.coderSum))
+	fmt.Fprintf(w, "retrospec_coder_duration_seconds_count %d\n", r.coderCount)
+	metric(w, "retrospec_coder_failures_total", "counter", "Coder runs that ended with an error.")
+	fmt.Fprintf(w, "retrospec_coder_failures_total %d\n", r.coderFailures)
+
+	metric(w, "retrospec_llm_calls_total", "counter", "LLM role calls.")
+	labeled(w, "retrospec_llm_calls_total", "role", r.llmCalls)
+	metric(w, "retrospec_llm_call_failures_total", "counter", "LLM role calls that failed.")
+	labeled(w, "retrospec_llm_call_failures_total", "role", r.llmFailures)
+	metric(w, "retrospec_test_results_total", "counter", "Test outcomes of scored attempts by category.")
+	labeled(w, "retrospec_test_results_total", "category", r.testCategories)
+}
+
+// Start serves the metrics under /metrics on addr in the background. The
+// returned server can be closed to stop it.
+func Start(addr string, r *Registry) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	return srv, nil
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func labeled(w io.Writer, name, label string, values map[string]int) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), values[k])
+	}
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
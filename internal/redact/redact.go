@@ -0,0 +1,137 @@
+// Package redact removes content from patches that should not be sent to a
+// model: secret-looking values, large encoded blobs, and generated or
+// minified files that only waste the prompt budget.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+var (
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+		regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36,}`),
+		regexp.MustCompile(`github_pat_[A-Za-z0-9_]{40,}`),
+		regexp.MustCompile(`sk-[A-Za-z0-9_-]{20,}`),
+		regexp.MustCompile(`xox[abprs]-[A-Za-z0-9-]{10,}`),
+		regexp.MustCompile(`eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+	}
+	secretAssignRe = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|client[_-]?secret)["']?\s*[:=]\s*["'])([^"'\s]{8,})(["'])`)
+	privateKeyRe   = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	privateKeyEnd  = regexp.MustCompile(`-----END [A-Z ]*PRIVATE KEY-----`)
+	blobRe         = regexp.MustCompile(`[A-Za-z0-9+/=_-]{120,}`)
+)
+
+// Report counts what was removed from a patch.
+type Report struct {
+	Secrets         int      `json:"secrets,omitempty"`
+	Blobs           int      `json:"blobs,omitempty"`
+	SummarizedFiles []string `json:"summarizedFiles,omitempty"`
+	Symlinks        []string `json:"symlinks,omitempty"`
+	RemovedBytes    int      `json:"removedBytes,omitempty"`
+}
+
+func (r Report) Empty() bool {
+	return r.Secrets == 0 && r.Blobs == 0 && len(r.SummarizedFiles) == 0 && len(r.Symlinks) == 0
+}
+
+// Patch returns a copy of the unified diff with secrets masked, long encoded
+// blobs replaced, and files the detector considers generated reduced to a
+// one line summary. Symlinks are reduced to a line naming their target, so
+// the link text is not shown as if it were file content.
+func Patch(patch string, d generated.Detector) (string, Report) {
+	var rep Report
+	var b strings.Builder
+	for _, file := range generated.SplitPatch(patch) {
+		if reason := d.Reason(file.Path, file.Lines); file.Header != "" && reason != "" {
+			added, removed := countChanges(file.Lines)
+			summary := fmt.Sprintf("[%s: %s, +%d -%d lines omitted]", file.Path, reason, added, removed)
+			b.WriteString(file.Header)
+			b.WriteString("\n")
+			b.WriteString(summary)
+			b.WriteString("\n")
+			rep.SummarizedFiles = append(rep.SummarizedFiles, file.Path)
+			continue
+		}
+		if link, ok := git.ParseSymlinks(file.Header + "\n" + strings.Join(file.Lines, "\n"))[file.Path]; ok && file.Header != "" {
+			b.WriteString(file.Header)
+			b.WriteString("\n")
+			b.WriteString(fmt.Sprintf("[%s: %s]", file.Path, link))
+			b.WriteString("\n")
+			rep.Symlinks = append(rep.Symlinks, file.Path)
+			continue
+		}
+		if file.Header != "" {
+			b.WriteString(file.Header)
+			b.WriteString("\n")
+		}
+		// A private key spans lines whose base64 body is too short to be a
+		// blob, so everything from BEGIN through END is one secret.
+		inKey := false
+		for _, line := range file.Lines {
+			if inKey {
+				if privateKeyEnd.MatchString(line) || strings.HasPrefix(line, "@@") {
+					inKey = false
+				}
+				if !strings.HasPrefix(line, "@@") {
+					continue
+				}
+			} else if privateKeyRe.MatchString(line) {
+				inKey = !privateKeyEnd.MatchString(line)
+			}
+			b.WriteString(redactLine(line, &rep))
+			b.WriteString("\n")
+		}
+	}
+	out := strings.TrimSuffix(b.String(), "\n")
+	if strings.HasSuffix(patch, "\n") {
+		out += "\n"
+	}
+	if diff := len(patch) - len(out); diff > 0 {
+		rep.RemovedBytes = diff
+	}
+	return out, rep
+}
+
+func redactLine(line string, rep *Report) string {
+	if privateKeyRe.MatchString(line) {
+		rep.Secrets++
+		return line[:1] + "[redacted private key]"
+	}
+	for _, re := range secretPatterns {
+		if n := len(re.FindAllStringIndex(line, -1)); n > 0 {
+			rep.Secrets += n
+			line = re.ReplaceAllString(line, "[redacted secret]")
+		}
+	}
+	if n := len(secretAssignRe.FindAllStringIndex(line, -1)); n > 0 {
+		rep.Secrets += n
+		line = secretAssignRe.ReplaceAllString(line, "${1}[redacted secret]${3}")
+	}
+	if n := len(blobRe.FindAllStringIndex(line, -1)); n > 0 {
+		rep.Blobs += n
+		line = blobRe.ReplaceAllStringFunc(line, func(s string) string {
+			return fmt.Sprintf("[redacted blob %d chars]", len(s))
+		})
+	}
+	return line
+}
+
+func countChanges(lines []string) (int, int) {
+	added, removed := 0, 0
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "+++"), strings.HasPrefix(l, "---"):
+		case strings.HasPrefix(l, "+"):
+			added++
+		case strings.HasPrefix(l, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
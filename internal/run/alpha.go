@@ -0,0 +1,86 @@
+package run
+
+import "fmt"
+
+const (
+	AlphaStatic  = "static"
+	AlphaAnneal  = "anneal"
+	AlphaPlateau = "plateau"
+)
+
+// alphaSchedule moves alpha between iterations. It starts at start and ends
+// at end, the configured Alpha: anneal moves linearly over MaxIters, and
+// plateau moves step towards end after each iteration without improvement,
+// so realism leads the search until it stalls.
+type alphaSchedule struct {
+	mode  string
+	start float64
+	end   float64
+	step  float64
+}
+
+func newAlphaSchedule(cfg Config) *alphaSchedule {
+	if cfg.AlphaSchedule == "" || cfg.AlphaSchedule == AlphaStatic {
+		return nil
+	}
+	return &alphaSchedule{mode: cfg.AlphaSchedule, start: cfg.AlphaStart, end: cfg.Alpha, step: cfg.AlphaStep}
+}
+
+// next returns the alpha of iteration iter given the current one.
+func (s *alphaSchedule) next(iter, maxIters int, current float64, noImprovement int) float64 {
+	switch {
+	case iter <= 1:
+		return s.start
+	case s.mode == AlphaAnneal:
+		if maxIters <= 1 {
+			return s.end
+		}
+		t := float64(iter-1) / float64(maxIters-1)
+		if t > 1 {
+			t = 1
+		}
+		return s.start + (s.end-s.start)*t
+	case s.mode == AlphaPlateau && noImprovement > 0:
+		if current < s.end {
+			return minFloat(current+s.step, s.end)
+		}
+		return maxFloat(current-s.step, s.end)
+	}
+	return current
+}
+
+// scheduleAlpha sets the alpha of the iteration when a schedule is on. Like
+// an alpha change from the control file, it rescores the incumbent so later
+// comparisons stay on the same scale.
+func (r *Runner) scheduleAlpha(state *loopState, iter int) {
+	if state.alpha == nil {
+		return
+	}
+	alpha := state.alpha.next(iter, r.cfg.MaxIters, r.cfg.Alpha, state.noImprovement)
+	if alpha == r.cfg.Alpha {
+		return
+	}
+	r.log.Info("alpha scheduled", "iteration", iter, "schedule", state.alpha.mode, "alpha", alpha, "previous", r.cfg.Alpha)
+	r.cfg.Alpha = alpha
+	if state.best.iteration > 0 {
+		state.best.final = r.finalScore(state.best.tech, state.best.realism)
+	}
+	state.runLog.Alpha = alpha
+}
+
+func validateAlphaSchedule(c Config) error {
+	switch c.AlphaSchedule {
+	case "", AlphaStatic:
+		return nil
+	case AlphaAnneal, AlphaPlateau:
+	default:
+		return fmt.Errorf("alpha-schedule must be %s, %s, or %s", AlphaStatic, AlphaAnneal, AlphaPlateau)
+	}
+	if c.AlphaStart < 0 || c.AlphaStart > 1 {
+		return fmt.Errorf("alpha-start must be in [0,1]")
+	}
+	if c.AlphaSchedule == AlphaPlateau && (c.AlphaStep <= 0 || c.AlphaStep > 1) {
+		return fmt.Errorf("alpha-step must be in (0,1]")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	AnonymizeOff         = "off"
+	AnonymizeHistory     = "history"
+	AnonymizeIdentifiers = "identifiers"
+)
+
+// Neutral names that replace the module path and the GitHub owner/name of
+// the repository in identifiers mode.
+const (
+	anonymousModule = "example.com/project"
+	anonymousSlug   = "example/project"
+)
+
+// Files larger than this, or with a NUL byte near the start, are left as
+// they are when identifiers are renamed.
+const (
+	maxRenameFileBytes = 1 << 20
+	binarySniffBytes   = 8000
+)
+
+// AnonymizationLog records how the repository shown to coders was
+// anonymized.
+type AnonymizationLog struct {
+	Mode string `json:"mode"`
+	// BaseCommit is the single commit of the anonymized repository, which
+	// coder worktrees start at instead of the parent commit.
+	BaseCommit string             `json:"baseCommit"`
+	Renames    []IdentifierRename `json:"renames,omitempty"`
+}
+
+// IdentifierRename is one identifier replaced in the files coders see.
+type IdentifierRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Files is the number of files the identifier was found in.
+	Files int `json:"files"`
+}
+
+// anonymizer maps text between the real repository and the anonymized one:
+// prompts and target tests are hidden before coders see them, and produced
+// patches are revealed before they are scored against the target.
+type anonymizer struct {
+	log             AnonymizationLog
+	hider, revealer *strings.Replacer
+}
+
+func (a *anonymizer) hide(s string) string {
+	if a == nil || a.hider == nil {
+		return s
+	}
+	return a.hider.Replace(s)
+}
+
+func (a *anonymizer) reveal(s string) string {
+	if a == nil || a.revealer == nil {
+		return s
+	}
+	return a.revealer.Replace(s)
+}
+
+// revealBytes is reveal for file contents, where nil means no file.
+func (a *anonymizer) revealBytes(b []byte) []byte {
+	if b == nil || a == nil || a.revealer == nil {
+		return b
+	}
+	return []byte(a.revealer.Replace(string(b)))
+}
+
+func (a *anonymizer) runLog() *AnonymizationLog {
+	if a == nil {
+		return nil
+	}
+	return &a.log
+}
+
+// hideTests returns the target tests as they would read in the anonymized
+// repository.
+func (a *anonymizer) hideTests(tests []targetTestFile) []targetTestFile {
+	if a == nil || a.hider == nil {
+		return tests
+	}
+	out := make([]targetTestFile, len(tests))
+	for i, t := range tests {
+		out[i] = t
+		if t.content != nil {
+			out[i].content = []byte(a.hider.Replace(string(t.content)))
+		}
+	}
+	return out
+}
+
+// anonymizeRepo replaces the sealed repository coders see with one holding
+// the parent tree as a single commit, so upstream commit IDs, messages, and
+// authors cannot be used to look the project up. In identifiers mode the Go
+// module path and the GitHub owner/name are also replaced in every text file.
+func (r *Runner) anonymizeRepo(ctx context.Context, env *runEnv) error {
+	a := &anonymizer{log: AnonymizationLog{Mode: r.cfg.Anonymize}}
+	var rewrite func(dir string) error
+	if r.cfg.Anonymize == AnonymizeIdentifiers {
+		rewrite = func(dir string) error {
+			renames := identifierRenames(dir, r.publicRepoSlug(ctx, env))
+			if len(renames) == 0 {
+				return nil
+			}
+			var hide, reveal []string
+			for _, rn := range renames {
+				hide = append(hide, rn.From, rn.To)
+				reveal = append(reveal, rn.To, rn.From)
+			}
+			a.hider, a.revealer = strings.NewReplacer(hide...), strings.NewReplacer(reveal...)
+			var err error
+			a.log.Renames, err = renameIdentifiers(dir, renames, a.hider)
+			return err
+		}
+	}
+	path := filepath.Join(r.cfg.Workdir, "anonymized")
+	base, err := git.AnonymizeRepo(ctx, env.sealedRepo, path, env.commitInfo.ParentSHA, rewrite)
+	if err != nil {
+		return categorize(ErrorGit, fmt.Errorf("anonymize repository: %w", err))
+	}
+	if err := git.CheckIsolation(ctx, path, base, env.commitInfo.TargetSHA, "", false); err != nil {
+		return categorize(ErrorIsolation, fmt.Errorf("anonymized repository could leak the target: %w", err))
+	}
+	a.log.BaseCommit = base
+	env.sealedRepo, env.workBase, env.anonymizer = path, base, a
+	for _, rn := range a.log.Renames {
+		r.log.Info("renamed identifier for coders", "from", rn.From, "to", rn.To, "files", rn.Files)
+	}
+	return nil
+}
+
+// publicRepoSlug is the owner/name of the repository on GitHub, if any.
+func (r *Runner) publicRepoSlug(ctx context.Context, env *runEnv) string {
+	name, ok := strings.CutPrefix(r.publicRepoName(ctx, env), "github.com/")
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// identifierRenames lists what to rename in the tree at dir, longest first
+// so the module path wins over the owner/name it usually contains. Module
+// paths without a dot or slash are too generic to replace safely.
+func identifierRenames(dir, slug string) []IdentifierRename {
+	var out []IdentifierRename
+	if module := goModulePath(filepath.Join(dir, "go.mod")); strings.ContainsAny(module, "./") && module != anonymousModule {
+		out = append(out, IdentifierRename{From: module, To: anonymousModule})
+	}
+	if slug != "" && slug != anonymousSlug {
+		out = append(out, IdentifierRename{From: slug, To: anonymousSlug})
+	}
+	return out
+}
+
+// renameIdentifiers rewrites the text files under dir with replacer and
+// counts the files each identifier was found in.
+func renameIdentifiers(dir string, renames []IdentifierRename, replacer *strings.Replacer) ([]IdentifierRename, error) {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxRenameFileBytes {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data[:minInt(len(data), binarySniffBytes)], 0) >= 0 {
+			return nil
+		}
+		found := false
+		for i := range renames {
+			if bytes.Contains(data, []byte(renames[i].From)) {
+				renames[i].Files++
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+		return os.WriteFile(p, []byte(replacer.Replace(string(data))), info.Mode().Perm())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rename identifiers: %w", err)
+	}
+	return renames, nil
+}
@@ -0,0 +1,145 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ArtifactSink stores run artifacts. Names are slash-separated and relative
+// to the artifacts directory, like "run_log.json" or
+// "iter-001-cand-01.patch".
+type ArtifactSink interface {
+	WriteArtifact(name string, data []byte) error
+}
+
+// DirSink writes artifacts to a local directory. Files are replaced
+// atomically, so readers and killed processes never leave a truncated file.
+type DirSink string
+
+func (d DirSink) WriteArtifact(name string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// MemorySink keeps artifacts in memory so embedders and tests can inspect
+// them without reading the artifacts directory.
+type MemorySink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *MemorySink) WriteArtifact(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = map[string][]byte{}
+	}
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Artifact returns the last data written under name.
+func (m *MemorySink) Artifact(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	return append([]byte(nil), data...), ok
+}
+
+// Names returns the names of the written artifacts, sorted.
+func (m *MemorySink) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// artifactWriter writes artifacts to the artifacts directory and copies them
+// to the configured sinks. Later steps such as the report, rerun, and rescore
+// read the directory, so only its errors are returned; sink errors are
+// logged. Writes are serialized since parallel attempts share the writer and
+// sinks need not be safe for concurrent use.
+type artifactWriter struct {
+	dir   string
+	sinks []ArtifactSink
+	log   *slog.Logger
+	mu    *sync.Mutex
+}
+
+func (r *Runner) newArtifactWriter(dir string) artifactWriter {
+	return artifactWriter{dir: dir, sinks: r.cfg.ArtifactSinks, log: r.log, mu: &sync.Mutex{}}
+}
+
+// path is the location of an artifact in the artifacts directory.
+func (w artifactWriter) path(name string) string {
+	return filepath.Join(w.dir, filepath.FromSlash(name))
+}
+
+func (w artifactWriter) write(name string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := DirSink(w.dir).WriteArtifact(name, data); err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range w.sinks {
+		if s == nil {
+			continue
+		}
+		if err := s.WriteArtifact(name, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		w.log.Warn("artifact sink failed", "artifact", name, "error", err)
+	}
+	return nil
+}
+
+func (w artifactWriter) writeJSON(name string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", name, err)
+	}
+	return w.write(name, append(data, '\n'))
+}
@@ -0,0 +1,97 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/github"
+)
+
+const (
+	backgroundMaxIssues   = 3
+	backgroundMaxComments = 5
+	backgroundNoteBytes   = 400
+)
+
+// GitHubContextLog records the pull request context that was added to the
+// initial feedback packet.
+type GitHubContextLog struct {
+	PullRequest    int      `json:"pullRequest,omitempty"`
+	PullRequestURL string   `json:"pullRequestUrl,omitempty"`
+	Issues         []int    `json:"issues,omitempty"`
+	Comments       int      `json:"comments"`
+	Background     []string `json:"background,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// githubBackground fetches the description, linked issues, and review
+// comments of the pull request that introduced the target commit and returns
+// them as sanitized background notes. Failures are recorded in the log and
+// leave the packet without background.
+func (r *Runner) githubBackground(ctx context.Context, env *runEnv) GitHubContextLog {
+	var out GitHubContextLog
+	remote, err := git.OriginURL(ctx, env.baseRepo)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	owner, name, ok := github.ParseRepo(remote)
+	if !ok {
+		out.Error = fmt.Sprintf("origin %s is not a GitHub repository", remote)
+		return out
+	}
+
+	client := &github.Client{Token: r.cfg.GitHubToken}
+	fetchCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	pulls, err := client.PullsForCommit(fetchCtx, owner, name, env.commitInfo.TargetSHA)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	if len(pulls) == 0 {
+		out.Error = "no pull request found for the target commit"
+		return out
+	}
+	pr := pulls[0]
+	out.PullRequest = pr.Number
+	out.PullRequestURL = pr.URL
+
+	add := func(label, text string) {
+		if note := feedback.SanitizeBackground(text, backgroundNoteBytes); note != "" {
+			out.Background = append(out.Background, label+": "+note)
+		}
+	}
+	add("pull request", pr.Title+". "+pr.Body)
+
+	issues := github.LinkedIssueNumbers(pr.Body)
+	if len(issues) > backgroundMaxIssues {
+		issues = issues[:backgroundMaxIssues]
+	}
+	for _, n := range issues {
+		issue, err := client.Issue(fetchCtx, owner, name, n)
+		if err != nil {
+			out.Error = err.Error()
+			continue
+		}
+		out.Issues = append(out.Issues, n)
+		add("linked issue", issue.Title+". "+issue.Body)
+	}
+
+	comments, err := client.ReviewDiscussion(fetchCtx, owner, name, pr.Number)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Comments = len(comments)
+	for i, c := range comments {
+		if i == backgroundMaxComments {
+			break
+		}
+		add("review comment", c.Body)
+	}
+	return out
+}
@@ -0,0 +1,416 @@
+package run
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/batch"
+	"github.com/igolaizola/retrospec/internal/difficulty"
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+const (
+	BatchStatusDone            = batch.StatusDone
+	BatchStatusFailed          = batch.StatusFailed
+	BatchStatusSkipped         = batch.StatusSkipped
+	BatchStatusBudgetExhausted = "budget_exhausted"
+)
+
+type BatchOptions struct {
+	Targets []batch.Target
+	// Base holds the run settings shared by every target. Its Workdir is the
+	// batch root; each target runs in its own subdirectory.
+	Base                Config
+	MaxRetries          int
+	Dedupe              bool
+	AdaptiveBudget      bool
+	TotalCoderRuns      int
+	MaxCoderRunsPerIter int
+	// ShareStyles passes the style results of finished targets on to later
+	// targets of the same repository as Config.StylePriors, keeping them in
+	// batch_styles.json.
+	ShareStyles bool
+}
+
+type BatchResult struct {
+	Repo           string              `json:"repo"`
+	Commit         string              `json:"commit"`
+	Status         string              `json:"status"`
+	Workdir        string              `json:"workdir,omitempty"`
+	Difficulty     difficulty.Estimate `json:"difficulty"`
+	Budget         batch.Budget        `json:"budget"`
+	CoderRuns      int                 `json:"coderRuns"`
+	BestTitle      string              `json:"bestTitle,omitempty"`
+	BestIteration  int                 `json:"bestIteration,omitempty"`
+	TechSimilarity float64             `json:"techSimilarity"`
+	RealismScore   float64             `json:"realismScore"`
+	FinalScore     float64             `json:"finalScore"`
+	ScoringVersion int                 `json:"scoringVersion"`
+	// Rescore names the rescore file the scores come from when the run was
+	// scored by another scoring version.
+	Rescore         string        `json:"rescore,omitempty"`
+	FailureCategory ErrorCategory `json:"failureCategory,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+type BatchSummary struct {
+	Targets        int                `json:"targets"`
+	Done           int                `json:"done"`
+	Failed         int                `json:"failed"`
+	Skipped        int                `json:"skipped"`
+	MeanFinalScore float64            `json:"meanFinalScore"`
+	ScoringVersion int                `json:"scoringVersion"`
+	Results        []BatchResult      `json:"results"`
+	SkipRecords    []batch.SkipRecord `json:"skipRecords,omitempty"`
+	StartedAt      time.Time          `json:"startedAt"`
+	CompletedAt    time.Time          `json:"completedAt"`
+	// Styles are the shared style statistics per repository with
+	// ShareStyles.
+	Styles []BatchRepoStyles `json:"styles,omitempty"`
+}
+
+type inspectedTarget struct {
+	batch.Fingerprinted
+	estimate difficulty.Estimate
+}
+
+// ExecuteBatch runs the optimization loop for every target in its own
+// workdir under opts.Base.Workdir. Progress is persisted in
+// batch_status.json so an interrupted batch resumes where it stopped, and
+// the consolidated summary is written to batch_summary.json.
+func ExecuteBatch(ctx context.Context, opts BatchOptions) (BatchSummary, error) {
+	root := opts.Base.Workdir
+	targets := uniqueTargets(opts.Targets)
+	summary := BatchSummary{Targets: len(targets), ScoringVersion: scoring.Version, StartedAt: time.Now()}
+	status, err := batch.LoadStatus(filepath.Join(root, "batch_status.json"))
+	if err != nil {
+		return summary, categorize(ErrorArtifact, err)
+	}
+
+	var styles *batchStyles
+	if opts.ShareStyles {
+		styles, err = loadBatchStyles(filepath.Join(root, batchStylesFile))
+		if err != nil {
+			return summary, categorize(ErrorArtifact, err)
+		}
+	}
+
+	log := opts.Base.logger()
+	inspected, failures := inspectTargets(ctx, root, targets, opts.Base.Parent, opts.Base.cloneOptions(), log)
+	for _, f := range failures {
+		summary.Results = append(summary.Results, f)
+	}
+
+	queue := inspected
+	if opts.Dedupe {
+		items := make([]batch.Fingerprinted, 0, len(inspected))
+		byTarget := map[batch.Target]inspectedTarget{}
+		for _, it := range inspected {
+			items = append(items, it.Fingerprinted)
+			byTarget[it.Target] = it
+		}
+		keep, skipped := batch.Dedupe(items)
+		queue = make([]inspectedTarget, 0, len(keep))
+		for _, k := range keep {
+			queue = append(queue, byTarget[k.Target])
+		}
+		for _, s := range skipped {
+			if err := status.Mark(s.Target, batch.StatusSkipped, "", errors.New(s.Reason)); err != nil {
+				return summary, categorize(ErrorArtifact, err)
+			}
+			summary.Results = append(summary.Results, BatchResult{
+				Repo:       s.Target.Repo,
+				Commit:     s.Target.Commit,
+				Status:     BatchStatusSkipped,
+				Difficulty: byTarget[s.Target].estimate,
+				Error:      s.Reason,
+			})
+		}
+		summary.SkipRecords = skipped
+	}
+
+	maxPerIter := opts.Base.CandidatesPerIter
+	if opts.MaxCoderRunsPerIter > 0 {
+		maxPerIter = minInt(maxPerIter, opts.MaxCoderRunsPerIter)
+	}
+	alloc := &batch.Allocator{
+		Base:                batch.Budget{MaxIters: opts.Base.MaxIters, CoderRunsPerIter: opts.Base.CoderRunsPerIter},
+		MaxCoderRunsPerIter: maxPerIter,
+		TotalCoderRuns:      opts.TotalCoderRuns,
+	}
+
+	for i, it := range queue {
+		if ctx.Err() != nil {
+			break
+		}
+		workdir := filepath.Join(root, targetSlug(it.Target))
+		res := BatchResult{Repo: it.Repo, Commit: it.Commit, Workdir: workdir, Difficulty: it.estimate}
+
+		if !status.ShouldRun(it.Target, opts.MaxRetries) {
+			prev := status.Get(it.Target)
+			res.Status = prev.Status
+			res.Error = prev.Error
+			if prev.Status == batch.StatusDone {
+				fillFromArtifacts(&res, workdir, opts.Base, log)
+				if err := shareStyles(styles, it.Target, workdir); err != nil {
+					return summary, categorize(ErrorArtifact, err)
+				}
+			}
+			alloc.Consume(res.CoderRuns)
+			summary.Results = append(summary.Results, res)
+			continue
+		}
+		if alloc.Remaining() == 0 {
+			res.Status = BatchStatusBudgetExhausted
+			summary.Results = append(summary.Results, res)
+			continue
+		}
+
+		res.Budget = alloc.Base
+		if opts.AdaptiveBudget || opts.TotalCoderRuns > 0 {
+			res.Budget = alloc.Allocate(it.estimate, len(queue)-i)
+		}
+		cfg := opts.Base
+		cfg.Repo = it.Repo
+		cfg.Commit = it.Commit
+		cfg.Workdir = workdir
+		cfg.MaxIters = res.Budget.MaxIters
+		cfg.CoderRunsPerIter = res.Budget.CoderRunsPerIter
+		if styles != nil {
+			cfg.StylePriors = styles.priors(it.Repo)
+		}
+
+		cfg.Logger = log.With("repo", it.Repo, "commit", it.Commit)
+		cfg.Logger.Info("batch target", "target", i+1, "targets", len(queue), "bucket", it.estimate.Bucket, "maxIters", cfg.MaxIters, "coderRunsPerIter", cfg.CoderRunsPerIter)
+		if err := status.Mark(it.Target, batch.StatusRunning, workdir, nil); err != nil {
+			return summary, categorize(ErrorArtifact, err)
+		}
+		runErr := cfg.Validate()
+		if runErr == nil {
+			_, runErr = NewRunner(cfg).Execute(ctx)
+		}
+		fillFromArtifacts(&res, workdir, cfg, cfg.Logger)
+		alloc.Consume(res.CoderRuns)
+
+		res.Status = batch.StatusDone
+		if runErr != nil {
+			res.Status = batch.StatusFailed
+			res.FailureCategory = ErrorCategoryOf(runErr)
+			res.Error = runErr.Error()
+		}
+		if err := status.Mark(it.Target, res.Status, workdir, runErr); err != nil {
+			return summary, categorize(ErrorArtifact, err)
+		}
+		if runErr == nil {
+			if err := shareStyles(styles, it.Target, workdir); err != nil {
+				return summary, categorize(ErrorArtifact, err)
+			}
+		}
+		summary.Results = append(summary.Results, res)
+	}
+
+	if styles != nil {
+		summary.Styles = styles.repos
+	}
+	summary.finish()
+	if err := writeJSON(filepath.Join(root, "batch_summary.json"), summary); err != nil {
+		return summary, categorize(ErrorArtifact, fmt.Errorf("write batch_summary.json: %w", err))
+	}
+	if err := ctx.Err(); err != nil {
+		return summary, categorize(ErrorCanceled, err)
+	}
+	return summary, nil
+}
+
+// shareStyles adds the style breakdown of a finished target to styles, if
+// sharing is on and the run log can be read.
+func shareStyles(styles *batchStyles, t batch.Target, workdir string) error {
+	if styles == nil {
+		return nil
+	}
+	runLog, err := readRunLog(filepath.Join(workdir, "artifacts", "run_log.json"))
+	if err != nil {
+		return nil
+	}
+	return styles.add(t.Repo, t.Commit, runLog.Styles)
+}
+
+func (s *BatchSummary) finish() {
+	total := 0.0
+	for _, r := range s.Results {
+		switch r.Status {
+		case batch.StatusDone:
+			s.Done++
+			total += r.FinalScore
+		case batch.StatusFailed:
+			s.Failed++
+		case batch.StatusSkipped, BatchStatusBudgetExhausted:
+			s.Skipped++
+		}
+	}
+	if s.Done > 0 {
+		s.MeanFinalScore = total / float64(s.Done)
+	}
+	s.CompletedAt = time.Now()
+}
+
+// inspectTargets clones each repository once and computes the fingerprint and
+// difficulty of every target commit, without calling any model.
+func inspectTargets(ctx context.Context, root string, targets []batch.Target, parent int, clone git.CloneOptions, log *slog.Logger) ([]inspectedTarget, []BatchResult) {
+	var out []inspectedTarget
+	var failures []BatchResult
+	bases := map[string]string{}
+	baseErrs := map[string]error{}
+
+	for _, t := range targets {
+		base, ok := bases[t.Repo]
+		if !ok && baseErrs[t.Repo] == nil {
+			dir := filepath.Join(root, "inspect", repoSlug(t.Repo))
+			var err error
+			base, err = git.PrepareBaseRepo(ctx, t.Repo, dir, clone)
+			if err != nil {
+				baseErrs[t.Repo] = err
+			} else {
+				bases[t.Repo] = base
+			}
+		}
+		if err := baseErrs[t.Repo]; err != nil {
+			failures = append(failures, BatchResult{Repo: t.Repo, Commit: t.Commit, Status: batch.StatusFailed, FailureCategory: ErrorGit, Error: err.Error()})
+			continue
+		}
+
+		info, err := git.ResolveCommitInfo(ctx, base, t.Commit, parent)
+		var snap git.DiffSnapshot
+		if err == nil {
+			snap, err = git.SnapshotBetween(ctx, base, info.ParentSHA, info.TargetSHA)
+		}
+		if err != nil {
+			log.Warn("inspect failed", "repo", t.Repo, "commit", t.Commit, "error", err)
+			failures = append(failures, BatchResult{Repo: t.Repo, Commit: t.Commit, Status: batch.StatusFailed, FailureCategory: ErrorGit, Error: err.Error()})
+			continue
+		}
+		out = append(out, inspectedTarget{
+			Fingerprinted: batch.Fingerprinted{
+				Target:            t,
+				Fingerprint:       git.PatchFingerprint(snap.Patch),
+				RevertFingerprint: git.RevertFingerprint(snap.Patch),
+			},
+			estimate: difficulty.Assess(snap),
+		})
+	}
+	return out, failures
+}
+
+// fillFromArtifacts copies scores and coder usage of a finished run into res.
+// Runs scored by another scoring version are rescored with cfg, or take the
+// scores of an earlier rescore with the current version, so the summary
+// never mixes versions.
+func fillFromArtifacts(res *BatchResult, workdir string, cfg Config, log *slog.Logger) {
+	artifacts := filepath.Join(workdir, "artifacts")
+	if data, err := os.ReadFile(filepath.Join(artifacts, "metrics.json")); err == nil {
+		var m Metrics
+		if json.Unmarshal(data, &m) == nil {
+			if m.ScoringVersion != scoring.Version {
+				m = currentScoringMetrics(res, workdir, m, cfg, log)
+			}
+			res.BestTitle = m.Title
+			res.BestIteration = m.BestIteration
+			res.TechSimilarity = m.TechSimilarity
+			res.RealismScore = m.RealismScore
+			res.FinalScore = m.FinalScore
+			res.ScoringVersion = m.ScoringVersion
+		}
+	}
+	if runLog, err := readRunLog(filepath.Join(artifacts, "run_log.json")); err == nil {
+		res.CoderRuns = 0
+		for _, it := range runLog.Iterations {
+			for _, a := range it.CoderAttempts {
+				if a.DuplicateOf == nil {
+					res.CoderRuns++
+				}
+			}
+		}
+	}
+}
+
+func currentScoringMetrics(res *BatchResult, workdir string, m Metrics, cfg Config, log *slog.Logger) Metrics {
+	artifacts := filepath.Join(workdir, "artifacts")
+	if rescored, name, ok := latestRescore(artifacts); ok && rescored.ScoringVersion == scoring.Version {
+		res.Rescore = name
+		return rescored.Metrics
+	}
+	cfg.Workdir = workdir
+	cfg.Logger = log
+	rescored, err := NewRunner(cfg).Rescore()
+	if err != nil {
+		log.Warn("run scored by another scoring version could not be rescored", "workdir", workdir, "scoringVersion", m.ScoringVersion, "error", err)
+		return m
+	}
+	res.Rescore = fmt.Sprintf("rescore-%03d.json", rescored.Version)
+	log.Warn("rescored run from another scoring version", "workdir", workdir, "from", m.ScoringVersion, "to", scoring.Version)
+	return rescored.Metrics
+}
+
+// latestRescore returns the rescore file with the highest number.
+func latestRescore(artifactsDir string) (RescoreLog, string, bool) {
+	next, err := nextRescoreVersion(artifactsDir)
+	if err != nil || next == 1 {
+		return RescoreLog{}, "", false
+	}
+	name := fmt.Sprintf("rescore-%03d.json", next-1)
+	data, err := os.ReadFile(filepath.Join(artifactsDir, name))
+	if err != nil {
+		return RescoreLog{}, "", false
+	}
+	var out RescoreLog
+	if json.Unmarshal(data, &out) != nil {
+		return RescoreLog{}, "", false
+	}
+	return out, name, true
+}
+
+// uniqueTargets drops repeated listings of the same repo and commit, which
+// would otherwise share one status entry and workdir.
+func uniqueTargets(targets []batch.Target) []batch.Target {
+	seen := map[batch.Target]struct{}{}
+	out := make([]batch.Target, 0, len(targets))
+	for _, t := range targets {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		out = append(out, t)
+	}
+	return out
+}
+
+var slugRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func repoSlug(repo string) string {
+	name := strings.TrimSuffix(filepath.Base(strings.TrimRight(repo, "/")), ".git")
+	name = strings.Trim(slugRe.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		name = "repo"
+	}
+	sum := sha256.Sum256([]byte(repo))
+	return name + "-" + hex.EncodeToString(sum[:3])
+}
+
+func targetSlug(t batch.Target) string {
+	commit := strings.Trim(slugRe.ReplaceAllString(t.Commit, "-"), "-")
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return repoSlug(t.Repo) + "-" + commit
+}
@@ -0,0 +1,124 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const batchStylesFile = "batch_styles.json"
+
+// BatchRepoStyles is what the targets of one repository in a batch taught
+// about SpecWriter styles. Commits lists the targets counted, so a resumed
+// batch does not count a run twice.
+type BatchRepoStyles struct {
+	Repo    string            `json:"repo"`
+	Commits []string          `json:"commits"`
+	Styles  []BatchStyleStats `json:"styles"`
+}
+
+// BatchStyleStats sums the StyleStats of a style over the runs of a
+// repository.
+type BatchStyleStats struct {
+	Style    string `json:"style"`
+	Runs     int    `json:"runs"`
+	Attempts int    `json:"attempts"`
+	// Scored is the number of attempts behind MeanFinal; attempts that
+	// failed in the coder are not scored.
+	Scored    int     `json:"scored"`
+	Selected  int     `json:"selected"`
+	MeanFinal float64 `json:"meanFinal"`
+	MaxFinal  float64 `json:"maxFinal"`
+}
+
+// batchStyles persists the shared style statistics of a batch in
+// batch_styles.json in the batch root.
+type batchStyles struct {
+	path  string
+	repos []BatchRepoStyles
+}
+
+func loadBatchStyles(path string) (*batchStyles, error) {
+	s := &batchStyles{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read batch styles: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.repos); err != nil {
+		return nil, fmt.Errorf("parse batch styles %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *batchStyles) repo(name string) *BatchRepoStyles {
+	for i := range s.repos {
+		if s.repos[i].Repo == name {
+			return &s.repos[i]
+		}
+	}
+	s.repos = append(s.repos, BatchRepoStyles{Repo: name})
+	return &s.repos[len(s.repos)-1]
+}
+
+// priors returns the style priors of a repository, nil before any of its
+// targets finished.
+func (s *batchStyles) priors(repo string) []StylePrior {
+	var out []StylePrior
+	for _, r := range s.repos {
+		if r.Repo != repo {
+			continue
+		}
+		for _, st := range r.Styles {
+			out = append(out, StylePrior{Style: st.Style, Scored: st.Scored, MeanFinal: st.MeanFinal})
+		}
+	}
+	return out
+}
+
+// add counts the style breakdown of a finished run of commit and saves the
+// file. A commit already counted is ignored.
+func (s *batchStyles) add(repo, commit string, styles []StyleStats) error {
+	r := s.repo(repo)
+	for _, c := range r.Commits {
+		if c == commit {
+			return nil
+		}
+	}
+	r.Commits = append(r.Commits, commit)
+	for _, st := range styles {
+		var agg *BatchStyleStats
+		for i := range r.Styles {
+			if r.Styles[i].Style == st.Style {
+				agg = &r.Styles[i]
+			}
+		}
+		if agg == nil {
+			r.Styles = append(r.Styles, BatchStyleStats{Style: st.Style})
+			agg = &r.Styles[len(r.Styles)-1]
+		}
+		scored := st.Attempts - st.Failed
+		if total := agg.Scored + scored; total > 0 {
+			agg.MeanFinal = (agg.MeanFinal*float64(agg.Scored) + st.MeanFinal*float64(scored)) / float64(total)
+		}
+		agg.Runs++
+		agg.Attempts += st.Attempts
+		agg.Scored += scored
+		agg.Selected += st.Selected
+		agg.MaxFinal = maxFloat(agg.MaxFinal, st.MaxFinal)
+	}
+	sort.Slice(r.Styles, func(i, j int) bool {
+		if r.Styles[i].MeanFinal != r.Styles[j].MeanFinal {
+			return r.Styles[i].MeanFinal > r.Styles[j].MeanFinal
+		}
+		return r.Styles[i].Style < r.Styles[j].Style
+	})
+	if err := writeJSON(s.path, s.repos); err != nil {
+		return fmt.Errorf("write %s: %w", batchStylesFile, err)
+	}
+	return nil
+}
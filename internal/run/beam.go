@@ -0,0 +1,253 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/redact"
+)
+
+// lineage is one prompt line of the search: the prompt it continues from,
+// that attempt's feedback, and the SpecWriter session that refines it. With
+// a beam width of 1 there is a single lineage and the loop is a plain hill
+// climb from the best attempt of each iteration.
+type lineage struct {
+	// slot is the lineage's position in the beam, and selects its SpecWriter.
+	slot            int
+	spec            llm.ChatProvider
+	previousPrompt  string
+	previousOutcome string
+	feedbackText    string
+	directives      []string
+}
+
+// BeamLog records which attempt each lineage continues from after an
+// iteration. It is only written when the beam is wider than one.
+type BeamLog struct {
+	Lineage int `json:"lineage"`
+	// Parent is the lineage whose candidate the attempt came from.
+	Parent         int             `json:"parent"`
+	CandidateIndex int             `json:"candidateIndex"`
+	FinalScore     float64         `json:"finalScore"`
+	FeedbackPacket feedback.Packet `json:"feedbackPacket"`
+	Critic         *CriticLog      `json:"critic,omitempty"`
+}
+
+func newBeam(env *runEnv, width int, initialFeedback string) []*lineage {
+	beam := []*lineage{{slot: 0, spec: env.providers.spec, feedbackText: initialFeedback}}
+	for i, spec := range env.providers.beamSpec {
+		if i+1 >= width {
+			break
+		}
+		beam = append(beam, &lineage{slot: i + 1, spec: spec, feedbackText: initialFeedback})
+	}
+	return beam
+}
+
+// id is the lineage number recorded in logs, 1-based and only set when the
+// beam has more than one lineage so single-lineage logs are unchanged.
+func (l *lineage) id(beam []*lineage) int {
+	if len(beam) <= 1 {
+		return 0
+	}
+	return l.slot + 1
+}
+
+// selectSurvivors returns the positions of the best attempts with distinct
+// prompts, best first, up to width.
+func selectSurvivors(attempts []coderAttemptRuntime, width int) []int {
+	order := make([]int, len(attempts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return attempts[order[i]].log.FinalScore > attempts[order[j]].log.FinalScore
+	})
+	seen := map[string]struct{}{}
+	var out []int
+	for _, i := range order {
+		h := attempts[i].log.PromptHash
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		out = append(out, i)
+		if len(out) == width {
+			break
+		}
+	}
+	return out
+}
+
+// GapBatchLog records an iteration's batched gap call.
+type GapBatchLog struct {
+	// CandidateIndexes are the attempts summarized together, in prompt order.
+	CandidateIndexes []int    `json:"candidateIndexes"`
+	Shared           []string `json:"shared,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// batchedGaps are an attempt's intent gaps from a batched gap call.
+type batchedGaps struct {
+	gaps   []string
+	shared []string
+}
+
+// batchGaps summarizes the intent gaps of the survivors, topped up with the
+// next best attempts, in one gap call when GapBatch is above 1. It returns
+// the gaps by attempt position. Survivors left out of the batch, or all of
+// them when the call fails, get a call of their own in attemptFeedback.
+func (r *Runner) batchGaps(ctx context.Context, env *runEnv, attempts []coderAttemptRuntime, survivors []int) (map[int]batchedGaps, *GapBatchLog) {
+	if r.cfg.GapBatch < 2 {
+		return nil, nil
+	}
+	picked := append([]int(nil), survivors...)
+	if len(picked) > r.cfg.GapBatch {
+		picked = picked[:r.cfg.GapBatch]
+	}
+	seen := map[string]bool{}
+	for _, i := range picked {
+		seen[attempts[i].log.PromptHash] = true
+	}
+	for _, i := range selectSurvivors(attempts, len(attempts)) {
+		if len(picked) >= r.cfg.GapBatch {
+			break
+		}
+		if seen[attempts[i].log.PromptHash] || attempts[i].produced.Patch == "XX" {
+			continue
+		}
+		seen[attempts[i].log.PromptHash] = true
+		picked = append(picked, i)
+	}
+	if len(picked) < 2 {
+		return nil, nil
+	}
+
+	batchLog := &GapBatchLog{}
+	patches := make([]string, len(picked))
+	for j, i := range picked {
+		patches[j], _ = redact.Patch(attempts[i].produced.Patch, env.detector)
+		batchLog.CandidateIndexes = append(batchLog.CandidateIndexes, attempts[i].log.CandidateIndex)
+	}
+	gapCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	res, err := llm.SummarizeIntentGaps(gapCtx, env.providers.gap, env.redactedTarget, patches, 4)
+	cancel()
+	if err != nil {
+		r.log.Warn("batched gap summary failed, summarizing attempts one by one", "attempts", len(picked), "error", err)
+		batchLog.Error = err.Error()
+		return nil, batchLog
+	}
+	batchLog.Shared = res.Shared
+	out := map[int]batchedGaps{}
+	for j, i := range picked {
+		out[i] = batchedGaps{gaps: res.Attempts[j].Gaps, shared: res.Shared}
+	}
+	return out, batchLog
+}
+
+// attemptFeedback builds the feedback packet for an attempt, including the
+// gap model's intent gaps when available. Gaps already summarized in a batch
+// are used instead of a call of its own.
+func (r *Runner) attemptFeedback(ctx context.Context, env *runEnv, iter int, attempt coderAttemptRuntime, batched *batchedGaps) feedback.Packet {
+	packet := feedback.BuildIterationPacket(
+		iter,
+		env.target,
+		attempt.produced,
+		attempt.log.Tech,
+		attempt.log.TestResult.Category,
+		r.cfg.MaxPathRefs,
+	)
+	if attempt.log.CoderError != "" {
+		packet.IntentGaps = append(packet.IntentGaps, "coder execution had issues; refine acceptance criteria and constraints")
+	}
+
+	if batched != nil {
+		packet.SharedGaps = batched.shared
+		packet.IntentGaps = dedupeStrings(append(packet.IntentGaps, batched.gaps...))
+		return packet
+	}
+
+	gapCtx, cancelGap := context.WithTimeout(ctx, 90*time.Second)
+	producedPatch, _ := redact.Patch(attempt.produced.Patch, env.detector)
+	llmGap, gapErr := llm.SummarizeIntentGap(gapCtx, env.providers.gap, env.redactedTarget, producedPatch, 4)
+	cancelGap()
+	if gapErr == nil && len(llmGap.Gaps) > 0 {
+		packet.IntentGaps = dedupeStrings(append(packet.IntentGaps, llmGap.Gaps...))
+	}
+	return packet
+}
+
+// advanceBeam moves each lineage on to a surviving attempt. A survivor keeps
+// the slot, and so the SpecWriter session, of the lineage it came from when
+// that slot is still free. Slots left without a survivor keep their lineage
+// unchanged.
+func (r *Runner) advanceBeam(ctx context.Context, env *runEnv, state *loopState, iter int, attempts []coderAttemptRuntime, survivors []int) ([]BeamLog, feedback.Packet, *CriticLog, *GapBatchLog) {
+	beam := state.beam
+	bySlot := map[int]*lineage{}
+	for _, l := range beam {
+		bySlot[l.slot] = l
+	}
+	assigned := make([]*lineage, len(survivors))
+	taken := map[int]bool{}
+	for i, idx := range survivors {
+		slot := attempts[idx].lineage
+		if !taken[slot] {
+			assigned[i] = bySlot[slot]
+			taken[slot] = true
+		}
+	}
+	for i := range survivors {
+		if assigned[i] != nil {
+			continue
+		}
+		for _, l := range beam {
+			if !taken[l.slot] {
+				assigned[i] = l
+				taken[l.slot] = true
+				break
+			}
+		}
+	}
+
+	gaps, gapLog := r.batchGaps(ctx, env, attempts, survivors)
+	var logs []BeamLog
+	var bestPacket feedback.Packet
+	var bestCritic *CriticLog
+	for i, idx := range survivors {
+		a := attempts[idx]
+		l := assigned[i]
+		var batched *batchedGaps
+		if g, ok := gaps[idx]; ok {
+			batched = &g
+		}
+		packet := r.attemptFeedback(ctx, env, iter, a, batched)
+		l.feedbackText = feedback.PacketText(packet)
+		l.previousPrompt = a.log.CandidatePrompt
+		l.previousOutcome = fmt.Sprintf(
+			"tech %.2f realism %.2f final %.2f test=%s",
+			a.log.Tech.Score,
+			a.log.Realism.Score,
+			a.log.FinalScore,
+			a.log.TestResult.Category,
+		)
+		criticLog := r.critique(ctx, env, l, a.log.CandidatePrompt)
+		if i == 0 {
+			bestPacket, bestCritic = packet, criticLog
+		}
+		if len(beam) > 1 {
+			logs = append(logs, BeamLog{
+				Lineage:        l.id(beam),
+				Parent:         a.lineage + 1,
+				CandidateIndex: a.log.CandidateIndex,
+				FinalScore:     a.log.FinalScore,
+				FeedbackPacket: packet,
+				Critic:         criticLog,
+			})
+		}
+	}
+	return logs, bestPacket, bestCritic, gapLog
+}
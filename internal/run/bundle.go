@@ -0,0 +1,330 @@
+package run
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/sandbox"
+)
+
+const (
+	runConfigFile  = "run_config.json"
+	bundleManifest = "manifest.json"
+	bundleConfig   = "config.yaml"
+	bundleVersion  = 1
+)
+
+// RunConfig is written to artifacts/run_config.json when a run starts. It
+// records what a bundle needs to reproduce the run and cannot be recovered
+// from the other artifacts.
+type RunConfig struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"goVersion"`
+	Platform  string            `json:"platform"`
+	Settings  map[string]string `json:"settings,omitempty"`
+	Seeds     map[string]int64  `json:"seeds"`
+	Tools     map[string]string `json:"tools"`
+	StartedAt time.Time         `json:"startedAt"`
+}
+
+// BundleFile is an archived artifact with its checksum.
+type BundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BundlePrompt identifies a prompt that was sent to the coder.
+type BundlePrompt struct {
+	Iteration  int    `json:"iteration"`
+	Candidate  int    `json:"candidate"`
+	PromptHash string `json:"promptHash"`
+	PatchPath  string `json:"patchPath,omitempty"`
+}
+
+// BundleManifest describes a reproducibility bundle. It is the first entry of
+// the archive.
+type BundleManifest struct {
+	FormatVersion int            `json:"formatVersion"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Repo          string         `json:"repo"`
+	TargetCommit  string         `json:"targetCommit"`
+	ParentCommit  string         `json:"parentCommit"`
+	CommitRange   string         `json:"commitRange,omitempty"`
+	RangeCommits  []string       `json:"rangeCommits,omitempty"`
+	Fingerprint   string         `json:"fingerprint,omitempty"`
+	StoppedReason string         `json:"stoppedReason,omitempty"`
+	Run           *RunConfig     `json:"run,omitempty"`
+	Prompts       []BundlePrompt `json:"prompts"`
+	Files         []BundleFile   `json:"files"`
+	Warnings      []string       `json:"warnings,omitempty"`
+}
+
+// writeRunConfig records the settings, seeds, and tool versions of the run.
+// It is best effort since a missing file only weakens a later bundle.
+func (r *Runner) writeRunConfig(ctx context.Context, env *runEnv) {
+	rc := RunConfig{
+		Version:   buildVersion(),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Settings:  r.runSettings(),
+		Seeds:     map[string]int64{"bootstrap": bootstrapSeed},
+		Tools:     map[string]string{},
+		StartedAt: time.Now().UTC(),
+	}
+	tools := map[string][]string{"git": {"git", "--version"}}
+	if r.cfg.usesProvider(ProviderCopilot) {
+		tools["copilot"] = []string{"copilot", "--version"}
+	}
+	if env.sandbox != nil && env.sandbox.Kind() == sandbox.KindDocker {
+		tools["docker"] = []string{"docker", "version", "--format", "{{.Server.Version}}"}
+		rc.Tools["sandbox-image"] = r.cfg.SandboxImage
+	}
+	for name, args := range tools {
+		rc.Tools[name] = toolVersion(ctx, args)
+	}
+	if err := env.artifacts.writeJSON(runConfigFile, rc); err != nil {
+		r.log.Warn("failed to write "+runConfigFile, "error", err)
+	}
+}
+
+// runSettings returns the recorded settings with the values a batch sets per
+// target.
+func (r *Runner) runSettings() map[string]string {
+	if len(r.cfg.Settings) == 0 {
+		return nil
+	}
+	settings := make(map[string]string, len(r.cfg.Settings))
+	for k, v := range r.cfg.Settings {
+		settings[k] = v
+	}
+	settings["repo"] = r.cfg.Repo
+	settings["commit"] = r.cfg.Commit
+	settings["workdir"] = r.cfg.Workdir
+	settings["max-iters"] = strconv.Itoa(r.cfg.MaxIters)
+	settings["coder-runs-per-iter"] = strconv.Itoa(r.cfg.CoderRunsPerIter)
+	return settings
+}
+
+func toolVersion(ctx context.Context, args []string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "unavailable"
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// Bundle packages the artifacts of the run in workdir into a gzipped tar at
+// out with a manifest of the commits, settings, seeds, tool versions, prompt
+// hashes, and file checksums. When the run recorded its settings, the
+// archive also gets a config.yaml that --config accepts. Clones and
+// worktrees are not included; the commit SHAs identify them.
+func Bundle(workdir, out string) (BundleManifest, error) {
+	workdir, err := filepath.Abs(workdir)
+	if err != nil {
+		return BundleManifest{}, categorize(ErrorConfig, fmt.Errorf("resolve workdir: %w", err))
+	}
+	artifactsDir := filepath.Join(workdir, "artifacts")
+	runLog, err := readRunLog(filepath.Join(artifactsDir, "run_log.json"))
+	if err != nil {
+		return BundleManifest{}, categorize(ErrorArtifact, err)
+	}
+
+	manifest := BundleManifest{
+		FormatVersion: bundleVersion,
+		CreatedAt:     time.Now().UTC(),
+		Repo:          runLog.Repo,
+		TargetCommit:  runLog.TargetCommit,
+		ParentCommit:  runLog.ParentCommit,
+		CommitRange:   runLog.CommitRange,
+		RangeCommits:  runLog.RangeCommits,
+		Fingerprint:   runLog.Fingerprint,
+		StoppedReason: runLog.StoppedReason,
+		Prompts:       []BundlePrompt{},
+	}
+	for _, iter := range runLog.Iterations {
+		for _, a := range iter.CoderAttempts {
+			manifest.Prompts = append(manifest.Prompts, BundlePrompt{
+				Iteration:  iter.Iteration,
+				Candidate:  a.CandidateIndex,
+				PromptHash: a.PromptHash,
+				PatchPath:  relArtifact(artifactsDir, a.ProducedPatchPath),
+			})
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(artifactsDir, runConfigFile)); err == nil {
+		var rc RunConfig
+		if err := json.Unmarshal(data, &rc); err != nil {
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("parse %s: %v", runConfigFile, err))
+		} else {
+			manifest.Run = &rc
+		}
+	} else {
+		manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("%s is missing; settings, seeds, and tool versions are unknown", runConfigFile))
+	}
+
+	var files []string
+	err = filepath.WalkDir(artifactsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return BundleManifest{}, categorize(ErrorArtifact, fmt.Errorf("list artifacts: %w", err))
+	}
+	sort.Strings(files)
+	manifest.Files = []BundleFile{}
+	for _, path := range files {
+		sum, size, err := fileSHA256(path)
+		if err != nil {
+			return BundleManifest{}, categorize(ErrorArtifact, err)
+		}
+		manifest.Files = append(manifest.Files, BundleFile{Path: relArtifact(artifactsDir, path), Size: size, SHA256: sum})
+	}
+
+	if err := writeBundle(out, manifest, artifactsDir, files); err != nil {
+		_ = os.Remove(out)
+		return BundleManifest{}, categorize(ErrorArtifact, err)
+	}
+	return manifest, nil
+}
+
+func writeBundle(out string, manifest BundleManifest, artifactsDir string, files []string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("create bundle: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := addBundleBytes(tw, bundleManifest, append(data, '\n'), manifest.CreatedAt); err != nil {
+		return err
+	}
+	if manifest.Run != nil && len(manifest.Run.Settings) > 0 {
+		if err := addBundleBytes(tw, bundleConfig, settingsYAML(manifest.Run.Settings), manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+	for _, path := range files {
+		if err := addBundleFile(tw, path, "artifacts/"+relArtifact(artifactsDir, path)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	return f.Close()
+}
+
+func addBundleBytes(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write bundle %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write bundle %s: %w", name, err)
+	}
+	return nil
+}
+
+func addBundleFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", name, err)
+	}
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write bundle %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("write bundle %s: %w", name, err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// relArtifact returns path relative to the artifacts dir with forward
+// slashes, or path itself when it is outside of it.
+func relArtifact(artifactsDir, path string) string {
+	if path == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(artifactsDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// settingsYAML writes settings in the flat config file format, sorted by
+// flag name and with every value double quoted.
+func settingsYAML(settings map[string]string) []byte {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var b strings.Builder
+	b.WriteString("# Settings of the bundled run; use with --config.\n")
+	for _, k := range keys {
+		b.WriteString(k + ": \"" + quote.Replace(settings[k]) + "\"\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,116 @@
+package run
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// candidateCache stores SpecWriter replies under the workdir, keyed by a
+// hash of the rendered prompt, the spec model, and the draft slot. A retried
+// or resumed run whose feedback and style are unchanged gets the same prompt
+// and reuses the reply instead of paying for it again.
+type candidateCache struct {
+	dir   string
+	model string
+}
+
+type cachedCandidate struct {
+	Candidate llm.SpecCandidate `json:"candidate"`
+	Raw       string            `json:"raw"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// candidateCache returns the cache of the run, or a disabled one.
+func (r *Runner) candidateCache() candidateCache {
+	if !r.cfg.CandidateCache {
+		return candidateCache{}
+	}
+	model := r.providerIdentity(r.cfg.providerFor(r.cfg.SpecProvider), r.roleModel(llm.RoleSpecWriter))
+	return candidateCache{dir: filepath.Join(r.cfg.Workdir, "cache", "candidates"), model: model}
+}
+
+// providerIdentity names the provider, model, and settings behind a role, so
+// cached replies are only reused for the same model.
+func (r *Runner) providerIdentity(kind string, m roleModel) string {
+	model := kind + "\x00" + m.model + "\x00" + m.effort
+	switch kind {
+	case ProviderOpenAI:
+		model += "\x00" + r.cfg.OpenAIEndpoint + "\x00" + r.cfg.OpenAIModel
+	case ProviderAnthropic:
+		model += "\x00" + r.cfg.AnthropicModel
+	case ProviderOllama:
+		model += "\x00" + r.cfg.OllamaEndpoint + "\x00" + r.cfg.OllamaModel
+	}
+	return model
+}
+
+// key addresses a generation. The slot and attempt keep drafts that share a
+// prompt, like repeated styles, beam lineages, or validation retries with
+// the same violation, from collapsing into one.
+func (c candidateCache) key(prompt string, lineage, slot, attempt int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\x00%s", c.model, lineage, slot, attempt, prompt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c candidateCache) get(key string) (cachedCandidate, bool) {
+	if c.dir == "" {
+		return cachedCandidate{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+	if err != nil {
+		return cachedCandidate{}, false
+	}
+	var entry cachedCandidate
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return cachedCandidate{}, false
+	}
+	return entry, true
+}
+
+func (c candidateCache) put(key string, entry cachedCandidate) error {
+	if c.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return DirSink(c.dir).WriteArtifact(key+".json", append(data, '\n'))
+}
+
+// generateSpecCandidate asks the SpecWriter for a candidate, reusing a cached
+// reply to the same prompt. Only replies that parse are cached, so a failed
+// generation is retried.
+func (r *Runner) generateSpecCandidate(ctx context.Context, spec llm.ChatProvider, req llm.GenerateSpecRequest, lineage, slot, attempt int) (llm.SpecCandidate, string, bool, error) {
+	cache := r.candidateCache()
+	if cache.dir == "" {
+		candidate, raw, err := llm.GenerateSpecCandidate(ctx, spec, req)
+		return candidate, raw, false, err
+	}
+	prompt, err := req.Templates.SpecWriter(req)
+	if err != nil {
+		return llm.SpecCandidate{}, "", false, err
+	}
+	key := cache.key(prompt, lineage, slot, attempt)
+	if entry, ok := cache.get(key); ok {
+		r.log.Debug("reusing cached candidate", "iteration", req.Iteration, "style", req.Style, "key", key[:12])
+		return entry.Candidate, entry.Raw, true, nil
+	}
+	candidate, raw, err := llm.GenerateSpecCandidate(ctx, spec, req)
+	if err != nil {
+		return candidate, raw, false, err
+	}
+	if err := cache.put(key, cachedCandidate{Candidate: candidate, Raw: raw, CreatedAt: time.Now()}); err != nil {
+		r.log.Warn("failed to cache candidate", "error", err)
+	}
+	return candidate, raw, false, nil
+}
@@ -0,0 +1,193 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/cluster"
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	// clusterDraftIndex offsets the draft indexes of cluster candidates so
+	// they do not collide with the pool or the commit-message seed.
+	clusterDraftIndex = 2000
+	clusterStyle      = "focused request for one sub-change of a larger commit"
+)
+
+// targetCluster is a sub-change of the target. Its candidates are scored
+// against its own part of the target.
+type targetCluster struct {
+	cluster.Cluster
+	target  git.DiffSnapshot
+	scoring git.DiffSnapshot
+}
+
+// ClusterLog describes a target cluster and the best prompt found for it.
+type ClusterLog struct {
+	ID int `json:"id"`
+	cluster.Cluster
+	BestIteration  int     `json:"bestIteration,omitempty"`
+	BestFinal      float64 `json:"bestFinal,omitempty"`
+	BestPromptPath string  `json:"bestPromptPath,omitempty"`
+}
+
+// clusterState is the search state of one cluster across iterations.
+type clusterState struct {
+	bestFinal     float64
+	bestPrompt    string
+	bestIteration int
+	feedbackText  string
+}
+
+// splitTarget clusters large targets. Targets below ClusterMinFiles or
+// without at least two clusters are handled by the umbrella pool alone.
+func (r *Runner) splitTarget(env *runEnv) []targetCluster {
+	if r.cfg.ClusterMinFiles <= 0 || len(env.target.ChangedFiles) < r.cfg.ClusterMinFiles {
+		return nil
+	}
+	clusters := cluster.Split(env.target, r.cfg.MaxClusters)
+	if len(clusters) < 2 {
+		return nil
+	}
+	out := make([]targetCluster, 0, len(clusters))
+	for _, c := range clusters {
+		target := cluster.Snapshot(env.target, c)
+		scoringTarget, _ := env.detector.Strip(target)
+		out = append(out, targetCluster{Cluster: c, target: target, scoring: scoringTarget})
+	}
+	return out
+}
+
+// scoringFor returns the scoring target of a cluster, or of the whole target
+// for umbrella candidates (cluster 0).
+func (env *runEnv) scoringFor(cluster int) git.DiffSnapshot {
+	if cluster > 0 && cluster <= len(env.clusters) {
+		return env.clusters[cluster-1].scoring
+	}
+	return env.scoringTarget
+}
+
+func (r *Runner) initClusters(env *runEnv) ([]ClusterLog, []clusterState) {
+	if len(env.clusters) == 0 {
+		return nil, nil
+	}
+	logs := make([]ClusterLog, len(env.clusters))
+	states := make([]clusterState, len(env.clusters))
+	for i, c := range env.clusters {
+		logs[i] = ClusterLog{ID: i + 1, Cluster: c.Cluster}
+		states[i] = clusterState{
+			bestFinal:    -1,
+			feedbackText: feedback.PacketText(feedback.BuildInitialPacket(0, c.target, env.commitInfo.CommitMessage, r.cfg.MaxPathRefs)),
+		}
+	}
+	return logs, states
+}
+
+// clusterDrafts generates one candidate per cluster with the first lineage's
+// SpecWriter. Failed generations are logged as drafts and skipped.
+func (r *Runner) clusterDrafts(ctx context.Context, env *runEnv, state *loopState, iter int) []candidateDraftRuntime {
+	spec := state.beam[0].spec
+	var out []candidateDraftRuntime
+	for i, c := range env.clusters {
+		cs := state.clusters[i]
+		focus := fmt.Sprintf("Sub-change %d of %d of the target commit, touching %s. Describe only this sub-change; the other sub-changes are requested separately.", i+1, len(env.clusters), strings.Join(c.Dirs, ", "))
+		in := generationInput{
+			iteration:      iter,
+			feedbackText:   buildObjectiveAnchor(env.commitInfo.CommitMessage, c.target) + "\n\n" + focus + "\n\n" + cs.feedbackText,
+			previousPrompt: cs.bestPrompt,
+			promptHistory:  state.promptHistory,
+			commitMessage:  env.commitInfo.CommitMessage,
+			target:         c.target,
+			exemplars:      env.exemplarPool,
+			scopes:         env.scopes,
+			templates:      env.providers.templates,
+		}
+		gen, err := r.generateValidCandidate(ctx, spec, in, i, clusterStyle)
+		d := candidateDraftRuntime{
+			log: CandidateDraftLog{
+				Index:             clusterDraftIndex + i + 1,
+				Style:             clusterStyle,
+				Cluster:           i + 1,
+				ValidationRetries: gen.retries,
+				RawSpecResponse:   gen.raw,
+				ShortenedFrom:     gen.shortenedFrom,
+				Refinement:        gen.refinement,
+			},
+			cluster: i + 1,
+		}
+		if err != nil {
+			d.log.GenerationError = err.Error()
+			out = append(out, d)
+			continue
+		}
+		d.log.Title = gen.candidate.Title
+		d.log.CandidatePrompt = gen.candidate.CandidatePrompt
+		d.log.Rationale = gen.candidate.Rationale
+		d.log.ScopeHints = append([]string(nil), gen.candidate.ScopeHints...)
+		lint := LintPrompt(gen.candidate.CandidatePrompt)
+		d.log.Lint = &lint
+		d.candidate = gen.candidate
+		d.valid = true
+		out = append(out, d)
+	}
+	return out
+}
+
+// splitClusterAttempts separates umbrella attempts, which compete for the
+// best prompt and the beam, from cluster attempts. When no umbrella attempt
+// ran, the cluster attempts stand in so the iteration still has a best.
+func splitClusterAttempts(attempts []coderAttemptRuntime) (umbrella, clusters []coderAttemptRuntime) {
+	for _, a := range attempts {
+		if a.cluster > 0 {
+			clusters = append(clusters, a)
+		} else {
+			umbrella = append(umbrella, a)
+		}
+	}
+	if len(umbrella) == 0 {
+		return attempts, clusters
+	}
+	return umbrella, clusters
+}
+
+// updateClusters keeps the best prompt of each cluster and prepares its
+// feedback for the next iteration.
+func (r *Runner) updateClusters(env *runEnv, state *loopState, iter int, attempts []coderAttemptRuntime) {
+	for _, a := range attempts {
+		if a.cluster <= 0 || a.cluster > len(state.clusters) {
+			continue
+		}
+		cs := &state.clusters[a.cluster-1]
+		c := env.clusters[a.cluster-1]
+		packet := feedback.BuildIterationPacket(iter, c.target, a.produced, a.log.Tech, a.log.TestResult.Category, r.cfg.MaxPathRefs)
+		cs.feedbackText = feedback.PacketText(packet)
+		if a.log.FinalScore > cs.bestFinal {
+			cs.bestFinal = a.log.FinalScore
+			cs.bestPrompt = a.log.CandidatePrompt
+			cs.bestIteration = iter
+		}
+	}
+}
+
+// writeClusterPrompts writes best_prompt.cluster-NN.md for every cluster
+// that produced an attempt and records the results in the run log.
+func (r *Runner) writeClusterPrompts(env *runEnv, state *loopState) error {
+	for i := range state.runLog.Clusters {
+		cs := state.clusters[i]
+		if cs.bestIteration == 0 {
+			continue
+		}
+		name := fmt.Sprintf("best_prompt.cluster-%02d.md", i+1)
+		if err := env.artifacts.write(name, []byte(cs.bestPrompt+"\n")); err != nil {
+			return categorize(ErrorArtifact, fmt.Errorf("write cluster prompt: %w", err))
+		}
+		l := &state.runLog.Clusters[i]
+		l.BestIteration = cs.bestIteration
+		l.BestFinal = cs.bestFinal
+		l.BestPromptPath = env.artifacts.path(name)
+	}
+	return nil
+}
@@ -2,27 +2,318 @@ package run
 
 import (
 	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/scoring"
 )
 
 type Config struct {
-	Repo              string
-	Commit            string
-	Workdir           string
-	MaxIters          int
-	Threshold         float64
-	TimeoutSeconds    int
-	KeepRuns          bool
-	Verbose           bool
+	Repo        string
+	Commit      string
+	CommitRange string
+	// Parent is the 1-based parent a merge Commit is diffed against, like
+	// git's -m option; 0 uses the first parent.
+	Parent         int
+	Workdir        string
+	MaxIters       int
+	Threshold      float64
+	TimeoutSeconds int
+	// TestCommand replaces test runner discovery with a shell command run
+	// at the repository root.
+	TestCommand string
+	// TestScope is changed, dependents, or all; the first two narrow Go
+	// tests to the packages the produced patch touches.
+	TestScope string
+	KeepRuns  bool
+	// Workspace is how each coder run gets its checkout: a worktree of the
+	// sealed repository, a shared clone of it, or a copy-on-write copy of a
+	// checkout made once per run.
+	Workspace string
+	// CloneDepth and CloneFilter make the base clone shallow or partial, like
+	// git clone's --depth and --filter, for large repositories.
+	CloneDepth  int
+	CloneFilter string
+	// Submodules checks the submodules of the parent out in every coder
+	// checkout, and LFS fills in its git-lfs files; otherwise submodule
+	// directories stay empty and LFS files hold their pointers.
+	Submodules bool
+	LFS        bool
+	// Verbose logs at debug level unless LogLevel is set.
+	Verbose bool
+	// LogLevel is debug, info, warn, or error and LogFormat is text or
+	// json. Logs go to stderr unless Logger is set.
+	LogLevel          string
+	LogFormat         string
+	Logger            *slog.Logger
 	Alpha             float64
 	MaxPathRefs       int
 	MaxIdentifiers    int
 	MaxLength         int
+	IncludeGenerated  bool
+	GeneratedPatterns string
+	GoASTScoring      bool
+	// AlphaSchedule is static, anneal, or plateau; the last two move alpha
+	// from AlphaStart to Alpha over the run, see alphaSchedule.
+	AlphaSchedule string
+	AlphaStart    float64
+	AlphaStep     float64
+	// StylePriors are style results of earlier runs. Styles that did better
+	// are drafted first when CandidatesPerIter leaves some out, and their
+	// drafts get a small pre-score bonus. Batches fill them in per repository.
+	StylePriors []StylePrior
+	// TargetTests runs the target's added or changed test files against
+	// each produced change and blends the outcome into technical similarity.
+	TargetTests bool
+	// SeedPrompts are files with prompts of the user's own that join the
+	// first iteration's candidate pool, validated and scored like drafts.
+	SeedPrompts []string
+	// TestHint ends coder prompts with a reminder to verify the change with
+	// the project's tests when the target adds or changes tests.
+	TestHint bool
+	// RenameMatch is exact, source, or path; see scoring.RenameMatchPath.
+	RenameMatch string
+	// ScoreIgnore are comma-separated path globs left out of technical
+	// similarity, and ScoreWeights comma-separated pattern=weight pairs
+	// scaling the lines of matching files.
+	ScoreIgnore       string
+	ScoreWeights      string
+	Explain           bool
+	ShortenOverlength bool
+	SelfRefine        string
 	CandidatesPerIter int
 	CoderRunsPerIter  int
-	Model             string
+	// DedupeSimilarity makes drafts whose prompts are at least this similar
+	// share one coder run (0 only shares identical prompts), and
+	// ReuseAttempts extends the sharing to prompts run in earlier
+	// iterations.
+	DedupeSimilarity float64
+	ReuseAttempts    bool
+	// Novelty is the metric comparing candidates to earlier prompts:
+	// jaccard, ngram, or embedding, which calls the embeddings API of an
+	// openai or ollama SpecWriter with EmbeddingModel. NoveltyWeight is its
+	// share of the realism part of the pre-score.
+	Novelty        string
+	NoveltyWeight  float64
+	EmbeddingModel string
+	// BeamWidth is the number of prompt lineages kept between iterations;
+	// each generates CandidatesPerIter drafts and runs CoderRunsPerIter.
+	BeamWidth int
+	// GapBatch summarizes the intent gaps of up to this many top attempts
+	// in one gap call; 1 makes a call per lineage with the best attempt only.
+	GapBatch int
+	// ClusterMinFiles enables diff clustering for targets that change at
+	// least this many files (0 disables); each of up to MaxClusters clusters
+	// gets its own candidate per iteration.
+	ClusterMinFiles int
+	MaxClusters     int
+	// CoderSamples is the number of coder runs per attempt.
+	CoderSamples   int
+	ParallelCoders int
+	Model          string
+	SpecModel      string
+	JudgeModel     string
+	CoderModel     string
+	// Reasoning efforts are low, medium, or high; empty uses the default.
+	SpecReasoningEffort  string
+	JudgeReasoningEffort string
+	CoderReasoningEffort string
+	FreezePolicy         string
+	FreezeSections       string
+	PromptLibrary        string
+	Exemplars            int
+	ExemplarTokenBudget  int
+	SpecLanguage         string
+	// RealismProfile is a calibration profile written by the calibrate
+	// command that replaces the heuristic realism limits and weights.
+	RealismProfile string
+	// TemplatesDir holds text/template files overriding the SpecWriter,
+	// judge, and coder prompts; empty uses the embedded defaults.
+	TemplatesDir       string
+	Provider           string
+	SpecProvider       string
+	JudgeProvider      string
+	GapProvider        string
+	CriticProvider     string
+	OpenAIEndpoint     string
+	OpenAIModel        string
+	OpenAIAPIKey       string
+	AnthropicModel     string
+	AnthropicAPIKey    string
+	OllamaEndpoint     string
+	OllamaModel        string
+	ProviderRetries    int
+	JudgeMaxFailures   int
+	JudgeNormalization string
+	// JudgeSamples is the number of judge calls per prompt; realism uses
+	// their mean. JudgeModels are more judge models the samples rotate
+	// through after JudgeModel, on the judge provider.
+	JudgeSamples int
+	JudgeModels  string
+	// JudgeCache reuses judge scores stored under the workdir for prompts
+	// judged before, and JudgeCacheSimilarity also for prompts at least that
+	// similar by word-pair Jaccard (0 = identical prompts only).
+	JudgeCache           bool
+	JudgeCacheSimilarity float64
+	// JudgeTopN judges only the N attempts of an iteration ranked highest
+	// on heuristic realism (0 judges all), and JudgeEvery only every K-th
+	// iteration, starting with the first.
+	JudgeTopN        int
+	JudgeEvery       int
+	ReviewComparison bool
+	// ContaminationCheck asks the coder model what it remembers of the
+	// target commit before the first iteration.
+	ContaminationCheck bool
+	// Anonymize hides the upstream project from coders: off, history (a
+	// single neutral commit instead of the sealed history), or identifiers
+	// (history, plus the Go module path and GitHub owner/name renamed).
+	Anonymize string
+	// Intents writes intents.json, a decomposition of the target into
+	// sub-intents by the gap model.
+	Intents bool
+	// GitHubContext adds the pull request description, linked issues, and
+	// review comments to the initial feedback packet.
+	GitHubContext bool
+	Traceability  bool
+	Report        bool
+	// CandidateCache reuses SpecWriter replies stored under the workdir for
+	// identical prompts.
+	CandidateCache bool
+	GitHubToken    string
+	// Sandbox is host or docker; docker runs coder commands and tests in a
+	// container of SandboxImage with the worktree mounted.
+	Sandbox        string
+	SandboxImage   string
+	SandboxCPUs    string
+	SandboxMemory  string
+	SandboxNetwork string
+
+	Patience int
+	// MinDelta is how much the best final score must rise for an iteration
+	// to count as an improvement for Patience.
+	MinDelta float64
+	// MaxDuration and MaxTokens stop the run after the iteration that goes
+	// over them; zero disables them. Tokens are the approximate prompt and
+	// reply tokens of every role whose calls go through the middleware.
+	MaxDuration time.Duration
+	MaxTokens   int
+	StopFile    string
+	// Step runs a single iteration per invocation, continuing from the loop
+	// state saved in the workdir by the previous one.
+	Step bool
+	// Smoothing is off, ema, or window; it applies to the improvement check
+	// behind patience and adaptive freezing.
+	Smoothing       string
+	SmoothingFactor float64
+	SmoothingWindow int
+
+	// Settings are the effective command-line settings keyed by flag name,
+	// without secrets. They are recorded in run_config.json for bundles.
+	Settings map[string]string
+
+	// StopConditions are checked after the built-in stop conditions.
+	StopConditions []StopCondition
+
+	// NoveltyMetric replaces the metric selected by Novelty.
+	NoveltyMetric NoveltyMetric
+
+	// Middleware is applied to every spec, judge, and gap provider call.
+	Middleware []llm.Middleware
+
+	// EventSinks receive every event written to events.jsonl.
+	EventSinks []EventSink
+
+	// Review pauses each iteration after candidate generation for the
+	// candidates to be approved, edited, or rejected before any coder runs
+	// them: off, terminal, or file. Reviewer replaces the built-in reviewer
+	// of the mode and turns the gate on by itself.
+	Review   string
+	Reviewer CandidateReviewer
+
+	// ArtifactSinks receive a copy of every artifact written to the
+	// artifacts directory, except the events.jsonl stream.
+	ArtifactSinks []ArtifactSink
+}
+
+// DefaultConfig returns the defaults of the command-line flags. Repo and
+// Commit (or CommitRange) must still be set, and API keys are not read from
+// the environment.
+func DefaultConfig() Config {
+	return Config{
+		Workdir:             "./work",
+		MaxIters:            8,
+		Threshold:           0.9,
+		Patience:            3,
+		Smoothing:           SmoothingOff,
+		SmoothingFactor:     0.5,
+		SmoothingWindow:     3,
+		StopFile:            "STOP",
+		TimeoutSeconds:      600,
+		LogFormat:           LogFormatText,
+		Alpha:               0.75,
+		AlphaSchedule:       AlphaStatic,
+		AlphaStart:          0.4,
+		AlphaStep:           0.1,
+		MaxPathRefs:         3,
+		MaxIdentifiers:      25,
+		ShortenOverlength:   true,
+		RenameMatch:         scoring.RenameMatchPath,
+		CandidatesPerIter:   3,
+		CoderRunsPerIter:    2,
+		ReuseAttempts:       true,
+		Novelty:             NoveltyJaccard,
+		NoveltyWeight:       0.2,
+		MaxClusters:         4,
+		CoderSamples:        1,
+		BeamWidth:           1,
+		GapBatch:            1,
+		TestScope:           TestScopeChanged,
+		ParallelCoders:      1,
+		FreezePolicy:        FreezePolicyOff,
+		FreezeSections:      "context,constraints",
+		Review:              ReviewOff,
+		Anonymize:           AnonymizeOff,
+		Workspace:           WorkspaceWorktree,
+		Submodules:          true,
+		LFS:                 true,
+		Exemplars:           2,
+		ExemplarTokenBudget: 1200,
+		SpecLanguage:        "en",
+		Provider:            ProviderCopilot,
+		OllamaEndpoint:      "http://localhost:11434/v1",
+		JudgeMaxFailures:    3,
+		JudgeNormalization:  JudgeNormalizationRejudge,
+		JudgeSamples:        1,
+		JudgeCache:          true,
+		JudgeEvery:          1,
+		Traceability:        true,
+		Report:              true,
+		CandidateCache:      true,
+		Sandbox:             "host",
+		SandboxCPUs:         "2",
+		SandboxMemory:       "4g",
+		SandboxNetwork:      "none",
+	}
 }
 
 func (c Config) Validate() error {
+	if c.Commit != "" && c.CommitRange != "" {
+		return fmt.Errorf("commit and commit-range are mutually exclusive")
+	}
+	if c.CommitRange != "" && !strings.Contains(c.CommitRange, "..") {
+		return fmt.Errorf("commit-range must be of the form base..head")
+	}
+	if c.Parent < 0 {
+		return fmt.Errorf("parent must be >= 0")
+	}
+	if c.Parent > 0 && c.CommitRange != "" {
+		return fmt.Errorf("parent only applies to commit, not commit-range")
+	}
 	if c.MaxIters <= 0 {
 		return fmt.Errorf("max-iters must be > 0")
 	}
@@ -53,12 +344,216 @@ func (c Config) Validate() error {
 	if c.CoderRunsPerIter > c.CandidatesPerIter {
 		return fmt.Errorf("coder-runs-per-iter must be <= candidates-per-iter")
 	}
+	if c.DedupeSimilarity < 0 || c.DedupeSimilarity > 1 {
+		return fmt.Errorf("dedupe-similarity must be in [0,1]")
+	}
+	switch c.Novelty {
+	case NoveltyJaccard, NoveltyNGram, NoveltyEmbedding:
+	default:
+		return fmt.Errorf("novelty must be one of %s", strings.Join(noveltyMetrics(), ", "))
+	}
+	if c.Novelty == NoveltyEmbedding && c.NoveltyMetric == nil {
+		if kind := c.providerFor(c.SpecProvider); kind != ProviderOpenAI && kind != ProviderOllama {
+			return fmt.Errorf("novelty embedding needs an openai or ollama spec provider, got %s", kind)
+		}
+	}
+	if c.NoveltyWeight < 0 || c.NoveltyWeight > 1 {
+		return fmt.Errorf("novelty-weight must be in [0,1]")
+	}
+	if c.ClusterMinFiles < 0 {
+		return fmt.Errorf("cluster-min-files must be >= 0")
+	}
+	if c.ClusterMinFiles > 0 && c.MaxClusters < 2 {
+		return fmt.Errorf("max-clusters must be >= 2")
+	}
+	if c.CoderSamples < 1 {
+		return fmt.Errorf("coder-samples must be >= 1")
+	}
+	if c.BeamWidth < 1 {
+		return fmt.Errorf("beam-width must be >= 1")
+	}
+	switch c.TestScope {
+	case TestScopeChanged, TestScopeDependents, TestScopeAll:
+	default:
+		return fmt.Errorf("test-scope must be one of %s", strings.Join(testScopes(), ", "))
+	}
+	if c.GapBatch < 1 || c.GapBatch > 4 {
+		return fmt.Errorf("gap-batch must be between 1 and 4")
+	}
+	if c.ParallelCoders < 1 {
+		return fmt.Errorf("parallel-coders must be >= 1")
+	}
+	if c.Exemplars < 0 {
+		return fmt.Errorf("exemplars must be >= 0")
+	}
+	if c.ExemplarTokenBudget < 0 {
+		return fmt.Errorf("exemplar-token-budget must be >= 0")
+	}
+	switch c.RenameMatch {
+	case scoring.RenameMatchExact, scoring.RenameMatchSource, scoring.RenameMatchPath:
+	default:
+		return fmt.Errorf("rename-match must be one of %s, %s, %s", scoring.RenameMatchExact, scoring.RenameMatchSource, scoring.RenameMatchPath)
+	}
+	if c.RealismProfile != "" {
+		if _, err := scoring.LoadRealismProfile(c.RealismProfile); err != nil {
+			return fmt.Errorf("realism-profile: %w", err)
+		}
+	}
+	if !scoring.IsSupportedLanguage(c.SpecLanguage) {
+		return fmt.Errorf("spec-language must be one of %s", strings.Join(scoring.SupportedLanguages(), ", "))
+	}
+	for _, p := range []struct{ role, kind string }{
+		{"", c.Provider},
+		{"spec", c.SpecProvider},
+		{"judge", c.JudgeProvider},
+		{"gap", c.GapProvider},
+		{"critic", c.CriticProvider},
+	} {
+		if p.kind == "" {
+			continue
+		}
+		if err := validateProviderKind(p.role, p.kind); err != nil {
+			return err
+		}
+	}
+	for _, e := range []struct{ role, effort string }{
+		{"spec", c.SpecReasoningEffort},
+		{"judge", c.JudgeReasoningEffort},
+		{"coder", c.CoderReasoningEffort},
+	} {
+		switch e.effort {
+		case "", "low", "medium", "high":
+		default:
+			return fmt.Errorf("%s-reasoning-effort must be one of low, medium, high", e.role)
+		}
+	}
+	if c.TemplatesDir != "" {
+		if _, err := llm.LoadTemplates(c.TemplatesDir); err != nil {
+			return fmt.Errorf("templates-dir: %w", err)
+		}
+	}
+	if c.Patience < 0 {
+		return fmt.Errorf("patience must be >= 0")
+	}
+	if err := validateAlphaSchedule(c); err != nil {
+		return err
+	}
+	if c.MinDelta < 0 || c.MinDelta >= 1 {
+		return fmt.Errorf("min-delta must be in [0,1)")
+	}
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("max-duration must be >= 0")
+	}
+	if c.MaxTokens < 0 {
+		return fmt.Errorf("max-tokens must be >= 0")
+	}
+	if err := validateLogging(c); err != nil {
+		return err
+	}
+	if err := validateSmoothing(c); err != nil {
+		return err
+	}
+	if c.JudgeSamples < 1 {
+		return fmt.Errorf("judge-samples must be >= 1")
+	}
+	if c.JudgeCacheSimilarity < 0 || c.JudgeCacheSimilarity > 1 {
+		return fmt.Errorf("judge-cache-similarity must be in [0,1]")
+	}
+	if c.JudgeTopN < 0 {
+		return fmt.Errorf("judge-top-n must be >= 0")
+	}
+	if c.JudgeEvery < 1 {
+		return fmt.Errorf("judge-every must be >= 1")
+	}
+	if c.JudgeMaxFailures < 0 {
+		return fmt.Errorf("judge-max-failures must be >= 0")
+	}
+	switch c.JudgeNormalization {
+	case JudgeNormalizationOff, JudgeNormalizationRejudge, JudgeNormalizationHeuristic:
+	default:
+		return fmt.Errorf("judge-normalization must be one of %s, %s, %s", JudgeNormalizationOff, JudgeNormalizationRejudge, JudgeNormalizationHeuristic)
+	}
+	for _, p := range generated.ParsePatterns(c.GeneratedPatterns) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("generated-patterns contains invalid glob %q", p)
+		}
+	}
+	for _, p := range generated.ParsePatterns(c.ScoreIgnore) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("score-ignore contains invalid glob %q", p)
+		}
+	}
+	if _, err := scoring.ParsePathWeights(c.ScoreWeights); err != nil {
+		return fmt.Errorf("score-weights: %w", err)
+	}
+	if c.ProviderRetries < 0 {
+		return fmt.Errorf("provider-retries must be >= 0")
+	}
+	if c.usesProvider(ProviderOpenAI) {
+		if strings.TrimSpace(c.OpenAIEndpoint) == "" {
+			return fmt.Errorf("openai-endpoint is required when a role uses the openai provider")
+		}
+		if strings.TrimSpace(c.OpenAIModel) == "" {
+			return fmt.Errorf("openai-model is required when a role uses the openai provider")
+		}
+	}
+	if c.usesProvider(ProviderAnthropic) {
+		if strings.TrimSpace(c.AnthropicModel) == "" {
+			return fmt.Errorf("anthropic-model is required when a role uses the anthropic provider")
+		}
+		if strings.TrimSpace(c.AnthropicAPIKey) == "" {
+			return fmt.Errorf("anthropic-api-key is required when a role uses the anthropic provider")
+		}
+	}
+	if c.usesProvider(ProviderOllama) {
+		if strings.TrimSpace(c.OllamaEndpoint) == "" {
+			return fmt.Errorf("ollama-endpoint is required when a role uses the ollama provider")
+		}
+		if strings.TrimSpace(c.OllamaModel) == "" {
+			return fmt.Errorf("ollama-model is required when a role uses the ollama provider")
+		}
+	}
+	if err := validateSandbox(c); err != nil {
+		return err
+	}
+	if err := validateFreezeConfig(c.FreezePolicy, c.FreezeSections); err != nil {
+		return err
+	}
+	switch c.Review {
+	case "", ReviewOff, ReviewTerminal, ReviewFile:
+	default:
+		return fmt.Errorf("review must be %s, %s, or %s", ReviewOff, ReviewTerminal, ReviewFile)
+	}
+	switch c.Anonymize {
+	case "", AnonymizeOff, AnonymizeHistory, AnonymizeIdentifiers:
+	default:
+		return fmt.Errorf("anonymize must be %s, %s, or %s", AnonymizeOff, AnonymizeHistory, AnonymizeIdentifiers)
+	}
+	if c.CloneDepth < 0 {
+		return fmt.Errorf("clone-depth must be >= 0")
+	}
+	if strings.ContainsAny(c.CloneFilter, " \t\n") {
+		return fmt.Errorf("clone-filter must be a git object filter such as blob:none")
+	}
+	switch c.Workspace {
+	case "", WorkspaceWorktree, WorkspaceShared, WorkspaceCopy:
+	default:
+		return fmt.Errorf("workspace must be %s, %s, or %s", WorkspaceWorktree, WorkspaceShared, WorkspaceCopy)
+	}
 	return nil
 }
 
+func (c Config) cloneOptions() git.CloneOptions {
+	return git.CloneOptions{Depth: c.CloneDepth, Filter: c.CloneFilter}
+}
+
 type Result struct {
+	BestTitle          string
 	BestIteration      int
 	BestTechSimilarity float64
 	BestRealism        float64
 	BestFinalScore     float64
+	// Paused is set in step mode when the run continues at the next
+	// invocation.
+	Paused bool
 }
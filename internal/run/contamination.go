@@ -0,0 +1,155 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/github"
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+const (
+	ContaminationNone     = "none"
+	ContaminationPossible = "possible"
+	ContaminationLikely   = "likely"
+)
+
+// ContaminationLog records what the coder model remembered of the target
+// when asked by repository and commit alone. A model trained on the commit
+// can reproduce it from a vague prompt, which inflates technical scores.
+type ContaminationLog struct {
+	Repo       string           `json:"repo"`
+	CommitDate time.Time        `json:"commitDate"`
+	Recall     llm.CommitRecall `json:"recall"`
+	// FileRecall is the share of the target's changed files the model named,
+	// and MessageOverlap the share of the commit message's words found in
+	// its description.
+	FileRecall     float64 `json:"fileRecall"`
+	MessageOverlap float64 `json:"messageOverlap"`
+	Verdict        string  `json:"verdict"`
+	// CutoffNote compares the commit date with the stated knowledge cutoff.
+	CutoffNote string `json:"cutoffNote,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// checkContamination asks the coder model, in a session of its own, what it
+// remembers of the target and compares the answer with the actual commit.
+// Failures are recorded in the log, never fatal.
+func (r *Runner) checkContamination(ctx context.Context, env *runEnv) *ContaminationLog {
+	out := &ContaminationLog{Repo: r.publicRepoName(ctx, env)}
+	if t, err := git.CommitTime(ctx, env.baseRepo, env.commitInfo.TargetSHA); err == nil {
+		out.CommitDate = t
+	}
+	llmCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+	recall, err := llm.RecallCommit(llmCtx, env.providers.recall, out.Repo, env.commitInfo.TargetSHA)
+	if err != nil {
+		out.Error = err.Error()
+		r.log.Warn("contamination check failed", "error", err)
+		return out
+	}
+	out.Recall = recall
+	out.FileRecall = fileRecall(env.target.ChangedFiles, recall.Files)
+	out.MessageOverlap = messageOverlap(env.commitInfo.CommitMessage, recall.Description)
+	out.Verdict = contaminationVerdict(recall, out.FileRecall, out.MessageOverlap)
+	out.CutoffNote = cutoffNote(out.CommitDate, recall.KnowledgeCutoff)
+	if out.Verdict != ContaminationNone {
+		r.log.Warn("coder model may remember the target commit", "verdict", out.Verdict, "fileRecall", out.FileRecall, "messageOverlap", out.MessageOverlap)
+	}
+	return out
+}
+
+// publicRepoName names the repository the way the model would have seen it
+// published: owner/name on GitHub, the base name of the remote otherwise.
+// Remote URLs are not sent as is since they may carry credentials.
+func (r *Runner) publicRepoName(ctx context.Context, env *runEnv) string {
+	remote, err := git.OriginURL(ctx, env.baseRepo)
+	if err != nil || strings.TrimSpace(remote) == "" {
+		remote = r.cfg.Repo
+	}
+	if owner, name, ok := github.ParseRepo(remote); ok {
+		return "github.com/" + owner + "/" + name
+	}
+	return strings.TrimSuffix(filepath.Base(filepath.ToSlash(strings.TrimRight(remote, "/"))), ".git")
+}
+
+// fileRecall is the share of changed files named by the model. A named path
+// may leave out leading directories, but a bare file name does not count.
+func fileRecall(changed, named []string) float64 {
+	if len(changed) == 0 || len(named) == 0 {
+		return 0
+	}
+	hits := 0
+	for _, c := range changed {
+		for _, n := range named {
+			if c == n || (strings.Contains(n, "/") && strings.HasSuffix(c, "/"+n)) {
+				hits++
+				break
+			}
+		}
+	}
+	return float64(hits) / float64(len(changed))
+}
+
+// messageOverlap is the share of the commit message's words that the
+// description uses.
+func messageOverlap(message, description string) float64 {
+	want := toTokenSet(message)
+	if len(want) == 0 {
+		return 0
+	}
+	got := toTokenSet(description)
+	hits := 0
+	for tok := range want {
+		if _, ok := got[tok]; ok {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(want))
+}
+
+// contaminationVerdict grades the recall. File names alone can be guessed
+// from a well-known layout, so a likely verdict needs the description to
+// match too, unless it matches the message closely on its own.
+func contaminationVerdict(recall llm.CommitRecall, files, message float64) string {
+	switch {
+	case message >= 0.5, files >= 0.5 && message >= 0.25:
+		return ContaminationLikely
+	case recall.KnowsCommit, files > 0, message >= 0.25:
+		return ContaminationPossible
+	default:
+		return ContaminationNone
+	}
+}
+
+// cutoffNote relates the commit date to the cutoff the model states. The
+// stated cutoff is only indicative; models often misreport it.
+func cutoffNote(commit time.Time, cutoff string) string {
+	if commit.IsZero() {
+		return ""
+	}
+	end, ok := cutoffEnd(cutoff)
+	if !ok {
+		return "the coder model stated no knowledge cutoff"
+	}
+	date := commit.UTC().Format("2006-01-02")
+	if commit.Before(end) {
+		return fmt.Sprintf("the target commit (%s) predates the coder model's stated knowledge cutoff (%s), so a public commit may be in its training data", date, cutoff)
+	}
+	return fmt.Sprintf("the target commit (%s) is after the coder model's stated knowledge cutoff (%s)", date, cutoff)
+}
+
+// cutoffEnd returns the end of a YYYY-MM or YYYY cutoff.
+func cutoffEnd(cutoff string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01", cutoff); err == nil {
+		return t.AddDate(0, 1, 0), true
+	}
+	if t, err := time.Parse("2006", cutoff); err == nil {
+		return t.AddDate(1, 0, 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,177 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	controlFile         = "control.json"
+	controlPollInterval = 2 * time.Second
+)
+
+// Control is the content of <workdir>/control.json. It is read between
+// iterations, so a long run can be paused, retuned, or wrapped up without
+// being killed. Unset fields leave the current setting unchanged.
+type Control struct {
+	// Pause holds the loop after the current iteration until it is cleared.
+	Pause bool `json:"pause,omitempty"`
+	// Finalize ends the loop and writes the artifacts for the best so far.
+	Finalize          bool     `json:"finalize,omitempty"`
+	Threshold         *float64 `json:"threshold,omitempty"`
+	Alpha             *float64 `json:"alpha,omitempty"`
+	MaxIters          *int     `json:"maxIters,omitempty"`
+	CandidatesPerIter *int     `json:"candidatesPerIter,omitempty"`
+	CoderRunsPerIter  *int     `json:"coderRunsPerIter,omitempty"`
+	Patience          *int     `json:"patience,omitempty"`
+}
+
+type ControlLog struct {
+	AfterIteration int       `json:"afterIteration"`
+	Applied        []string  `json:"applied,omitempty"`
+	Paused         bool      `json:"paused,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// controlState remembers the last control file content so each edit is
+// applied once.
+type controlState struct {
+	last []byte
+}
+
+// applyControl reads the control file after an iteration, applies new
+// settings, and waits while the loop is paused. It reports whether the loop
+// should end and why.
+func (r *Runner) applyControl(ctx context.Context, env *runEnv, state *loopState, iter int) (bool, string) {
+	path := filepath.Join(r.cfg.Workdir, controlFile)
+	if r.finishRequested() {
+		r.recordControl(env, state, ControlLog{AfterIteration: iter, Applied: []string{"finalize"}})
+		return true, "finalize requested"
+	}
+	paused := false
+	for {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			data, err = nil, nil
+		}
+		var ctl Control
+		if err == nil && len(bytes.TrimSpace(data)) > 0 {
+			err = json.Unmarshal(data, &ctl)
+		}
+		changed := !bytes.Equal(data, state.control.last)
+		if changed {
+			state.control.last = data
+		}
+		if err != nil {
+			if changed {
+				r.recordControl(env, state, ControlLog{AfterIteration: iter, Error: fmt.Sprintf("read %s: %v", controlFile, err)})
+			}
+			return false, ""
+		}
+
+		if changed {
+			entry := ControlLog{AfterIteration: iter, Paused: ctl.Pause}
+			entry.Applied, err = r.applyControlSettings(state, ctl)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			if len(entry.Applied) > 0 || entry.Error != "" || ctl.Pause || ctl.Finalize {
+				r.recordControl(env, state, entry)
+			}
+		}
+		if ctl.Finalize {
+			return true, "finalize requested"
+		}
+		if !ctl.Pause {
+			break
+		}
+		if !paused {
+			paused = true
+			r.log.Info("paused by control file", "iteration", iter, "file", controlFile)
+		}
+		select {
+		case <-ctx.Done():
+			return false, ""
+		case <-time.After(controlPollInterval):
+		}
+	}
+	if iter >= r.cfg.MaxIters {
+		return true, "max-iters reached"
+	}
+	return false, ""
+}
+
+// applyControlSettings validates the adjusted config as a whole before
+// applying it. A new alpha rescores the incumbent so later comparisons stay
+// on the same scale.
+func (r *Runner) applyControlSettings(state *loopState, ctl Control) ([]string, error) {
+	cfg := r.cfg
+	var applied []string
+	setF := func(name string, dst *float64, v *float64) {
+		if v != nil && *v != *dst {
+			*dst = *v
+			applied = append(applied, fmt.Sprintf("%s=%g", name, *v))
+		}
+	}
+	setI := func(name string, dst *int, v *int) {
+		if v != nil && *v != *dst {
+			*dst = *v
+			applied = append(applied, fmt.Sprintf("%s=%d", name, *v))
+		}
+	}
+	setF("threshold", &cfg.Threshold, ctl.Threshold)
+	setF("alpha", &cfg.Alpha, ctl.Alpha)
+	setI("max-iters", &cfg.MaxIters, ctl.MaxIters)
+	setI("candidates-per-iter", &cfg.CandidatesPerIter, ctl.CandidatesPerIter)
+	setI("coder-runs-per-iter", &cfg.CoderRunsPerIter, ctl.CoderRunsPerIter)
+	setI("patience", &cfg.Patience, ctl.Patience)
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("rejected %s: %w", strings.Join(applied, ", "), err)
+	}
+
+	alphaChanged := cfg.Alpha != r.cfg.Alpha
+	r.cfg = cfg
+	if alphaChanged && state.best.iteration > 0 {
+		state.best.final = r.finalScore(state.best.tech, state.best.realism)
+	}
+	if alphaChanged && state.alpha != nil {
+		// A schedule keeps moving towards the new alpha.
+		state.alpha.end = cfg.Alpha
+	}
+	state.runLog.Alpha = cfg.Alpha
+	state.runLog.Threshold = cfg.Threshold
+	state.runLog.MaxIters = cfg.MaxIters
+	return applied, nil
+}
+
+func (r *Runner) recordControl(env *runEnv, state *loopState, entry ControlLog) {
+	entry.At = time.Now()
+	state.runLog.Controls = append(state.runLog.Controls, entry)
+	msg := strings.Join(entry.Applied, ", ")
+	switch {
+	case entry.Error != "":
+		msg = entry.Error
+	case entry.Paused:
+		msg = strings.TrimPrefix(msg+", paused", ", ")
+	}
+	if msg == "" {
+		msg = "finalize requested"
+	}
+	if entry.Error != "" {
+		r.log.Warn("control file rejected", "iteration", entry.AfterIteration, "error", entry.Error)
+	} else {
+		r.log.Info("control applied", "iteration", entry.AfterIteration, "changes", msg)
+	}
+	env.events.emit(Event{Type: EventControl, Iteration: entry.AfterIteration, Message: msg})
+}
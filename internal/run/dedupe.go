@@ -0,0 +1,175 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// promptHash identifies prompts that are the same after case and whitespace
+// normalization.
+func promptHash(prompt string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// promptSimilarity is the Jaccard similarity of the word pairs of two
+// prompts after case and whitespace normalization. Pairs keep short words
+// like "not" in context, so a negated requirement is not a near duplicate.
+func promptSimilarity(a, b string) float64 {
+	pa, pb := wordPairs(a), wordPairs(b)
+	if len(pa) == 0 && len(pb) == 0 {
+		return 1
+	}
+	inter := 0
+	for k := range pa {
+		if _, ok := pb[k]; ok {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(pa)+len(pb)-inter)
+}
+
+func wordPairs(s string) map[string]struct{} {
+	words := strings.Fields(strings.ToLower(s))
+	out := map[string]struct{}{}
+	if len(words) == 1 {
+		out[words[0]] = struct{}{}
+	}
+	for i := 0; i+1 < len(words); i++ {
+		out[words[i]+" "+words[i+1]] = struct{}{}
+	}
+	return out
+}
+
+type duplicateDraft struct {
+	draft candidateDraftRuntime
+	// original is the position in the unique list of the draft it repeats.
+	original int
+	// similarity is 1 for identical prompts and lower for near duplicates.
+	similarity float64
+}
+
+// dedupeDrafts keeps the first draft of each prompt hash, preserving rank
+// order, and returns the rest as duplicates of it. With a threshold above
+// 0, a draft whose prompt is at least that similar to a kept one is a
+// duplicate too.
+func dedupeDrafts(drafts []candidateDraftRuntime, threshold float64) ([]candidateDraftRuntime, []duplicateDraft) {
+	seen := map[string]int{}
+	unique := make([]candidateDraftRuntime, 0, len(drafts))
+	var duplicates []duplicateDraft
+	for _, d := range drafts {
+		h := promptHash(d.candidate.CandidatePrompt)
+		if i, ok := seen[h]; ok {
+			duplicates = append(duplicates, duplicateDraft{draft: d, original: i, similarity: 1})
+			continue
+		}
+		if i, sim := nearestPrompt(d.candidate.CandidatePrompt, unique, threshold); i >= 0 {
+			duplicates = append(duplicates, duplicateDraft{draft: d, original: i, similarity: sim})
+			continue
+		}
+		seen[h] = len(unique)
+		unique = append(unique, d)
+	}
+	return unique, duplicates
+}
+
+// nearestPrompt returns the position of the draft most similar to prompt
+// with a similarity of at least threshold, or -1.
+func nearestPrompt(prompt string, drafts []candidateDraftRuntime, threshold float64) (int, float64) {
+	if threshold <= 0 {
+		return -1, 0
+	}
+	best, bestSim := -1, 0.0
+	for i, d := range drafts {
+		if sim := promptSimilarity(prompt, d.candidate.CandidatePrompt); sim >= threshold && sim > bestSim {
+			best, bestSim = i, sim
+		}
+	}
+	return best, bestSim
+}
+
+// shareDuplicateAttempts appends one attempt per duplicate draft that reuses
+// the coder result of the draft it repeats.
+func shareDuplicateAttempts(attempts []coderAttemptRuntime, duplicates []duplicateDraft) []coderAttemptRuntime {
+	for _, dup := range duplicates {
+		attempts = append(attempts, duplicateAttempt(attempts[dup.original], dup.draft, 0, dup.similarity))
+	}
+	return attempts
+}
+
+// duplicateAttempt is the attempt of a draft that reuses the coder result
+// and scores of shared, made in iteration (0 for the current one).
+func duplicateAttempt(shared coderAttemptRuntime, draft candidateDraftRuntime, iteration int, similarity float64) coderAttemptRuntime {
+	log := shared.log
+	original := log.CandidateIndex
+	log.CandidateIndex = draft.log.Index
+	log.CandidateStyle = draft.log.Style
+	log.CandidateTitle = draft.candidate.Title
+	log.DuplicateOf = &original
+	log.DuplicateOfIteration = iteration
+	log.DuplicateSimilarity = 0
+	if similarity < 1 {
+		// The attempt is scored as the near-duplicate prompt it reuses, so
+		// keep that prompt and record how close this one was.
+		log.DuplicateSimilarity = similarity
+	}
+	log.Lineage = draft.log.Lineage
+	log.ProducedFiles = append([]string(nil), log.ProducedFiles...)
+	return coderAttemptRuntime{log: log, produced: shared.produced, lineage: draft.lineage}
+}
+
+// pastAttempt is a coder result of an earlier iteration that later drafts
+// with the same prompt reuse.
+type pastAttempt struct {
+	iteration int
+	attempt   coderAttemptRuntime
+}
+
+// reusePastAttempts splits off the drafts whose prompts repeat, or nearly
+// repeat, a prompt already run in an earlier iteration, and returns attempts
+// that reuse those results.
+func reusePastAttempts(drafts []candidateDraftRuntime, past []pastAttempt, threshold float64) ([]candidateDraftRuntime, []coderAttemptRuntime) {
+	if len(past) == 0 {
+		return drafts, nil
+	}
+	byHash := map[string]int{}
+	for i, p := range past {
+		if _, ok := byHash[p.attempt.log.PromptHash]; !ok {
+			byHash[p.attempt.log.PromptHash] = i
+		}
+	}
+	pastDrafts := make([]candidateDraftRuntime, len(past))
+	for i, p := range past {
+		pastDrafts[i].candidate.CandidatePrompt = p.attempt.log.CandidatePrompt
+	}
+	var fresh []candidateDraftRuntime
+	var reused []coderAttemptRuntime
+	for _, d := range drafts {
+		i, sim := -1, 1.0
+		if j, ok := byHash[promptHash(d.candidate.CandidatePrompt)]; ok {
+			i = j
+		} else {
+			i, sim = nearestPrompt(d.candidate.CandidatePrompt, pastDrafts, threshold)
+		}
+		if i < 0 {
+			fresh = append(fresh, d)
+			continue
+		}
+		reused = append(reused, duplicateAttempt(past[i].attempt, d, past[i].iteration, sim))
+	}
+	return fresh, reused
+}
+
+// recordPastAttempts adds the attempts that ran the coder in an iteration,
+// without errors, to the ones later iterations may reuse.
+func recordPastAttempts(past []pastAttempt, iteration int, attempts []coderAttemptRuntime) []pastAttempt {
+	for _, a := range attempts {
+		if a.cluster > 0 || a.log.DuplicateOf != nil || a.log.CoderError != "" {
+			continue
+		}
+		past = append(past, pastAttempt{iteration: iteration, attempt: a})
+	}
+	return past
+}
@@ -0,0 +1,98 @@
+package run
+
+import (
+	"context"
+	"errors"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+type ErrorCategory string
+
+const (
+	ErrorGit                  ErrorCategory = "git"
+	ErrorProvider             ErrorCategory = "provider"
+	ErrorValidationExhaustion ErrorCategory = "validation_exhausted"
+	ErrorBudgetExhausted      ErrorCategory = "budget_exhausted"
+	ErrorArtifact             ErrorCategory = "artifact"
+	ErrorConfig               ErrorCategory = "config"
+	ErrorIsolation            ErrorCategory = "isolation"
+	ErrorCanceled             ErrorCategory = "canceled"
+	ErrorInternal             ErrorCategory = "internal"
+	ErrorUnknown              ErrorCategory = "unknown"
+)
+
+// Error attaches a failure category to an error so callers such as the CLI
+// or batch aggregation can report why a run failed.
+type Error struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// categorize wraps err with the given category unless it already carries one.
+func categorize(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	var existing *Error
+	if errors.As(err, &existing) {
+		return err
+	}
+	return &Error{Category: category, Err: err}
+}
+
+func ErrorCategoryOf(err error) ErrorCategory {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	var panicErr *iterationPanicError
+	if errors.As(err, &panicErr) {
+		return ErrorInternal
+	}
+	var callErr *llm.CallError
+	if errors.As(err, &callErr) {
+		return ErrorProvider
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorBudgetExhausted
+	}
+	return ErrorUnknown
+}
+
+func ExitCode(err error) int {
+	switch ErrorCategoryOf(err) {
+	case "":
+		return 0
+	case ErrorConfig:
+		return 2
+	case ErrorGit:
+		return 3
+	case ErrorProvider:
+		return 4
+	case ErrorValidationExhaustion:
+		return 5
+	case ErrorBudgetExhausted:
+		return 6
+	case ErrorArtifact:
+		return 7
+	case ErrorCanceled:
+		return 130
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,229 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const eventsFile = "events.jsonl"
+
+const (
+	EventRunStart       = "run-start"
+	EventIterationStart = "iteration-start"
+	EventCandidate      = "candidate"
+	EventCandidates     = "candidates"
+	EventReview         = "review"
+	EventCoderStart     = "coder-start"
+	EventCoderTool      = "coder-tool"
+	EventCoderDone      = "coder-done"
+	EventAttempt        = "attempt"
+	EventBest           = "best"
+	EventIterationEnd   = "iteration-end"
+	EventControl        = "control"
+	EventRunEnd         = "run-end"
+)
+
+// Event is one line of artifacts/events.jsonl, appended while a run is in
+// progress so it can be followed from another process.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Iteration int       `json:"iteration,omitempty"`
+	Candidate int       `json:"candidate,omitempty"`
+	// Attempt names a coder run, like its patch artifact.
+	Attempt    string  `json:"attempt,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Style      string  `json:"style,omitempty"`
+	PreScore   float64 `json:"preScore,omitempty"`
+	Tech       float64 `json:"tech,omitempty"`
+	Realism    float64 `json:"realism,omitempty"`
+	Final      float64 `json:"final,omitempty"`
+	BestFinal  float64 `json:"bestFinal,omitempty"`
+	Count      int     `json:"count,omitempty"`
+	DurationMs int64   `json:"durationMs,omitempty"`
+	// TestCategory is the test outcome of an attempt.
+	TestCategory string `json:"testCategory,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// Prompt is the candidate prompt a coder starts on.
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// EventSink receives the events of a run as they happen, for example to
+// show live progress. Events are delivered one at a time from the goroutine
+// that emits them, so sinks should return quickly.
+type EventSink interface {
+	Event(e Event)
+}
+
+// EventFunc adapts a function to EventSink.
+type EventFunc func(e Event)
+
+func (f EventFunc) Event(e Event) {
+	f(e)
+}
+
+// eventLog appends events to a JSONL file and passes them to the sinks. A
+// nil log discards events, and write errors only disable further writes
+// since they must not fail a run.
+type eventLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	sinks []EventSink
+	log   *slog.Logger
+}
+
+func newEventLog(artifactsDir string, appendTo bool, log *slog.Logger, sinks []EventSink) *eventLog {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filepath.Join(artifactsDir, eventsFile), flags, 0o644)
+	if err != nil {
+		log.Warn("events disabled", "error", err)
+		if len(sinks) == 0 {
+			return nil
+		}
+		return &eventLog{sinks: sinks, log: log}
+	}
+	return &eventLog{f: f, sinks: sinks, log: log}
+}
+
+func (l *eventLog) emit(e Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, s := range l.sinks {
+		if s != nil {
+			s.Event(e)
+		}
+	}
+	if l.f == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = l.f.Write(append(data, '\n'))
+	}
+	if err != nil {
+		l.log.Warn("events disabled", "error", err)
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// candidateEvent reports a generated draft with its heuristic realism and
+// pre-score; the message says why an invalid draft was dropped.
+func candidateEvent(iter int, d candidateDraftRuntime) Event {
+	e := Event{
+		Type:      EventCandidate,
+		Iteration: iter,
+		Candidate: d.log.Index,
+		Title:     d.log.Title,
+		Style:     d.log.Style,
+		Realism:   d.log.PreRealism,
+		PreScore:  d.log.PreScore,
+	}
+	if !d.valid {
+		e.Message = "invalid"
+		if d.log.GenerationError != "" {
+			e.Message = d.log.GenerationError
+		}
+	}
+	return e
+}
+
+func (l *eventLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// TailEvents reads the events of the run in workdir and passes each to fn.
+// With follow, it waits for the events file to appear and for new events
+// until a run-end event is read or ctx is done. A truncated file means a new
+// run started in the same workdir, and reading restarts from the top.
+func TailEvents(ctx context.Context, workdir string, follow bool, poll time.Duration, fn func(Event)) error {
+	path := filepath.Join(workdir, "artifacts", eventsFile)
+	var offset int64
+	var pending []byte
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+			return nil
+		}
+	}
+	for {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) && follow {
+			if err := wait(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("open events: %w", err)
+		}
+		info, err := f.Stat()
+		if err == nil && info.Size() < offset {
+			offset, pending = 0, nil
+		}
+		var chunk []byte
+		if err == nil {
+			if _, err = f.Seek(offset, io.SeekStart); err == nil {
+				chunk, err = io.ReadAll(f)
+			}
+		}
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("read events: %w", err)
+		}
+		offset += int64(len(chunk))
+		pending = append(pending, chunk...)
+
+		done := false
+		if i := bytes.LastIndexByte(pending, '\n'); i >= 0 {
+			scanner := bufio.NewScanner(bytes.NewReader(pending[:i+1]))
+			scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+			for scanner.Scan() {
+				var e Event
+				if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+					continue
+				}
+				fn(e)
+				if e.Type == EventRunEnd {
+					done = true
+				}
+			}
+			pending = append([]byte(nil), pending[i+1:]...)
+		}
+		if !follow || done {
+			return nil
+		}
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package run
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// explainMaxFiles caps the files listed per category and with line details
+// in a breakdown.
+const explainMaxFiles = 50
+
+// explainAttempts writes a breakdown next to the patch of each attempt when
+// --explain is set. It runs after judge normalization so the breakdown
+// matches the final scores. Duplicates share the breakdown of their attempt.
+func (r *Runner) explainAttempts(env *runEnv, attempts []coderAttemptRuntime) error {
+	if !r.cfg.Explain {
+		return nil
+	}
+	for i := range attempts {
+		a := &attempts[i]
+		if a.log.ProducedPatchPath == "" {
+			continue
+		}
+		path := strings.TrimSuffix(a.log.ProducedPatchPath, ".patch") + ".explain.md"
+		if a.log.DuplicateOf != nil {
+			a.log.ExplanationPath = path
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".explain.md")
+		produced, _ := env.detector.Strip(a.produced)
+		text := r.explanation(name, env.scoringFor(a.cluster), produced, a.log)
+		if err := env.artifacts.write(filepath.Base(path), []byte(text)); err != nil {
+			return categorize(ErrorArtifact, fmt.Errorf("write %s: %w", filepath.Base(path), err))
+		}
+		a.log.ExplanationPath = path
+	}
+	return nil
+}
+
+// explanation is a readable breakdown of an attempt's scores: the weighted
+// technical components, which files and normalized lines matched, and the
+// realism rubric checks.
+func (r *Runner) explanation(name string, target, produced git.DiffSnapshot, attempt CoderAttemptLog) string {
+	tech := attempt.Tech
+	realism := attempt.Realism
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Score breakdown: %s\n\n", name)
+	fmt.Fprintf(&b, "final = %.2f × tech + %.2f × realism = %.2f × %.3f + %.2f × %.3f = **%.3f**\n\n",
+		r.cfg.Alpha, 1-r.cfg.Alpha, r.cfg.Alpha, tech.Score, 1-r.cfg.Alpha, realism.Score, attempt.FinalScore)
+
+	b.WriteString("## Technical similarity\n\n")
+	lineScore := tech.Score
+	if tech.TargetTests != nil {
+		lineScore = tech.BaseScore
+	}
+	if tech.GoAST != nil {
+		lineScore = tech.LineScore
+	}
+	b.WriteString("| component | value | weight |\n|---|---|---|\n")
+	fmt.Fprintf(&b, "| file jaccard | %.3f | 0.40 |\n", tech.FileJaccard)
+	fmt.Fprintf(&b, "| diff similarity | %.3f | 0.30 |\n", tech.DiffSimilarity)
+	fmt.Fprintf(&b, "| hunk alignment | %.3f | 0.15 |\n", tech.HunkSimilarity)
+	fmt.Fprintf(&b, "| line F1 (precision %.3f, recall %.3f) | %.3f | 0.15 |\n", tech.LinePrecision, tech.LineRecall, tech.LineF1)
+	fmt.Fprintf(&b, "\nline-based score: %.3f\n", lineScore)
+	if ast := tech.GoAST; ast != nil {
+		blended := tech.Score
+		if tech.TargetTests != nil {
+			blended = tech.BaseScore
+		}
+		fmt.Fprintf(&b, "\nGo AST score %.3f (declarations %.3f, signatures %.3f, calls %.3f) blended at %.2f: tech = %.3f\n",
+			ast.Score, ast.DeclSimilarity, ast.SignatureSimilarity, ast.CallSimilarity, scoring.GoASTBlendWeight, blended)
+	}
+	if tt := tech.TargetTests; tt != nil {
+		fmt.Fprintf(&b, "\nTarget tests (%d files) %s, %d cases passed and %d failed: score %.3f blended at %.2f: tech = %.3f\n",
+			len(tt.Files), tt.Category, tt.Passed, tt.Failed, tt.Score, scoring.TargetTestBlendWeight, tech.Score)
+	}
+
+	ex := scoring.ExplainTech(target, produced, r.techConfig())
+	b.WriteString("\n### Files\n\n")
+	writeList(&b, "matched", ex.MatchedFiles)
+	writeList(&b, "missing (in target only)", ex.MissingFiles)
+	writeList(&b, "extra (produced only)", ex.ExtraFiles)
+	for _, p := range tech.RenamePairs {
+		fmt.Fprintf(&b, "- renamed: %s in the target and %s in the patch, both from %s (credit %.3f)\n", p.Target, p.Produced, p.Source, p.Credit)
+	}
+	for _, pf := range tech.PerFile {
+		if pf.TargetMode != "" || pf.ProducedMode != "" {
+			fmt.Fprintf(&b, "- mode of %s: %s in the target, %s in the patch\n", pf.Path, modeOrUnchanged(pf.TargetMode), modeOrUnchanged(pf.ProducedMode))
+		}
+	}
+
+	b.WriteString("\n### Hunks\n\nEach target hunk is matched with at most one produced hunk, in any file, by the similarity of their lines.\n\n")
+	for _, pf := range tech.PerFile {
+		for _, h := range pf.Hunks {
+			switch {
+			case h.MatchedHeader == "":
+				fmt.Fprintf(&b, "- %s `%s`: unmatched\n", pf.Path, h.Header)
+			case h.MatchedPath != pf.Path:
+				fmt.Fprintf(&b, "- %s `%s`: %.3f, relocated to %s `%s`\n", pf.Path, h.Header, h.Similarity, h.MatchedPath, h.MatchedHeader)
+			default:
+				fmt.Fprintf(&b, "- %s `%s`: %.3f with `%s`\n", pf.Path, h.Header, h.Similarity, h.MatchedHeader)
+			}
+		}
+		if pf.UnmatchedHunks > 0 {
+			fmt.Fprintf(&b, "- %s: %d extra produced hunks\n", pf.Path, pf.UnmatchedHunks)
+		}
+	}
+
+	b.WriteString("\n### Lines\n\nMatched lines raise precision and recall, missing lines lower recall, and extra lines lower precision. Lines are whitespace-normalized.\n")
+	for i, f := range ex.Files {
+		if i == explainMaxFiles {
+			fmt.Fprintf(&b, "\n(%d more files omitted)\n", len(ex.Files)-explainMaxFiles)
+			break
+		}
+		fmt.Fprintf(&b, "\n#### %s\n", f.Path)
+		writeLines(&b, "matched", f.Matched)
+		writeLines(&b, "missing", f.Missing)
+		writeLines(&b, "extra", f.Extra)
+		if f.Omitted > 0 {
+			fmt.Fprintf(&b, "\n(%d more lines omitted)\n", f.Omitted)
+		}
+	}
+
+	b.WriteString("\n## Realism\n\n")
+	if attempt.Judged {
+		fmt.Fprintf(&b, "realism = 0.6 × heuristic %.3f + 0.4 × judge %.3f = %.3f\n\n", realism.HeuristicScore, realism.JudgeScore, realism.Score)
+		if len(realism.JudgeSamples) > 0 {
+			fmt.Fprintf(&b, "The judge score is the mean of %d samples, standard deviation %.3f.\n\n", len(realism.JudgeSamples), realism.JudgeStdDev)
+		}
+	} else {
+		fmt.Fprintf(&b, "realism = heuristic %.3f (no judge score)\n\n", realism.HeuristicScore)
+	}
+	fmt.Fprintf(&b, "Heuristic rubric, starting from %.2f:\n\n| rule | value | delta | note |\n|---|---|---|---|\n", scoring.RealismBase)
+	for _, c := range scoring.ExplainRealism(attempt.CandidatePrompt, r.realismConfig()) {
+		fmt.Fprintf(&b, "| %s | %d | %+.3f | %s |\n", c.Rule, c.Value, c.Delta, c.Reason)
+	}
+	return b.String()
+}
+
+func modeOrUnchanged(s string) string {
+	if s == "" {
+		return "unchanged"
+	}
+	return s
+}
+
+func writeList(b *strings.Builder, label string, items []string) {
+	if len(items) == 0 {
+		fmt.Fprintf(b, "- %s: none\n", label)
+		return
+	}
+	more := ""
+	if len(items) > explainMaxFiles {
+		more = fmt.Sprintf(", and %d more", len(items)-explainMaxFiles)
+		items = items[:explainMaxFiles]
+	}
+	fmt.Fprintf(b, "- %s: %s%s\n", label, strings.Join(items, ", "), more)
+}
+
+func writeLines(b *strings.Builder, label string, lines []scoring.LineMatch) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%s:\n\n```diff\n", label)
+	for _, l := range lines {
+		if l.Count > 1 {
+			fmt.Fprintf(b, "%s  (×%d)\n", l.Line, l.Count)
+			continue
+		}
+		b.WriteString(l.Line + "\n")
+	}
+	b.WriteString("```\n")
+}
@@ -0,0 +1,157 @@
+package run
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+const (
+	FreezePolicyOff       = "off"
+	FreezePolicyIncumbent = "incumbent"
+	FreezePolicyAdaptive  = "adaptive"
+
+	freezeThawAfter = 2
+)
+
+type FreezeLog struct {
+	Policy   string   `json:"policy"`
+	Decision string   `json:"decision"`
+	Reason   string   `json:"reason,omitempty"`
+	Frozen   []string `json:"frozen,omitempty"`
+}
+
+type freezeState struct {
+	policy   string
+	sections []string
+	frozen   bool
+}
+
+func newFreezeState(policy, sections string) *freezeState {
+	return &freezeState{policy: policy, sections: parseCommaList(sections)}
+}
+
+// decide returns the sections of the incumbent prompt that should stay fixed
+// for the next iteration. improved reports whether the previous iteration
+// raised the best score and stalled counts iterations without improvement.
+func (f *freezeState) decide(incumbent string, improved bool, stalled int) ([]promptSection, FreezeLog) {
+	entry := FreezeLog{Policy: f.policy}
+	if f.policy == FreezePolicyOff || f.policy == "" {
+		entry.Decision = "none"
+		return nil, entry
+	}
+	if strings.TrimSpace(incumbent) == "" {
+		entry.Decision = "none"
+		entry.Reason = "no incumbent prompt yet"
+		return nil, entry
+	}
+
+	switch f.policy {
+	case FreezePolicyIncumbent:
+		entry.Decision = "freeze"
+		entry.Reason = "incumbent best prompt available"
+		f.frozen = true
+	case FreezePolicyAdaptive:
+		switch {
+		case f.frozen && stalled >= freezeThawAfter:
+			f.frozen = false
+			entry.Decision = "thaw"
+			entry.Reason = fmt.Sprintf("no improvement for %d iterations", stalled)
+		case improved:
+			entry.Decision = "freeze"
+			entry.Reason = "previous iteration improved the best score"
+			f.frozen = true
+		case f.frozen:
+			entry.Decision = "hold"
+			entry.Reason = "keeping sections frozen while search converges"
+		default:
+			entry.Decision = "none"
+			entry.Reason = "waiting for an improving iteration"
+		}
+	}
+	if !f.frozen {
+		return nil, entry
+	}
+
+	sections := splitSections(incumbent)
+	frozen := make([]promptSection, 0, len(f.sections))
+	for _, key := range f.sections {
+		if s, ok := sectionByKey(sections, key); ok {
+			frozen = append(frozen, s)
+			entry.Frozen = append(entry.Frozen, key)
+		}
+	}
+	return frozen, entry
+}
+
+// applyFrozenSections replaces the frozen sections in the candidate with the
+// incumbent text so that only unfrozen sections can change.
+func applyFrozenSections(candidate string, frozen []promptSection) string {
+	if len(frozen) == 0 {
+		return candidate
+	}
+	sections := splitSections(candidate)
+	for i, s := range sections {
+		for _, f := range frozen {
+			if s.Key != "" && s.Key == f.Key {
+				sections[i] = f
+			}
+		}
+	}
+	return joinSections(sections)
+}
+
+func parseCommaList(s string) []string {
+	out := []string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		out = append(out, part)
+	}
+	return out
+}
+
+func validateFreezeConfig(policy, sections string) error {
+	switch policy {
+	case FreezePolicyOff, FreezePolicyIncumbent, FreezePolicyAdaptive:
+	default:
+		return fmt.Errorf("freeze-policy must be one of %s, %s, %s", FreezePolicyOff, FreezePolicyIncumbent, FreezePolicyAdaptive)
+	}
+	valid := toSetStrings(sectionKeys())
+	for _, key := range parseCommaList(sections) {
+		if _, ok := valid[key]; !ok {
+			return fmt.Errorf("freeze-sections contains unknown section %q (valid: %s)", key, strings.Join(sectionKeys(), ", "))
+		}
+	}
+	return nil
+}
+
+func toSetStrings(items []string) map[string]struct{} {
+	out := map[string]struct{}{}
+	for _, it := range items {
+		out[it] = struct{}{}
+	}
+	return out
+}
+
+func frozenKeys(sections []promptSection) []string {
+	out := make([]string, 0, len(sections))
+	for _, s := range sections {
+		out = append(out, s.Key)
+	}
+	return out
+}
+
+func toFrozenSections(sections []promptSection) []llm.FrozenSection {
+	if len(sections) == 0 {
+		return nil
+	}
+	out := make([]llm.FrozenSection, 0, len(sections))
+	for _, s := range sections {
+		out = append(out, llm.FrozenSection{Heading: s.Heading, Body: s.Body})
+	}
+	return out
+}
@@ -0,0 +1,66 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// goFiles returns the changed Go files of a snapshot that are not generated.
+func goFiles(snap git.DiffSnapshot, excluded []string) []string {
+	skip := toSetStrings(excluded)
+	var out []string
+	for _, f := range scoring.GoFiles(snap.ChangedFiles) {
+		if _, ok := skip[f]; !ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// targetGoFeatures extracts the structural Go changes of the target. It
+// returns nil when the target changes no Go files.
+func targetGoFeatures(ctx context.Context, env *runEnv) (*scoring.GoFeatures, error) {
+	files := goFiles(env.target, env.generatedFiles)
+	if len(files) == 0 {
+		return nil, nil
+	}
+	changes := make([]scoring.GoFileChange, 0, len(files))
+	for _, f := range files {
+		before, err := git.ShowFile(ctx, env.baseRepo, env.commitInfo.ParentSHA, f)
+		if err != nil {
+			return nil, err
+		}
+		after, err := git.ShowFile(ctx, env.baseRepo, env.commitInfo.TargetSHA, f)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, scoring.GoFileChange{Path: f, Before: before, After: after})
+	}
+	features := scoring.ExtractGoFeatures(changes)
+	return &features, nil
+}
+
+// producedGoFeatures extracts the structural Go changes left in a worktree.
+func producedGoFeatures(ctx context.Context, env *runEnv, runPath string, files []string) (scoring.GoFeatures, error) {
+	changes := make([]scoring.GoFileChange, 0, len(files))
+	for _, f := range files {
+		before, err := git.ShowFile(ctx, runPath, env.workBase, f)
+		if err != nil {
+			return scoring.GoFeatures{}, err
+		}
+		after, err := os.ReadFile(filepath.Join(runPath, filepath.FromSlash(f)))
+		if errors.Is(err, os.ErrNotExist) {
+			after = nil
+		} else if err != nil {
+			return scoring.GoFeatures{}, fmt.Errorf("read %s: %w", f, err)
+		}
+		changes = append(changes, scoring.GoFileChange{Path: f, Before: env.anonymizer.revealBytes(before), After: env.anonymizer.revealBytes(after)})
+	}
+	return scoring.ExtractGoFeatures(changes), nil
+}
@@ -0,0 +1,114 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// GoldenOptions sets how far a run may drift from its golden run before a
+// check counts as a deviation.
+type GoldenOptions struct {
+	// ScoreTolerance is the largest absolute difference allowed in each of
+	// the best scores.
+	ScoreTolerance float64 `json:"scoreTolerance"`
+	// IterationTolerance is the largest difference allowed in the number of
+	// iterations run.
+	IterationTolerance int `json:"iterationTolerance"`
+}
+
+// GoldenCheck is one comparison of a run with its golden run.
+type GoldenCheck struct {
+	Name   string `json:"name"`
+	Golden string `json:"golden"`
+	Actual string `json:"actual"`
+	OK     bool   `json:"ok"`
+}
+
+// GoldenReport compares the artifacts of a run with those of a reference
+// run of the same target, for use as an end-to-end regression gate.
+type GoldenReport struct {
+	Golden     string        `json:"golden"`
+	Workdir    string        `json:"workdir"`
+	Options    GoldenOptions `json:"options"`
+	Checks     []GoldenCheck `json:"checks"`
+	Deviations int           `json:"deviations"`
+	// Warnings note differences that make the comparison less meaningful,
+	// like another target commit or scoring version.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// CompareGolden compares the best scores, stop reason, failure category, and
+// iteration count recorded in the artifacts of workdir with those of the
+// golden run in goldenWorkdir.
+func CompareGolden(goldenWorkdir, workdir string, opts GoldenOptions) (GoldenReport, error) {
+	golden, goldenMetrics, err := readRunArtifacts(goldenWorkdir)
+	if err != nil {
+		return GoldenReport{}, categorize(ErrorConfig, fmt.Errorf("golden run: %w", err))
+	}
+	actual, actualMetrics, err := readRunArtifacts(workdir)
+	if err != nil {
+		return GoldenReport{}, categorize(ErrorConfig, fmt.Errorf("run: %w", err))
+	}
+
+	out := GoldenReport{Golden: goldenWorkdir, Workdir: workdir, Options: opts}
+	if golden.TargetCommit != actual.TargetCommit {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("target commits differ: golden %s, run %s", golden.TargetCommit, actual.TargetCommit))
+	}
+	if golden.ScoringVersion != actual.ScoringVersion {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("scoring versions differ: golden %d, run %d; rescore the golden run first", golden.ScoringVersion, actual.ScoringVersion))
+	}
+
+	score := func(name string, g, a float64) {
+		out.Checks = append(out.Checks, GoldenCheck{
+			Name:   name,
+			Golden: strconv.FormatFloat(g, 'f', 4, 64),
+			Actual: strconv.FormatFloat(a, 'f', 4, 64),
+			OK:     math.Abs(g-a) <= opts.ScoreTolerance+1e-9,
+		})
+	}
+	score("finalScore", goldenMetrics.FinalScore, actualMetrics.FinalScore)
+	score("techSimilarity", goldenMetrics.TechSimilarity, actualMetrics.TechSimilarity)
+	score("realismScore", goldenMetrics.RealismScore, actualMetrics.RealismScore)
+
+	gi, ai := len(golden.Iterations), len(actual.Iterations)
+	out.Checks = append(out.Checks,
+		GoldenCheck{Name: "iterations", Golden: strconv.Itoa(gi), Actual: strconv.Itoa(ai), OK: absInt(gi-ai) <= opts.IterationTolerance},
+		GoldenCheck{Name: "stoppedReason", Golden: golden.StoppedReason, Actual: actual.StoppedReason, OK: golden.StoppedReason == actual.StoppedReason},
+		GoldenCheck{Name: "failureCategory", Golden: string(golden.FailureCategory), Actual: string(actual.FailureCategory), OK: golden.FailureCategory == actual.FailureCategory},
+	)
+	for _, c := range out.Checks {
+		if !c.OK {
+			out.Deviations++
+		}
+	}
+	return out, nil
+}
+
+// readRunArtifacts reads the run log and metrics of a finished run.
+func readRunArtifacts(workdir string) (RunLog, Metrics, error) {
+	artifactsDir := filepath.Join(workdir, "artifacts")
+	runLog, err := readRunLog(filepath.Join(artifactsDir, "run_log.json"))
+	if err != nil {
+		return RunLog{}, Metrics{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(artifactsDir, "metrics.json"))
+	if err != nil {
+		return RunLog{}, Metrics{}, fmt.Errorf("read metrics: %w", err)
+	}
+	var metrics Metrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return RunLog{}, Metrics{}, fmt.Errorf("parse metrics: %w", err)
+	}
+	return runLog, metrics, nil
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
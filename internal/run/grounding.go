@@ -0,0 +1,65 @@
+package run
+
+import (
+	"path"
+	"strings"
+)
+
+const scopeGroundingPenalty = 0.15
+
+// conceptualScopeTerms are cross-cutting concerns that are valid scope hints
+// even though no path is named after them.
+var conceptualScopeTerms = map[string]struct{}{
+	"error": {}, "errors": {}, "handling": {}, "logging": {}, "coverage": {}, "configuration": {},
+	"documentation": {}, "docs": {}, "performance": {}, "security": {}, "validation": {}, "compatibility": {},
+	"backward": {}, "api": {}, "cli": {}, "flags": {}, "options": {}, "tests": {}, "testing": {},
+	"concurrency": {}, "lifecycle": {}, "state": {}, "management": {}, "behavior": {}, "output": {},
+	"input": {}, "parsing": {}, "serialization": {}, "retry": {}, "timeouts": {}, "caching": {},
+}
+
+// scopeIndex is the vocabulary of directory and file names present in the
+// repository at the parent commit.
+type scopeIndex map[string]struct{}
+
+func newScopeIndex(files []string) scopeIndex {
+	idx := scopeIndex{}
+	for _, f := range files {
+		dir, file := path.Split(f)
+		file = strings.TrimSuffix(file, path.Ext(file))
+		for _, t := range splitTerms(dir + " " + file) {
+			idx[stemTerm(t)] = struct{}{}
+		}
+	}
+	return idx
+}
+
+// ground reports the fraction of scope hints that map to something in the
+// repository, and the hints that do not. An empty index grounds everything.
+func (idx scopeIndex) ground(hints []string) (float64, []string) {
+	if len(idx) == 0 || len(hints) == 0 {
+		return 1, nil
+	}
+	ungrounded := []string{}
+	for _, hint := range hints {
+		if !idx.grounded(hint) {
+			ungrounded = append(ungrounded, hint)
+		}
+	}
+	return 1 - float64(len(ungrounded))/float64(len(hints)), ungrounded
+}
+
+func (idx scopeIndex) grounded(hint string) bool {
+	terms := splitTerms(hint)
+	if len(terms) == 0 {
+		return true
+	}
+	for _, t := range terms {
+		if _, ok := conceptualScopeTerms[t]; ok {
+			return true
+		}
+		if _, ok := idx[stemTerm(t)]; ok {
+			return true
+		}
+	}
+	return false
+}
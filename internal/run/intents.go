@@ -0,0 +1,76 @@
+package run
+
+import (
+	"context"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/cluster"
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+const maxIntentGroups = 6
+
+// IntentGroupLog is a heuristic file group with the intents inferred from
+// its changes.
+type IntentGroupLog struct {
+	Files   []string `json:"files"`
+	Intents []string `json:"intents,omitempty"`
+}
+
+// IntentsLog is written to intents.json. Heuristic intents and groups come
+// from the feedback and clustering code, and the model turns them into
+// sub-intents with files and a confidence.
+type IntentsLog struct {
+	Heuristic []string         `json:"heuristic,omitempty"`
+	Groups    []IntentGroupLog `json:"groups,omitempty"`
+	Summary   string           `json:"summary,omitempty"`
+	Intents   []llm.SubIntent  `json:"intents,omitempty"`
+	// Unassigned are changed files the model left out of every intent.
+	Unassigned []string `json:"unassigned,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// writeIntents decomposes the target into sub-intents. Failures are recorded
+// in the artifact, never fatal.
+func (r *Runner) writeIntents(ctx context.Context, env *runEnv) {
+	out := r.decomposeIntents(ctx, env)
+	if out.Error != "" {
+		r.log.Warn("intent decomposition failed", "error", out.Error)
+	}
+	if err := env.artifacts.writeJSON("intents.json", out); err != nil {
+		r.log.Warn("failed to write intents.json", "error", err)
+	}
+}
+
+func (r *Runner) decomposeIntents(ctx context.Context, env *runEnv) IntentsLog {
+	out := IntentsLog{Heuristic: feedback.InferIntents(env.target)}
+	var groups []llm.IntentGroup
+	for _, c := range cluster.Split(env.target, maxIntentGroups) {
+		intents := feedback.InferIntents(cluster.Snapshot(env.target, c))
+		out.Groups = append(out.Groups, IntentGroupLog{Files: c.Files, Intents: intents})
+		groups = append(groups, llm.IntentGroup{Files: c.Files, Intents: intents})
+	}
+
+	llmCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+	res, err := llm.DecomposeIntents(llmCtx, env.providers.gap, env.redactedTarget, env.target.ChangedFiles, groups, 6)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Summary = res.Summary
+	out.Intents = res.Intents
+	assigned := map[string]bool{}
+	for _, in := range res.Intents {
+		for _, f := range in.Files {
+			assigned[f] = true
+		}
+	}
+	for _, f := range env.target.ChangedFiles {
+		if !assigned[f] {
+			out.Unassigned = append(out.Unassigned, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAttemptAborted is the coder error of an attempt stopped with
+// AbortAttempt. The attempt is still scored on what the coder left.
+var ErrAttemptAborted = errors.New("attempt aborted")
+
+// liveControl lets another goroutine, such as a terminal UI, steer a run in
+// progress: abort coder runs and end the loop after the current iteration.
+type liveControl struct {
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+	finish  bool
+}
+
+// AbortAttempt stops the coder run of an attempt in progress, named like its
+// patch artifact. It reports false when no such coder is running.
+func (r *Runner) AbortAttempt(name string) bool {
+	r.live.mu.Lock()
+	defer r.live.mu.Unlock()
+	abort, ok := r.live.running[name]
+	if ok {
+		abort(ErrAttemptAborted)
+	}
+	return ok
+}
+
+// Finish ends the loop after the current iteration, like the finalize field
+// of the control file.
+func (r *Runner) Finish() {
+	r.live.mu.Lock()
+	defer r.live.mu.Unlock()
+	r.live.finish = true
+}
+
+func (r *Runner) finishRequested() bool {
+	r.live.mu.Lock()
+	defer r.live.mu.Unlock()
+	return r.live.finish
+}
+
+// trackAttempt registers a coder run so it can be aborted. The returned
+// function unregisters it.
+func (r *Runner) trackAttempt(ctx context.Context, name string) (context.Context, func()) {
+	ctx, abort := context.WithCancelCause(ctx)
+	r.live.mu.Lock()
+	if r.live.running == nil {
+		r.live.running = map[string]context.CancelCauseFunc{}
+	}
+	r.live.running[name] = abort
+	r.live.mu.Unlock()
+	return ctx, func() {
+		r.live.mu.Lock()
+		delete(r.live.running, name)
+		r.live.mu.Unlock()
+		abort(nil)
+	}
+}
@@ -0,0 +1,280 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// sealRepo prepares the sealed repository coder worktrees are created from
+// and checks that the target commit cannot be reached from it. The base
+// clone has the target in its objects and refs, so a coder running git in a
+// worktree of it could read the answer.
+func (r *Runner) sealRepo(ctx context.Context, env *runEnv) error {
+	env.sealedRepo = filepath.Join(r.cfg.Workdir, "sealed")
+	if err := git.SealRepo(ctx, env.baseRepo, env.sealedRepo, env.commitInfo.ParentSHA, r.sealDepth()); err != nil {
+		return categorize(ErrorGit, fmt.Errorf("seal repository at the parent commit: %w", err))
+	}
+	tree, err := git.TreeOf(ctx, env.baseRepo, env.commitInfo.TargetSHA)
+	if err != nil {
+		return categorize(ErrorGit, fmt.Errorf("resolve target tree: %w", err))
+	}
+	if err := git.CheckIsolation(ctx, env.sealedRepo, env.commitInfo.ParentSHA, env.commitInfo.TargetSHA, tree, false); err != nil {
+		return categorize(ErrorIsolation, fmt.Errorf("sealed repository could leak the target: %w", err))
+	}
+	env.workBase = env.commitInfo.ParentSHA
+	if r.cfg.Anonymize != "" && r.cfg.Anonymize != AnonymizeOff {
+		return r.anonymizeRepo(ctx, env)
+	}
+	return nil
+}
+
+// sealDepth limits the history of the sealed repository with a partial base
+// clone. Copying the whole history out of a clone with an object filter
+// would download every object it left out, so only the parent is kept then.
+func (r *Runner) sealDepth() int {
+	if r.cfg.CloneDepth == 0 && r.cfg.CloneFilter != "" {
+		return 1
+	}
+	return r.cfg.CloneDepth
+}
+
+// IsolationLog is written to isolation.json. It documents what each role
+// was shown of the target, derived from the prompts actually sent, so the
+// blinding of a run can be checked.
+type IsolationLog struct {
+	TargetCommit string `json:"targetCommit"`
+	ParentCommit string `json:"parentCommit"`
+	// SealedRepo is the repository coder worktrees were created from.
+	SealedRepo string         `json:"sealedRepo"`
+	Roles      []RoleExposure `json:"roles"`
+	Notes      []string       `json:"notes,omitempty"`
+}
+
+// RoleExposure sums up the prompts sent to one role. Each Calls count is the
+// number of prompts that showed that kind of information.
+type RoleExposure struct {
+	Role        string `json:"role"`
+	Calls       int    `json:"calls"`
+	PromptChars int    `json:"promptChars"`
+	// CommitMessage is set when a prompt quoted the target's commit
+	// subject or a line of its body.
+	CommitMessage      bool `json:"commitMessage"`
+	CommitMessageCalls int  `json:"commitMessageCalls,omitempty"`
+	// TargetSHA is set when a prompt named the target commit.
+	TargetSHA bool `json:"targetSha"`
+	// Paths are the target's changed files named in a prompt.
+	Paths     []string `json:"paths,omitempty"`
+	PathCalls int      `json:"pathCalls,omitempty"`
+	// PatchLines counts distinct lines added by the target quoted verbatim,
+	// with a few of them as examples. Short lines are not counted, since
+	// they are common in any code.
+	PatchLines     int      `json:"patchLines"`
+	PatchLineCalls int      `json:"patchLineCalls,omitempty"`
+	PatchExamples  []string `json:"patchExamples,omitempty"`
+}
+
+const (
+	// minQuotedLine is the length, once trimmed, from which a target line
+	// quoted in a prompt is counted as exposure.
+	minQuotedLine     = 24
+	maxPatchExamples  = 5
+	maxExampleLength  = 100
+	minCommitLineSize = 8
+)
+
+// isolationAudit checks every prompt sent against the target as it goes,
+// keeping only the findings, not the prompts.
+type isolationAudit struct {
+	target      string
+	commitLines []string
+	paths       []string
+	patchLines  map[string]bool
+	mu          sync.Mutex
+	roles       map[llm.Role]*roleAudit
+	order       []llm.Role
+}
+
+type roleAudit struct {
+	exposure RoleExposure
+	paths    map[string]bool
+	lines    map[string]bool
+}
+
+func newIsolationAudit(info git.CommitInfo, target git.DiffSnapshot) *isolationAudit {
+	a := &isolationAudit{
+		target:     info.TargetSHA,
+		paths:      target.ChangedFiles,
+		patchLines: map[string]bool{},
+		roles:      map[llm.Role]*roleAudit{},
+	}
+	for _, line := range strings.Split(info.CommitMessage, "\n") {
+		if line = strings.ToLower(strings.TrimSpace(line)); len(line) >= minCommitLineSize {
+			a.commitLines = append(a.commitLines, line)
+		}
+	}
+	removed := map[string]bool{}
+	var added []string
+	for _, line := range strings.Split(target.Patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added = append(added, strings.TrimSpace(line[1:]))
+		case strings.HasPrefix(line, "-"):
+			removed[strings.TrimSpace(line[1:])] = true
+		}
+	}
+	// Lines the target only moved were already in the parent.
+	for _, line := range added {
+		if len(line) >= minQuotedLine && !removed[line] {
+			a.patchLines[line] = true
+		}
+	}
+	return a
+}
+
+// Middleware records the prompts of each role as they are sent.
+func (a *isolationAudit) Middleware() llm.Middleware {
+	return func(role llm.Role, next llm.ChatProvider) llm.ChatProvider {
+		return llm.ChatFunc(func(ctx context.Context, prompt string) (string, error) {
+			a.record(role, prompt)
+			return next.Chat(ctx, prompt)
+		})
+	}
+}
+
+func (a *isolationAudit) record(role llm.Role, prompt string) {
+	if a == nil {
+		return
+	}
+	lower := strings.ToLower(prompt)
+	commit := false
+	for _, line := range a.commitLines {
+		if strings.Contains(lower, line) {
+			commit = true
+			break
+		}
+	}
+	// Seven characters is the shortest abbreviation git prints.
+	sha := len(a.target) >= 7 && strings.Contains(lower, strings.ToLower(a.target[:7]))
+	var paths []string
+	for _, p := range a.paths {
+		if strings.Contains(prompt, p) {
+			paths = append(paths, p)
+		}
+	}
+	var lines []string
+	if len(a.patchLines) > 0 {
+		for _, line := range strings.Split(prompt, "\n") {
+			line = strings.TrimSpace(strings.TrimLeft(line, "+- "))
+			if a.patchLines[line] {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ra, ok := a.roles[role]
+	if !ok {
+		ra = &roleAudit{exposure: RoleExposure{Role: string(role)}, paths: map[string]bool{}, lines: map[string]bool{}}
+		a.roles[role] = ra
+		a.order = append(a.order, role)
+	}
+	e := &ra.exposure
+	e.Calls++
+	e.PromptChars += len(prompt)
+	if commit {
+		e.CommitMessage = true
+		e.CommitMessageCalls++
+	}
+	e.TargetSHA = e.TargetSHA || sha
+	if len(paths) > 0 {
+		e.PathCalls++
+		for _, p := range paths {
+			ra.paths[p] = true
+		}
+	}
+	if len(lines) > 0 {
+		e.PatchLineCalls++
+		for _, line := range lines {
+			if ra.lines[line] {
+				continue
+			}
+			ra.lines[line] = true
+			if len(e.PatchExamples) < maxPatchExamples {
+				if r := []rune(line); len(r) > maxExampleLength {
+					line = string(r[:maxExampleLength]) + "..."
+				}
+				e.PatchExamples = append(e.PatchExamples, line)
+			}
+		}
+	}
+}
+
+// log returns the findings so far, roles in the order they were first
+// called.
+func (a *isolationAudit) log() []RoleExposure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]RoleExposure, 0, len(a.order))
+	for _, role := range a.order {
+		ra := a.roles[role]
+		e := ra.exposure
+		e.Paths = nil
+		for p := range ra.paths {
+			e.Paths = append(e.Paths, p)
+		}
+		sort.Strings(e.Paths)
+		e.PatchLines = len(ra.lines)
+		e.PatchExamples = append([]string(nil), e.PatchExamples...)
+		out = append(out, e)
+	}
+	return out
+}
+
+// writeIsolation writes isolation.json. Failures are logged, not fatal.
+func (r *Runner) writeIsolation(env *runEnv) {
+	if env == nil || env.isolation == nil {
+		return
+	}
+	out := IsolationLog{
+		TargetCommit: env.commitInfo.TargetSHA,
+		ParentCommit: env.commitInfo.ParentSHA,
+		SealedRepo:   env.sealedRepo,
+		Roles:        env.isolation.log(),
+		Notes:        []string{r.workspaceNote()},
+	}
+	if len(env.checkoutExtras.Submodules) > 0 {
+		note := "submodules were checked out as repositories holding only the history up to the commits the parent pins, with no remote, and each was verified to hold no later commit"
+		if env.checkoutExtras.PlainSubmodules {
+			note = "submodules were checked out as plain files with no repository, so none of their commits can be read"
+		}
+		out.Notes = append(out.Notes, note)
+	}
+	if env.testHint != "" {
+		out.Notes = append(out.Notes, "coder prompts ended with a hint to verify the change with the project's tests, which tells the coder the target adds or changes tests")
+	}
+	if a := env.anonymizer; a != nil {
+		note := "the sealed repository was replaced by an anonymized one whose only commit holds the parent tree, with a neutral author, date, and message"
+		if len(a.log.Renames) > 0 {
+			note += "; the Go module path and GitHub owner/name were renamed in its files and in the prompts and target tests coders were given"
+		}
+		out.Notes = append(out.Notes, note)
+	}
+	if env.providers.recall != nil {
+		out.Notes = append(out.Notes, "the contamination check named the repository and target commit to the coder model in a session of its own, apart from the coder runs; it is not counted above")
+	}
+	if _, ok := env.providers.coder.(*llm.ChatBackend); !ok {
+		out.Notes = append(out.Notes, "the copilot coder's own prompt template and tool calls are not seen; its coder entry covers the candidate prompts it was given")
+	}
+	if err := env.artifacts.writeJSON("isolation.json", out); err != nil {
+		r.log.Warn("failed to write isolation.json", "error", err)
+	}
+}
@@ -0,0 +1,310 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// JudgeLog records how the LLM realism judge behaved during one iteration.
+// Available is true only when every attempt in the iteration was judged.
+type JudgeLog struct {
+	Available bool   `json:"available"`
+	Calls     int    `json:"calls"`
+	Failures  int    `json:"failures"`
+	Skipped   int    `json:"skipped"`
+	Disabled  bool   `json:"disabled,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// Normalization describes how mixed judge availability was reconciled.
+	Normalization string `json:"normalization,omitempty"`
+	// Policy is the judge frequency policy, and PolicySkipped the attempts
+	// it left unjudged.
+	Policy        string `json:"policy,omitempty"`
+	PolicySkipped int    `json:"policySkipped,omitempty"`
+	// CacheHits are the prompts scored from the judge cache instead of a
+	// judge call.
+	CacheHits int `json:"cacheHits,omitempty"`
+}
+
+const (
+	JudgeNormalizationOff       = "off"
+	JudgeNormalizationRejudge   = "rejudge"
+	JudgeNormalizationHeuristic = "heuristic"
+
+	scoreBasisBlended   = "heuristic+judge"
+	scoreBasisHeuristic = "heuristic"
+
+	judgeSkippedIteration = "iteration"
+	judgeSkippedTopN      = "top-n"
+)
+
+// judgeGuard disables the LLM judge for the rest of the run once it fails
+// maxFailures times in a row, so later attempts fall back to the heuristic
+// consistently instead of depending on which calls happened to succeed.
+type judgeGuard struct {
+	mu          sync.Mutex
+	maxFailures int
+	consecutive int
+	disabled    bool
+	reason      string
+	iter        JudgeLog
+	log         *slog.Logger
+}
+
+func newJudgeGuard(maxFailures int, log *slog.Logger) *judgeGuard {
+	return &judgeGuard{maxFailures: maxFailures, log: log}
+}
+
+func (g *judgeGuard) allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.disabled {
+		g.iter.Skipped++
+		return false
+	}
+	return true
+}
+
+func (g *judgeGuard) record(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.iter.Calls++
+	if err == nil {
+		g.consecutive = 0
+		return
+	}
+	g.iter.Failures++
+	g.consecutive++
+	if g.maxFailures > 0 && g.consecutive >= g.maxFailures && !g.disabled {
+		g.disabled = true
+		g.reason = fmt.Sprintf("disabled after %d consecutive failures: %v", g.consecutive, err)
+		g.log.Warn("realism judge disabled; using heuristic realism for the rest of the run", "failures", g.consecutive, "error", err)
+	}
+}
+
+func (g *judgeGuard) hit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.iter.CacheHits++
+}
+
+// endIteration returns the judge log for the iteration and resets counters.
+func (g *judgeGuard) endIteration() JudgeLog {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := g.iter
+	out.Disabled = g.disabled
+	out.Reason = g.reason
+	out.Available = out.Calls+out.CacheHits > 0 && out.Failures == 0 && out.Skipped == 0
+	g.iter = JudgeLog{}
+	return out
+}
+
+// judgePolicy describes the judge frequency settings, empty when every
+// attempt is judged.
+func (r *Runner) judgePolicy() string {
+	var parts []string
+	if r.cfg.JudgeTopN > 0 {
+		parts = append(parts, fmt.Sprintf("top %d per iteration", r.cfg.JudgeTopN))
+	}
+	if r.cfg.JudgeEvery > 1 {
+		parts = append(parts, fmt.Sprintf("every %d iterations", r.cfg.JudgeEvery))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// judgePlan tells whether an attempt of the iteration is judged as soon as
+// it is scored, and otherwise why not. Top-N attempts are judged once the
+// whole iteration has run, so skipped is empty for them.
+func (r *Runner) judgePlan(env *runEnv, iter int) (inline bool, skipped string) {
+	if env.judgeAll {
+		return true, ""
+	}
+	if every := maxInt(1, r.cfg.JudgeEvery); (iter-1)%every != 0 {
+		return false, judgeSkippedIteration
+	}
+	return r.cfg.JudgeTopN <= 0, ""
+}
+
+// judgeTopAttempts judges the JudgeTopN attempts of the iteration with the
+// highest heuristic final score, and marks the rest as skipped.
+func (r *Runner) judgeTopAttempts(ctx context.Context, env *runEnv, iter int, attempts []coderAttemptRuntime) {
+	if inline, skipped := r.judgePlan(env, iter); inline || skipped != "" {
+		return
+	}
+	order := make([]int, len(attempts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return attempts[order[i]].log.FinalScore > attempts[order[j]].log.FinalScore
+	})
+	for rank, i := range order {
+		a := &attempts[i].log
+		if rank >= r.cfg.JudgeTopN {
+			a.JudgeSkipped = judgeSkippedTopN
+			continue
+		}
+		judged, err := r.judgeRealism(ctx, env, a.CandidatePrompt, &a.Realism)
+		if err != nil {
+			a.JudgeError = err.Error()
+			continue
+		}
+		if judged {
+			a.Judged = true
+			a.Realism.Score = scoring.CombineRealism(a.Realism.HeuristicScore, a.Realism.JudgeScore, true)
+			a.FinalScore = r.finalScore(a.Tech.Score, a.Realism.Score)
+		}
+	}
+}
+
+func (r *Runner) finalScore(tech, realism float64) float64 {
+	return r.cfg.Alpha*tech + (1-r.cfg.Alpha)*realism
+}
+
+// judgeRealism asks the LLM judge to score the prompt and stores the result
+// in realism. With several judge samples, the judge is asked once per sample,
+// rotating through the judge models, and realism gets the mean and spread of
+// the samples that succeeded. Scores of prompts judged before come from the
+// judge cache. It reports whether a judge score is available.
+func (r *Runner) judgeRealism(ctx context.Context, env *runEnv, prompt string, realism *scoring.RealismResult) (bool, error) {
+	if !env.judge.allow() {
+		return false, nil
+	}
+	if cached, similarity, ok := env.judgeCache.lookup(prompt); ok {
+		env.judge.hit()
+		r.log.Info("reusing cached judge score", "similarity", similarity, "samples", len(cached.Scores))
+		realism.SetJudgeSamples(cached.Scores)
+		if cached.Justification != "" {
+			realism.Reasons = append(realism.Reasons, "judge: "+cached.Justification)
+		}
+		if similarity < 1 {
+			realism.Reasons = append(realism.Reasons, fmt.Sprintf("judge: cached score of a prompt %.0f%% similar", similarity*100))
+		} else {
+			realism.Reasons = append(realism.Reasons, "judge: cached score of the same prompt")
+		}
+		return true, nil
+	}
+	samples := maxInt(1, r.cfg.JudgeSamples)
+	var scores []float64
+	var justification string
+	var firstErr error
+	for i := 0; i < samples; i++ {
+		judgeCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+		judge, err := llm.JudgeRealism(judgeCtx, env.providers.judgeFor(i), env.providers.templates, prompt)
+		cancel()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			r.log.Debug("judge sample failed", "sample", i+1, "error", err)
+			continue
+		}
+		scores = append(scores, judge.Score)
+		if justification == "" {
+			justification = strings.TrimSpace(judge.Justification)
+		}
+	}
+	// The samples count as one judge call: it fails only if none succeeded.
+	if len(scores) == 0 {
+		env.judge.record(firstErr)
+		return false, firstErr
+	}
+	env.judge.record(nil)
+	realism.SetJudgeSamples(scores)
+	if justification != "" {
+		realism.Reasons = append(realism.Reasons, "judge: "+justification)
+	}
+	if len(scores) < samples {
+		realism.Reasons = append(realism.Reasons, fmt.Sprintf("judge: %d of %d samples failed", samples-len(scores), samples))
+		// Partly failed judgements are not cached, so they are retried.
+	} else if err := env.judgeCache.put(prompt, scores, justification); err != nil {
+		r.log.Warn("failed to cache judge score", "error", err)
+	}
+	return true, nil
+}
+
+// normalizeJudging makes sure every attempt in an iteration is scored on the
+// same realism scale. With the rejudge policy, attempts missing a judge score
+// get one more judge call first. If availability is still mixed, all attempts
+// are ranked on heuristic realism only; judge scores stay in the log.
+func (r *Runner) normalizeJudging(ctx context.Context, env *runEnv, attempts []coderAttemptRuntime) string {
+	policy := r.cfg.JudgeNormalization
+	if policy == JudgeNormalizationOff || policy == "" {
+		return ""
+	}
+
+	note := ""
+	if policy == JudgeNormalizationRejudge {
+		rejudged := 0
+		for i := range attempts {
+			a := &attempts[i].log
+			if a.Judged || a.JudgeSkipped != "" {
+				continue
+			}
+			judged, err := r.judgeRealism(ctx, env, a.CandidatePrompt, &a.Realism)
+			if err != nil {
+				a.JudgeError = err.Error()
+				continue
+			}
+			if judged {
+				a.Judged = true
+				a.JudgeError = ""
+				rejudged++
+				a.Realism.Score = scoring.CombineRealism(a.Realism.HeuristicScore, a.Realism.JudgeScore, true)
+				a.FinalScore = r.finalScore(a.Tech.Score, a.Realism.Score)
+			}
+		}
+		if rejudged > 0 {
+			note = fmt.Sprintf("rejudged %d attempts", rejudged)
+		}
+	}
+
+	// Attempts the judge policy skipped are ranked on heuristic realism
+	// without forcing the others to be.
+	judged, eligible := 0, 0
+	for _, a := range attempts {
+		if a.log.JudgeSkipped != "" {
+			continue
+		}
+		eligible++
+		if a.log.Judged {
+			judged++
+		}
+	}
+	basis := scoreBasisBlended
+	if eligible == 0 {
+		basis = scoreBasisHeuristic
+	}
+	if judged != eligible {
+		basis = scoreBasisHeuristic
+		for i := range attempts {
+			a := &attempts[i].log
+			a.Realism.Score = scoring.CombineRealism(a.Realism.HeuristicScore, 0, false)
+			a.FinalScore = r.finalScore(a.Tech.Score, a.Realism.Score)
+		}
+		if judged > 0 {
+			if note != "" {
+				note += "; "
+			}
+			note += fmt.Sprintf("judge scored %d of %d attempts, ranked on heuristic realism", judged, eligible)
+		}
+	}
+	for i := range attempts {
+		attempts[i].log.ScoreBasis = basis
+		if attempts[i].log.JudgeSkipped != "" {
+			attempts[i].log.ScoreBasis = scoreBasisHeuristic
+		}
+	}
+	return note
+}
@@ -0,0 +1,142 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// judgeCache stores realism judge scores under the workdir, so prompts that
+// come back across retries, iterations, and resumed runs are not judged
+// again. Entries are keyed by the normalized prompt and a scope: the judge
+// models, the number of samples, and the judge template. With a similarity
+// above 0, a prompt at least that similar to a cached one of the same scope
+// reuses its scores too. A nil cache is disabled.
+type judgeCache struct {
+	dir        string
+	scope      string
+	similarity float64
+
+	mu sync.Mutex
+	// entries are the cached judgements of the scope, read on the first
+	// fuzzy lookup.
+	entries []cachedJudgement
+	loaded  bool
+}
+
+type cachedJudgement struct {
+	Scope         string    `json:"scope"`
+	Prompt        string    `json:"prompt"`
+	Scores        []float64 `json:"scores"`
+	Justification string    `json:"justification,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// newJudgeCache returns the judge cache of the run, or nil when disabled.
+func (r *Runner) newJudgeCache(templates *llm.Templates) (*judgeCache, error) {
+	if !r.cfg.JudgeCache {
+		return nil, nil
+	}
+	tmpl, err := templates.Judge("")
+	if err != nil {
+		return nil, fmt.Errorf("render judge template: %w", err)
+	}
+	kind := r.cfg.providerFor(r.cfg.JudgeProvider)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s", r.providerIdentity(kind, r.roleModel(llm.RoleJudge)), maxInt(1, r.cfg.JudgeSamples), tmpl)
+	for _, m := range r.cfg.extraJudgeModels() {
+		fmt.Fprintf(h, "\x00%s", r.providerIdentity(kind, roleModel{model: m, effort: r.cfg.JudgeReasoningEffort}))
+	}
+	return &judgeCache{
+		dir:        filepath.Join(r.cfg.Workdir, "cache", "judge"),
+		scope:      hex.EncodeToString(h.Sum(nil)),
+		similarity: r.cfg.JudgeCacheSimilarity,
+	}, nil
+}
+
+func (c *judgeCache) key(prompt string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	sum := sha256.Sum256([]byte(c.scope + "\x00" + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the cached judgement of prompt, or of the most similar
+// cached prompt, with the similarity of the match.
+func (c *judgeCache) lookup(prompt string) (cachedJudgement, float64, bool) {
+	if c == nil {
+		return cachedJudgement{}, 0, false
+	}
+	if entry, ok := c.read(filepath.Join(c.dir, c.key(prompt)+".json")); ok {
+		return entry, 1, true
+	}
+	if c.similarity <= 0 {
+		return cachedJudgement{}, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	best, bestSim := -1, 0.0
+	for i, e := range c.entries {
+		if sim := promptSimilarity(prompt, e.Prompt); sim >= c.similarity && sim > bestSim {
+			best, bestSim = i, sim
+		}
+	}
+	if best < 0 {
+		return cachedJudgement{}, 0, false
+	}
+	return c.entries[best], bestSim, true
+}
+
+// load reads the cached judgements of the scope; mu must be held.
+func (c *judgeCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	paths, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, p := range paths {
+		if e, ok := c.read(p); ok {
+			c.entries = append(c.entries, e)
+		}
+	}
+}
+
+func (c *judgeCache) read(path string) (cachedJudgement, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cachedJudgement{}, false
+	}
+	var e cachedJudgement
+	if err := json.Unmarshal(data, &e); err != nil || e.Scope != c.scope || len(e.Scores) == 0 {
+		return cachedJudgement{}, false
+	}
+	return e, true
+}
+
+func (c *judgeCache) put(prompt string, scores []float64, justification string) error {
+	if c == nil {
+		return nil
+	}
+	e := cachedJudgement{Scope: c.scope, Prompt: prompt, Scores: scores, Justification: justification, CreatedAt: time.Now()}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := DirSink(c.dir).WriteArtifact(c.key(prompt)+".json", append(data, '\n')); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		c.entries = append(c.entries, e)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package run
+
+import (
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/library"
+)
+
+// ExportLibrary collects every validated prompt from the run logs found in
+// the given workdirs into a prompt library.
+func ExportLibrary(workdirs []string) (library.Library, error) {
+	lib := library.Library{Version: library.Version}
+	for _, wd := range workdirs {
+		runLog, err := readRunLog(filepath.Join(wd, "artifacts", "run_log.json"))
+		if err != nil {
+			return library.Library{}, err
+		}
+		lib.Add(libraryEntries(runLog)...)
+	}
+	return lib, nil
+}
+
+func libraryEntries(runLog RunLog) []library.Entry {
+	var out []library.Entry
+	for _, it := range runLog.Iterations {
+		attempts := map[int]CoderAttemptLog{}
+		for _, a := range it.CoderAttempts {
+			attempts[a.CandidateIndex] = a
+		}
+		for _, d := range it.Drafts {
+			if d.CandidatePrompt == "" || d.GenerationError != "" {
+				continue
+			}
+			entry := library.Entry{
+				Title:     d.Title,
+				Prompt:    d.CandidatePrompt,
+				Repo:      runLog.Repo,
+				Commit:    runLog.TargetCommit,
+				Languages: runLog.Languages,
+				Style:     d.Style,
+				Iteration: it.Iteration,
+				Realism:   d.PreRealism,
+			}
+			if a, ok := attempts[d.Index]; ok {
+				entry.Executed = true
+				entry.Tech = a.Tech.Score
+				entry.Realism = a.Realism.Score
+				entry.FinalScore = a.FinalScore
+				entry.ScoringVersion = runLog.ScoringVersion
+			}
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// loadExemplarPool returns the exemplars available to the SpecWriter, ordered
+// by relevance. The pool is larger than the per-candidate count so that
+// different candidate styles can rotate through different exemplars.
+func (r *Runner) loadExemplarPool(languages []string, styles int) ([]string, error) {
+	if r.cfg.Exemplars <= 0 {
+		return nil, nil
+	}
+	lib := library.Defaults()
+	if r.cfg.PromptLibrary != "" {
+		loaded, err := library.Load(r.cfg.PromptLibrary)
+		if err != nil {
+			return nil, err
+		}
+		lib = loaded
+	}
+	selected := lib.Select(languages, r.cfg.Exemplars*maxInt(1, styles))
+	out := make([]string, 0, len(selected))
+	for _, e := range selected {
+		out = append(out, e.Prompt)
+	}
+	return out, nil
+}
+
+// exemplarsForStyle rotates through the pool so each candidate style sees a
+// different subset, and drops exemplars that do not fit the token budget.
+func exemplarsForStyle(pool []string, styleIdx, k, tokenBudget int) []string {
+	if len(pool) == 0 || k <= 0 {
+		return nil
+	}
+	out := []string{}
+	used := 0
+	for j := 0; j < len(pool) && len(out) < k; j++ {
+		ex := pool[(styleIdx*k+j)%len(pool)]
+		cost := estimateTokens(ex)
+		if tokenBudget > 0 && used+cost > tokenBudget {
+			continue
+		}
+		used += cost
+		out = append(out, ex)
+	}
+	return out
+}
+
+// estimateTokens approximates the token count of English prose.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
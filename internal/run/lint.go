@@ -0,0 +1,160 @@
+package run
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	passiveVoiceRe  = regexp.MustCompile(`(?i)\b(?:am|is|are|was|were|be|been|being)\s+(?:\w+ly\s+)?(?:\w+ed|\w+en|built|made|done|kept|left|lost|met|paid|put|run|seen|sent|set|shown|split|spent|told|thought|found|held|read|known|given|taken|written)\b`) //nolint:lll
+	sentenceSplitRe = regexp.MustCompile(`[.!?]+(?:\s+|$)|\n+`)
+	wordRe          = regexp.MustCompile(`[A-Za-z][A-Za-z0-9_'-]*`)
+	jargonTokenRe   = regexp.MustCompile(`^(?:[A-Z]{2,}[0-9]*s?|[a-z]+[A-Z][A-Za-z0-9]*|[A-Za-z]+_[A-Za-z0-9_]+)$`)
+)
+
+var vagueCriteriaTerms = []string{
+	"appropriate", "properly", "correctly", "robust", "seamless", "user-friendly", "intuitive",
+	"efficient", "fast", "reasonable", "as needed", "as expected", "etc", "and so on", "graceful",
+	"clean", "better", "improved", "nice", "good",
+}
+
+var verifiableCriteriaTerms = []string{
+	"test", "tests", "tested", "returns", "rejects", "error", "fails", "succeeds", "within", "at least",
+	"at most", "no more than", "exactly", "when", "if", "given", "logs", "emits", "reports",
+}
+
+// Criteria terms match whole words, so "etc" is not found in "fetch" nor
+// "test" in "latest".
+var (
+	vagueCriteriaRe      = termsRe(vagueCriteriaTerms)
+	verifiableCriteriaRe = termsRe(verifiableCriteriaTerms)
+	criterionMarkerRe    = regexp.MustCompile(`^(?:[-*]|\d+[.)])\s+`)
+)
+
+func termsRe(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+var buzzwords = []string{
+	"leverage", "synergy", "paradigm", "holistic", "scalable", "idempotent", "orchestration",
+	"middleware", "serialization", "deserialization", "refactor", "abstraction", "polymorphic",
+}
+
+type LintReport struct {
+	SectionLengths       map[string]int `json:"sectionLengths"`
+	Sentences            int            `json:"sentences"`
+	Words                int            `json:"words"`
+	PassiveVoice         int            `json:"passiveVoice"`
+	AcceptanceCriteria   int            `json:"acceptanceCriteria"`
+	UnverifiableCriteria int            `json:"unverifiableCriteria"`
+	JargonTerms          int            `json:"jargonTerms"`
+	JargonDensity        float64        `json:"jargonDensity"`
+	Findings             []string       `json:"findings,omitempty"`
+}
+
+// LintPrompt reports descriptive statistics about a candidate prompt. Unlike
+// validation it never rejects a prompt; it only describes it.
+func LintPrompt(prompt string) LintReport {
+	report := LintReport{SectionLengths: map[string]int{}}
+	text := strings.TrimSpace(prompt)
+	if text == "" {
+		return report
+	}
+
+	sections := splitSections(text)
+	for _, s := range sections {
+		key := s.Key
+		if key == "" {
+			key = "other"
+		}
+		report.SectionLengths[key] += len(s.Body)
+	}
+
+	sentences := splitSentences(text)
+	report.Sentences = len(sentences)
+	for _, sentence := range sentences {
+		if passiveVoiceRe.MatchString(sentence) {
+			report.PassiveVoice++
+		}
+	}
+
+	words := wordRe.FindAllString(text, -1)
+	report.Words = len(words)
+	lowerText := strings.ToLower(text)
+	for _, w := range words {
+		if jargonTokenRe.MatchString(w) {
+			report.JargonTerms++
+		}
+	}
+	for _, bw := range buzzwords {
+		report.JargonTerms += strings.Count(lowerText, bw)
+	}
+	if report.Words > 0 {
+		report.JargonDensity = float64(report.JargonTerms) / float64(report.Words)
+	}
+
+	if acceptance, ok := sectionByKey(sections, sectionAcceptance); ok {
+		for _, criterion := range splitCriteria(acceptance.Body) {
+			report.AcceptanceCriteria++
+			if isUnverifiableCriterion(criterion) {
+				report.UnverifiableCriteria++
+			}
+		}
+	}
+
+	for _, key := range sectionKeys() {
+		if report.SectionLengths[key] == 0 {
+			report.Findings = append(report.Findings, key+" section is empty or missing")
+		}
+	}
+	if report.Sentences > 0 && float64(report.PassiveVoice)/float64(report.Sentences) > 0.3 {
+		report.Findings = append(report.Findings, "heavy use of passive voice")
+	}
+	if report.UnverifiableCriteria > 0 {
+		report.Findings = append(report.Findings, "some acceptance criteria are not objectively verifiable")
+	}
+	if report.JargonDensity > 0.08 {
+		report.Findings = append(report.Findings, "jargon density is high for a human-written request")
+	}
+	return report
+}
+
+func splitSentences(text string) []string {
+	out := []string{}
+	for _, part := range sentenceSplitRe.Split(text, -1) {
+		part = strings.TrimSpace(part)
+		if part == "" || topLevelHeadingRe.MatchString(part) {
+			continue
+		}
+		out = append(out, part)
+	}
+	return out
+}
+
+func splitCriteria(body string) []string {
+	lines := []string{}
+	bulleted := false
+	for _, line := range strings.Split(body, "\n") {
+		l := strings.TrimSpace(line)
+		if l == "" {
+			continue
+		}
+		if m := criterionMarkerRe.FindString(l); m != "" {
+			bulleted = true
+			l = l[len(m):]
+		}
+		lines = append(lines, l)
+	}
+	if bulleted {
+		return lines
+	}
+	return splitSentences(body)
+}
+
+func isUnverifiableCriterion(criterion string) bool {
+	return vagueCriteriaRe.MatchString(criterion) && !verifiableCriteriaRe.MatchString(criterion)
+}
@@ -0,0 +1,73 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel resolves the configured level. Without one, verbose runs log
+// everything down to provider calls and other runs only warnings.
+func (c Config) logLevel() (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(c.LogLevel)) {
+	case "":
+		if c.Verbose {
+			return slog.LevelDebug, nil
+		}
+		return slog.LevelWarn, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("log-level must be debug, info, warn, or error")
+}
+
+func validateLogging(c Config) error {
+	if _, err := c.logLevel(); err != nil {
+		return err
+	}
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("log-format must be %s or %s", LogFormatText, LogFormatJSON)
+}
+
+// logger returns Config.Logger or a logger writing to stderr with the
+// configured level and format.
+func (c Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return newLogger(os.Stderr, c)
+}
+
+// LoggerTo returns a logger writing to w with the configured level and
+// format, for callers that need logs away from stderr.
+func (c Config) LoggerTo(w io.Writer) *slog.Logger {
+	return newLogger(w, c)
+}
+
+func newLogger(w io.Writer, c Config) *slog.Logger {
+	level, err := c.logLevel()
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if c.LogFormat == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
@@ -0,0 +1,196 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// NoveltyMetric scores how different a candidate prompt is from the prompts
+// already tried, from 0 for a repeat to 1 for unlike any of them. Novelty
+// pushes the pre-score of candidates toward unexplored requests.
+type NoveltyMetric interface {
+	Name() string
+	Novelty(ctx context.Context, candidate string, history []string) (float64, error)
+}
+
+const (
+	NoveltyJaccard   = "jaccard"
+	NoveltyNGram     = "ngram"
+	NoveltyEmbedding = "embedding"
+)
+
+func noveltyMetrics() []string {
+	return []string{NoveltyJaccard, NoveltyNGram, NoveltyEmbedding}
+}
+
+// noveltyMetric returns the configured novelty metric. A metric set in the
+// config takes precedence over the named one.
+func (r *Runner) noveltyMetric() NoveltyMetric {
+	if r.cfg.NoveltyMetric != nil {
+		return r.cfg.NoveltyMetric
+	}
+	switch r.cfg.Novelty {
+	case NoveltyNGram:
+		return ngramNovelty{n: 3}
+	case NoveltyEmbedding:
+		return newEmbeddingNovelty(r.embedder())
+	default:
+		return jaccardNovelty{}
+	}
+}
+
+// embedder calls the embeddings API of the SpecWriter's OpenAI-compatible
+// provider.
+func (r *Runner) embedder() llm.Embedder {
+	p := &llm.OpenAIProvider{Model: r.cfg.EmbeddingModel}
+	switch r.cfg.providerFor(r.cfg.SpecProvider) {
+	case ProviderOpenAI:
+		p.Endpoint, p.APIKey = r.cfg.OpenAIEndpoint, r.cfg.OpenAIAPIKey
+		if p.Model == "" {
+			p.Model = "text-embedding-3-small"
+		}
+	case ProviderOllama:
+		p.Endpoint = r.cfg.OllamaEndpoint
+		if p.Model == "" {
+			p.Model = "nomic-embed-text"
+		}
+	}
+	return p
+}
+
+// novelty scores a candidate with the metric, falling back to token Jaccard
+// when the metric fails so a flaky embeddings endpoint does not fail the
+// iteration. It returns the score and the name of the metric that produced
+// it.
+func (r *Runner) novelty(ctx context.Context, metric NoveltyMetric, candidate string, history []string) (float64, string) {
+	if metric == nil {
+		metric = jaccardNovelty{}
+	}
+	v, err := metric.Novelty(ctx, candidate, history)
+	if err == nil {
+		return clamp01(v), metric.Name()
+	}
+	r.log.Warn("novelty metric failed, using token jaccard", "metric", metric.Name(), "error", err)
+	v, _ = jaccardNovelty{}.Novelty(ctx, candidate, history)
+	return v, NoveltyJaccard
+}
+
+// jaccardNovelty is one minus the highest word Jaccard similarity to a
+// previous prompt, on words of four letters or more.
+type jaccardNovelty struct{}
+
+func (jaccardNovelty) Name() string { return NoveltyJaccard }
+
+func (jaccardNovelty) Novelty(_ context.Context, candidate string, history []string) (float64, error) {
+	return noveltyScore(candidate, history), nil
+}
+
+// ngramNovelty compares character n-grams, which tolerates inflections and
+// reworded compounds that token Jaccard counts as different words.
+type ngramNovelty struct {
+	n int
+}
+
+func (m ngramNovelty) Name() string { return NoveltyNGram }
+
+func (m ngramNovelty) Novelty(_ context.Context, candidate string, history []string) (float64, error) {
+	if len(history) == 0 {
+		return 1, nil
+	}
+	cand := charNGrams(candidate, m.n)
+	best := 0.0
+	for _, h := range history {
+		best = math.Max(best, jaccardTokens(cand, charNGrams(h, m.n)))
+	}
+	return clamp01(1 - best), nil
+}
+
+func charNGrams(s string, n int) map[string]struct{} {
+	runes := []rune(strings.Join(strings.Fields(strings.ToLower(s)), " "))
+	out := map[string]struct{}{}
+	for i := 0; i+n <= len(runes); i++ {
+		out[string(runes[i:i+n])] = struct{}{}
+	}
+	return out
+}
+
+// embeddingNovelty is one minus the highest cosine similarity between the
+// embedding of the candidate and those of previous prompts. Embeddings are
+// cached for the run, so each prompt is embedded once.
+type embeddingNovelty struct {
+	embedder llm.Embedder
+	mu       sync.Mutex
+	cache    map[string][]float64
+}
+
+func newEmbeddingNovelty(e llm.Embedder) *embeddingNovelty {
+	return &embeddingNovelty{embedder: e, cache: map[string][]float64{}}
+}
+
+func (m *embeddingNovelty) Name() string { return NoveltyEmbedding }
+
+func (m *embeddingNovelty) Novelty(ctx context.Context, candidate string, history []string) (float64, error) {
+	if len(history) == 0 {
+		return 1, nil
+	}
+	vecs, err := m.embed(ctx, append([]string{candidate}, history...))
+	if err != nil {
+		return 0, err
+	}
+	best := 0.0
+	for _, v := range vecs[1:] {
+		best = math.Max(best, cosine(vecs[0], v))
+	}
+	return clamp01(1 - best), nil
+}
+
+func (m *embeddingNovelty) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []string
+	seen := map[string]bool{}
+	for _, t := range texts {
+		if _, ok := m.cache[t]; !ok && !seen[t] {
+			seen[t] = true
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		vecs, err := m.embedder.Embed(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		if len(vecs) != len(missing) {
+			return nil, fmt.Errorf("got %d embeddings for %d inputs", len(vecs), len(missing))
+		}
+		for i, t := range missing {
+			m.cache[t] = vecs[i]
+		}
+	}
+	out := make([][]float64, len(texts))
+	for i, t := range texts {
+		out[i] = m.cache[t]
+	}
+	return out, nil
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	dot, na, nb := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,314 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/copilot"
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+const (
+	ProviderCopilot   = "copilot"
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+)
+
+type roleProviders struct {
+	spec  llm.ChatProvider
+	judge llm.ChatProvider
+	// extraJudges are the judges of the additional judge models.
+	extraJudges []llm.ChatProvider
+	gap         llm.ChatProvider
+	// critic is nil unless a critic provider is configured.
+	critic llm.ChatProvider
+	// beamSpec are the SpecWriter providers of beam lineages 2 and up.
+	beamSpec []llm.ChatProvider
+	// coder runs candidates on worktrees, selected by the run-wide provider.
+	coder llm.Provider
+	// recall asks the coder model about the target for the contamination
+	// check, in its own session; nil unless the check is enabled.
+	recall llm.ChatProvider
+	// templates render the SpecWriter, judge, and coder prompts.
+	templates *llm.Templates
+}
+
+func providerKinds() []string {
+	return []string{ProviderCopilot, ProviderOpenAI, ProviderAnthropic, ProviderOllama}
+}
+
+func validateProviderKind(role, kind string) error {
+	for _, k := range providerKinds() {
+		if kind == k {
+			return nil
+		}
+	}
+	flag := "provider"
+	if role != "" {
+		flag = role + "-provider"
+	}
+	return fmt.Errorf("%s must be one of %s", flag, strings.Join(providerKinds(), ", "))
+}
+
+// providerFor resolves a role's provider kind, falling back to the run-wide
+// provider and then to Copilot.
+func (c Config) providerFor(kind string) string {
+	if kind != "" {
+		return kind
+	}
+	if c.Provider != "" {
+		return c.Provider
+	}
+	return ProviderCopilot
+}
+
+func (c Config) usesProvider(kind string) bool {
+	for _, k := range []string{c.Provider, c.SpecProvider, c.JudgeProvider, c.GapProvider} {
+		if c.providerFor(k) == kind {
+			return true
+		}
+	}
+	return c.CriticProvider == kind
+}
+
+// roleModel is the model and reasoning effort configured for a role. Empty
+// fields fall back to the provider defaults.
+type roleModel struct {
+	model  string
+	effort string
+}
+
+// roleModel returns the model settings of a role. The gap summarizer follows
+// the SpecWriter so both can keep sharing a session; the critic uses the
+// provider defaults.
+func (r *Runner) roleModel(role llm.Role) roleModel {
+	switch role {
+	case llm.RoleSpecWriter, llm.RoleGap:
+		return roleModel{model: r.cfg.SpecModel, effort: r.cfg.SpecReasoningEffort}
+	case llm.RoleJudge:
+		return roleModel{model: r.cfg.JudgeModel, effort: r.cfg.JudgeReasoningEffort}
+	case llm.RoleCoder:
+		return roleModel{model: r.cfg.CoderModel, effort: r.cfg.CoderReasoningEffort}
+	default:
+		return roleModel{}
+	}
+}
+
+// extraJudgeModels returns the judge models after JudgeModel that judge
+// samples rotate through.
+func (c Config) extraJudgeModels() []string {
+	var models []string
+	for _, m := range strings.Split(c.JudgeModels, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// judgeFor returns the judge of a sample, rotating through the judge models.
+func (p roleProviders) judgeFor(sample int) llm.ChatProvider {
+	n := sample % (1 + len(p.extraJudges))
+	if n == 0 {
+		return p.judge
+	}
+	return p.extraJudges[n-1]
+}
+
+// newRoleProviders builds the chat provider for each LLM role and the coder
+// backend. Roles without their own provider use the run-wide one. The spec,
+// judge, and gap roles share a Copilot session when backed by Copilot with the
+// same model settings, matching the original behavior where judge and gap
+// calls reused the SpecWriter conversation. The critic always gets its own
+// session so its review is not colored by the SpecWriter's history, and so do
+// the SpecWriters of additional beam lineages.
+func (r *Runner) newRoleProviders(ctx context.Context, manager *copilot.Manager, usage *llm.TokenCounter, audit *isolationAudit, templates *llm.Templates) (roleProviders, func(), error) {
+	shared := map[roleModel]*copilot.ChatSession{}
+	var sessions []*copilot.ChatSession
+	cleanup := func() {
+		for _, s := range sessions {
+			if err := s.Destroy(); err != nil {
+				r.log.Warn("failed to destroy chat session", "error", err)
+			}
+		}
+	}
+
+	build := func(kind string, m roleModel, dedicated bool) (llm.ChatProvider, error) {
+		kind = r.cfg.providerFor(kind)
+		if kind != ProviderCopilot {
+			return r.chatProvider(kind, m)
+		}
+		if s, ok := shared[m]; ok && !dedicated {
+			return s, nil
+		}
+		s, err := manager.CreateChatSession(ctx, r.cfg.Workdir, copilot.SessionOptions{Model: m.model, ReasoningEffort: m.effort})
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+		if !dedicated {
+			shared[m] = s
+		}
+		return s, nil
+	}
+
+	mws := r.middleware(usage, audit)
+	wrapModel := func(role llm.Role, kind string, m roleModel, dedicated bool) (llm.ChatProvider, error) {
+		p, err := build(kind, m, dedicated)
+		if err != nil {
+			return nil, err
+		}
+		return llm.Chain(role, p, mws...), nil
+	}
+	wrap := func(role llm.Role, kind string, dedicated bool) (llm.ChatProvider, error) {
+		return wrapModel(role, kind, r.roleModel(role), dedicated)
+	}
+
+	out := roleProviders{templates: templates}
+	var err error
+	if out.spec, err = wrap(llm.RoleSpecWriter, r.cfg.SpecProvider, false); err != nil {
+		cleanup()
+		return roleProviders{}, func() {}, err
+	}
+	if out.judge, err = wrap(llm.RoleJudge, r.cfg.JudgeProvider, false); err != nil {
+		cleanup()
+		return roleProviders{}, func() {}, err
+	}
+	for _, model := range r.cfg.extraJudgeModels() {
+		judge, err := wrapModel(llm.RoleJudge, r.cfg.JudgeProvider, roleModel{model: model, effort: r.cfg.JudgeReasoningEffort}, false)
+		if err != nil {
+			cleanup()
+			return roleProviders{}, func() {}, err
+		}
+		out.extraJudges = append(out.extraJudges, judge)
+	}
+	if out.gap, err = wrap(llm.RoleGap, r.cfg.GapProvider, false); err != nil {
+		cleanup()
+		return roleProviders{}, func() {}, err
+	}
+	// Every beam lineage after the first gets its own SpecWriter session so
+	// lineages do not see each other's conversation.
+	for i := 1; i < r.cfg.BeamWidth; i++ {
+		spec, err := wrap(llm.RoleSpecWriter, r.cfg.SpecProvider, true)
+		if err != nil {
+			cleanup()
+			return roleProviders{}, func() {}, err
+		}
+		out.beamSpec = append(out.beamSpec, spec)
+	}
+	if r.cfg.CriticProvider != "" {
+		if out.critic, err = wrap(llm.RoleCritic, r.cfg.CriticProvider, true); err != nil {
+			cleanup()
+			return roleProviders{}, func() {}, err
+		}
+	}
+
+	if r.cfg.ContaminationCheck {
+		// The probe names the target on purpose, so it bypasses the
+		// isolation audit; its session is never reused by a coder.
+		p, err := build(r.cfg.Provider, r.roleModel(llm.RoleCoder), true)
+		if err != nil {
+			cleanup()
+			return roleProviders{}, func() {}, err
+		}
+		out.recall = llm.Chain(llm.RoleRecall, p, r.middleware(usage, nil)...)
+	}
+
+	if kind := r.cfg.providerFor(r.cfg.Provider); kind == ProviderCopilot {
+		// The coder model is applied by the manager.
+		backend := &copilot.Backend{Manager: manager}
+		if s, ok := shared[r.roleModel(llm.RoleSpecWriter)]; ok {
+			backend.Chat = s
+		}
+		out.coder = backend
+	} else {
+		chat, err := r.chatProvider(kind, r.roleModel(llm.RoleCoder))
+		if err != nil {
+			cleanup()
+			return roleProviders{}, func() {}, err
+		}
+		out.coder = &llm.ChatBackend{ChatProvider: llm.Chain(llm.RoleCoder, chat, mws...), Templates: templates}
+	}
+	return out, cleanup, nil
+}
+
+// loadTemplates reads the prompt templates and logs the overridden ones.
+func (r *Runner) loadTemplates() (*llm.Templates, error) {
+	t, err := llm.LoadTemplates(r.cfg.TemplatesDir)
+	if err != nil {
+		return nil, fmt.Errorf("load templates: %w", err)
+	}
+	for name, path := range t.Sources {
+		r.log.Info("using custom prompt template", "template", name, "path", path)
+	}
+	return t, nil
+}
+
+// chatProvider builds a stateless HTTP chat provider for the given kind. The
+// Anthropic API has no reasoning effort setting, so only the model applies.
+func (r *Runner) chatProvider(kind string, m roleModel) (llm.ChatProvider, error) {
+	model := func(def string) string {
+		if m.model != "" {
+			return m.model
+		}
+		return def
+	}
+	switch kind {
+	case ProviderOpenAI:
+		return &llm.OpenAIProvider{
+			Endpoint:        r.cfg.OpenAIEndpoint,
+			Model:           model(r.cfg.OpenAIModel),
+			APIKey:          r.cfg.OpenAIAPIKey,
+			ReasoningEffort: m.effort,
+		}, nil
+	case ProviderAnthropic:
+		return &llm.AnthropicProvider{
+			Model:  model(r.cfg.AnthropicModel),
+			APIKey: r.cfg.AnthropicAPIKey,
+		}, nil
+	case ProviderOllama:
+		return &llm.OpenAIProvider{
+			Endpoint:        r.cfg.OllamaEndpoint,
+			Model:           model(r.cfg.OllamaModel),
+			ReasoningEffort: m.effort,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", kind)
+	}
+}
+
+// middleware returns the chain applied to every role provider. Caller hooks
+// run outermost so they observe calls exactly as the runner issues them,
+// and the isolation audit innermost so it sees every prompt sent, retries
+// included.
+func (r *Runner) middleware(usage *llm.TokenCounter, audit *isolationAudit) []llm.Middleware {
+	mws := append([]llm.Middleware{}, r.cfg.Middleware...)
+	if r.log.Enabled(context.Background(), slog.LevelDebug) {
+		mws = append(mws, llm.Logging(r.log))
+	}
+	if usage != nil {
+		mws = append(mws, usage.Middleware())
+	}
+	if r.cfg.ProviderRetries > 0 {
+		mws = append(mws, llm.Retry(r.cfg.ProviderRetries, 2*time.Second))
+	}
+	if audit != nil {
+		mws = append(mws, audit.Middleware())
+	}
+	return mws
+}
+
+func (e *runEnv) providerUsage() map[llm.Role]llm.Usage {
+	if e == nil || e.usage == nil {
+		return nil
+	}
+	usage := e.usage.Snapshot()
+	if len(usage) == 0 {
+		return nil
+	}
+	return usage
+}
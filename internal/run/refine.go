@@ -0,0 +1,77 @@
+package run
+
+import (
+	"context"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+const SelfRefineAll = "all"
+
+// RefinementLog keeps the draft that went into the self-refine pass next to
+// the critique, so both versions can be compared after the run. The refined
+// version is the candidate prompt of the enclosing draft log when Accepted.
+type RefinementLog struct {
+	InitialTitle  string `json:"initialTitle,omitempty"`
+	InitialPrompt string `json:"initialPrompt"`
+	Critique      string `json:"critique,omitempty"`
+	RawResponse   string `json:"rawResponse,omitempty"`
+	Accepted      bool   `json:"accepted"`
+	Error         string `json:"error,omitempty"`
+}
+
+// selfRefineEnabled reports whether the style matches the self-refine
+// setting: "all", or a comma-separated list of keywords matched against the
+// style description.
+func (r *Runner) selfRefineEnabled(style string) bool {
+	setting := strings.ToLower(strings.TrimSpace(r.cfg.SelfRefine))
+	if setting == "" {
+		return false
+	}
+	if setting == SelfRefineAll {
+		return true
+	}
+	style = strings.ToLower(style)
+	for _, kw := range parseCommaList(setting) {
+		if strings.Contains(style, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// refineCandidate runs one critique-and-revise turn on a valid draft. The
+// revision replaces the draft only if it passes the same validation.
+func (r *Runner) refineCandidate(ctx context.Context, spec llm.ChatProvider, in generationInput, style string, gen generatedCandidate) generatedCandidate {
+	entry := &RefinementLog{
+		InitialTitle:  gen.candidate.Title,
+		InitialPrompt: gen.candidate.CandidatePrompt,
+	}
+	gen.refinement = entry
+
+	refined, raw, err := llm.RefineSpecCandidate(ctx, spec, llm.RefineSpecRequest{
+		Draft:          gen.candidate,
+		Style:          style,
+		MaxPathRefs:    r.cfg.MaxPathRefs,
+		MaxLength:      r.cfg.MaxLength,
+		FrozenSections: toFrozenSections(in.frozen),
+		Language:       r.cfg.SpecLanguage,
+	})
+	entry.RawResponse = raw
+	if err != nil {
+		entry.Error = err.Error()
+		return gen
+	}
+	entry.Critique = refined.Critique
+
+	candidate, shortenedFrom, _, err := r.checkCandidate(refined.Candidate, in.frozen)
+	if err != nil {
+		entry.Error = err.Error()
+		return gen
+	}
+	entry.Accepted = true
+	gen.candidate = candidate
+	gen.shortenedFrom = shortenedFrom
+	return gen
+}
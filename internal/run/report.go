@@ -0,0 +1,422 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+)
+
+const (
+	reportChartWidth  = 720
+	reportChartHeight = 220
+	reportChartPad    = 30
+	reportMaxDiffLine = 4000
+)
+
+type reportData struct {
+	Log          RunLog
+	Chart        reportChart
+	Iterations   []reportIteration
+	Styles       []reportStyle
+	Diffs        []reportFileDiff
+	Trace        *TraceabilityMatrix
+	TraceHunks   map[int]TraceHunk
+	BestPrompt   string
+	HasBestPatch bool
+}
+
+type reportIteration struct {
+	IterationLog
+	Best bool
+}
+
+// reportStyle is a row of the style table, with the best final score of the
+// style in each iteration, empty where it had no scored attempt.
+type reportStyle struct {
+	StyleStats
+	Cells []string
+}
+
+type reportChart struct {
+	Width, Height int
+	Points        []reportPoint
+	IterBest      string
+	BestSoFar     string
+	ThresholdY    float64
+	Ticks         []reportTick
+}
+
+type reportPoint struct {
+	X, Y  float64
+	Label string
+}
+
+type reportTick struct {
+	X, Y  float64
+	Label string
+}
+
+type reportFileDiff struct {
+	Path     string
+	Target   []reportDiffLine
+	Produced []reportDiffLine
+}
+
+type reportDiffLine struct {
+	Class string
+	Text  string
+}
+
+// writeReport renders the HTML report when enabled. The report is a
+// convenience view of the other artifacts, so failures are only warned about.
+func (r *Runner) writeReport(env *runEnv, runLog RunLog) {
+	if !r.cfg.Report {
+		return
+	}
+	if err := renderReport(env.artifacts, runLog); err != nil {
+		r.log.Warn("failed to write report.html", "error", err)
+	}
+}
+
+// renderReport renders artifacts/report.html from the run log and the patches
+// and analyses already written to the artifacts directory.
+func renderReport(w artifactWriter, runLog RunLog) error {
+	artifactsDir := w.dir
+	data := reportData{Log: runLog, Chart: buildReportChart(runLog)}
+	for _, it := range runLog.Iterations {
+		data.Iterations = append(data.Iterations, reportIteration{IterationLog: it, Best: it.Iteration == runLog.BestIteration})
+	}
+	data.Styles = reportStyles(runLog.Iterations)
+
+	target, err := readOptional(filepath.Join(artifactsDir, "target.patch"))
+	if err != nil {
+		return err
+	}
+	best, err := readOptional(filepath.Join(artifactsDir, "best.patch"))
+	if err != nil {
+		return err
+	}
+	prompt, err := readOptional(filepath.Join(artifactsDir, "best_prompt.md"))
+	if err != nil {
+		return err
+	}
+	data.BestPrompt = strings.TrimSpace(prompt)
+	data.HasBestPatch = runLog.BestIteration > 0
+	data.Diffs = sideBySide(target, best)
+
+	trace, err := readOptional(filepath.Join(artifactsDir, "traceability.json"))
+	if err != nil {
+		return err
+	}
+	if trace != "" {
+		var m TraceabilityMatrix
+		if err := json.Unmarshal([]byte(trace), &m); err != nil {
+			return fmt.Errorf("decode traceability.json: %w", err)
+		}
+		data.Trace = &m
+		data.TraceHunks = map[int]TraceHunk{}
+		for _, h := range m.Hunks {
+			data.TraceHunks[h.ID] = h
+		}
+	}
+
+	var b bytes.Buffer
+	if err := reportTemplate.Execute(&b, data); err != nil {
+		return err
+	}
+	return w.write("report.html", b.Bytes())
+}
+
+func readOptional(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildReportChart plots every scored attempt, the best attempt of each
+// iteration, and the best score so far.
+func buildReportChart(runLog RunLog) reportChart {
+	c := reportChart{Width: reportChartWidth, Height: reportChartHeight}
+	n := len(runLog.Iterations)
+	plotW := float64(c.Width - 2*reportChartPad)
+	plotH := float64(c.Height - 2*reportChartPad)
+	x := func(i int) float64 {
+		if n <= 1 {
+			return float64(reportChartPad) + plotW/2
+		}
+		return float64(reportChartPad) + plotW*float64(i)/float64(n-1)
+	}
+	y := func(v float64) float64 {
+		return float64(reportChartPad) + plotH*(1-clamp01(v))
+	}
+	c.ThresholdY = y(runLog.Threshold)
+	for _, v := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		c.Ticks = append(c.Ticks, reportTick{X: float64(reportChartPad) - 4, Y: y(v), Label: fmt.Sprintf("%.2f", v)})
+	}
+
+	var iterBest, bestSoFar []string
+	best := 0.0
+	for i, it := range runLog.Iterations {
+		for _, a := range it.CoderAttempts {
+			if a.CoderError != "" {
+				continue
+			}
+			c.Points = append(c.Points, reportPoint{
+				X:     x(i),
+				Y:     y(a.FinalScore),
+				Label: fmt.Sprintf("iter %d cand %d: %.3f", it.Iteration, a.CandidateIndex, a.FinalScore),
+			})
+		}
+		best = maxFloat(best, it.IterationBestScore)
+		iterBest = append(iterBest, fmt.Sprintf("%.1f,%.1f", x(i), y(it.IterationBestScore)))
+		bestSoFar = append(bestSoFar, fmt.Sprintf("%.1f,%.1f", x(i), y(best)))
+	}
+	c.IterBest = strings.Join(iterBest, " ")
+	c.BestSoFar = strings.Join(bestSoFar, " ")
+	return c
+}
+
+func reportStyles(iterations []IterationLog) []reportStyle {
+	var out []reportStyle
+	for _, s := range styleBreakdown(iterations) {
+		row := reportStyle{StyleStats: s, Cells: make([]string, len(iterations))}
+		for _, si := range s.Iterations {
+			for i, it := range iterations {
+				if it.Iteration == si.Iteration {
+					row.Cells[i] = fmt.Sprintf("%.3f", si.BestFinal)
+				}
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// sideBySide pairs the per-file diffs of the target and the best patch, in
+// path order.
+func sideBySide(target, produced string) []reportFileDiff {
+	byPath := map[string]*reportFileDiff{}
+	add := func(patch string, produced bool) {
+		for _, f := range generated.SplitPatch(patch) {
+			if f.Header == "" {
+				continue
+			}
+			d, ok := byPath[f.Path]
+			if !ok {
+				d = &reportFileDiff{Path: f.Path}
+				byPath[f.Path] = d
+			}
+			lines := diffLines(f.Lines)
+			if produced {
+				d.Produced = lines
+			} else {
+				d.Target = lines
+			}
+		}
+	}
+	add(target, false)
+	add(produced, true)
+
+	paths := make([]string, 0, len(byPath))
+	for p := range byPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	out := make([]reportFileDiff, 0, len(paths))
+	for _, p := range paths {
+		out = append(out, *byPath[p])
+	}
+	return out
+}
+
+func diffLines(lines []string) []reportDiffLine {
+	var out []reportDiffLine
+	for _, l := range lines {
+		class := "ctx"
+		switch {
+		case strings.HasPrefix(l, "@@"):
+			class = "hunk"
+		case strings.HasPrefix(l, "+++"), strings.HasPrefix(l, "---"), strings.HasPrefix(l, "index "):
+			continue
+		case strings.HasPrefix(l, "+"):
+			class = "add"
+		case strings.HasPrefix(l, "-"):
+			class = "del"
+		}
+		out = append(out, reportDiffLine{Class: class, Text: truncateText(l, reportMaxDiffLine)})
+	}
+	return out
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"score": func(v float64) string { return fmt.Sprintf("%.3f", v) },
+	"short": func(s string) string {
+		if len(s) > 12 {
+			return s[:12]
+		}
+		return s
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>retrospec report {{short .Log.TargetCommit}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", sans-serif; margin: 2em; color: #222; }
+h1, h2, h3 { font-weight: 600; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; font-size: 0.9em; }
+th { background: #f4f4f4; }
+pre { white-space: pre-wrap; background: #f8f8f8; padding: 8px; margin: 0; font-size: 0.85em; }
+details { margin: 0.3em 0; }
+.best { background: #eef8ee; }
+.err { color: #b00; }
+.diff { display: grid; grid-template-columns: 1fr 1fr; gap: 8px; }
+.diff pre { overflow-x: auto; white-space: pre; }
+.diff span { display: block; }
+.add { background: #e6ffec; }
+.del { background: #ffebe9; }
+.hunk { color: #6f42c1; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>retrospec report</h1>
+<table>
+<tr><th>Repository</th><td>{{.Log.Repo}}</td></tr>
+<tr><th>Target</th><td>{{.Log.TargetCommit}}{{if .Log.CommitRange}} ({{.Log.CommitRange}}){{end}}</td></tr>
+<tr><th>Parent</th><td>{{.Log.ParentCommit}}</td></tr>
+<tr><th>Best iteration</th><td>{{.Log.BestIteration}}</td></tr>
+<tr><th>Stopped</th><td>{{.Log.StoppedReason}}</td></tr>
+<tr><th>Alpha / threshold</th><td>{{.Log.Alpha}} / {{.Log.Threshold}}</td></tr>
+<tr><th>Scoring version</th><td>{{.Log.ScoringVersion}}</td></tr>
+{{if .Log.Failure}}<tr><th>Failure</th><td class="err">{{.Log.Failure}}</td></tr>{{end}}
+</table>
+
+<h2>Commit message</h2>
+<pre>{{.Log.CommitMessage}}</pre>
+
+{{if .BestPrompt}}
+<h2>Best prompt</h2>
+<pre>{{.BestPrompt}}</pre>
+{{end}}
+
+<h2>Score trend</h2>
+{{with .Chart}}
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img">
+{{range .Ticks}}<text x="{{.X}}" y="{{.Y}}" font-size="10" text-anchor="end" dominant-baseline="middle" fill="#888">{{.Label}}</text>
+{{end}}
+<line x1="30" x2="{{.Width}}" y1="{{.ThresholdY}}" y2="{{.ThresholdY}}" stroke="#d33" stroke-dasharray="4 3"/>
+{{range .Points}}<circle cx="{{.X}}" cy="{{.Y}}" r="3" fill="#999"><title>{{.Label}}</title></circle>
+{{end}}
+<polyline points="{{.IterBest}}" fill="none" stroke="#3a7bd5" stroke-width="1.5"/>
+<polyline points="{{.BestSoFar}}" fill="none" stroke="#2a9d4a" stroke-width="2"/>
+</svg>
+<p class="muted">Dots: attempts. Blue: iteration best. Green: best so far. Red: threshold.</p>
+{{end}}
+
+{{if .Styles}}
+<h2>Candidate styles</h2>
+<table>
+<tr><th>Style</th><th>Drafts</th><th>Attempts</th><th>Selected</th><th>Mean tech</th><th>Mean realism</th><th>Mean final</th><th>Max final</th>{{range .Iterations}}<th>Iter {{.Iteration}}</th>{{end}}</tr>
+{{range .Styles}}
+<tr>
+<td>{{.Style}}</td>
+<td>{{.Drafts}}</td>
+<td>{{.Attempts}}{{if .Failed}} <span class="err">({{.Failed}} failed)</span>{{end}}</td>
+<td>{{.Selected}}</td>
+<td>{{score .MeanTech}}</td>
+<td>{{score .MeanRealism}}</td>
+<td>{{score .MeanFinal}}</td>
+<td>{{score .MaxFinal}}</td>
+{{range .Cells}}<td>{{if .}}{{.}}{{else}}<span class="muted">-</span>{{end}}</td>{{end}}
+</tr>
+{{end}}
+</table>
+<p class="muted">Per iteration: the best final score of the style's attempts. Selected: iterations whose best attempt had the style.</p>
+{{end}}
+
+<h2>Iterations</h2>
+{{range .Iterations}}
+<details{{if .Best}} open{{end}}>
+<summary>Iteration {{.Iteration}}: best {{score .IterationBestScore}}{{if .Best}} (overall best){{end}}</summary>
+<table>
+<tr><th>Candidate</th><th>Title</th><th>Tech</th><th>Realism</th><th>Final</th><th>Files</th><th>Notes</th></tr>
+{{$sel := .SelectedAttempt}}
+{{range $i, $a := .CoderAttempts}}
+<tr{{if eq $i $sel}} class="best"{{end}}>
+<td>{{$a.CandidateIndex}}</td>
+<td>{{$a.CandidateTitle}}</td>
+<td>{{score $a.Tech.Score}}</td>
+<td>{{score $a.Realism.Score}}</td>
+<td>{{score $a.FinalScore}}</td>
+<td>{{len $a.ProducedFiles}}</td>
+<td>{{if $a.CoderError}}<span class="err">{{$a.CoderError}}</span>{{end}}{{if $a.JudgeError}}<span class="err">{{$a.JudgeError}}</span>{{end}}{{if $a.DuplicateOf}}duplicate of {{$a.DuplicateOf}}{{if $a.DuplicateOfIteration}} in iteration {{$a.DuplicateOfIteration}}{{end}}{{if $a.DuplicateSimilarity}} ({{printf "%.2f" $a.DuplicateSimilarity}} similar){{end}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{range .Drafts}}
+<details>
+<summary>Candidate {{.Index}} ({{.Style}}){{if .Title}}: {{.Title}}{{end}}{{if .GenerationError}} <span class="err">{{.GenerationError}}</span>{{end}}</summary>
+{{if .CandidatePrompt}}<pre>{{.CandidatePrompt}}</pre>{{end}}
+</details>
+{{end}}
+</details>
+{{end}}
+
+{{if .Trace}}
+<h2>Traceability</h2>
+{{if .Trace.Error}}<p class="err">{{.Trace.Error}}</p>{{end}}
+<p>{{.Trace.Unaddressed}} prompt items without a hunk, {{.Trace.Untraced}} hunks without an item.</p>
+<table>
+<tr><th>Section</th><th>Item</th><th>Hunks</th></tr>
+{{$hunks := .TraceHunks}}
+{{range .Trace.Items}}
+<tr>
+<td>{{.Section}}</td>
+<td>{{.Text}}{{if .Note}}<br><span class="muted">{{.Note}}</span>{{end}}</td>
+<td>{{range .Hunks}}{{with index $hunks .}}<div>{{.Path}} <span class="muted">{{.Header}}</span></div>{{end}}{{else}}<span class="err">none</span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+
+<h2>Target vs best patch</h2>
+{{if not .HasBestPatch}}<p class="muted">No attempt was scored.</p>{{end}}
+{{range .Diffs}}
+<h3>{{.Path}}</h3>
+<div class="diff">
+<pre>{{range .Target}}<span class="{{.Class}}">{{.Text}}</span>{{else}}<span class="muted">not changed by the target</span>{{end}}</pre>
+<pre>{{range .Produced}}<span class="{{.Class}}">{{.Text}}</span>{{else}}<span class="muted">not changed by the best attempt</span>{{end}}</pre>
+</div>
+{{end}}
+</body>
+</html>
+`))
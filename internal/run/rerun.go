@@ -0,0 +1,124 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// RerunLog compares a replayed coder attempt with the attempt recorded in
+// run_log.json, if the candidate was executed during the original run.
+type RerunLog struct {
+	Iteration      int              `json:"iteration"`
+	CandidateIndex int              `json:"candidateIndex"`
+	Attempt        CoderAttemptLog  `json:"attempt"`
+	Original       *CoderAttemptLog `json:"original,omitempty"`
+	// The replay is scored with ScoringVersion; the original attempt was
+	// scored with OriginalScoringVersion.
+	ScoringVersion         int       `json:"scoringVersion"`
+	OriginalScoringVersion int       `json:"originalScoringVersion"`
+	StartedAt              time.Time `json:"startedAt"`
+	CompletedAt            time.Time `json:"completedAt"`
+}
+
+// RerunAttempt replays the coder and scoring pipeline for one candidate of a
+// finished run, using the current settings. The repository and target commit
+// come from the run log in the workdir, and the existing base clone is reused
+// when present. The result is written to artifacts/rerun-iter-NNN-cand-MM.json.
+func (r *Runner) RerunAttempt(ctx context.Context, iteration, candidate int) (RerunLog, error) {
+	start := time.Now()
+	runLog, err := readRunLog(filepath.Join(r.cfg.Workdir, "artifacts", "run_log.json"))
+	if err != nil {
+		return RerunLog{}, categorize(ErrorConfig, err)
+	}
+	draft, original, err := findDraft(runLog, iteration, candidate)
+	if err != nil {
+		return RerunLog{}, categorize(ErrorConfig, err)
+	}
+
+	r.cfg.Repo = runLog.Repo
+	r.cfg.Commit = runLog.TargetCommit
+	r.cfg.CommitRange = ""
+	if runLog.CommitRange != "" {
+		r.cfg.Commit = ""
+		r.cfg.CommitRange = runLog.ParentCommit + ".." + runLog.TargetCommit
+	}
+	r.reuseBase = true
+	env, cleanup, err := r.prepare(ctx)
+	if err != nil {
+		return RerunLog{}, err
+	}
+	defer cleanup()
+	env.attemptPrefix = "rerun-"
+	env.judgeAll = true
+
+	// Runs are named after rank+1; pass the candidate index so the replay is
+	// easy to match with its draft in run_log.json.
+	attempt, err := r.runAttempt(ctx, env, iteration, candidate-1, draft)
+	if err != nil {
+		return RerunLog{}, err
+	}
+	attempts := []coderAttemptRuntime{attempt}
+	r.normalizeJudging(ctx, env, attempts)
+	if err := r.explainAttempts(env, attempts); err != nil {
+		return RerunLog{}, err
+	}
+
+	out := RerunLog{
+		Iteration:              iteration,
+		CandidateIndex:         candidate,
+		Attempt:                attempts[0].log,
+		Original:               original,
+		ScoringVersion:         scoring.Version,
+		OriginalScoringVersion: runLog.ScoringVersion,
+		StartedAt:              start,
+		CompletedAt:            time.Now(),
+	}
+	name := fmt.Sprintf("rerun-iter-%03d-cand-%02d.json", iteration, candidate)
+	if err := env.artifacts.writeJSON(name, out); err != nil {
+		return RerunLog{}, categorize(ErrorArtifact, fmt.Errorf("write %s: %w", name, err))
+	}
+	return out, nil
+}
+
+// findDraft returns the draft with the given index in an iteration of the run
+// log, along with its recorded coder attempt when there is one.
+func findDraft(runLog RunLog, iteration, candidate int) (candidateDraftRuntime, *CoderAttemptLog, error) {
+	for _, it := range runLog.Iterations {
+		if it.Iteration != iteration {
+			continue
+		}
+		for _, d := range it.Drafts {
+			if d.Index != candidate {
+				continue
+			}
+			if d.CandidatePrompt == "" {
+				return candidateDraftRuntime{}, nil, fmt.Errorf("iteration %d candidate %d has no prompt: %s", iteration, candidate, d.GenerationError)
+			}
+			draft := candidateDraftRuntime{
+				log: d,
+				candidate: llm.SpecCandidate{
+					Title:           d.Title,
+					CandidatePrompt: d.CandidatePrompt,
+					Rationale:       d.Rationale,
+					ScopeHints:      d.ScopeHints,
+				},
+				valid:   true,
+				cluster: d.Cluster,
+			}
+			for i := range it.CoderAttempts {
+				if it.CoderAttempts[i].CandidateIndex == candidate {
+					original := it.CoderAttempts[i]
+					return draft, &original, nil
+				}
+			}
+			return draft, nil, nil
+		}
+		return candidateDraftRuntime{}, nil, fmt.Errorf("iteration %d has no candidate %d", iteration, candidate)
+	}
+	return candidateDraftRuntime{}, nil, fmt.Errorf("run log has no iteration %d", iteration)
+}
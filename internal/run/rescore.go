@@ -0,0 +1,186 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// RescoredAttempt is an attempt of a finished run scored again.
+type RescoredAttempt struct {
+	Iteration       int               `json:"iteration"`
+	CandidateIndex  int               `json:"candidateIndex"`
+	PatchPath       string            `json:"patchPath,omitempty"`
+	Tech            scoring.TechScore `json:"tech"`
+	Realism         float64           `json:"realism"`
+	FinalScore      float64           `json:"finalScore"`
+	PreviousTech    float64           `json:"previousTech"`
+	PreviousRealism float64           `json:"previousRealism"`
+	PreviousFinal   float64           `json:"previousFinal"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// RescoreLog is written to artifacts/rescore-NNN.json; each rescore gets
+// the next number so earlier results are kept.
+type RescoreLog struct {
+	Version int `json:"version"`
+	// ScoringVersion is the version of the scores in this file and
+	// PreviousScoringVersion the one recorded by the run.
+	ScoringVersion         int               `json:"scoringVersion"`
+	PreviousScoringVersion int               `json:"previousScoringVersion"`
+	RescoredAt             time.Time         `json:"rescoredAt"`
+	Alpha                  float64           `json:"alpha"`
+	GeneratedFiles         []string          `json:"generatedFiles,omitempty"`
+	Attempts               []RescoredAttempt `json:"attempts"`
+	Metrics                Metrics           `json:"metrics"`
+	PreviousBest           Metrics           `json:"previousBest"`
+	Warnings               []string          `json:"warnings,omitempty"`
+}
+
+// Rescore recomputes the scores of every attempt of the run in the workdir
+// from target.patch and the attempt patches, with the current scoring code
+// and settings. Heuristic realism is recomputed from the recorded prompts and
+// recorded judge scores are reused, so no model, git, or network work is
+// done. Go AST scoring needs the repository and is not applied.
+func (r *Runner) Rescore() (RescoreLog, error) {
+	artifactsDir := filepath.Join(r.cfg.Workdir, "artifacts")
+	runLog, err := readRunLog(filepath.Join(artifactsDir, "run_log.json"))
+	if err != nil {
+		return RescoreLog{}, categorize(ErrorConfig, err)
+	}
+	targetPatch, err := os.ReadFile(filepath.Join(artifactsDir, "target.patch"))
+	if err != nil {
+		return RescoreLog{}, categorize(ErrorConfig, fmt.Errorf("read target patch: %w", err))
+	}
+
+	detector := generated.Detector{Include: r.cfg.IncludeGenerated, Patterns: generated.ParsePatterns(r.cfg.GeneratedPatterns)}
+	target, generatedFiles := detector.Strip(git.ParseSnapshot(string(targetPatch)))
+	out := RescoreLog{
+		ScoringVersion:         scoring.Version,
+		PreviousScoringVersion: runLog.ScoringVersion,
+		RescoredAt:             time.Now(),
+		Alpha:                  r.cfg.Alpha,
+		GeneratedFiles:         generatedFiles,
+		Attempts:               []RescoredAttempt{},
+		Metrics:                Metrics{Alpha: r.cfg.Alpha, ScoringVersion: scoring.Version},
+	}
+	if r.cfg.GoASTScoring {
+		out.Warnings = append(out.Warnings, "go ast scoring needs the repository and is not applied")
+	}
+	if r.cfg.TargetTests {
+		out.Warnings = append(out.Warnings, "target tests need the worktrees and are not applied")
+	}
+
+	// Ties keep the earlier attempt, like the loop does.
+	best, previous := -1, -1
+	var titles []string
+	for _, iter := range runLog.Iterations {
+		for _, a := range iter.CoderAttempts {
+			res := r.rescoreAttempt(artifactsDir, detector, target, iter.Iteration, a)
+			out.Attempts = append(out.Attempts, res)
+			titles = append(titles, a.CandidateTitle)
+			i := len(out.Attempts) - 1
+			if res.Error == "" && (best < 0 || res.FinalScore > out.Attempts[best].FinalScore) {
+				best = i
+			}
+			if previous < 0 || res.PreviousFinal > out.Attempts[previous].PreviousFinal {
+				previous = i
+			}
+		}
+	}
+	if best >= 0 {
+		b := out.Attempts[best]
+		out.Metrics = Metrics{
+			Title:          titles[best],
+			TechSimilarity: b.Tech.Score,
+			RealismScore:   b.Realism,
+			FinalScore:     b.FinalScore,
+			Alpha:          r.cfg.Alpha,
+			BestIteration:  b.Iteration,
+			ScoringVersion: scoring.Version,
+		}
+	}
+	if previous >= 0 {
+		p := out.Attempts[previous]
+		out.PreviousBest = Metrics{
+			Title:          titles[previous],
+			TechSimilarity: p.PreviousTech,
+			RealismScore:   p.PreviousRealism,
+			FinalScore:     p.PreviousFinal,
+			Alpha:          runLog.Alpha,
+			BestIteration:  p.Iteration,
+			ScoringVersion: runLog.ScoringVersion,
+		}
+	}
+
+	out.Version, err = nextRescoreVersion(artifactsDir)
+	if err != nil {
+		return RescoreLog{}, categorize(ErrorArtifact, err)
+	}
+	name := fmt.Sprintf("rescore-%03d.json", out.Version)
+	if err := r.newArtifactWriter(artifactsDir).writeJSON(name, out); err != nil {
+		return RescoreLog{}, categorize(ErrorArtifact, fmt.Errorf("write %s: %w", name, err))
+	}
+	return out, nil
+}
+
+func (r *Runner) rescoreAttempt(artifactsDir string, detector generated.Detector, target git.DiffSnapshot, iteration int, a CoderAttemptLog) RescoredAttempt {
+	out := RescoredAttempt{
+		Iteration:       iteration,
+		CandidateIndex:  a.CandidateIndex,
+		PatchPath:       a.ProducedPatchPath,
+		PreviousTech:    a.Tech.Score,
+		PreviousRealism: a.Realism.Score,
+		PreviousFinal:   a.FinalScore,
+	}
+	patch, err := readAttemptPatch(artifactsDir, a.ProducedPatchPath)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	produced, _ := detector.Strip(git.ParseSnapshot(patch))
+	out.Tech = scoring.ScoreTechSimilarity(target, produced, r.techConfig())
+
+	heuristic := scoring.ScoreRealismHeuristic(a.CandidatePrompt, r.realismConfig()).HeuristicScore
+	judged := a.Judged && a.ScoreBasis != scoreBasisHeuristic
+	out.Realism = scoring.CombineRealism(heuristic, a.Realism.JudgeScore, judged)
+	out.FinalScore = r.finalScore(out.Tech.Score, out.Realism)
+	return out
+}
+
+// readAttemptPatch reads a recorded patch, falling back to the artifacts dir
+// when the workdir was moved since the run.
+func readAttemptPatch(artifactsDir, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("attempt has no patch")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = os.ReadFile(filepath.Join(artifactsDir, filepath.Base(path)))
+	}
+	if err != nil {
+		return "", fmt.Errorf("read attempt patch: %w", err)
+	}
+	return string(data), nil
+}
+
+func nextRescoreVersion(artifactsDir string) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(artifactsDir, "rescore-*.json"))
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	for _, m := range matches {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(m), "rescore-%03d.json", &n); err == nil && n >= version {
+			version = n + 1
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,107 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/github"
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// ReviewComparisonLog is written to review_comparison.json. Coverage counts a
+// concern reflected in the best prompt as 1 and a partially reflected one as
+// 0.5, averaged over all concerns.
+type ReviewComparisonLog struct {
+	PullRequest    int                 `json:"pullRequest,omitempty"`
+	PullRequestURL string              `json:"pullRequestUrl,omitempty"`
+	Comments       int                 `json:"comments"`
+	Summary        string              `json:"summary,omitempty"`
+	Concerns       []llm.ReviewConcern `json:"concerns,omitempty"`
+	Coverage       float64             `json:"coverage"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// compareReview fetches the review discussion of the pull request that
+// introduced the target commit and asks the gap model which concerns the best
+// prompt anticipates. Failures are recorded in the artifact, never fatal.
+func (r *Runner) compareReview(ctx context.Context, env *runEnv, prompt string) {
+	out := r.reviewComparison(ctx, env, prompt)
+	if out.Error != "" {
+		r.log.Warn("review comparison failed", "error", out.Error)
+	}
+	if err := env.artifacts.writeJSON("review_comparison.json", out); err != nil {
+		r.log.Warn("failed to write review_comparison.json", "error", err)
+	}
+}
+
+func (r *Runner) reviewComparison(ctx context.Context, env *runEnv, prompt string) ReviewComparisonLog {
+	var out ReviewComparisonLog
+	remote, err := git.OriginURL(ctx, env.baseRepo)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	owner, name, ok := github.ParseRepo(remote)
+	if !ok {
+		out.Error = fmt.Sprintf("origin %s is not a GitHub repository", remote)
+		return out
+	}
+
+	client := &github.Client{Token: r.cfg.GitHubToken}
+	fetchCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	pulls, err := client.PullsForCommit(fetchCtx, owner, name, env.commitInfo.TargetSHA)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	if len(pulls) == 0 {
+		out.Error = "no pull request found for the target commit"
+		return out
+	}
+	out.PullRequest = pulls[0].Number
+	out.PullRequestURL = pulls[0].URL
+	comments, err := client.ReviewDiscussion(fetchCtx, owner, name, pulls[0].Number)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Comments = len(comments)
+	if len(comments) == 0 {
+		out.Error = "pull request has no review discussion"
+		return out
+	}
+
+	texts := make([]string, 0, len(comments))
+	for _, c := range comments {
+		text := c.Body
+		if c.Path != "" {
+			text = "(on " + c.Path + ") " + text
+		}
+		texts = append(texts, text)
+	}
+	llmCtx, cancelLLM := context.WithTimeout(ctx, 90*time.Second)
+	defer cancelLLM()
+	res, err := llm.CompareReview(llmCtx, env.providers.gap, prompt, texts, 8)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Summary = res.Summary
+	out.Concerns = res.Concerns
+	if len(res.Concerns) > 0 {
+		total := 0.0
+		for _, c := range res.Concerns {
+			switch c.Reflected {
+			case llm.ReflectedYes:
+				total++
+			case llm.ReflectedPartial:
+				total += 0.5
+			}
+		}
+		out.Coverage = total / float64(len(res.Concerns))
+	}
+	return out
+}
@@ -0,0 +1,358 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Review modes of the candidate review gate.
+const (
+	ReviewOff      = "off"
+	ReviewTerminal = "terminal"
+	ReviewFile     = "file"
+)
+
+const (
+	DecisionApprove = "approve"
+	DecisionEdit    = "edit"
+	DecisionReject  = "reject"
+)
+
+// ReviewCandidate is a draft selected for a coder run, waiting for review.
+type ReviewCandidate struct {
+	Index    int     `json:"index"`
+	Cluster  int     `json:"cluster,omitempty"`
+	Style    string  `json:"style"`
+	Title    string  `json:"title,omitempty"`
+	PreScore float64 `json:"preScore"`
+	Prompt   string  `json:"-"`
+}
+
+// ReviewDecision is the reviewer's call on a candidate. Prompt is the new
+// text of an edited candidate.
+type ReviewDecision struct {
+	Index    int    `json:"index"`
+	Decision string `json:"decision"`
+	Prompt   string `json:"prompt,omitempty"`
+}
+
+// CandidateReviewer approves, edits, or rejects the candidates of an
+// iteration before any coder runs them. Candidates it returns no decision
+// for are approved.
+type CandidateReviewer interface {
+	ReviewCandidates(ctx context.Context, iter int, candidates []ReviewCandidate) ([]ReviewDecision, error)
+}
+
+// reviewer returns Config.Reviewer or the built-in reviewer of the review
+// mode, nil when the gate is off.
+func (r *Runner) reviewer() CandidateReviewer {
+	if r.cfg.Reviewer != nil {
+		return r.cfg.Reviewer
+	}
+	switch r.cfg.Review {
+	case ReviewTerminal:
+		if r.terminalReviewer == nil {
+			r.terminalReviewer = &TerminalReviewer{In: os.Stdin, Out: os.Stderr}
+		}
+		return r.terminalReviewer
+	case ReviewFile:
+		return &FileReviewer{Dir: filepath.Join(r.cfg.Workdir, "review"), Poll: controlPollInterval}
+	}
+	return nil
+}
+
+// reviewDrafts passes the selected drafts through the review gate and
+// returns the ones to run, edited where the reviewer changed them. The
+// decisions are recorded on the drafts' logs.
+func (r *Runner) reviewDrafts(ctx context.Context, env *runEnv, reviewer CandidateReviewer, iter int, drafts []candidateDraftRuntime, draftLogs []CandidateDraftLog) ([]candidateDraftRuntime, error) {
+	candidates := make([]ReviewCandidate, 0, len(drafts))
+	for _, d := range drafts {
+		candidates = append(candidates, ReviewCandidate{
+			Index:    d.log.Index,
+			Cluster:  d.cluster,
+			Style:    d.log.Style,
+			Title:    d.log.Title,
+			PreScore: d.log.PreScore,
+			Prompt:   d.candidate.CandidatePrompt,
+		})
+	}
+	env.events.emit(Event{Type: EventReview, Iteration: iter, Count: len(candidates), Message: fmt.Sprintf("waiting for review of %d candidates", len(candidates))})
+	decisions, err := reviewer.ReviewCandidates(ctx, iter, candidates)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, categorize(ErrorConfig, fmt.Errorf("review candidates of iteration %d: %w", iter, err))
+	}
+	byIndex := map[int]ReviewDecision{}
+	for _, dec := range decisions {
+		byIndex[dec.Index] = dec
+	}
+
+	kept := make([]candidateDraftRuntime, 0, len(drafts))
+	counts := map[string]int{}
+	for _, d := range drafts {
+		dec, ok := byIndex[d.log.Index]
+		if !ok {
+			dec = ReviewDecision{Decision: DecisionApprove}
+		}
+		prompt := strings.TrimSpace(dec.Prompt)
+		switch {
+		case dec.Decision == DecisionReject:
+		case dec.Decision == DecisionEdit && prompt != "" && prompt != strings.TrimSpace(d.candidate.CandidatePrompt):
+			if err := validateReviewedPrompt(prompt, r.cfg.MaxLength); err != nil {
+				r.log.Warn("edited candidate fails validation, running it anyway", "iteration", iter, "candidate", d.log.Index, "error", err)
+			}
+			d.log.ReviewedFrom = d.candidate.CandidatePrompt
+			d.candidate.CandidatePrompt = prompt
+			d.log.CandidatePrompt = prompt
+		default:
+			dec.Decision = DecisionApprove
+		}
+		d.log.Review = dec.Decision
+		counts[dec.Decision]++
+		for i := range draftLogs {
+			if draftLogs[i].Index == d.log.Index && draftLogs[i].Cluster == d.log.Cluster {
+				draftLogs[i].Review = d.log.Review
+				draftLogs[i].ReviewedFrom = d.log.ReviewedFrom
+				draftLogs[i].CandidatePrompt = d.log.CandidatePrompt
+			}
+		}
+		if dec.Decision != DecisionReject {
+			kept = append(kept, d)
+		}
+	}
+	env.events.emit(Event{Type: EventReview, Iteration: iter, Count: len(kept), Message: fmt.Sprintf("%d approved, %d edited, %d rejected", counts[DecisionApprove], counts[DecisionEdit], counts[DecisionReject])})
+	return kept, nil
+}
+
+func validateReviewedPrompt(prompt string, maxLength int) error {
+	if err := ValidateNoCodePrompt(prompt, maxLength); err != nil {
+		return err
+	}
+	return ValidateStructuredPrompt(prompt)
+}
+
+// TerminalReviewer asks about each candidate on a terminal. Edits open
+// $VISUAL or $EDITOR on the prompt, or read the new prompt from In up to a
+// line with a single dot when neither is set.
+type TerminalReviewer struct {
+	In  io.Reader
+	Out io.Writer
+
+	lines *bufio.Reader
+}
+
+func (t *TerminalReviewer) ReviewCandidates(ctx context.Context, iter int, candidates []ReviewCandidate) ([]ReviewDecision, error) {
+	if t.lines == nil {
+		t.lines = bufio.NewReader(t.In)
+	}
+	out := make([]ReviewDecision, 0, len(candidates))
+	approveRest := false
+	for i, c := range candidates {
+		if approveRest {
+			out = append(out, ReviewDecision{Index: c.Index, Decision: DecisionApprove})
+			continue
+		}
+		fmt.Fprintf(t.Out, "\n=== iteration %d, candidate %d of %d (index %d, %s, pre-score %.4f)", iter, i+1, len(candidates), c.Index, c.Style, c.PreScore)
+		if c.Title != "" {
+			fmt.Fprintf(t.Out, ": %s", c.Title)
+		}
+		fmt.Fprintf(t.Out, "\n%s\n", strings.TrimSpace(c.Prompt))
+		for {
+			fmt.Fprint(t.Out, "[a]pprove, [e]dit, [r]eject, approve [A]ll remaining? ")
+			answer, err := t.readLine(ctx)
+			if err != nil {
+				return nil, err
+			}
+			switch answer {
+			case "a", "":
+				out = append(out, ReviewDecision{Index: c.Index, Decision: DecisionApprove})
+			case "A":
+				approveRest = true
+				out = append(out, ReviewDecision{Index: c.Index, Decision: DecisionApprove})
+			case "r":
+				out = append(out, ReviewDecision{Index: c.Index, Decision: DecisionReject})
+			case "e":
+				prompt, err := t.edit(c.Prompt)
+				if err != nil {
+					fmt.Fprintf(t.Out, "edit failed: %v\n", err)
+					continue
+				}
+				out = append(out, ReviewDecision{Index: c.Index, Decision: DecisionEdit, Prompt: prompt})
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return out, nil
+}
+
+// readLine returns the next answer, or the context's error when the run is
+// interrupted while waiting for it.
+func (t *TerminalReviewer) readLine(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := t.lines.ReadString('\n')
+		ch <- result{line, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.err != nil && res.line == "" {
+			if errors.Is(res.err, io.EOF) {
+				return "", fmt.Errorf("review input closed")
+			}
+			return "", res.err
+		}
+		return strings.TrimSpace(res.line), nil
+	}
+}
+
+func (t *TerminalReviewer) edit(prompt string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		fmt.Fprintln(t.Out, "enter the new prompt, ending with a line with a single dot:")
+		var b strings.Builder
+		for {
+			line, err := t.lines.ReadString('\n')
+			if strings.TrimSpace(line) == "." {
+				break
+			}
+			b.WriteString(line)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return "", err
+			}
+		}
+		return b.String(), nil
+	}
+
+	f, err := os.CreateTemp("", "retrospec-review-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strings.TrimSpace(prompt) + "\n"); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	// The editor command may carry arguments, like "code --wait".
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("run editor: %w", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FileReviewer writes each candidate of an iteration to Dir/iter-NNN as
+// cand-MM.md, next to a review.json listing them with a decision each, and
+// waits until review.json is marked done. Editing a prompt file edits the
+// candidate, unless it is rejected.
+type FileReviewer struct {
+	Dir  string
+	Poll time.Duration
+}
+
+// fileReview is the content of review.json.
+type fileReview struct {
+	Iteration  int                   `json:"iteration"`
+	Done       bool                  `json:"done"`
+	Help       string                `json:"help,omitempty"`
+	Candidates []fileReviewCandidate `json:"candidates"`
+}
+
+type fileReviewCandidate struct {
+	ReviewCandidate
+	File     string `json:"file"`
+	Decision string `json:"decision"`
+}
+
+func (f *FileReviewer) ReviewCandidates(ctx context.Context, iter int, candidates []ReviewCandidate) ([]ReviewDecision, error) {
+	dir := filepath.Join(f.Dir, fmt.Sprintf("iter-%03d", iter))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	review := fileReview{
+		Iteration: iter,
+		Help:      "set decision to approve or reject, edit the prompt files to change candidates, then set done to true",
+	}
+	for i, c := range candidates {
+		name := fmt.Sprintf("cand-%02d.md", i+1)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.TrimSpace(c.Prompt)+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+		review.Candidates = append(review.Candidates, fileReviewCandidate{ReviewCandidate: c, File: name, Decision: DecisionApprove})
+	}
+	data, err := json.MarshalIndent(review, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "review.json")
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+
+	poll := f.Poll
+	if poll <= 0 {
+		poll = controlPollInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var got fileReview
+		// A half-saved file is read again on the next poll.
+		if json.Unmarshal(data, &got) != nil || !got.Done {
+			continue
+		}
+		out := make([]ReviewDecision, 0, len(got.Candidates))
+		for _, c := range got.Candidates {
+			dec := ReviewDecision{Index: c.Index, Decision: strings.ToLower(strings.TrimSpace(c.Decision))}
+			if dec.Decision != DecisionReject {
+				prompt, err := os.ReadFile(filepath.Join(dir, filepath.Base(c.File)))
+				if err != nil {
+					return nil, err
+				}
+				dec.Decision, dec.Prompt = DecisionEdit, string(prompt)
+			}
+			out = append(out, dec)
+		}
+		return out, nil
+	}
+}
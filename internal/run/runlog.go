@@ -0,0 +1,216 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RunLogSchemaVersion is the version of the run_log.json layout. Bump it
+// whenever a field is renamed, removed, or changes meaning, and add a
+// migration from the previous version to runLogMigrations. New optional
+// fields do not need a bump. Logs from before versioning have version 0.
+const RunLogSchemaVersion = 1
+
+// runLogMigrations upgrade a decoded run log one version at a time: entry i
+// turns version i into version i+1.
+var runLogMigrations = []func(map[string]any) error{
+	// 0 to 1 only stamps the version; the layout was unchanged.
+	func(map[string]any) error { return nil },
+}
+
+// DecodeRunLog parses a run_log.json, migrating older layouts to the current
+// one, and validates it. Logs written by a newer version are rejected rather
+// than misread.
+func DecodeRunLog(data []byte) (RunLog, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return RunLog{}, err
+	}
+	version := 0
+	if v, ok := raw["schemaVersion"].(float64); ok {
+		version = int(v)
+	}
+	if version > RunLogSchemaVersion {
+		return RunLog{}, fmt.Errorf("run log schema version %d is newer than the supported %d", version, RunLogSchemaVersion)
+	}
+	if version < RunLogSchemaVersion {
+		for v := version; v < RunLogSchemaVersion; v++ {
+			if err := runLogMigrations[v](raw); err != nil {
+				return RunLog{}, fmt.Errorf("migrate run log from schema version %d: %w", v, err)
+			}
+		}
+		raw["schemaVersion"] = RunLogSchemaVersion
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return RunLog{}, err
+		}
+	}
+	var runLog RunLog
+	if err := json.Unmarshal(data, &runLog); err != nil {
+		return RunLog{}, err
+	}
+	if err := runLog.Validate(); err != nil {
+		return RunLog{}, err
+	}
+	return runLog, nil
+}
+
+// Validate checks the invariants tools reading run_log.json rely on beyond
+// its schema: the version, iteration numbering, the best iteration, and
+// score ranges.
+func (l RunLog) Validate() error {
+	var errs []error
+	if l.SchemaVersion != RunLogSchemaVersion {
+		errs = append(errs, fmt.Errorf("schemaVersion is %d, want %d", l.SchemaVersion, RunLogSchemaVersion))
+	}
+	if strings.TrimSpace(l.Repo) == "" {
+		errs = append(errs, errors.New("repo is empty"))
+	}
+	seen := map[int]bool{}
+	prev := 0
+	for i, iter := range l.Iterations {
+		if iter.Iteration <= prev {
+			errs = append(errs, fmt.Errorf("iterations[%d]: iteration %d does not follow %d", i, iter.Iteration, prev))
+		}
+		prev = iter.Iteration
+		seen[iter.Iteration] = true
+		for j, a := range iter.CoderAttempts {
+			for name, v := range map[string]float64{"tech.score": a.Tech.Score, "realism.score": a.Realism.Score, "finalScore": a.FinalScore} {
+				if math.IsNaN(v) || v < 0 || v > 1 {
+					errs = append(errs, fmt.Errorf("iterations[%d].coderAttempts[%d].%s is %v, want a value in [0, 1]", i, j, name, v))
+				}
+			}
+		}
+	}
+	if l.BestIteration != 0 && !seen[l.BestIteration] {
+		errs = append(errs, fmt.Errorf("bestIteration %d is not a logged iteration", l.BestIteration))
+	}
+	return errors.Join(errs...)
+}
+
+// RunLogSchema returns a JSON Schema of run_log.json, generated from the Go
+// types so it cannot drift from what is written. Fields without omitempty
+// are required.
+func RunLogSchema() ([]byte, error) {
+	g := schemaGen{defs: map[string]any{}}
+	root := g.schema(reflect.TypeOf(RunLog{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "retrospec run_log.json"
+	root["$comment"] = fmt.Sprintf("schemaVersion %d", RunLogSchemaVersion)
+	root["$defs"] = g.defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+type schemaGen struct {
+	defs map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g schemaGen) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		return g.object(t)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	}
+	return map[string]any{}
+}
+
+// object returns a reference to the definition of a named struct, adding it
+// on first use, or the inline schema of an anonymous one.
+func (g schemaGen) object(t reflect.Type) map[string]any {
+	name := t.Name()
+	if name == "" {
+		return g.properties(t)
+	}
+	if pkg := t.PkgPath(); pkg != "" {
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	if _, ok := g.defs[name]; !ok {
+		g.defs[name] = nil
+		g.defs[name] = g.properties(t)
+	}
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func (g schemaGen) properties(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				add(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	add(t)
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+func readRunLog(path string) (RunLog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RunLog{}, fmt.Errorf("read run log: %w", err)
+	}
+	runLog, err := DecodeRunLog(data)
+	if err != nil {
+		return RunLog{}, fmt.Errorf("parse run log %s: %w", path, err)
+	}
+	return runLog, nil
+}
+
+// writeRunLog validates the run log before writing it, so a broken log is
+// reported where it is made rather than by the tools reading it.
+func writeRunLog(w artifactWriter, runLog RunLog) error {
+	if err := runLog.Validate(); err != nil {
+		return categorize(ErrorInternal, fmt.Errorf("invalid run log: %w", err))
+	}
+	if err := w.writeJSON("run_log.json", runLog); err != nil {
+		return categorize(ErrorArtifact, fmt.Errorf("write run_log.json: %w", err))
+	}
+	return nil
+}
@@ -3,18 +3,26 @@ package run
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	sdk "github.com/github/copilot-sdk/go"
 	"github.com/igolaizola/retrospec/internal/copilot"
+	"github.com/igolaizola/retrospec/internal/difficulty"
 	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/generated"
 	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/redact"
+	"github.com/igolaizola/retrospec/internal/sandbox"
 	"github.com/igolaizola/retrospec/internal/scoring"
 )
 
@@ -22,34 +30,83 @@ var trackerRefCleanupRe = regexp.MustCompile(`(?i)(?:^|\s)(?:#\d+|(?:issue|issue
 
 type Runner struct {
 	cfg Config
+	log *slog.Logger
+	// realismProfile is the loaded RealismProfile, if any.
+	realismProfile *scoring.RealismProfile
+	// reuseBase keeps an existing base clone in the workdir instead of
+	// cloning again.
+	reuseBase bool
+	live      liveControl
+	// terminalReviewer keeps its buffered input across iterations.
+	terminalReviewer *TerminalReviewer
 }
 
 type CandidateDraftLog struct {
-	Index             int      `json:"index"`
-	Style             string   `json:"style"`
-	CandidatePrompt   string   `json:"candidatePrompt,omitempty"`
-	Rationale         string   `json:"rationale,omitempty"`
-	ScopeHints        []string `json:"scopeHints,omitempty"`
-	ValidationRetries int      `json:"validationRetries,omitempty"`
-	RawSpecResponse   string   `json:"rawSpecResponse,omitempty"`
-	PreRealism        float64  `json:"preRealism,omitempty"`
-	Novelty           float64  `json:"novelty,omitempty"`
-	PreScore          float64  `json:"preScore,omitempty"`
-	GenerationError   string   `json:"generationError,omitempty"`
+	Index             int            `json:"index"`
+	Lineage           int            `json:"lineage,omitempty"`
+	Cluster           int            `json:"cluster,omitempty"`
+	Style             string         `json:"style"`
+	Title             string         `json:"title,omitempty"`
+	CandidatePrompt   string         `json:"candidatePrompt,omitempty"`
+	Rationale         string         `json:"rationale,omitempty"`
+	ScopeHints        []string       `json:"scopeHints,omitempty"`
+	ValidationRetries int            `json:"validationRetries,omitempty"`
+	RawSpecResponse   string         `json:"rawSpecResponse,omitempty"`
+	PreRealism        float64        `json:"preRealism,omitempty"`
+	Novelty           float64        `json:"novelty,omitempty"`
+	NoveltyMethod     string         `json:"noveltyMethod,omitempty"`
+	TechFit           float64        `json:"techFit,omitempty"`
+	ScopeGrounding    float64        `json:"scopeGrounding,omitempty"`
+	UngroundedScopes  []string       `json:"ungroundedScopes,omitempty"`
+	ShortenedFrom     int            `json:"shortenedFrom,omitempty"`
+	Refinement        *RefinementLog `json:"refinement,omitempty"`
+	PreScore          float64        `json:"preScore,omitempty"`
+	GenerationError   string         `json:"generationError,omitempty"`
+	// Cached is set when the SpecWriter reply came from the candidate cache.
+	Cached bool        `json:"cached,omitempty"`
+	Lint   *LintReport `json:"lint,omitempty"`
+	// Review is the review gate's decision on a draft selected for a coder
+	// run, and ReviewedFrom the drafted prompt of an edited one.
+	Review       string `json:"review,omitempty"`
+	ReviewedFrom string `json:"reviewedFrom,omitempty"`
+	// StylePrior is the part of PreScore that comes from how the style did
+	// in earlier runs, see Config.StylePriors.
+	StylePrior float64 `json:"stylePrior,omitempty"`
 }
 
 type CoderAttemptLog struct {
-	CandidateIndex    int                   `json:"candidateIndex"`
-	CandidateStyle    string                `json:"candidateStyle"`
-	CandidatePrompt   string                `json:"candidatePrompt"`
-	CoderError        string                `json:"coderError,omitempty"`
-	CoderFinalMessage string                `json:"coderFinalMessage,omitempty"`
+	CandidateIndex  int    `json:"candidateIndex"`
+	CandidateStyle  string `json:"candidateStyle"`
+	CandidateTitle  string `json:"candidateTitle,omitempty"`
+	CandidatePrompt string `json:"candidatePrompt"`
+	PromptHash      string `json:"promptHash,omitempty"`
+	Lineage         int    `json:"lineage,omitempty"`
+	// Cluster is the target cluster the attempt was scored against.
+	Cluster     int  `json:"cluster,omitempty"`
+	DuplicateOf *int `json:"duplicateOf,omitempty"`
+	// DuplicateOfIteration is set when the attempt reuses the result of an
+	// earlier iteration, and DuplicateSimilarity when the reused prompt was
+	// a near duplicate rather than the same.
+	DuplicateOfIteration int     `json:"duplicateOfIteration,omitempty"`
+	DuplicateSimilarity  float64 `json:"duplicateSimilarity,omitempty"`
+	CoderError           string  `json:"coderError,omitempty"`
+	CoderFinalMessage    string  `json:"coderFinalMessage,omitempty"`
+	Judged               bool    `json:"judged"`
+	JudgeError           string  `json:"judgeError,omitempty"`
+	// JudgeSkipped is why the judge policy left the attempt unjudged:
+	// judgeSkippedIteration or judgeSkippedTopN.
+	JudgeSkipped      string                `json:"judgeSkipped,omitempty"`
+	ScoreBasis        string                `json:"scoreBasis,omitempty"`
 	Tech              scoring.TechScore     `json:"tech"`
 	Realism           scoring.RealismResult `json:"realism"`
 	FinalScore        float64               `json:"finalScore"`
 	TestResult        TestRunResult         `json:"testResult"`
 	ProducedPatchPath string                `json:"producedPatchPath,omitempty"`
-	ProducedFiles     []string              `json:"producedFiles,omitempty"`
+	ExplanationPath   string                `json:"explanationPath,omitempty"`
+	// Samples are the coder runs behind the attempt when there are several;
+	// the attempt's patch and scores come from the median one.
+	Samples       []SampleLog `json:"samples,omitempty"`
+	ProducedFiles []string    `json:"producedFiles,omitempty"`
 }
 
 type IterationLog struct {
@@ -59,33 +116,88 @@ type IterationLog struct {
 	SelectedAttempt    int                 `json:"selectedAttempt"`
 	FeedbackPacket     feedback.Packet     `json:"feedbackPacket"`
 	IterationBestScore float64             `json:"iterationBestScore"`
+	// SmoothedScore is the score the improvement check used when smoothing
+	// is enabled.
+	SmoothedScore *float64   `json:"smoothedScore,omitempty"`
+	Freeze        *FreezeLog `json:"freeze,omitempty"`
+	Judge         JudgeLog   `json:"judge"`
+	Critic        *CriticLog `json:"critic,omitempty"`
+	Beam          []BeamLog  `json:"beam,omitempty"`
+	// GapBatch is set when the attempts' intent gaps were summarized in one
+	// call.
+	GapBatch *GapBatchLog `json:"gapBatch,omitempty"`
+	// Alpha is the alpha the iteration was scored with, set when an alpha
+	// schedule is on.
+	Alpha *float64 `json:"alpha,omitempty"`
+}
+
+type CriticLog struct {
+	Summary    string   `json:"summary,omitempty"`
+	Directives []string `json:"directives,omitempty"`
+	Error      string   `json:"error,omitempty"`
 }
 
 type RunLog struct {
-	Repo          string         `json:"repo"`
-	TargetCommit  string         `json:"targetCommit"`
-	ParentCommit  string         `json:"parentCommit"`
-	Alpha         float64        `json:"alpha"`
-	Threshold     float64        `json:"threshold"`
-	MaxIters      int            `json:"maxIters"`
-	BestIteration int            `json:"bestIteration"`
-	Iterations    []IterationLog `json:"iterations"`
-	StoppedReason string         `json:"stoppedReason"`
-	CommitMessage string         `json:"commitMessage"`
-	StartedAt     time.Time      `json:"startedAt"`
-	CompletedAt   time.Time      `json:"completedAt"`
+	SchemaVersion   int                    `json:"schemaVersion"`
+	Repo            string                 `json:"repo"`
+	TargetCommit    string                 `json:"targetCommit"`
+	ParentCommit    string                 `json:"parentCommit"`
+	CommitRange     string                 `json:"commitRange,omitempty"`
+	RangeCommits    []string               `json:"rangeCommits,omitempty"`
+	MergeParents    []string               `json:"mergeParents,omitempty"`
+	MainlineParent  int                    `json:"mainlineParent,omitempty"`
+	Alpha           float64                `json:"alpha"`
+	AlphaSchedule   string                 `json:"alphaSchedule,omitempty"`
+	Threshold       float64                `json:"threshold"`
+	MaxIters        int                    `json:"maxIters"`
+	BestIteration   int                    `json:"bestIteration"`
+	Iterations      []IterationLog         `json:"iterations"`
+	StoppedReason   string                 `json:"stoppedReason"`
+	CommitMessage   string                 `json:"commitMessage"`
+	Languages       []string               `json:"languages,omitempty"`
+	Fingerprint     string                 `json:"fingerprint,omitempty"`
+	Difficulty      difficulty.Estimate    `json:"difficulty"`
+	InternalError   string                 `json:"internalError,omitempty"`
+	FailureCategory ErrorCategory          `json:"failureCategory,omitempty"`
+	Failure         string                 `json:"failure,omitempty"`
+	ProviderUsage   map[llm.Role]llm.Usage `json:"providerUsage,omitempty"`
+	TargetRedaction *redact.Report         `json:"targetRedaction,omitempty"`
+	GeneratedFiles  []string               `json:"generatedFiles,omitempty"`
+	Controls        []ControlLog           `json:"controls,omitempty"`
+	GitHubContext   *GitHubContextLog      `json:"githubContext,omitempty"`
+	Contamination   *ContaminationLog      `json:"contamination,omitempty"`
+	Anonymization   *AnonymizationLog      `json:"anonymization,omitempty"`
+	TestHint        string                 `json:"testHint,omitempty"`
+	Clusters        []ClusterLog           `json:"clusters,omitempty"`
+	Styles          []StyleStats           `json:"styles,omitempty"`
+	StylePriors     []StylePrior           `json:"stylePriors,omitempty"`
+	RealismProfile  string                 `json:"realismProfile,omitempty"`
+	ScoringVersion  int                    `json:"scoringVersion"`
+	StartedAt       time.Time              `json:"startedAt"`
+	CompletedAt     time.Time              `json:"completedAt"`
 }
 
 type Metrics struct {
+	Title          string  `json:"title,omitempty"`
 	TechSimilarity float64 `json:"techSimilarity"`
 	RealismScore   float64 `json:"realismScore"`
 	FinalScore     float64 `json:"finalScore"`
 	Alpha          float64 `json:"alpha"`
 	BestIteration  int     `json:"bestIteration"`
+	ScoringVersion int     `json:"scoringVersion"`
+	// Partial is set when the run was interrupted before its last
+	// iteration.
+	Partial bool `json:"partial,omitempty"`
+	// Confidence is set when the best attempt had several coder samples.
+	Confidence *MetricsConfidence `json:"confidence,omitempty"`
+	// Contamination is the verdict of the contamination check, if run.
+	Contamination string `json:"contamination,omitempty"`
 }
 
 type bestState struct {
+	samples   []SampleLog
 	iteration int
+	title     string
 	prompt    string
 	patch     string
 	tech      float64
@@ -95,315 +207,914 @@ type bestState struct {
 
 type candidateDraftRuntime struct {
 	log       CandidateDraftLog
-	candidate copilot.SpecCandidate
+	candidate llm.SpecCandidate
 	valid     bool
+	// lineage is the beam slot whose SpecWriter produced the draft.
+	lineage int
+	// cluster is the 1-based target cluster the draft describes, 0 for the
+	// whole target.
+	cluster int
 }
 
 type coderAttemptRuntime struct {
 	log      CoderAttemptLog
 	produced git.DiffSnapshot
+	lineage  int
+	cluster  int
 }
 
 func NewRunner(cfg Config) *Runner {
-	return &Runner{cfg: cfg}
+	r := &Runner{cfg: cfg, log: cfg.logger()}
+	if cfg.RealismProfile != "" {
+		p, err := scoring.LoadRealismProfile(cfg.RealismProfile)
+		if err != nil {
+			r.log.Warn("failed to load realism profile, using the built-in rubric", "path", cfg.RealismProfile, "error", err)
+		}
+		r.realismProfile = p
+	}
+	return r
+}
+
+type runEnv struct {
+	paths      layoutPaths
+	artifacts  artifactWriter
+	baseRepo   string
+	commitInfo git.CommitInfo
+	target     git.DiffSnapshot
+	manager    *copilot.Manager
+	providers  roleProviders
+	usage      *llm.TokenCounter
+	judge      *judgeGuard
+	novelty    NoveltyMetric
+	// sealedRepo holds the history up to the parent only; coder worktrees
+	// are created from it so they cannot reach the target.
+	sealedRepo string
+	// workBase is the commit coder worktrees start at: the parent, or the
+	// single commit of the anonymized repository.
+	workBase   string
+	anonymizer *anonymizer
+	isolation  *isolationAudit
+	// contamination is set when the contamination check ran.
+	contamination *ContaminationLog
+	// targetTests are the target's test files, loaded for TargetTests.
+	targetTests []targetTestFile
+	// testHint is appended to coder prompts under TestHint.
+	testHint    string
+	seedPrompts []seedPrompt
+	// worktreeMu serializes worktree add/remove, which both prune the shared
+	// worktree registry of the sealed repository.
+	worktreeMu      sync.Mutex
+	objectiveAnchor string
+	exemplarPool    []string
+	scopes          scopeIndex
+	// redactedTarget is the target patch as shown to models.
+	redactedTarget string
+	detector       generated.Detector
+	generatedFiles []string
+	// attemptPrefix distinguishes worktrees and patches of replayed attempts
+	// from those of the original run.
+	attemptPrefix string
+	// judgeAll ignores the judge policy, for replayed attempts.
+	judgeAll bool
+	// scoringTarget is the target without the line content of generated files.
+	scoringTarget git.DiffSnapshot
+	// goTarget holds the target's Go AST changes when AST scoring is enabled
+	// and the target touches Go files.
+	goTarget *scoring.GoFeatures
+	events   *eventLog
+	// sandbox runs coder commands and tests.
+	sandbox sandbox.Sandbox
+	// clusters are the sub-changes of a large target, if clustered.
+	clusters []targetCluster
+	// workspaceTemplate is the checkout copied for each coder run in copy
+	// mode.
+	workspaceTemplate string
+	judgeCache        *judgeCache
+	// checkoutExtras are the submodules and git-lfs files coder checkouts
+	// are filled in with.
+	checkoutExtras git.CheckoutExtras
+}
+
+type loopState struct {
+	runLog        RunLog
+	best          bestState
+	stoppedReason string
+	noImprovement int
+	improvedLast  bool
+	promptHistory []string
+	beam          []*lineage
+	clusters      []clusterState
+	freeze        *freezeState
+	control       controlState
+	smoothing     *improvementTracker
+	alpha         *alphaSchedule
+	// pastAttempts are the coder results later iterations may reuse.
+	pastAttempts []pastAttempt
+	// priorTokens are the tokens used by earlier invocations in step mode.
+	priorTokens int
 }
 
 func (r *Runner) Execute(ctx context.Context) (Result, error) {
 	start := time.Now()
-	paths, err := r.ensureLayout()
+	var resumed *stepState
+	if r.cfg.Step {
+		var err error
+		if resumed, err = loadStepState(r.cfg.Workdir); err != nil {
+			return Result{}, categorize(ErrorArtifact, err)
+		}
+		if resumed != nil && resumed.Done {
+			return r.endedStep(resumed), nil
+		}
+		r.reuseBase = resumed != nil
+	}
+	env, cleanup, err := r.prepare(ctx)
 	if err != nil {
 		return Result{}, err
 	}
+	defer cleanup()
+	r.writeRunConfig(ctx, env)
+	env.events = newEventLog(env.paths.artifactsDir, resumed != nil, r.log, r.cfg.EventSinks)
+	defer env.events.close()
+	target := r.cfg.Commit
+	if r.cfg.CommitRange != "" {
+		target = r.cfg.CommitRange
+	}
+	env.events.emit(Event{Type: EventRunStart, Message: r.cfg.Repo + " " + target})
+
+	initialPacket := feedback.BuildInitialPacket(0, env.target, env.commitInfo.CommitMessage, r.cfg.MaxPathRefs)
+	estimate := difficulty.Assess(env.target)
+	r.log.Info("difficulty", "bucket", estimate.Bucket, "score", estimate.Score, "files", estimate.FilesTouched, "lines", estimate.LinesChanged)
+	languages := feedback.DetectLanguages(env.target)
+	redactedTarget, redaction := redact.Patch(env.target.Patch, env.detector)
+	env.redactedTarget = redactedTarget
+	if files, err := git.ListFiles(ctx, env.baseRepo, env.commitInfo.ParentSHA); err != nil {
+		r.log.Warn("scope-hint grounding disabled", "error", err)
+	} else {
+		env.scopes = newScopeIndex(files)
+	}
 
-	baseRepo, err := git.PrepareBaseRepo(ctx, r.cfg.Repo, r.cfg.Workdir)
+	env.exemplarPool, err = r.loadExemplarPool(languages, len(candidateStyles(r.cfg.CandidatesPerIter)))
 	if err != nil {
-		return Result{}, err
+		return Result{}, categorize(ErrorConfig, err)
 	}
 
-	commitInfo, err := git.ResolveCommitInfo(ctx, baseRepo, r.cfg.Commit)
+	// A resumed step already did these in the run's first invocation.
+	var githubContext *GitHubContextLog
+	if r.cfg.GitHubContext && resumed == nil {
+		gh := r.githubBackground(ctx, env)
+		if gh.Error != "" {
+			r.log.Warn("github context incomplete", "error", gh.Error)
+		}
+		initialPacket.Background = gh.Background
+		githubContext = &gh
+	}
+
+	if r.cfg.Intents && resumed == nil {
+		r.writeIntents(ctx, env)
+	}
+
+	if r.cfg.ContaminationCheck && resumed == nil {
+		env.contamination = r.checkContamination(ctx, env)
+	}
+
+	if !redaction.Empty() {
+		r.log.Info("target patch redacted", "secrets", redaction.Secrets, "blobs", redaction.Blobs, "summarizedFiles", len(redaction.SummarizedFiles))
+	}
+
+	clusterLogs, clusterStates := r.initClusters(env)
+	state := &loopState{
+		clusters: clusterStates,
+		runLog: RunLog{
+			SchemaVersion:  RunLogSchemaVersion,
+			Repo:           r.cfg.Repo,
+			TargetCommit:   env.commitInfo.TargetSHA,
+			ParentCommit:   env.commitInfo.ParentSHA,
+			CommitRange:    r.cfg.CommitRange,
+			RangeCommits:   env.commitInfo.Commits,
+			MergeParents:   env.commitInfo.Parents,
+			MainlineParent: env.commitInfo.Mainline,
+			Alpha:          r.cfg.Alpha,
+			Threshold:      r.cfg.Threshold,
+			MaxIters:       r.cfg.MaxIters,
+			CommitMessage:  env.commitInfo.CommitMessage,
+			Languages:      languages,
+			Fingerprint:    git.PatchFingerprint(env.target.Patch),
+			Difficulty:     estimate,
+			GeneratedFiles: env.generatedFiles,
+			GitHubContext:  githubContext,
+			Contamination:  env.contamination,
+			Anonymization:  env.anonymizer.runLog(),
+			TestHint:       env.testHint,
+			StylePriors:    r.cfg.StylePriors,
+			Clusters:       clusterLogs,
+			ScoringVersion: scoring.Version,
+			RealismProfile: r.cfg.RealismProfile,
+			StartedAt:      start,
+		},
+		best:          bestState{final: -1},
+		stoppedReason: "max-iters reached",
+		promptHistory: []string{},
+		beam:          newBeam(env, r.cfg.BeamWidth, feedback.PacketText(initialPacket)),
+		freeze:        newFreezeState(r.cfg.FreezePolicy, r.cfg.FreezeSections),
+		smoothing:     newImprovementTracker(r.cfg),
+		alpha:         newAlphaSchedule(r.cfg),
+	}
+	if !redaction.Empty() {
+		state.runLog.TargetRedaction = &redaction
+	}
+	if state.alpha != nil {
+		state.runLog.AlphaSchedule = r.cfg.AlphaSchedule
+	}
+	first := 1
+	if resumed != nil {
+		if err := resumed.restore(r, env, state); err != nil {
+			return Result{}, err
+		}
+		first = resumed.NextIteration
+	}
+
+	// The iteration budget is enforced by BudgetStop.
+	for iter := first; ; iter++ {
+		stop, err := r.guardIteration(iter, func() (bool, error) {
+			return r.runIteration(ctx, env, state, iter)
+		})
+		var panicErr *iterationPanicError
+		if errors.As(err, &panicErr) {
+			state.stoppedReason = "internal error"
+			state.runLog.InternalError = panicErr.Error()
+			state.runLog.FailureCategory = ErrorInternal
+			r.log.Error("iteration panicked", "iteration", iter, "error", panicErr)
+			break
+		}
+		if err != nil {
+			// Canceled calls surface as provider or git errors.
+			if errors.Is(ctx.Err(), context.Canceled) {
+				err = &Error{Category: ErrorCanceled, Err: err}
+			}
+			return Result{}, r.fail(env, state, err)
+		}
+		if stop {
+			break
+		}
+		if stop, reason := r.applyControl(ctx, env, state, iter); stop {
+			state.stoppedReason = reason
+			break
+		}
+		if r.cfg.Step {
+			return r.pauseStep(env, state, iter)
+		}
+	}
+
+	if r.cfg.Step {
+		if err := r.saveStep(env, state, 0, true); err != nil {
+			return Result{}, err
+		}
+	}
+	if r.cfg.Traceability && state.best.iteration > 0 {
+		r.writeTraceability(ctx, env, state.best.prompt, state.best.patch)
+	}
+	if r.cfg.ReviewComparison && state.best.iteration > 0 {
+		r.compareReview(ctx, env, state.best.prompt)
+	}
+	return r.finalize(env, state)
+}
+
+// fail records the categorized failure in run_log.json before returning it.
+// When the run was interrupted, the best prompt and patch so far, partial
+// metrics, and the report are written too.
+func (r *Runner) fail(env *runEnv, state *loopState, err error) error {
+	category := ErrorCategoryOf(err)
+	if category == ErrorUnknown {
+		err = categorize(ErrorInternal, err)
+		category = ErrorInternal
+	}
+	interrupted := category == ErrorCanceled
+	runLog := state.runLog
+	runLog.BestIteration = state.best.iteration
+	runLog.StoppedReason = "failed"
+	if interrupted {
+		runLog.StoppedReason = "interrupted"
+	}
+	runLog.FailureCategory = category
+	runLog.Failure = err.Error()
+	runLog.ProviderUsage = env.providerUsage()
+	runLog.Styles = styleBreakdown(runLog.Iterations)
+	r.writeIsolation(env)
+	env.events.emit(Event{Type: EventRunEnd, Iteration: state.best.iteration, BestFinal: state.best.final, Message: runLog.StoppedReason + ": " + err.Error()})
+	runLog.CompletedAt = time.Now()
+	if interrupted && state.best.iteration > 0 {
+		if writeErr := r.writeBest(env, state.best); writeErr != nil {
+			r.log.Warn("failed to write best so far", "error", writeErr)
+		}
+		if writeErr := env.artifacts.writeJSON("metrics.json", r.bestMetrics(env, state.best, true)); writeErr != nil {
+			r.log.Warn("failed to write metrics.json", "error", writeErr)
+		}
+	}
+	// The failure is recorded even if the log is invalid, since it is the
+	// only trace of the run.
+	if invalid := runLog.Validate(); invalid != nil {
+		r.log.Warn("run log is invalid", "error", invalid)
+	}
+	if writeErr := env.artifacts.writeJSON("run_log.json", runLog); writeErr != nil {
+		r.log.Warn("failed to write run_log.json", "error", writeErr)
+	}
+	if interrupted {
+		r.writeReport(env, runLog)
+	}
+	return err
+}
+
+// prepare clones the repository, resolves the target commit, and starts the
+// model providers, including the Copilot client when any role needs it. The
+// returned cleanup releases them.
+func (r *Runner) prepare(ctx context.Context) (*runEnv, func(), error) {
+	env := &runEnv{}
+	cleanups := []func(){}
+	cleanup := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	fail := func(err error) (*runEnv, func(), error) {
+		cleanup()
+		return nil, func() {}, err
+	}
+
+	paths, err := r.ensureLayout()
 	if err != nil {
-		return Result{}, err
+		return fail(categorize(ErrorArtifact, err))
+	}
+	env.paths = paths
+	env.artifacts = r.newArtifactWriter(paths.artifactsDir)
+
+	env.baseRepo = filepath.Join(r.cfg.Workdir, "base")
+	if _, statErr := os.Stat(env.baseRepo); !r.reuseBase || statErr != nil {
+		env.baseRepo, err = git.PrepareBaseRepo(ctx, r.cfg.Repo, r.cfg.Workdir, r.cfg.cloneOptions())
+		if err != nil {
+			return fail(categorize(ErrorGit, err))
+		}
 	}
 
-	target, err := git.SnapshotBetween(ctx, baseRepo, commitInfo.ParentSHA, commitInfo.TargetSHA)
+	if r.cfg.CommitRange != "" {
+		env.commitInfo, err = git.ResolveCommitRange(ctx, env.baseRepo, r.cfg.CommitRange)
+	} else {
+		env.commitInfo, err = git.ResolveCommitInfo(ctx, env.baseRepo, r.cfg.Commit, r.cfg.Parent)
+	}
+	if err != nil {
+		return fail(categorize(ErrorGit, err))
+	}
+
+	env.target, err = git.SnapshotBetween(ctx, env.baseRepo, env.commitInfo.ParentSHA, env.commitInfo.TargetSHA)
+	if err != nil {
+		return fail(categorize(ErrorGit, fmt.Errorf("collect target patch: %w", err)))
+	}
+	if err := r.sealRepo(ctx, env); err != nil {
+		return fail(err)
+	}
+	if err := r.prepareWorkspaces(ctx, env); err != nil {
+		return fail(err)
+	}
+	env.isolation = newIsolationAudit(env.commitInfo, env.target)
+	if err := env.artifacts.write("target.patch", []byte(env.target.Patch)); err != nil {
+		return fail(categorize(ErrorArtifact, fmt.Errorf("write target.patch: %w", err)))
+	}
+	env.detector = generated.Detector{Include: r.cfg.IncludeGenerated, Patterns: generated.ParsePatterns(r.cfg.GeneratedPatterns)}
+	env.scoringTarget, env.generatedFiles = env.detector.Strip(env.target)
+	env.clusters = r.splitTarget(env)
+	if r.cfg.TargetTests {
+		env.targetTests, err = loadTargetTests(ctx, env)
+		if err != nil {
+			return fail(categorize(ErrorGit, fmt.Errorf("read target tests: %w", err)))
+		}
+		if len(env.targetTests) == 0 {
+			r.log.Info("target changes no test files, target tests are not scored")
+		}
+	}
+	env.seedPrompts, err = loadSeedPrompts(r.cfg.SeedPrompts)
 	if err != nil {
-		return Result{}, fmt.Errorf("collect target patch: %w", err)
+		return fail(categorize(ErrorConfig, err))
+	}
+	if r.cfg.TestHint && targetAddsTests(env.target) {
+		env.testHint = testHint
 	}
-	if err := os.WriteFile(filepath.Join(paths.artifactsDir, "target.patch"), []byte(target.Patch), 0o644); err != nil {
-		return Result{}, fmt.Errorf("write target.patch: %w", err)
+	if r.cfg.GoASTScoring {
+		env.goTarget, err = targetGoFeatures(ctx, env)
+		if err != nil {
+			return fail(categorize(ErrorGit, fmt.Errorf("go ast features for target: %w", err)))
+		}
 	}
 
-	manager, err := copilot.NewManager(ctx, r.cfg.Workdir, copilot.Options{Model: r.cfg.Model, Verbose: r.cfg.Verbose})
+	env.sandbox, err = r.newSandbox(ctx)
 	if err != nil {
-		return Result{}, err
+		return fail(categorize(ErrorConfig, err))
 	}
-	defer manager.Close()
 
-	specSession, err := manager.CreateSpecWriterSession(ctx, r.cfg.Workdir)
+	templates, err := r.loadTemplates()
 	if err != nil {
-		return Result{}, err
+		return fail(categorize(ErrorConfig, err))
+	}
+
+	if r.cfg.usesProvider(ProviderCopilot) {
+		env.manager, err = copilot.NewManager(ctx, r.cfg.Workdir, copilot.Options{
+			Model:                r.cfg.Model,
+			CoderModel:           r.cfg.CoderModel,
+			CoderReasoningEffort: r.cfg.CoderReasoningEffort,
+			Sandbox:              env.sandbox,
+			Templates:            templates,
+			Logger:               r.log,
+		})
+		if err != nil {
+			return fail(categorize(ErrorProvider, err))
+		}
+		cleanups = append(cleanups, func() { _ = env.manager.Close() })
+	}
+
+	env.usage = llm.NewTokenCounter()
+	env.judge = newJudgeGuard(r.cfg.JudgeMaxFailures, r.log)
+	if env.judgeCache, err = r.newJudgeCache(templates); err != nil {
+		return fail(categorize(ErrorConfig, err))
 	}
+	env.novelty = r.noveltyMetric()
+	providers, closeProviders, err := r.newRoleProviders(ctx, env.manager, env.usage, env.isolation, templates)
+	if err != nil {
+		return fail(categorize(ErrorProvider, err))
+	}
+	env.providers = providers
+	cleanups = append(cleanups, closeProviders)
+
+	env.objectiveAnchor = buildObjectiveAnchor(env.commitInfo.CommitMessage, env.target)
+	return env, cleanup, nil
+}
+
+type iterationPanicError struct {
+	iteration int
+	value     any
+	stack     string
+}
+
+func (e *iterationPanicError) Error() string {
+	return fmt.Sprintf("panic in iteration %d: %v\n%s", e.iteration, e.value, e.stack)
+}
+
+// guardIteration runs a single iteration and converts panics into an
+// iterationPanicError so the run can still be finalized.
+func (r *Runner) guardIteration(iter int, fn func() (bool, error)) (stop bool, err error) {
 	defer func() {
-		if err := specSession.Destroy(); err != nil && r.cfg.Verbose {
-			fmt.Printf("warning: failed to destroy spec session: %v\n", err)
+		if rec := recover(); rec != nil {
+			err = &iterationPanicError{iteration: iter, value: rec, stack: string(debug.Stack())}
 		}
 	}()
+	return fn()
+}
 
-	initialPacket := feedback.BuildInitialPacket(0, target, commitInfo.CommitMessage, r.cfg.MaxPathRefs)
-	feedbackText := feedback.PacketText(initialPacket)
-	objectiveAnchor := buildObjectiveAnchor(commitInfo.CommitMessage, target)
+func (r *Runner) runIteration(ctx context.Context, env *runEnv, state *loopState, iter int) (bool, error) {
+	iterStart := time.Now()
+	r.scheduleAlpha(state, iter)
+	r.log.Info("generating candidate prompts", "iteration", iter, "candidates", r.cfg.CandidatesPerIter*len(state.beam))
+	env.events.emit(Event{Type: EventIterationStart, Iteration: iter})
 
-	runLog := RunLog{
-		Repo:          r.cfg.Repo,
-		TargetCommit:  commitInfo.TargetSHA,
-		ParentCommit:  commitInfo.ParentSHA,
-		Alpha:         r.cfg.Alpha,
-		Threshold:     r.cfg.Threshold,
-		MaxIters:      r.cfg.MaxIters,
-		CommitMessage: commitInfo.CommitMessage,
-		StartedAt:     start,
-	}
-
-	best := bestState{final: -1}
-	stoppedReason := "max-iters reached"
-	noImprovement := 0
-	previousPrompt := ""
-	previousOutcome := ""
-	promptHistory := []string{}
-
-	for iter := 1; iter <= r.cfg.MaxIters; iter++ {
-		if r.cfg.Verbose {
-			fmt.Printf("[iter %d] generating %d candidate prompts\n", iter, r.cfg.CandidatesPerIter)
-		}
-
-		specFeedback := objectiveAnchor + "\n\n" + feedbackText
-		drafts, draftErr := r.generateCandidatePool(
-			ctx,
-			manager,
-			specSession,
-			iter,
-			specFeedback,
-			previousPrompt,
-			previousOutcome,
-			promptHistory,
-			commitInfo.CommitMessage,
-			target,
-		)
-		if draftErr != nil {
-			return Result{}, fmt.Errorf("generate candidates for iteration %d: %w", iter, draftErr)
+	frozen, freezeLog := state.freeze.decide(state.best.prompt, state.improvedLast, state.noImprovement)
+	if freezeLog.Decision != "none" {
+		r.log.Info("freeze", "iteration", iter, "decision", freezeLog.Decision, "sections", strings.Join(freezeLog.Frozen, ","), "reason", freezeLog.Reason)
+	}
+
+	// Each lineage generates its own pool and sends its top drafts to the
+	// coder; the attempts of all lineages then compete for the beam.
+	var draftLogs []CandidateDraftLog
+	var selected []candidateDraftRuntime
+	var draftErr error
+	validCount, draftCount := 0, 0
+	for _, lin := range state.beam {
+		specFeedback := env.objectiveAnchor + "\n\n" + lin.feedbackText
+		drafts, err := r.generateCandidatePool(ctx, lin.spec, generationInput{
+			iteration:       iter,
+			lineage:         lin.slot,
+			feedbackText:    specFeedback,
+			previousPrompt:  lin.previousPrompt,
+			previousOutcome: lin.previousOutcome,
+			promptHistory:   state.promptHistory,
+			novelty:         env.novelty,
+			commitMessage:   env.commitInfo.CommitMessage,
+			target:          env.target,
+			frozen:          frozen,
+			exemplars:       env.exemplarPool,
+			scopes:          env.scopes,
+			directives:      lin.directives,
+			templates:       env.providers.templates,
+			seeds:           env.seedsFor(iter, lin.slot),
+		})
+		if err != nil {
+			if draftErr == nil {
+				draftErr = err
+			}
+			continue
 		}
 
 		validDrafts := make([]candidateDraftRuntime, 0, len(drafts))
-		draftLogs := make([]CandidateDraftLog, 0, len(drafts))
 		for _, d := range drafts {
+			d.lineage = lin.slot
+			d.log.Index += lin.slot * r.cfg.CandidatesPerIter
+			d.log.Lineage = lin.id(state.beam)
 			draftLogs = append(draftLogs, d.log)
+			env.events.emit(candidateEvent(iter, d))
 			if d.valid {
 				validDrafts = append(validDrafts, d)
-				promptHistory = append(promptHistory, d.candidate.CandidatePrompt)
+				state.promptHistory = append(state.promptHistory, d.candidate.CandidatePrompt)
 			}
 		}
-		if len(validDrafts) == 0 {
-			return Result{}, fmt.Errorf("all candidate generations failed in iteration %d", iter)
-		}
+		validCount += len(validDrafts)
+		draftCount += len(drafts)
 
 		sort.Slice(validDrafts, func(i, j int) bool {
 			return validDrafts[i].log.PreScore > validDrafts[j].log.PreScore
 		})
-
-		coderBudget := minInt(r.cfg.CoderRunsPerIter, len(validDrafts))
-		attempts := make([]coderAttemptRuntime, 0, coderBudget)
-
-		for rank := 0; rank < coderBudget; rank++ {
-			draft := validDrafts[rank]
-			runPath := filepath.Join(paths.runsDir, fmt.Sprintf("iter-%03d-cand-%02d", iter, rank+1))
-			if err := git.CreateWorktree(ctx, baseRepo, runPath, commitInfo.ParentSHA); err != nil {
-				return Result{}, fmt.Errorf("create worktree for iteration %d candidate %d: %w", iter, rank+1, err)
+		selected = append(selected, validDrafts[:minInt(r.cfg.CoderRunsPerIter, len(validDrafts))]...)
+	}
+	// Cluster drafts always run and are kept out of deduplication since they
+	// are scored against a different target.
+	var clusterSelected []candidateDraftRuntime
+	if len(env.clusters) > 0 {
+		for _, d := range r.clusterDrafts(ctx, env, state, iter) {
+			draftLogs = append(draftLogs, d.log)
+			env.events.emit(candidateEvent(iter, d))
+			draftCount++
+			if d.valid {
+				validCount++
+				clusterSelected = append(clusterSelected, d)
+				state.promptHistory = append(state.promptHistory, d.candidate.CandidatePrompt)
 			}
+		}
+	}
+	if draftErr != nil && len(selected)+len(clusterSelected) == 0 {
+		return false, fmt.Errorf("generate candidates for iteration %d: %w", iter, draftErr)
+	}
+	env.events.emit(Event{Type: EventCandidates, Iteration: iter, Count: validCount, Message: fmt.Sprintf("%d of %d drafts valid", validCount, draftCount)})
+	if len(selected)+len(clusterSelected) == 0 {
+		return false, categorize(ErrorValidationExhaustion, fmt.Errorf("all candidate generations failed in iteration %d", iter))
+	}
 
-			coderCtx, cancelCoder := context.WithTimeout(ctx, time.Duration(r.cfg.TimeoutSeconds)*time.Second)
-			coderRes, coderErr := manager.RunCoder(coderCtx, runPath, draft.candidate.CandidatePrompt)
-			cancelCoder()
-
-			produced, snapErr := git.SnapshotWorktree(ctx, runPath)
-			if snapErr != nil {
-				if !r.cfg.KeepRuns {
-					_ = git.RemoveWorktree(ctx, baseRepo, runPath)
-				}
-				return Result{}, fmt.Errorf("snapshot produced patch for iteration %d candidate %d: %w", iter, rank+1, snapErr)
+	if reviewer := r.reviewer(); reviewer != nil {
+		kept, err := r.reviewDrafts(ctx, env, reviewer, iter, append(selected, clusterSelected...), draftLogs)
+		if err != nil {
+			return false, err
+		}
+		selected, clusterSelected = nil, nil
+		for _, d := range kept {
+			if d.cluster > 0 {
+				clusterSelected = append(clusterSelected, d)
+			} else {
+				selected = append(selected, d)
 			}
+		}
+		if len(kept) == 0 {
+			state.stoppedReason = "all candidates rejected in review"
+			return true, nil
+		}
+	}
 
-			tech := scoring.ScoreTechSimilarity(target, produced)
-			realism := scoring.ScoreRealismHeuristic(draft.candidate.CandidatePrompt, scoring.RealismConfig{
-				MaxPathRefs:    r.cfg.MaxPathRefs,
-				MaxIdentifiers: r.cfg.MaxIdentifiers,
-				MaxLength:      r.cfg.MaxLength,
-			})
-
-			judgeScore := 0.0
-			hasJudge := false
-			judgeCtx, cancelJudge := context.WithTimeout(ctx, 90*time.Second)
-			judge, judgeErr := manager.JudgeRealism(judgeCtx, specSession, draft.candidate.CandidatePrompt)
-			cancelJudge()
-			if judgeErr == nil {
-				hasJudge = true
-				judgeScore = judge.Score
-				realism.JudgeScore = judge.Score
-				if strings.TrimSpace(judge.Justification) != "" {
-					realism.Reasons = append(realism.Reasons, "judge: "+strings.TrimSpace(judge.Justification))
-				}
-			}
-			realism.Score = scoring.CombineRealism(realism.HeuristicScore, judgeScore, hasJudge)
+	var reused []coderAttemptRuntime
+	if r.cfg.ReuseAttempts {
+		selected, reused = reusePastAttempts(selected, state.pastAttempts, r.cfg.DedupeSimilarity)
+		for i := range reused {
+			// Alpha may have changed since the result was scored.
+			reused[i].log.FinalScore = r.finalScore(reused[i].log.Tech.Score, reused[i].log.Realism.Score)
+		}
+		if len(reused) > 0 {
+			r.log.Info("candidates reuse coder results of earlier iterations", "iteration", iter, "reused", len(reused))
+		}
+	}
+	unique, duplicates := dedupeDrafts(selected, r.cfg.DedupeSimilarity)
+	if len(duplicates) > 0 {
+		r.log.Info("duplicate candidates share coder results", "iteration", iter, "duplicates", len(duplicates))
+	}
+	attempts, err := r.runAttempts(ctx, env, iter, append(unique, clusterSelected...))
+	if err != nil {
+		return false, err
+	}
+	r.judgeTopAttempts(ctx, env, iter, attempts)
+	state.pastAttempts = recordPastAttempts(state.pastAttempts, iter, attempts)
+	attempts = shareDuplicateAttempts(attempts, duplicates)
+	attempts = append(attempts, reused...)
+	normalization := r.normalizeJudging(ctx, env, attempts)
+	if err := r.explainAttempts(env, attempts); err != nil {
+		return false, err
+	}
 
-			finalScore := r.cfg.Alpha*tech.Score + (1-r.cfg.Alpha)*realism.Score
+	umbrella, clusterAttempts := splitClusterAttempts(attempts)
+	r.updateClusters(env, state, iter, clusterAttempts)
+	bestAttemptIdx := -1
+	for i := range attempts {
+		if len(umbrella) < len(attempts) && attempts[i].cluster > 0 {
+			continue
+		}
+		if bestAttemptIdx < 0 || attempts[i].log.FinalScore > attempts[bestAttemptIdx].log.FinalScore {
+			bestAttemptIdx = i
+		}
+	}
+	bestAttempt := attempts[bestAttemptIdx]
+
+	survivors := selectSurvivors(umbrella, len(state.beam))
+	beamLogs, feedbackPacket, criticLog, gapBatch := r.advanceBeam(ctx, env, state, iter, umbrella, survivors)
+
+	iterLog := IterationLog{
+		Iteration:          iter,
+		Drafts:             draftLogs,
+		CoderAttempts:      collectAttemptLogs(attempts),
+		SelectedAttempt:    bestAttemptIdx,
+		FeedbackPacket:     feedbackPacket,
+		IterationBestScore: bestAttempt.log.FinalScore,
+		Judge:              env.judge.endIteration(),
+		Beam:               beamLogs,
+		GapBatch:           gapBatch,
+	}
+	iterLog.Judge.Normalization = normalization
+	iterLog.Judge.Policy = r.judgePolicy()
+	for _, a := range attempts {
+		if a.log.JudgeSkipped != "" {
+			iterLog.Judge.PolicySkipped++
+		}
+	}
+	iterLog.Critic = criticLog
+	if state.alpha != nil {
+		alpha := r.cfg.Alpha
+		iterLog.Alpha = &alpha
+	}
+	if freezeLog.Policy != FreezePolicyOff {
+		iterLog.Freeze = &freezeLog
+	}
 
-			testResult := TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: "coder session failed before test run"}
-			if coderErr == nil {
-				testTimeout := time.Duration(maxInt(30, r.cfg.TimeoutSeconds/4)) * time.Second
-				testResult = RunBestEffortTests(ctx, runPath, testTimeout)
-			}
+	improved := bestAttempt.log.FinalScore > state.best.final+r.cfg.MinDelta
+	if state.smoothing.enabled() {
+		smoothed, smoothedImproved := state.smoothing.observe(bestAttempt.log.FinalScore)
+		iterLog.SmoothedScore = &smoothed
+		if improved && !smoothedImproved {
+			r.log.Info("new best, but the smoothed score did not improve", "iteration", iter, "smoothed", smoothed)
+		}
+		improved = smoothedImproved
+	}
+	state.runLog.Iterations = append(state.runLog.Iterations, iterLog)
+
+	if bestAttempt.log.FinalScore > state.best.final {
+		state.best = bestState{
+			iteration: iter,
+			title:     bestAttempt.log.CandidateTitle,
+			prompt:    bestAttempt.log.CandidatePrompt,
+			patch:     bestAttempt.produced.Patch,
+			tech:      bestAttempt.log.Tech.Score,
+			realism:   bestAttempt.log.Realism.Score,
+			final:     bestAttempt.log.FinalScore,
+			samples:   bestAttempt.log.Samples,
+		}
+		env.events.emit(Event{
+			Type:      EventBest,
+			Iteration: iter,
+			Candidate: bestAttempt.log.CandidateIndex,
+			Title:     bestAttempt.log.CandidateTitle,
+			Tech:      bestAttempt.log.Tech.Score,
+			Realism:   bestAttempt.log.Realism.Score,
+			Final:     bestAttempt.log.FinalScore,
+			BestFinal: bestAttempt.log.FinalScore,
+		})
+	}
+	if improved {
+		state.noImprovement = 0
+		state.improvedLast = true
+	} else {
+		state.noImprovement++
+		state.improvedLast = false
+	}
 
-			iterPatchPath := filepath.Join(paths.artifactsDir, fmt.Sprintf("iter-%03d-cand-%02d.patch", iter, rank+1))
-			if err := os.WriteFile(iterPatchPath, []byte(produced.Patch), 0o644); err != nil {
-				return Result{}, fmt.Errorf("write iteration patch: %w", err)
-			}
+	env.events.emit(Event{
+		Type:      EventIterationEnd,
+		Iteration: iter,
+		Candidate: bestAttempt.log.CandidateIndex,
+		Title:     bestAttempt.log.CandidateTitle,
+		Tech:      bestAttempt.log.Tech.Score,
+		Realism:   bestAttempt.log.Realism.Score,
+		Final:     bestAttempt.log.FinalScore,
+		BestFinal: state.best.final,
+	})
 
-			attemptLog := CoderAttemptLog{
-				CandidateIndex:    draft.log.Index,
-				CandidateStyle:    draft.log.Style,
-				CandidatePrompt:   draft.candidate.CandidatePrompt,
-				CoderFinalMessage: coderRes.FinalMessage,
-				Tech:              tech,
-				Realism:           realism,
-				FinalScore:        finalScore,
-				TestResult:        testResult,
-				ProducedPatchPath: iterPatchPath,
-				ProducedFiles:     append([]string(nil), produced.ChangedFiles...),
-			}
-			if coderErr != nil {
-				attemptLog.CoderError = coderErr.Error()
-			}
+	r.log.Info("iteration done",
+		"iteration", iter,
+		"candidate", bestAttempt.log.CandidateIndex,
+		"final", bestAttempt.log.FinalScore,
+		"tech", bestAttempt.log.Tech.Score,
+		"realism", bestAttempt.log.Realism.Score,
+		"bestFinal", state.best.final,
+		"durationMs", time.Since(iterStart).Milliseconds(),
+	)
+	r.logStyles(iter, state.runLog.Iterations)
 
-			attempts = append(attempts, coderAttemptRuntime{log: attemptLog, produced: produced})
+	stop, reason := r.shouldStop(StopState{
+		Iteration:     iter,
+		MaxIters:      r.cfg.MaxIters,
+		Attempt:       bestAttempt.log,
+		BestFinal:     state.best.final,
+		NoImprovement: state.noImprovement,
+		Workdir:       r.cfg.Workdir,
+		Elapsed:       time.Since(state.runLog.StartedAt),
+		Tokens:        state.priorTokens + env.usage.Total(),
+	})
+	if stop {
+		state.stoppedReason = reason
+	}
+	return stop, nil
+}
 
-			if !r.cfg.KeepRuns {
-				if err := git.RemoveWorktree(ctx, baseRepo, runPath); err != nil && r.cfg.Verbose {
-					fmt.Printf("warning: failed to cleanup worktree %s: %v\n", runPath, err)
-				}
+// runAttempts executes the drafts in rank order, running up to
+// ParallelCoders of them at once. The first failure cancels the remaining
+// attempts.
+func (r *Runner) runAttempts(ctx context.Context, env *runEnv, iter int, drafts []candidateDraftRuntime) ([]coderAttemptRuntime, error) {
+	workers := minInt(maxInt(1, r.cfg.ParallelCoders), len(drafts))
+	if workers <= 1 {
+		attempts := make([]coderAttemptRuntime, 0, len(drafts))
+		for rank, d := range drafts {
+			attempt, err := r.runAttempt(ctx, env, iter, rank, d)
+			if err != nil {
+				return nil, err
 			}
+			attempts = append(attempts, attempt)
 		}
+		return attempts, nil
+	}
 
-		bestAttemptIdx := 0
-		for i := range attempts {
-			if attempts[i].log.FinalScore > attempts[bestAttemptIdx].log.FinalScore {
-				bestAttemptIdx = i
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	attempts := make([]coderAttemptRuntime, len(drafts))
+	errs := make([]error, len(drafts))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for rank := range drafts {
+		wg.Add(1)
+		go func(rank int) {
+			defer wg.Done()
+			defer func() {
+				if rec := recover(); rec != nil {
+					errs[rank] = &iterationPanicError{iteration: iter, value: rec, stack: string(debug.Stack())}
+					cancel()
+				}
+			}()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[rank] = ctx.Err()
+				return
 			}
-		}
-		bestAttempt := attempts[bestAttemptIdx]
-
-		feedbackPacket := feedback.BuildIterationPacket(
-			iter,
-			target,
-			bestAttempt.produced,
-			bestAttempt.log.Tech,
-			bestAttempt.log.TestResult.Category,
-			r.cfg.MaxPathRefs,
-		)
-		if bestAttempt.log.CoderError != "" {
-			feedbackPacket.IntentGaps = append(feedbackPacket.IntentGaps, "coder execution had issues; refine acceptance criteria and constraints")
-		}
-
-		gapCtx, cancelGap := context.WithTimeout(ctx, 90*time.Second)
-		llmGap, gapErr := manager.SummarizeIntentGap(gapCtx, specSession, target.Patch, bestAttempt.produced.Patch, 4)
-		cancelGap()
-		if gapErr == nil && len(llmGap.Gaps) > 0 {
-			feedbackPacket.IntentGaps = dedupeStrings(append(feedbackPacket.IntentGaps, llmGap.Gaps...))
-		}
-
-		feedbackText = feedback.PacketText(feedbackPacket)
-
-		iterLog := IterationLog{
-			Iteration:          iter,
-			Drafts:             draftLogs,
-			CoderAttempts:      collectAttemptLogs(attempts),
-			SelectedAttempt:    bestAttemptIdx,
-			FeedbackPacket:     feedbackPacket,
-			IterationBestScore: bestAttempt.log.FinalScore,
-		}
-		runLog.Iterations = append(runLog.Iterations, iterLog)
-
-		if bestAttempt.log.FinalScore > best.final {
-			best = bestState{
-				iteration: iter,
-				prompt:    bestAttempt.log.CandidatePrompt,
-				patch:     bestAttempt.produced.Patch,
-				tech:      bestAttempt.log.Tech.Score,
-				realism:   bestAttempt.log.Realism.Score,
-				final:     bestAttempt.log.FinalScore,
+			defer func() { <-sem }()
+			attempt, err := r.runAttempt(ctx, env, iter, rank, drafts[rank])
+			if err != nil {
+				errs[rank] = err
+				cancel()
+				return
 			}
-			noImprovement = 0
-		} else {
-			noImprovement++
-		}
-
-		previousPrompt = bestAttempt.log.CandidatePrompt
-		previousOutcome = fmt.Sprintf(
-			"tech %.2f realism %.2f final %.2f test=%s",
-			bestAttempt.log.Tech.Score,
-			bestAttempt.log.Realism.Score,
-			bestAttempt.log.FinalScore,
-			bestAttempt.log.TestResult.Category,
-		)
-
-		if r.cfg.Verbose {
-			fmt.Printf(
-				"[iter %d] best attempt final=%.4f tech=%.4f realism=%.4f\n",
-				iter,
-				bestAttempt.log.FinalScore,
-				bestAttempt.log.Tech.Score,
-				bestAttempt.log.Realism.Score,
-			)
-		}
-
-		if bestAttempt.log.FinalScore >= r.cfg.Threshold {
-			stoppedReason = "threshold reached"
-			break
+			attempts[rank] = attempt
+		}(rank)
+	}
+	wg.Wait()
+
+	// Report the root cause rather than the cancellations it triggered.
+	var firstErr error
+	for _, err := range errs {
+		if err == nil {
+			continue
 		}
-		if noImprovement >= 3 {
-			stoppedReason = "no improvement for 3 iterations"
-			break
+		var panicErr *iterationPanicError
+		if errors.As(err, &panicErr) {
+			return nil, err
+		}
+		if firstErr == nil || (errors.Is(firstErr, context.Canceled) && !errors.Is(err, context.Canceled)) {
+			firstErr = err
 		}
 	}
-
-	if best.iteration == 0 {
-		return Result{}, fmt.Errorf("no successful iteration produced a candidate")
+	if firstErr != nil {
+		return nil, firstErr
 	}
+	return attempts, nil
+}
 
-	if err := os.WriteFile(filepath.Join(paths.artifactsDir, "best_prompt.md"), []byte(best.prompt+"\n"), 0o644); err != nil {
-		return Result{}, fmt.Errorf("write best_prompt.md: %w", err)
+// runAttempt executes one candidate on a fresh parent worktree and scores the
+// produced change. The worktree is always cleaned up, even on error or panic.
+func (r *Runner) runAttempt(ctx context.Context, env *runEnv, iter, rank int, draft candidateDraftRuntime) (coderAttemptRuntime, error) {
+	start := time.Now()
+	name := fmt.Sprintf("%siter-%03d-cand-%02d", env.attemptPrefix, iter, rank+1)
+	samples := make([]coderSample, 0, maxInt(1, r.cfg.CoderSamples))
+	for i := 0; i < cap(samples); i++ {
+		sampleName := name
+		if i > 0 {
+			sampleName = fmt.Sprintf("%s-s%02d", name, i+1)
+		}
+		s, err := r.runSample(ctx, env, iter, rank, sampleName, draft.candidate.CandidatePrompt, draft.cluster)
+		if err != nil {
+			return coderAttemptRuntime{}, err
+		}
+		samples = append(samples, s)
+	}
+	// The median sample represents the attempt, so one lucky or failed coder
+	// run does not decide its score.
+	rep := samples[medianSample(samples)]
+	produced, tech, testResult := rep.produced, rep.tech, rep.testResult
+	coderRes, coderErr, iterPatchPath := rep.coderRes, rep.coderErr, rep.patchPath
+
+	realism := scoring.ScoreRealismHeuristic(draft.candidate.CandidatePrompt, r.realismConfig())
+
+	var judged bool
+	var judgeErr error
+	inline, skipped := r.judgePlan(env, iter)
+	if inline {
+		judged, judgeErr = r.judgeRealism(ctx, env, draft.candidate.CandidatePrompt, &realism)
+	}
+	realism.Score = scoring.CombineRealism(realism.HeuristicScore, realism.JudgeScore, judged)
+	finalScore := r.finalScore(tech.Score, realism.Score)
+
+	attemptLog := CoderAttemptLog{
+		CandidateIndex:    draft.log.Index,
+		Cluster:           draft.cluster,
+		CandidateStyle:    draft.log.Style,
+		CandidateTitle:    draft.candidate.Title,
+		CandidatePrompt:   draft.candidate.CandidatePrompt,
+		PromptHash:        promptHash(draft.candidate.CandidatePrompt),
+		Lineage:           draft.log.Lineage,
+		CoderFinalMessage: coderRes.FinalMessage,
+		Judged:            judged,
+		JudgeSkipped:      skipped,
+		Tech:              tech,
+		Realism:           realism,
+		FinalScore:        finalScore,
+		TestResult:        testResult,
+		ProducedPatchPath: iterPatchPath,
+		ProducedFiles:     append([]string(nil), produced.ChangedFiles...),
+		Samples:           sampleLogs(samples),
+	}
+	if coderErr != nil {
+		attemptLog.CoderError = coderErr.Error()
 	}
-	if err := os.WriteFile(filepath.Join(paths.artifactsDir, "best.patch"), []byte(best.patch), 0o644); err != nil {
-		return Result{}, fmt.Errorf("write best.patch: %w", err)
+	if judgeErr != nil {
+		attemptLog.JudgeError = judgeErr.Error()
 	}
+	env.events.emit(Event{
+		Type:         EventAttempt,
+		Iteration:    iter,
+		Candidate:    attemptLog.CandidateIndex,
+		Attempt:      name,
+		Title:        attemptLog.CandidateTitle,
+		Tech:         tech.Score,
+		Realism:      realism.Score,
+		Final:        finalScore,
+		DurationMs:   time.Since(start).Milliseconds(),
+		TestCategory: testResult.Category,
+		Message:      attemptLog.CoderError,
+	})
+	attrs := []any{"iteration", iter, "candidate", attemptLog.CandidateIndex, "attempt", name, "final", finalScore, "tech", tech.Score, "realism", realism.Score, "durationMs", time.Since(start).Milliseconds()}
+	if coderErr != nil {
+		r.log.Warn("coder failed", append(attrs, "error", coderErr)...)
+	} else {
+		r.log.Info("attempt scored", attrs...)
+	}
+	return coderAttemptRuntime{log: attemptLog, produced: produced, lineage: draft.lineage, cluster: draft.cluster}, nil
+}
 
+// finalize writes the best prompt, patches, run log, and metrics. The run log
+// is written even when no iteration succeeded so failures can be inspected.
+func (r *Runner) finalize(env *runEnv, state *loopState) (Result, error) {
+	if err := r.writeClusterPrompts(env, state); err != nil {
+		r.log.Warn("failed to write cluster prompts", "error", err)
+	}
+	best := state.best
+	runLog := state.runLog
 	runLog.BestIteration = best.iteration
-	runLog.StoppedReason = stoppedReason
+	runLog.StoppedReason = state.stoppedReason
+	runLog.ProviderUsage = env.providerUsage()
+	runLog.Styles = styleBreakdown(runLog.Iterations)
 	runLog.CompletedAt = time.Now()
-	if err := writeJSON(filepath.Join(paths.artifactsDir, "run_log.json"), runLog); err != nil {
-		return Result{}, fmt.Errorf("write run_log.json: %w", err)
+	r.writeIsolation(env)
+	env.events.emit(Event{Type: EventRunEnd, Iteration: best.iteration, Title: best.title, BestFinal: best.final, Message: state.stoppedReason})
+
+	if best.iteration == 0 {
+		if err := writeRunLog(env.artifacts, runLog); err != nil {
+			return Result{}, err
+		}
+		r.writeReport(env, runLog)
+		if runLog.InternalError != "" {
+			return Result{}, categorize(ErrorInternal, fmt.Errorf("no successful iteration produced a candidate: %s", strings.SplitN(runLog.InternalError, "\n", 2)[0]))
+		}
+		return Result{}, categorize(ErrorInternal, fmt.Errorf("no successful iteration produced a candidate"))
 	}
 
-	metrics := Metrics{
-		TechSimilarity: best.tech,
-		RealismScore:   best.realism,
-		FinalScore:     best.final,
-		Alpha:          r.cfg.Alpha,
-		BestIteration:  best.iteration,
+	if err := r.writeBest(env, best); err != nil {
+		return Result{}, err
+	}
+	if err := writeRunLog(env.artifacts, runLog); err != nil {
+		return Result{}, err
 	}
-	if err := writeJSON(filepath.Join(paths.artifactsDir, "metrics.json"), metrics); err != nil {
-		return Result{}, fmt.Errorf("write metrics.json: %w", err)
+	if err := env.artifacts.writeJSON("metrics.json", r.bestMetrics(env, best, false)); err != nil {
+		return Result{}, categorize(ErrorArtifact, fmt.Errorf("write metrics.json: %w", err))
 	}
+	r.writeReport(env, runLog)
 
 	return Result{
+		BestTitle:          best.title,
 		BestIteration:      best.iteration,
 		BestTechSimilarity: best.tech,
 		BestRealism:        best.realism,
@@ -411,6 +1122,40 @@ func (r *Runner) Execute(ctx context.Context) (Result, error) {
 	}, nil
 }
 
+// writeBest writes the best prompt, its title, and its patch.
+func (r *Runner) writeBest(env *runEnv, best bestState) error {
+	if err := env.artifacts.write("best_prompt.md", []byte(best.prompt+"\n")); err != nil {
+		return categorize(ErrorArtifact, fmt.Errorf("write best_prompt.md: %w", err))
+	}
+	if err := env.artifacts.write("best.patch", []byte(best.patch)); err != nil {
+		return categorize(ErrorArtifact, fmt.Errorf("write best.patch: %w", err))
+	}
+	if best.title != "" {
+		if err := env.artifacts.write("best_title.txt", []byte(best.title+"\n")); err != nil {
+			return categorize(ErrorArtifact, fmt.Errorf("write best_title.txt: %w", err))
+		}
+	}
+	return nil
+}
+
+func (r *Runner) bestMetrics(env *runEnv, best bestState, partial bool) Metrics {
+	m := Metrics{
+		Title:          best.title,
+		TechSimilarity: best.tech,
+		RealismScore:   best.realism,
+		FinalScore:     best.final,
+		Alpha:          r.cfg.Alpha,
+		BestIteration:  best.iteration,
+		ScoringVersion: scoring.Version,
+		Partial:        partial,
+		Confidence:     r.metricsConfidence(best.samples, best.realism),
+	}
+	if env.contamination != nil {
+		m.Contamination = env.contamination.Verdict
+	}
+	return m
+}
+
 type layoutPaths struct {
 	runsDir      string
 	artifactsDir string
@@ -428,80 +1173,116 @@ func (r *Runner) ensureLayout() (layoutPaths, error) {
 	return layoutPaths{runsDir: runsDir, artifactsDir: artifactsDir}, nil
 }
 
+type generationInput struct {
+	iteration       int
+	lineage         int
+	feedbackText    string
+	previousPrompt  string
+	previousOutcome string
+	promptHistory   []string
+	novelty         NoveltyMetric
+	commitMessage   string
+	target          git.DiffSnapshot
+	frozen          []promptSection
+	exemplars       []string
+	scopes          scopeIndex
+	directives      []string
+	templates       *llm.Templates
+	// seeds are user prompts added to the pool, see Config.SeedPrompts.
+	seeds []seedPrompt
+}
+
 func (r *Runner) generateCandidatePool(
 	ctx context.Context,
-	manager *copilot.Manager,
-	specSession *sdk.Session,
-	iteration int,
-	feedbackText string,
-	previousPrompt string,
-	previousOutcome string,
-	promptHistory []string,
-	commitMessage string,
-	target git.DiffSnapshot,
+	spec llm.ChatProvider,
+	in generationInput,
 ) ([]candidateDraftRuntime, error) {
-	styles := candidateStyles(r.cfg.CandidatesPerIter)
+	styles := rankStyles(r.cfg.CandidatesPerIter, r.cfg.StylePriors)
 	out := make([]candidateDraftRuntime, 0, len(styles))
 	validCount := 0
+	genErrs := []error{}
+	fit := newTechFitSignals(in.target)
 
 	for idx, style := range styles {
-		candidate, raw, retries, err := r.generateValidCandidate(
-			ctx,
-			manager,
-			specSession,
-			iteration,
-			feedbackText,
-			previousPrompt,
-			previousOutcome,
-			style,
-		)
+		gen, err := r.generateValidCandidate(ctx, spec, in, idx, style)
+		candidate := gen.candidate
 
 		logEntry := CandidateDraftLog{
 			Index:             idx,
 			Style:             style,
-			ValidationRetries: retries,
-			RawSpecResponse:   raw,
+			ValidationRetries: gen.retries,
+			RawSpecResponse:   gen.raw,
+			ShortenedFrom:     gen.shortenedFrom,
+			Refinement:        gen.refinement,
+			Cached:            gen.cached,
 		}
 
 		runtime := candidateDraftRuntime{log: logEntry}
 		if err != nil {
 			runtime.log.GenerationError = err.Error()
+			genErrs = append(genErrs, err)
 			out = append(out, runtime)
 			continue
 		}
 
-		realism := scoring.ScoreRealismHeuristic(candidate.CandidatePrompt, scoring.RealismConfig{
-			MaxPathRefs:    r.cfg.MaxPathRefs,
-			MaxIdentifiers: r.cfg.MaxIdentifiers,
-			MaxLength:      r.cfg.MaxLength,
-		})
-		novelty := noveltyScore(candidate.CandidatePrompt, promptHistory)
-		pre := 0.8*realism.HeuristicScore + 0.2*novelty
-
-		runtime.log.CandidatePrompt = candidate.CandidatePrompt
-		runtime.log.Rationale = candidate.Rationale
-		runtime.log.ScopeHints = append([]string(nil), candidate.ScopeHints...)
-		runtime.log.PreRealism = realism.HeuristicScore
-		runtime.log.Novelty = novelty
-		runtime.log.PreScore = pre
-		runtime.candidate = candidate
-		runtime.valid = true
+		r.scoreDraft(ctx, in, fit, &runtime, candidate)
 		validCount++
 		out = append(out, runtime)
 	}
 
-	if seed, ok := r.makeCommitSeedCandidate(commitMessage, target, promptHistory); ok {
+	if seed, ok := r.makeCommitSeedCandidate(ctx, in.commitMessage, in.target, in.promptHistory, in.novelty, fit); ok {
 		out = append(out, seed)
 		validCount++
 	}
 
+	for _, d := range r.userSeedDrafts(ctx, in, fit) {
+		out = append(out, d)
+		if d.valid {
+			validCount++
+		}
+	}
+
 	if validCount == 0 {
-		return out, fmt.Errorf("no valid candidates generated")
+		category := ErrorValidationExhaustion
+		if allProviderErrors(genErrs) {
+			category = ErrorProvider
+		}
+		return out, categorize(category, fmt.Errorf("no valid candidates generated: %w", errors.Join(genErrs...)))
 	}
 	return out, nil
 }
 
-func (r *Runner) makeCommitSeedCandidate(commitMessage string, target git.DiffSnapshot, promptHistory []string) (candidateDraftRuntime, bool) {
+// scoreDraft fills in the pre-scores of a valid candidate and marks the
+// draft valid.
+func (r *Runner) scoreDraft(ctx context.Context, in generationInput, fit techFitSignals, d *candidateDraftRuntime, candidate llm.SpecCandidate) {
+	realism := scoring.ScoreRealismHeuristic(candidate.CandidatePrompt, r.realismConfig())
+	novelty, noveltyMethod := r.novelty(ctx, in.novelty, candidate.CandidatePrompt, in.promptHistory)
+	techFit := fit.score(candidate.CandidatePrompt, candidate.ScopeHints)
+	grounding, ungrounded := in.scopes.ground(candidate.ScopeHints)
+	pre := preScore(realism.HeuristicScore, novelty, techFit, r.cfg.NoveltyWeight) - scopeGroundingPenalty*(1-grounding)
+	d.log.StylePrior = r.stylePriorBonus(d.log.Style)
+	pre += d.log.StylePrior
+
+	d.log.Title = candidate.Title
+	d.log.CandidatePrompt = candidate.CandidatePrompt
+	d.log.Rationale = candidate.Rationale
+	d.log.ScopeHints = append([]string(nil), candidate.ScopeHints...)
+	d.log.PreRealism = realism.HeuristicScore
+	d.log.Novelty = novelty
+	d.log.NoveltyMethod = noveltyMethod
+	d.log.TechFit = techFit
+	d.log.ScopeGrounding = grounding
+	d.log.UngroundedScopes = ungrounded
+	d.log.PreScore = pre
+	lint := LintPrompt(candidate.CandidatePrompt)
+	d.log.Lint = &lint
+	d.candidate = candidate
+	d.valid = true
+}
+
+const commitSeedStyle = "commit-message-seed"
+
+func (r *Runner) makeCommitSeedCandidate(ctx context.Context, commitMessage string, target git.DiffSnapshot, promptHistory []string, metric NoveltyMetric, fit techFitSignals) (candidateDraftRuntime, bool) {
 	msg := strings.TrimSpace(stripTrackerRefs(commitMessage))
 	if msg == "" {
 		return candidateDraftRuntime{}, false
@@ -530,7 +1311,11 @@ func (r *Runner) makeCommitSeedCandidate(commitMessage string, target git.DiffSn
 	)
 
 	if r.cfg.MaxLength > 0 && len(prompt) > r.cfg.MaxLength {
-		prompt = prompt[:r.cfg.MaxLength]
+		short, ok := shortenPrompt(prompt, r.cfg.MaxLength, nil)
+		if !ok {
+			return candidateDraftRuntime{}, false
+		}
+		prompt = short
 	}
 
 	if err := ValidateNoCodePrompt(prompt, r.cfg.MaxLength); err != nil {
@@ -540,90 +1325,179 @@ func (r *Runner) makeCommitSeedCandidate(commitMessage string, target git.DiffSn
 		return candidateDraftRuntime{}, false
 	}
 
-	realism := scoring.ScoreRealismHeuristic(prompt, scoring.RealismConfig{
-		MaxPathRefs:    r.cfg.MaxPathRefs,
-		MaxIdentifiers: r.cfg.MaxIdentifiers,
-		MaxLength:      r.cfg.MaxLength,
-	})
-	novelty := noveltyScore(prompt, promptHistory)
-	pre := 0.8*realism.HeuristicScore + 0.2*novelty
+	realism := scoring.ScoreRealismHeuristic(prompt, r.realismConfig())
+	novelty, noveltyMethod := r.novelty(ctx, metric, prompt, promptHistory)
+	techFit := fit.score(prompt, scope)
+	stylePrior := r.stylePriorBonus(commitSeedStyle)
+	pre := preScore(realism.HeuristicScore, novelty, techFit, r.cfg.NoveltyWeight) + stylePrior
 
-	candidate := copilot.SpecCandidate{
+	candidate := llm.SpecCandidate{
+		Title:           seedTitle(msg),
 		CandidatePrompt: prompt,
 		Rationale:       "Commit-message anchored seed to stabilize search around likely intent.",
 		ScopeHints:      scope,
 	}
 
+	lint := LintPrompt(prompt)
 	logEntry := CandidateDraftLog{
 		Index:             1000,
-		Style:             "commit-message-seed",
+		Style:             commitSeedStyle,
+		Title:             candidate.Title,
 		CandidatePrompt:   prompt,
 		Rationale:         candidate.Rationale,
 		ScopeHints:        append([]string(nil), scope...),
 		ValidationRetries: 0,
 		PreRealism:        realism.HeuristicScore,
 		Novelty:           novelty,
+		NoveltyMethod:     noveltyMethod,
+		TechFit:           techFit,
 		PreScore:          pre,
+		Lint:              &lint,
+		StylePrior:        stylePrior,
 	}
 
 	return candidateDraftRuntime{log: logEntry, candidate: candidate, valid: true}, true
 }
 
+// seedTitle derives a request title from the commit subject line.
+func seedTitle(msg string) string {
+	subject := strings.TrimSpace(strings.SplitN(msg, "\n", 2)[0])
+	subject = strings.TrimRight(subject, ". ")
+	if r := []rune(subject); len(r) > maxTitleLength {
+		subject = strings.TrimSpace(string(r[:maxTitleLength]))
+	}
+	if ValidateTitle(subject) != nil {
+		return ""
+	}
+	return subject
+}
+
 func (r *Runner) generateValidCandidate(
 	ctx context.Context,
-	manager *copilot.Manager,
-	specSession *sdk.Session,
-	iteration int,
-	feedbackText string,
-	previousPrompt string,
-	previousOutcome string,
+	spec llm.ChatProvider,
+	in generationInput,
+	styleIdx int,
 	style string,
-) (copilot.SpecCandidate, string, int, error) {
+) (generatedCandidate, error) {
 	maxAttempts := 5
+	exemplars := exemplarsForStyle(in.exemplars, styleIdx, r.cfg.Exemplars, r.cfg.ExemplarTokenBudget)
 	violation := ""
 	lastRaw := ""
 	var lastErr error
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
-		req := copilot.GenerateSpecRequest{
-			Iteration:       iteration,
-			FeedbackText:    feedbackText,
+		req := llm.GenerateSpecRequest{
+			Iteration:       in.iteration,
+			FeedbackText:    in.feedbackText,
 			MaxPathRefs:     r.cfg.MaxPathRefs,
 			MaxLength:       r.cfg.MaxLength,
 			Style:           style,
-			PreviousPrompt:  previousPrompt,
-			PreviousOutcome: previousOutcome,
+			PreviousPrompt:  in.previousPrompt,
+			PreviousOutcome: in.previousOutcome,
 			ViolationReason: violation,
+			FrozenSections:  toFrozenSections(in.frozen),
+			Exemplars:       exemplars,
+			Language:        r.cfg.SpecLanguage,
+			Directives:      in.directives,
+			Templates:       in.templates,
 		}
 
-		candidate, raw, err := manager.GenerateSpecCandidate(ctx, specSession, req)
+		candidate, raw, cached, err := r.generateSpecCandidate(ctx, spec, req, in.lineage, styleIdx, attempt)
 		lastRaw = raw
 		if err != nil {
 			lastErr = err
-			violation = "output must be strict JSON with candidatePrompt/rationale/scopeHints"
-			continue
-		}
-
-		if err := ValidateNoCodePrompt(candidate.CandidatePrompt, r.cfg.MaxLength); err != nil {
-			lastErr = err
-			violation = "no-code constraint violation: " + err.Error()
+			violation = "output must be strict JSON with title/candidatePrompt/rationale/scopeHints"
 			continue
 		}
-		if err := ValidateStructuredPrompt(candidate.CandidatePrompt); err != nil {
+		candidate, shortenedFrom, reason, err := r.checkCandidate(candidate, in.frozen)
+		if err != nil {
 			lastErr = err
-			violation = "structured format violation: " + err.Error()
+			violation = reason
 			continue
 		}
 
-		candidate.CandidatePrompt = strings.TrimSpace(candidate.CandidatePrompt)
-		candidate.Rationale = strings.TrimSpace(candidate.Rationale)
-		return candidate, lastRaw, attempt, nil
+		out := generatedCandidate{candidate: candidate, raw: lastRaw, retries: attempt, shortenedFrom: shortenedFrom, cached: cached}
+		if r.selfRefineEnabled(style) {
+			out = r.refineCandidate(ctx, spec, in, style, out)
+		}
+		return out, nil
 	}
 
 	if lastErr == nil {
 		lastErr = fmt.Errorf("unknown specwriter failure")
 	}
-	return copilot.SpecCandidate{}, lastRaw, maxAttempts, fmt.Errorf("failed after %d attempts: %w", maxAttempts, lastErr)
+	return generatedCandidate{raw: lastRaw, retries: maxAttempts}, fmt.Errorf("failed after %d attempts: %w", maxAttempts, lastErr)
+}
+
+type generatedCandidate struct {
+	candidate     llm.SpecCandidate
+	raw           string
+	retries       int
+	shortenedFrom int
+	refinement    *RefinementLog
+	cached        bool
+}
+
+// checkCandidate applies frozen sections and shortening, then validates the
+// candidate. On failure it returns the violation to report to the SpecWriter.
+func (r *Runner) checkCandidate(candidate llm.SpecCandidate, frozen []promptSection) (llm.SpecCandidate, int, string, error) {
+	if err := ValidateTitle(candidate.Title); err != nil {
+		return candidate, 0, "title violation: " + err.Error(), err
+	}
+	candidate.CandidatePrompt = applyFrozenSections(candidate.CandidatePrompt, frozen)
+
+	shortenedFrom := 0
+	if r.cfg.ShortenOverlength && r.cfg.MaxLength > 0 && len(strings.TrimSpace(candidate.CandidatePrompt)) > r.cfg.MaxLength {
+		if short, ok := shortenPrompt(candidate.CandidatePrompt, r.cfg.MaxLength, frozenKeys(frozen)); ok {
+			shortenedFrom = len(strings.TrimSpace(candidate.CandidatePrompt))
+			candidate.CandidatePrompt = short
+		}
+	}
+
+	if err := ValidateNoCodePrompt(candidate.CandidatePrompt, r.cfg.MaxLength); err != nil {
+		return candidate, 0, "no-code constraint violation: " + err.Error(), err
+	}
+	if err := ValidateStructuredPrompt(candidate.CandidatePrompt); err != nil {
+		return candidate, 0, "structured format violation: " + err.Error(), err
+	}
+
+	candidate.CandidatePrompt = strings.TrimSpace(candidate.CandidatePrompt)
+	candidate.Rationale = strings.TrimSpace(candidate.Rationale)
+	candidate.Title = strings.TrimSpace(candidate.Title)
+	return candidate, shortenedFrom, "", nil
+}
+
+func allProviderErrors(errs []error) bool {
+	if len(errs) == 0 {
+		return false
+	}
+	for _, err := range errs {
+		if ErrorCategoryOf(err) != ErrorProvider {
+			return false
+		}
+	}
+	return true
+}
+
+// techConfig returns the technical similarity settings. Weights were
+// checked by Validate.
+func (r *Runner) techConfig() scoring.TechConfig {
+	weights, _ := scoring.ParsePathWeights(r.cfg.ScoreWeights)
+	return scoring.TechConfig{
+		RenameMatch: r.cfg.RenameMatch,
+		Ignore:      generated.ParsePatterns(r.cfg.ScoreIgnore),
+		Weights:     weights,
+	}
+}
+
+func (r *Runner) realismConfig() scoring.RealismConfig {
+	return scoring.RealismConfig{
+		MaxPathRefs:    r.cfg.MaxPathRefs,
+		MaxIdentifiers: r.cfg.MaxIdentifiers,
+		MaxLength:      r.cfg.MaxLength,
+		Language:       r.cfg.SpecLanguage,
+		Profile:        r.realismProfile,
+	}
 }
 
 func writeJSON(path string, value any) error {
@@ -632,7 +1506,7 @@ func writeJSON(path string, value any) error {
 		return err
 	}
 	data = append(data, '\n')
-	return os.WriteFile(path, data, 0o644)
+	return writeFileAtomic(path, data)
 }
 
 func collectAttemptLogs(attempts []coderAttemptRuntime) []CoderAttemptLog {
@@ -658,18 +1532,19 @@ func buildObjectiveAnchor(commitMessage string, target git.DiffSnapshot) string
 	return "Objective anchor from target metadata: " + msg + ". Intent signals: " + strings.Join(intents, "; ") + "."
 }
 
+var baseStyles = []string{
+	"balanced high-level design request",
+	"minimal-scope request focused on core behavior",
+	"acceptance-criteria-first request",
+	"resilience and error-handling focused request",
+	"test-oriented request emphasizing observable behavior",
+}
+
 func candidateStyles(n int) []string {
-	base := []string{
-		"balanced high-level design request",
-		"minimal-scope request focused on core behavior",
-		"acceptance-criteria-first request",
-		"resilience and error-handling focused request",
-		"test-oriented request emphasizing observable behavior",
+	if n <= len(baseStyles) {
+		return append([]string(nil), baseStyles[:n]...)
 	}
-	if n <= len(base) {
-		return append([]string(nil), base[:n]...)
-	}
-	out := append([]string(nil), base...)
+	out := append([]string(nil), baseStyles...)
 	for len(out) < n {
 		out = append(out, "balanced high-level design request with concise constraints")
 	}
@@ -765,3 +1640,21 @@ func minInt(a, b int) int {
 func stripTrackerRefs(s string) string {
 	return trackerRefCleanupRe.ReplaceAllString(s, "")
 }
+
+// critique asks the critic role to review the iteration's best candidate
+// against the new feedback packet. Its directives replace the previous ones
+// and are passed to the next generation request.
+func (r *Runner) critique(ctx context.Context, env *runEnv, lin *lineage, candidatePrompt string) *CriticLog {
+	if env.providers.critic == nil {
+		return nil
+	}
+	criticCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	res, err := llm.CritiqueCandidate(criticCtx, env.providers.critic, candidatePrompt, lin.feedbackText, 4)
+	cancel()
+	if err != nil {
+		lin.directives = nil
+		return &CriticLog{Error: err.Error()}
+	}
+	lin.directives = res.Directives
+	return &CriticLog{Summary: res.Summary, Directives: res.Directives}
+}
@@ -0,0 +1,229 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+const (
+	bootstrapResamples = 2000
+	bootstrapSeed      = 1
+	confidenceLevel    = 0.95
+)
+
+// coderSample is one coder run of a candidate prompt on a fresh worktree.
+type coderSample struct {
+	produced   git.DiffSnapshot
+	tech       scoring.TechScore
+	testResult TestRunResult
+	coderRes   llm.CoderResult
+	coderErr   error
+	patchPath  string
+}
+
+// SampleLog is one coder run of an attempt when --coder-samples is above one.
+type SampleLog struct {
+	Tech         float64 `json:"tech"`
+	PatchPath    string  `json:"patchPath"`
+	CoderError   string  `json:"coderError,omitempty"`
+	TestCategory string  `json:"testCategory"`
+}
+
+// Interval is a confidence interval.
+type Interval struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// MetricsConfidence holds bootstrap confidence intervals for the best
+// attempt's scores across its coder samples. Realism does not depend on the
+// coder run, so only the technical and final scores vary.
+type MetricsConfidence struct {
+	Samples        int      `json:"samples"`
+	Level          float64  `json:"level"`
+	Resamples      int      `json:"resamples"`
+	TechSimilarity Interval `json:"techSimilarity"`
+	FinalScore     Interval `json:"finalScore"`
+}
+
+// runSample runs the coder once in its own worktree, snapshots and scores
+// the result against the target or one of its clusters, runs the tests, and
+// writes the patch as name.patch.
+func (r *Runner) runSample(ctx context.Context, env *runEnv, iter, rank int, name, prompt string, cluster int) (coderSample, error) {
+	runPath := filepath.Join(env.paths.runsDir, name)
+	if err := r.createWorkspace(ctx, env, runPath); err != nil {
+		return coderSample{}, categorize(ErrorGit, fmt.Errorf("create worktree for iteration %d candidate %d: %w", iter, rank+1, err))
+	}
+	if !r.cfg.KeepRuns {
+		defer func() {
+			if err := r.removeWorkspace(context.WithoutCancel(ctx), env, runPath); err != nil {
+				r.log.Warn("failed to cleanup worktree", "path", runPath, "error", err)
+			}
+		}()
+	}
+
+	if err := git.CheckIsolation(ctx, runPath, env.workBase, env.commitInfo.TargetSHA, "", true); err != nil {
+		return coderSample{}, categorize(ErrorIsolation, fmt.Errorf("worktree for iteration %d candidate %d could leak the target: %w", iter, rank+1, err))
+	}
+	if err := git.CheckSubmoduleIsolation(ctx, runPath, env.checkoutExtras.Submodules); err != nil {
+		return coderSample{}, categorize(ErrorIsolation, fmt.Errorf("worktree for iteration %d candidate %d could leak the target: %w", iter, rank+1, err))
+	}
+
+	if env.testHint != "" {
+		prompt = strings.TrimRight(prompt, "\n") + "\n\n" + env.testHint + "\n"
+	}
+	prompt = env.anonymizer.hide(prompt)
+	if _, ok := env.providers.coder.(*llm.ChatBackend); !ok {
+		// The chat coder's prompts go through the audited middleware.
+		env.isolation.record(llm.RoleCoder, prompt)
+	}
+
+	coderStart := time.Now()
+	env.events.emit(Event{Type: EventCoderStart, Iteration: iter, Candidate: rank + 1, Attempt: name, Prompt: prompt})
+	attemptCtx, release := r.trackAttempt(ctx, name)
+	attemptCtx = llm.WithToolObserver(attemptCtx, func(tool, detail string) {
+		env.events.emit(Event{Type: EventCoderTool, Iteration: iter, Attempt: name, Message: strings.TrimSpace(tool + " " + detail)})
+	})
+	coderCtx, cancelCoder := context.WithTimeout(attemptCtx, time.Duration(r.cfg.TimeoutSeconds)*time.Second)
+	coderRes, coderErr := env.providers.coder.RunCoder(coderCtx, runPath, prompt)
+	if errors.Is(context.Cause(attemptCtx), ErrAttemptAborted) {
+		coderErr = ErrAttemptAborted
+	}
+	cancelCoder()
+	release()
+	done := Event{Type: EventCoderDone, Iteration: iter, Attempt: name, DurationMs: time.Since(coderStart).Milliseconds()}
+	if coderErr != nil {
+		done.Message = coderErr.Error()
+	}
+	env.events.emit(done)
+
+	produced, snapErr := git.SnapshotWorktree(ctx, runPath)
+	if snapErr != nil {
+		return coderSample{}, categorize(ErrorGit, fmt.Errorf("snapshot produced patch for iteration %d candidate %d: %w", iter, rank+1, snapErr))
+	}
+	// Scoring and artifacts use the real identifiers of the target.
+	produced.Patch = env.anonymizer.reveal(produced.Patch)
+
+	scoredProduced, producedGenerated := env.detector.Strip(produced)
+	tech := scoring.ScoreTechSimilarity(env.scoringFor(cluster), scoredProduced, r.techConfig())
+	if env.goTarget != nil && cluster == 0 {
+		features, err := producedGoFeatures(ctx, env, runPath, goFiles(produced, producedGenerated))
+		if err != nil {
+			return coderSample{}, categorize(ErrorGit, fmt.Errorf("go ast features for iteration %d candidate %d: %w", iter, rank+1, err))
+		}
+		if ast, ok := scoring.ScoreGoAST(*env.goTarget, features); ok {
+			tech = scoring.BlendGoAST(tech, ast)
+		}
+	}
+
+	testResult := TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: "coder session failed before test run"}
+	if coderErr == nil {
+		testTimeout := time.Duration(maxInt(30, r.cfg.TimeoutSeconds/4)) * time.Second
+		testResult = RunBestEffortTests(ctx, env.sandbox, runPath, testTimeout, TestOptions{
+			Command:      r.cfg.TestCommand,
+			Scope:        r.cfg.TestScope,
+			ChangedFiles: produced.ChangedFiles,
+		})
+		if r.cfg.TargetTests && cluster == 0 {
+			targetTests, ok, err := r.runTargetTests(ctx, env, runPath, testTimeout)
+			switch {
+			case err != nil:
+				r.log.Warn("failed to apply target tests", "iteration", iter, "candidate", rank+1, "error", err)
+			case ok:
+				tech = scoring.BlendTargetTests(tech, targetTests)
+			}
+		}
+	}
+
+	patchPath := env.artifacts.path(name + ".patch")
+	if err := env.artifacts.write(name+".patch", []byte(produced.Patch)); err != nil {
+		return coderSample{}, categorize(ErrorArtifact, fmt.Errorf("write iteration patch: %w", err))
+	}
+	return coderSample{
+		produced:   produced,
+		tech:       tech,
+		testResult: testResult,
+		coderRes:   coderRes,
+		coderErr:   coderErr,
+		patchPath:  patchPath,
+	}, nil
+}
+
+// medianSample returns the position of the sample with the median technical
+// score, the lower one for an even count.
+func medianSample(samples []coderSample) int {
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return samples[order[i]].tech.Score < samples[order[j]].tech.Score
+	})
+	return order[(len(order)-1)/2]
+}
+
+func sampleLogs(samples []coderSample) []SampleLog {
+	if len(samples) < 2 {
+		return nil
+	}
+	out := make([]SampleLog, 0, len(samples))
+	for _, s := range samples {
+		l := SampleLog{Tech: s.tech.Score, PatchPath: s.patchPath, TestCategory: s.testResult.Category}
+		if s.coderErr != nil {
+			l.CoderError = s.coderErr.Error()
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// metricsConfidence bootstraps the median technical and final scores of the
+// best attempt over its coder samples. The resampling is seeded so metrics
+// are reproducible from the same run log.
+func (r *Runner) metricsConfidence(samples []SampleLog, realism float64) *MetricsConfidence {
+	if len(samples) < 2 {
+		return nil
+	}
+	tech := make([]float64, len(samples))
+	for i, s := range samples {
+		tech[i] = s.Tech
+	}
+	rng := rand.New(rand.NewSource(bootstrapSeed))
+	medians := make([]float64, bootstrapResamples)
+	resample := make([]float64, len(tech))
+	for b := range medians {
+		for i := range resample {
+			resample[i] = tech[rng.Intn(len(tech))]
+		}
+		medians[b] = lowerMedian(resample)
+	}
+	sort.Float64s(medians)
+	tail := (1 - confidenceLevel) / 2
+	lo := medians[int(tail*float64(len(medians)))]
+	hi := medians[minInt(len(medians)-1, int((1-tail)*float64(len(medians))))]
+	return &MetricsConfidence{
+		Samples:        len(samples),
+		Level:          confidenceLevel,
+		Resamples:      bootstrapResamples,
+		TechSimilarity: Interval{Low: lo, High: hi},
+		// Final is monotonic in tech for a fixed realism.
+		FinalScore: Interval{Low: r.finalScore(lo, realism), High: r.finalScore(hi, realism)},
+	}
+}
+
+// lowerMedian matches medianSample, which picks the lower middle sample.
+func lowerMedian(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[(len(sorted)-1)/2]
+}
@@ -0,0 +1,44 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/sandbox"
+)
+
+// newSandbox returns the sandbox for coder commands and tests. Docker is
+// checked up front so a missing daemon fails the run before any model call.
+func (r *Runner) newSandbox(ctx context.Context) (sandbox.Sandbox, error) {
+	if r.cfg.Sandbox != sandbox.KindDocker {
+		return sandbox.Host{}, nil
+	}
+	d := sandbox.Docker{
+		Image:   r.cfg.SandboxImage,
+		CPUs:    r.cfg.SandboxCPUs,
+		Memory:  r.cfg.SandboxMemory,
+		Network: r.cfg.SandboxNetwork,
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := d.Check(checkCtx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func validateSandbox(c Config) error {
+	switch c.Sandbox {
+	case "", sandbox.KindHost:
+		return nil
+	case sandbox.KindDocker:
+		if strings.TrimSpace(c.SandboxImage) == "" {
+			return fmt.Errorf("sandbox-image is required with --sandbox docker")
+		}
+		return nil
+	default:
+		return fmt.Errorf("sandbox must be one of %s, %s", sandbox.KindHost, sandbox.KindDocker)
+	}
+}
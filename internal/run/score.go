@@ -0,0 +1,90 @@
+package run
+
+import (
+	"context"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/copilot"
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// ScoreResult is the evaluation of a prompt and the patch it produced against
+// a target patch, outside of the optimization loop.
+type ScoreResult struct {
+	Tech           scoring.TechScore     `json:"tech"`
+	Realism        scoring.RealismResult `json:"realism"`
+	Judged         bool                  `json:"judged"`
+	JudgeError     string                `json:"judgeError,omitempty"`
+	FinalScore     float64               `json:"finalScore"`
+	Alpha          float64               `json:"alpha"`
+	GeneratedFiles []string              `json:"generatedFiles,omitempty"`
+	ScoringVersion int                   `json:"scoringVersion"`
+}
+
+// Score runs the loop's scoring on existing patches: technical similarity
+// with the generated file handling of the loop, heuristic realism, and the
+// realism judge when judge is set. Go AST scoring needs the repository and is
+// not applied.
+func (r *Runner) Score(ctx context.Context, targetPatch, producedPatch, prompt string, judge bool) (ScoreResult, error) {
+	tech, targetGenerated := r.TechScore(targetPatch, producedPatch)
+	out := ScoreResult{
+		Tech:           tech,
+		Realism:        scoring.ScoreRealismHeuristic(prompt, r.realismConfig()),
+		Alpha:          r.cfg.Alpha,
+		GeneratedFiles: targetGenerated,
+		ScoringVersion: scoring.Version,
+	}
+	if judge && strings.TrimSpace(prompt) != "" {
+		if err := r.scoreJudge(ctx, prompt, &out); err != nil {
+			return ScoreResult{}, err
+		}
+	}
+	out.Realism.Score = scoring.CombineRealism(out.Realism.HeuristicScore, out.Realism.JudgeScore, out.Judged)
+	out.FinalScore = r.finalScore(out.Tech.Score, out.Realism.Score)
+	return out, nil
+}
+
+// TechScore is the technical similarity of a produced patch to a target
+// patch, with the generated file handling of the loop. It also returns the
+// target's generated files, which are left out of the line scores. It is
+// safe for concurrent use.
+func (r *Runner) TechScore(targetPatch, producedPatch string) (scoring.TechScore, []string) {
+	detector := generated.Detector{Include: r.cfg.IncludeGenerated, Patterns: generated.ParsePatterns(r.cfg.GeneratedPatterns)}
+	target, targetGenerated := detector.Strip(git.ParseSnapshot(targetPatch))
+	produced, _ := detector.Strip(git.ParseSnapshot(producedPatch))
+	return scoring.ScoreTechSimilarity(target, produced, r.techConfig()), targetGenerated
+}
+
+// scoreJudge sets up the configured providers just for a judge call. Judge
+// failures are reported in the result, like in the loop, while failing to
+// start a provider is an error.
+func (r *Runner) scoreJudge(ctx context.Context, prompt string, out *ScoreResult) error {
+	templates, err := r.loadTemplates()
+	if err != nil {
+		return categorize(ErrorConfig, err)
+	}
+	var manager *copilot.Manager
+	if r.cfg.usesProvider(ProviderCopilot) {
+		manager, err = copilot.NewManager(ctx, r.cfg.Workdir, copilot.Options{Model: r.cfg.Model, Templates: templates, Logger: r.log})
+		if err != nil {
+			return categorize(ErrorProvider, err)
+		}
+		defer func() { _ = manager.Close() }()
+	}
+	providers, closeProviders, err := r.newRoleProviders(ctx, manager, llm.NewTokenCounter(), nil, templates)
+	if err != nil {
+		return categorize(ErrorProvider, err)
+	}
+	defer closeProviders()
+
+	env := &runEnv{providers: providers, judge: newJudgeGuard(r.cfg.JudgeMaxFailures, r.log)}
+	judged, err := r.judgeRealism(ctx, env, prompt, &out.Realism)
+	out.Judged = judged
+	if err != nil {
+		out.JudgeError = err.Error()
+	}
+	return nil
+}
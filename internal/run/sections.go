@@ -0,0 +1,96 @@
+package run
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	sectionContext     = "context"
+	sectionOutcomes    = "outcomes"
+	sectionConstraints = "constraints"
+	sectionAcceptance  = "acceptance"
+)
+
+var topLevelHeadingRe = regexp.MustCompile(`^\s*#\s+\S`)
+
+type promptSection struct {
+	Key     string
+	Heading string
+	Body    string
+}
+
+func sectionKeys() []string {
+	return []string{sectionContext, sectionOutcomes, sectionConstraints, sectionAcceptance}
+}
+
+func classifySectionHeading(line string) string {
+	switch {
+	case sectionContextRe.MatchString(line):
+		return sectionContext
+	case sectionOutcomeRe.MatchString(line):
+		return sectionOutcomes
+	case sectionConstraintRe.MatchString(line):
+		return sectionConstraints
+	case sectionAcceptRe.MatchString(line):
+		return sectionAcceptance
+	default:
+		return ""
+	}
+}
+
+// splitSections splits a structured prompt into its top-level sections.
+// Text before the first heading is returned with an empty key.
+func splitSections(prompt string) []promptSection {
+	var out []promptSection
+	var current *promptSection
+	var body []string
+
+	flush := func() {
+		if current == nil {
+			if text := strings.TrimSpace(strings.Join(body, "\n")); text != "" {
+				out = append(out, promptSection{Body: text})
+			}
+			return
+		}
+		current.Body = strings.TrimSpace(strings.Join(body, "\n"))
+		out = append(out, *current)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(prompt), "\n") {
+		if topLevelHeadingRe.MatchString(line) {
+			flush()
+			current = &promptSection{Key: classifySectionHeading(line), Heading: strings.TrimSpace(line)}
+			body = nil
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	return out
+}
+
+func joinSections(sections []promptSection) string {
+	parts := make([]string, 0, len(sections))
+	for _, s := range sections {
+		if s.Heading == "" {
+			parts = append(parts, s.Body)
+			continue
+		}
+		if s.Body == "" {
+			parts = append(parts, s.Heading)
+			continue
+		}
+		parts = append(parts, s.Heading+"\n"+s.Body)
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+func sectionByKey(sections []promptSection, key string) (promptSection, bool) {
+	for _, s := range sections {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return promptSection{}, false
+}
@@ -0,0 +1,90 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/igolaizola/retrospec/internal/llm"
+)
+
+// seedPromptIndex numbers user seed drafts apart from generated ones, the
+// commit-message seed, and cluster drafts.
+const seedPromptIndex = 3000
+
+// seedPrompt is a user's own candidate prompt, read from a file.
+type seedPrompt struct {
+	path   string
+	title  string
+	prompt string
+}
+
+// loadSeedPrompts reads the prompt files of Config.SeedPrompts. The title is
+// taken from the file name, so fix-retry-loop.md becomes "Fix retry loop".
+func loadSeedPrompts(paths []string) ([]seedPrompt, error) {
+	out := make([]seedPrompt, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read seed prompt: %w", err)
+		}
+		prompt := strings.TrimSpace(string(data))
+		if prompt == "" {
+			return nil, fmt.Errorf("seed prompt %s is empty", p)
+		}
+		out = append(out, seedPrompt{path: p, title: seedPromptTitle(p), prompt: prompt})
+	}
+	return out, nil
+}
+
+func seedPromptTitle(p string) string {
+	name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == '.' }), " ")
+	if r := []rune(name); len(r) > maxTitleLength {
+		name = strings.TrimSpace(string(r[:maxTitleLength]))
+	}
+	if r, size := utf8.DecodeRuneInString(name); size > 0 {
+		name = string(unicode.ToUpper(r)) + name[size:]
+	}
+	return name
+}
+
+// seedsFor returns the seed prompts that join the pool of the first
+// lineage in the first iteration.
+func (env *runEnv) seedsFor(iter, lineage int) []seedPrompt {
+	if iter != 1 || lineage != 0 {
+		return nil
+	}
+	return env.seedPrompts
+}
+
+// userSeedDrafts validates and scores the seed prompts of in like generated
+// candidates. A seed that fails validation is logged as an invalid draft.
+func (r *Runner) userSeedDrafts(ctx context.Context, in generationInput, fit techFitSignals) []candidateDraftRuntime {
+	out := make([]candidateDraftRuntime, 0, len(in.seeds))
+	for i, s := range in.seeds {
+		d := candidateDraftRuntime{log: CandidateDraftLog{Index: seedPromptIndex + i, Style: "user-seed"}}
+		candidate := llm.SpecCandidate{
+			Title:           s.title,
+			CandidatePrompt: s.prompt,
+			Rationale:       "User seed prompt from " + filepath.Base(s.path) + ".",
+		}
+		candidate, shortenedFrom, reason, err := r.checkCandidate(candidate, in.frozen)
+		if err != nil {
+			d.log.Title = candidate.Title
+			d.log.CandidatePrompt = candidate.CandidatePrompt
+			d.log.GenerationError = reason
+			r.log.Warn("seed prompt is not a valid candidate", "path", s.path, "reason", reason)
+			out = append(out, d)
+			continue
+		}
+		d.log.ShortenedFrom = shortenedFrom
+		r.scoreDraft(ctx, in, fit, &d, candidate)
+		out = append(out, d)
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package run
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// minSectionBody is the smallest body a section is condensed to; below this
+// a section loses its meaning and the prompt is better rejected.
+const minSectionBody = 40
+
+// shortenPrompt condenses an over-length structured prompt to fit maxLength.
+// Every section body is scaled by the same factor and cut at sentence or
+// bullet boundaries, so headings and section order survive. Sections whose
+// keys are listed in keep are left untouched. It reports false when the
+// prompt cannot be shortened without dropping a section.
+func shortenPrompt(prompt string, maxLength int, keep []string) (string, bool) {
+	prompt = strings.TrimSpace(prompt)
+	if maxLength <= 0 || len(prompt) <= maxLength {
+		return prompt, true
+	}
+	sections := splitSections(prompt)
+	kept := toSetStrings(keep)
+
+	fixed := 0
+	flexible := 0
+	for i, s := range sections {
+		overhead := len(s.Heading)
+		if s.Heading != "" && s.Body != "" {
+			overhead++
+		}
+		if i > 0 {
+			overhead += 2
+		}
+		fixed += overhead
+		if _, ok := kept[s.Key]; ok && s.Key != "" {
+			fixed += len(s.Body)
+			continue
+		}
+		flexible += len(s.Body)
+	}
+	budget := maxLength - fixed
+	if flexible == 0 || budget <= 0 {
+		return prompt, false
+	}
+
+	ratio := float64(budget) / float64(flexible)
+	for i, s := range sections {
+		if _, ok := kept[s.Key]; ok && s.Key != "" {
+			continue
+		}
+		limit := int(float64(len(s.Body)) * ratio)
+		if limit < minSectionBody && len(s.Body) > limit {
+			return prompt, false
+		}
+		sections[i].Body = condenseBody(s.Body, limit)
+	}
+	out := joinSections(sections)
+	if len(out) > maxLength {
+		return prompt, false
+	}
+	return out, true
+}
+
+// condenseBody keeps whole bullets or sentences from the start of the body
+// until the limit. If even the first one does not fit, it is cut at a word
+// boundary.
+func condenseBody(body string, limit int) string {
+	if len(body) <= limit {
+		return body
+	}
+	units := bodyUnits(body)
+	var b strings.Builder
+	for _, u := range units {
+		sep := ""
+		if b.Len() > 0 {
+			sep = " "
+			if strings.HasPrefix(u, "- ") || strings.HasPrefix(u, "* ") {
+				sep = "\n"
+			}
+		}
+		if b.Len()+len(sep)+len(u) > limit {
+			break
+		}
+		b.WriteString(sep)
+		b.WriteString(u)
+	}
+	if b.Len() > 0 {
+		return b.String()
+	}
+	return cutAtWord(units[0], limit)
+}
+
+func bodyUnits(body string) []string {
+	out := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		l := strings.TrimSpace(line)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "- ") || strings.HasPrefix(l, "* ") {
+			out = append(out, l)
+			continue
+		}
+		for _, s := range splitSentenceUnits(l) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// splitSentenceUnits splits prose into sentences keeping their punctuation.
+func splitSentenceUnits(text string) []string {
+	out := []string{}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '.', '!', '?':
+			if i+1 == len(text) || text[i+1] == ' ' {
+				if s := strings.TrimSpace(text[start : i+1]); s != "" {
+					out = append(out, s)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+func cutAtWord(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	if limit <= 1 {
+		return ""
+	}
+	end := limit - 1
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	cut := s[:end]
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:") + "."
+}
@@ -0,0 +1,80 @@
+package run
+
+import "fmt"
+
+const (
+	SmoothingOff    = "off"
+	SmoothingEMA    = "ema"
+	SmoothingWindow = "window"
+)
+
+// improvementTracker decides whether an iteration improved on the previous
+// ones for the patience and freeze logic. Coder outcomes are noisy, so with
+// smoothing a single lucky or unlucky iteration neither resets nor advances
+// the no-improvement count on its own. The incumbent best prompt is always the
+// raw best.
+type improvementTracker struct {
+	mode    string
+	factor  float64
+	window  int
+	scores  []float64
+	current float64
+	best    float64
+	// minDelta is how much the smoothed score must rise to improve.
+	minDelta float64
+}
+
+func newImprovementTracker(cfg Config) *improvementTracker {
+	return &improvementTracker{mode: cfg.Smoothing, factor: cfg.SmoothingFactor, window: cfg.SmoothingWindow, minDelta: cfg.MinDelta}
+}
+
+func (t *improvementTracker) enabled() bool {
+	return t != nil && t.mode != "" && t.mode != SmoothingOff
+}
+
+// observe records an iteration's best final score and returns the smoothed
+// score and whether it beats every earlier smoothed score.
+func (t *improvementTracker) observe(score float64) (float64, bool) {
+	t.scores = append(t.scores, score)
+	switch t.mode {
+	case SmoothingEMA:
+		if len(t.scores) == 1 {
+			t.current = score
+		} else {
+			t.current = t.factor*score + (1-t.factor)*t.current
+		}
+	case SmoothingWindow:
+		// Mean of the last window iterations. Comparing raw maxima would
+		// make every new best an improvement, which is what smoothing avoids.
+		start := maxInt(0, len(t.scores)-t.window)
+		sum := 0.0
+		for _, s := range t.scores[start:] {
+			sum += s
+		}
+		t.current = sum / float64(len(t.scores)-start)
+	default:
+		t.current = score
+	}
+	improved := len(t.scores) == 1 || t.current > t.best+t.minDelta
+	if improved {
+		t.best = t.current
+	}
+	return t.current, improved
+}
+
+func validateSmoothing(c Config) error {
+	switch c.Smoothing {
+	case "", SmoothingOff:
+	case SmoothingEMA:
+		if c.SmoothingFactor <= 0 || c.SmoothingFactor > 1 {
+			return fmt.Errorf("smoothing-factor must be in (0,1]")
+		}
+	case SmoothingWindow:
+		if c.SmoothingWindow < 1 {
+			return fmt.Errorf("smoothing-window must be >= 1")
+		}
+	default:
+		return fmt.Errorf("smoothing must be one of %s, %s, %s", SmoothingOff, SmoothingEMA, SmoothingWindow)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+// stepStateFile holds the loop state between invocations in step mode.
+const stepStateFile = "step_state.json"
+
+// stepState is what an invocation in step mode leaves for the next one:
+// everything the loop carries from one iteration to the following.
+type stepState struct {
+	// NextIteration is the iteration the next invocation runs. Done is set
+	// once a stop condition ended the run.
+	NextIteration int           `json:"nextIteration"`
+	Done          bool          `json:"done"`
+	StoppedReason string        `json:"stoppedReason,omitempty"`
+	RunLog        RunLog        `json:"runLog"`
+	Best          stepBest      `json:"best"`
+	NoImprovement int           `json:"noImprovement"`
+	ImprovedLast  bool          `json:"improvedLast"`
+	PromptHistory []string      `json:"promptHistory"`
+	Beam          []stepLineage `json:"beam"`
+	Clusters      []stepCluster `json:"clusters,omitempty"`
+	Frozen        bool          `json:"frozen,omitempty"`
+	Smoothing     stepSmoothing `json:"smoothing"`
+	Control       []byte        `json:"control,omitempty"`
+	PastAttempts  []stepAttempt `json:"pastAttempts,omitempty"`
+	Tokens        int           `json:"tokens"`
+}
+
+type stepBest struct {
+	Iteration int         `json:"iteration"`
+	Title     string      `json:"title,omitempty"`
+	Prompt    string      `json:"prompt,omitempty"`
+	Patch     string      `json:"patch,omitempty"`
+	Tech      float64     `json:"tech"`
+	Realism   float64     `json:"realism"`
+	Final     float64     `json:"final"`
+	Samples   []SampleLog `json:"samples,omitempty"`
+}
+
+type stepLineage struct {
+	PreviousPrompt  string   `json:"previousPrompt,omitempty"`
+	PreviousOutcome string   `json:"previousOutcome,omitempty"`
+	FeedbackText    string   `json:"feedbackText,omitempty"`
+	Directives      []string `json:"directives,omitempty"`
+}
+
+type stepCluster struct {
+	BestFinal     float64 `json:"bestFinal"`
+	BestPrompt    string  `json:"bestPrompt,omitempty"`
+	BestIteration int     `json:"bestIteration"`
+	FeedbackText  string  `json:"feedbackText,omitempty"`
+}
+
+type stepSmoothing struct {
+	Scores  []float64 `json:"scores,omitempty"`
+	Current float64   `json:"current"`
+	Best    float64   `json:"best"`
+}
+
+type stepAttempt struct {
+	Iteration int              `json:"iteration"`
+	Log       CoderAttemptLog  `json:"log"`
+	Produced  git.DiffSnapshot `json:"produced"`
+	Lineage   int              `json:"lineage"`
+}
+
+// loadStepState reads the state left by the previous invocation, or returns
+// nil when there is none.
+func loadStepState(workdir string) (*stepState, error) {
+	data, err := os.ReadFile(filepath.Join(workdir, stepStateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", stepStateFile, err)
+	}
+	var s stepState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", stepStateFile, err)
+	}
+	if !s.Done && s.NextIteration < 1 {
+		return nil, fmt.Errorf("%s has no next iteration", stepStateFile)
+	}
+	return &s, nil
+}
+
+// saveStep writes the loop state for the invocation that runs iteration
+// next, or marks the run as ended.
+func (r *Runner) saveStep(env *runEnv, state *loopState, next int, done bool) error {
+	s := stepState{
+		NextIteration: next,
+		Done:          done,
+		StoppedReason: state.stoppedReason,
+		RunLog:        state.runLog,
+		Best: stepBest{
+			Iteration: state.best.iteration,
+			Title:     state.best.title,
+			Prompt:    state.best.prompt,
+			Patch:     state.best.patch,
+			Tech:      state.best.tech,
+			Realism:   state.best.realism,
+			Final:     state.best.final,
+			Samples:   state.best.samples,
+		},
+		NoImprovement: state.noImprovement,
+		ImprovedLast:  state.improvedLast,
+		PromptHistory: state.promptHistory,
+		Frozen:        state.freeze.frozen,
+		Smoothing: stepSmoothing{
+			Scores:  state.smoothing.scores,
+			Current: state.smoothing.current,
+			Best:    state.smoothing.best,
+		},
+		Control: state.control.last,
+		Tokens:  state.priorTokens + env.usage.Total(),
+	}
+	for _, l := range state.beam {
+		s.Beam = append(s.Beam, stepLineage{PreviousPrompt: l.previousPrompt, PreviousOutcome: l.previousOutcome, FeedbackText: l.feedbackText, Directives: l.directives})
+	}
+	for _, c := range state.clusters {
+		s.Clusters = append(s.Clusters, stepCluster{BestFinal: c.bestFinal, BestPrompt: c.bestPrompt, BestIteration: c.bestIteration, FeedbackText: c.feedbackText})
+	}
+	for _, p := range state.pastAttempts {
+		s.PastAttempts = append(s.PastAttempts, stepAttempt{Iteration: p.iteration, Log: p.attempt.log, Produced: p.attempt.produced, Lineage: p.attempt.lineage})
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return categorize(ErrorArtifact, fmt.Errorf("encode %s: %w", stepStateFile, err))
+	}
+	if err := writeFileAtomic(filepath.Join(r.cfg.Workdir, stepStateFile), data); err != nil {
+		return categorize(ErrorArtifact, fmt.Errorf("write %s: %w", stepStateFile, err))
+	}
+	return nil
+}
+
+// restore loads the saved state into a freshly prepared loop. The target
+// must be the one the run started with; settings may change between
+// invocations, so the incumbent is rescored with the current alpha.
+func (s *stepState) restore(r *Runner, env *runEnv, state *loopState) error {
+	if s.RunLog.Repo != r.cfg.Repo || s.RunLog.TargetCommit != env.commitInfo.TargetSHA || s.RunLog.ParentCommit != env.commitInfo.ParentSHA {
+		return categorize(ErrorConfig, fmt.Errorf("%s belongs to a run of %s at %s; remove it or use another workdir", stepStateFile, s.RunLog.Repo, s.RunLog.TargetCommit))
+	}
+	state.runLog = s.RunLog
+	state.best = bestState{
+		samples:   s.Best.Samples,
+		iteration: s.Best.Iteration,
+		title:     s.Best.Title,
+		prompt:    s.Best.Prompt,
+		patch:     s.Best.Patch,
+		tech:      s.Best.Tech,
+		realism:   s.Best.Realism,
+		final:     s.Best.Final,
+	}
+	state.noImprovement = s.NoImprovement
+	state.improvedLast = s.ImprovedLast
+	state.promptHistory = s.PromptHistory
+	for i, l := range s.Beam {
+		if i >= len(state.beam) {
+			break
+		}
+		b := state.beam[i]
+		b.previousPrompt, b.previousOutcome, b.feedbackText, b.directives = l.PreviousPrompt, l.PreviousOutcome, l.FeedbackText, l.Directives
+	}
+	if len(s.Clusters) == len(state.clusters) {
+		for i, c := range s.Clusters {
+			state.clusters[i] = clusterState{bestFinal: c.BestFinal, bestPrompt: c.BestPrompt, bestIteration: c.BestIteration, feedbackText: c.FeedbackText}
+		}
+	}
+	state.freeze.frozen = s.Frozen
+	state.smoothing.scores, state.smoothing.current, state.smoothing.best = s.Smoothing.Scores, s.Smoothing.Current, s.Smoothing.Best
+	state.control.last = s.Control
+	for _, p := range s.PastAttempts {
+		state.pastAttempts = append(state.pastAttempts, pastAttempt{iteration: p.Iteration, attempt: coderAttemptRuntime{log: p.Log, produced: p.Produced, lineage: p.Lineage}})
+	}
+	state.priorTokens = s.Tokens
+	// A schedule continues from the alpha of the last iteration.
+	if state.alpha != nil {
+		r.cfg.Alpha = s.RunLog.Alpha
+	} else {
+		state.runLog.Alpha = r.cfg.Alpha
+	}
+	if state.best.iteration > 0 {
+		state.best.final = r.finalScore(state.best.tech, state.best.realism)
+	}
+	env.contamination = s.RunLog.Contamination
+	return nil
+}
+
+// pauseStep ends an invocation in step mode after iteration iter. The loop
+// state is saved for the next invocation, and the artifacts show the best so
+// far.
+func (r *Runner) pauseStep(env *runEnv, state *loopState, iter int) (Result, error) {
+	state.stoppedReason = fmt.Sprintf("paused after iteration %d (step mode)", iter)
+	if err := r.saveStep(env, state, iter+1, false); err != nil {
+		return Result{}, err
+	}
+	r.log.Info("step done, the run continues at the next invocation", "iteration", iter, "next", iter+1)
+	if state.best.iteration == 0 {
+		runLog := state.runLog
+		runLog.StoppedReason = state.stoppedReason
+		if err := writeRunLog(env.artifacts, runLog); err != nil {
+			return Result{}, err
+		}
+		return Result{Paused: true}, nil
+	}
+	result, err := r.finalize(env, state)
+	result.Paused = true
+	return result, err
+}
+
+// endedStep is the result of an invocation in step mode after the run
+// already ended.
+func (r *Runner) endedStep(s *stepState) Result {
+	r.log.Info("run already ended, remove the step state to start over", "stoppedReason", s.StoppedReason, "state", filepath.Join(r.cfg.Workdir, stepStateFile))
+	return Result{
+		BestTitle:          s.Best.Title,
+		BestIteration:      s.Best.Iteration,
+		BestTechSimilarity: s.Best.Tech,
+		BestRealism:        s.Best.Realism,
+		BestFinalScore:     s.Best.Final,
+	}
+}
@@ -0,0 +1,137 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StopState is what stop conditions see after each iteration.
+type StopState struct {
+	Iteration int
+	MaxIters  int
+	// Attempt is the best coder attempt of the iteration.
+	Attempt CoderAttemptLog
+	// BestFinal is the best final score of the run so far.
+	BestFinal     float64
+	NoImprovement int
+	Workdir       string
+	// Elapsed is the time since the run started and Tokens the approximate
+	// model tokens it has used.
+	Elapsed time.Duration
+	Tokens  int
+}
+
+// StopCondition decides whether the loop ends after an iteration. The reason
+// is recorded as the run's stoppedReason.
+type StopCondition interface {
+	ShouldStop(s StopState) (stop bool, reason string)
+}
+
+// StopFunc adapts a function to StopCondition.
+type StopFunc func(s StopState) (bool, string)
+
+func (f StopFunc) ShouldStop(s StopState) (bool, string) {
+	return f(s)
+}
+
+// ThresholdStop stops when the iteration's best final score reaches the
+// threshold.
+type ThresholdStop struct {
+	Threshold float64
+}
+
+func (c ThresholdStop) ShouldStop(s StopState) (bool, string) {
+	return s.Attempt.FinalScore >= c.Threshold, "threshold reached"
+}
+
+// PatienceStop stops after Iterations iterations without improving the best
+// score. Zero disables it.
+type PatienceStop struct {
+	Iterations int
+}
+
+func (c PatienceStop) ShouldStop(s StopState) (bool, string) {
+	return c.Iterations > 0 && s.NoImprovement >= c.Iterations, fmt.Sprintf("no improvement for %d iterations", c.Iterations)
+}
+
+// DurationStop stops once the run has taken Max. Zero disables it.
+type DurationStop struct {
+	Max time.Duration
+}
+
+func (c DurationStop) ShouldStop(s StopState) (bool, string) {
+	return c.Max > 0 && s.Elapsed >= c.Max, fmt.Sprintf("max-duration reached (%s)", c.Max)
+}
+
+// TokenStop stops once the run has used Max tokens. Zero disables it.
+type TokenStop struct {
+	Max int
+}
+
+func (c TokenStop) ShouldStop(s StopState) (bool, string) {
+	return c.Max > 0 && s.Tokens >= c.Max, fmt.Sprintf("max-tokens reached (%d of %d)", s.Tokens, c.Max)
+}
+
+// BudgetStop stops once MaxIters iterations have run.
+type BudgetStop struct{}
+
+func (BudgetStop) ShouldStop(s StopState) (bool, string) {
+	return s.Iteration >= s.MaxIters, "max-iters reached"
+}
+
+// ExactMatchStop stops when an attempt reproduced the target's files and
+// changed lines exactly; no later prompt can beat it technically.
+type ExactMatchStop struct{}
+
+func (ExactMatchStop) ShouldStop(s StopState) (bool, string) {
+	t := s.Attempt.Tech
+	return t.TargetFiles > 0 && t.FileJaccard == 1 && t.LineF1 == 1, "exact match"
+}
+
+// SignalFileStop stops when the file at Path exists, so a run can be ended
+// gracefully from outside. A relative path is resolved against the workdir.
+type SignalFileStop struct {
+	Path string
+}
+
+func (c SignalFileStop) ShouldStop(s StopState) (bool, string) {
+	p := c.Path
+	if p == "" {
+		return false, ""
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.Workdir, p)
+	}
+	if _, err := os.Stat(p); err != nil {
+		return false, ""
+	}
+	return true, "stop file " + p
+}
+
+// stopConditions returns the conditions checked after each iteration, in
+// order. Caller conditions run after the built-in ones and before the budget,
+// so a more specific reason wins when several apply.
+func (r *Runner) stopConditions() []StopCondition {
+	conds := []StopCondition{
+		ThresholdStop{Threshold: r.cfg.Threshold},
+		ExactMatchStop{},
+		PatienceStop{Iterations: r.cfg.Patience},
+		SignalFileStop{Path: r.cfg.StopFile},
+		DurationStop{Max: r.cfg.MaxDuration},
+		TokenStop{Max: r.cfg.MaxTokens},
+	}
+	conds = append(conds, r.cfg.StopConditions...)
+	return append(conds, BudgetStop{})
+}
+
+func (r *Runner) shouldStop(s StopState) (bool, string) {
+	for _, c := range r.stopConditions() {
+		if stop, reason := c.ShouldStop(s); stop {
+			return true, strings.TrimSpace(reason)
+		}
+	}
+	return false, ""
+}
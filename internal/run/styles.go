@@ -0,0 +1,177 @@
+package run
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// StyleStats is how the candidates of one SpecWriter style fared over a run.
+// Attempts that failed in the coder are counted but not scored.
+type StyleStats struct {
+	Style    string `json:"style"`
+	Drafts   int    `json:"drafts"`
+	Attempts int    `json:"attempts"`
+	Failed   int    `json:"failed,omitempty"`
+	// Selected counts the iterations whose best attempt had this style.
+	Selected    int                   `json:"selected"`
+	MeanFinal   float64               `json:"meanFinal"`
+	MaxFinal    float64               `json:"maxFinal"`
+	MeanTech    float64               `json:"meanTech"`
+	MeanRealism float64               `json:"meanRealism"`
+	Iterations  []StyleIterationStats `json:"iterations,omitempty"`
+}
+
+// StyleIterationStats is the best final score of a style in one iteration.
+type StyleIterationStats struct {
+	Iteration int     `json:"iteration"`
+	Attempts  int     `json:"attempts"`
+	BestFinal float64 `json:"bestFinal"`
+}
+
+// StylePrior is how a SpecWriter style fared in earlier runs, such as other
+// targets of the same repository in a batch.
+type StylePrior struct {
+	Style string `json:"style"`
+	// Scored is the number of scored attempts behind MeanFinal.
+	Scored    int     `json:"scored"`
+	MeanFinal float64 `json:"meanFinal"`
+}
+
+// Style priors nudge pre-scores by at most stylePriorWeight times the gap
+// between a style's mean final score and the mean over all styles, shrunk
+// towards zero while a style has few attempts.
+const (
+	stylePriorWeight = 0.2
+	stylePriorShrink = 4.0
+)
+
+// stylePriorBonus is the pre-score adjustment of style under
+// Config.StylePriors, 0 for a style without history.
+func (r *Runner) stylePriorBonus(style string) float64 {
+	mean, ok := priorMean(r.cfg.StylePriors)
+	if !ok {
+		return 0
+	}
+	for _, p := range r.cfg.StylePriors {
+		if p.Style == style && p.Scored > 0 {
+			n := float64(p.Scored)
+			return stylePriorWeight * (p.MeanFinal - mean) * n / (n + stylePriorShrink)
+		}
+	}
+	return 0
+}
+
+// priorMean is the mean final score over all scored attempts of priors.
+func priorMean(priors []StylePrior) (float64, bool) {
+	sum, n := 0.0, 0
+	for _, p := range priors {
+		sum += p.MeanFinal * float64(p.Scored)
+		n += p.Scored
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// rankStyles picks the n styles to draft. With priors and fewer candidates
+// than styles, the styles that did best so far are drafted first; styles
+// without history rank as average.
+func rankStyles(n int, priors []StylePrior) []string {
+	mean, ok := priorMean(priors)
+	if !ok || n >= len(baseStyles) {
+		return candidateStyles(n)
+	}
+	all := candidateStyles(len(baseStyles))
+	score := map[string]float64{}
+	for _, p := range priors {
+		if p.Scored > 0 {
+			score[p.Style] = p.MeanFinal
+		}
+	}
+	rank := func(s string) float64 {
+		if v, ok := score[s]; ok {
+			return v
+		}
+		return mean
+	}
+	sort.SliceStable(all, func(i, j int) bool { return rank(all[i]) > rank(all[j]) })
+	return all[:n]
+}
+
+// styleBreakdown aggregates drafts and scored attempts per style, ordered by
+// mean final score.
+func styleBreakdown(iterations []IterationLog) []StyleStats {
+	byStyle := map[string]*StyleStats{}
+	get := func(style string) *StyleStats {
+		s, ok := byStyle[style]
+		if !ok {
+			s = &StyleStats{Style: style}
+			byStyle[style] = s
+		}
+		return s
+	}
+	for _, it := range iterations {
+		for _, d := range it.Drafts {
+			get(d.Style).Drafts++
+		}
+		for i, a := range it.CoderAttempts {
+			s := get(a.CandidateStyle)
+			s.Attempts++
+			if i == it.SelectedAttempt {
+				s.Selected++
+			}
+			if a.CoderError != "" {
+				s.Failed++
+				continue
+			}
+			s.MeanFinal += a.FinalScore
+			s.MeanTech += a.Tech.Score
+			s.MeanRealism += a.Realism.Score
+			s.MaxFinal = maxFloat(s.MaxFinal, a.FinalScore)
+			if n := len(s.Iterations); n > 0 && s.Iterations[n-1].Iteration == it.Iteration {
+				s.Iterations[n-1].Attempts++
+				s.Iterations[n-1].BestFinal = maxFloat(s.Iterations[n-1].BestFinal, a.FinalScore)
+			} else {
+				s.Iterations = append(s.Iterations, StyleIterationStats{Iteration: it.Iteration, Attempts: 1, BestFinal: a.FinalScore})
+			}
+		}
+	}
+
+	out := make([]StyleStats, 0, len(byStyle))
+	for _, s := range byStyle {
+		if scored := s.Attempts - s.Failed; scored > 0 {
+			s.MeanFinal /= float64(scored)
+			s.MeanTech /= float64(scored)
+			s.MeanRealism /= float64(scored)
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].MeanFinal != out[j].MeanFinal {
+			return out[i].MeanFinal > out[j].MeanFinal
+		}
+		return out[i].Style < out[j].Style
+	})
+	return out
+}
+
+// logStyles logs the style breakdown so far at debug level, so verbose runs
+// show which styles are paying off as the run goes.
+func (r *Runner) logStyles(iter int, iterations []IterationLog) {
+	if !r.log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	for _, s := range styleBreakdown(iterations) {
+		r.log.Debug("style scores",
+			"iteration", iter,
+			"style", s.Style,
+			"drafts", s.Drafts,
+			"attempts", s.Attempts,
+			"selected", s.Selected,
+			"meanFinal", s.MeanFinal,
+			"maxFinal", s.MaxFinal,
+		)
+	}
+}
@@ -0,0 +1,153 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+// targetTestFile is a test file the target commit added, changed, or
+// removed, with its content at the target; nil content means removed.
+type targetTestFile struct {
+	path    string
+	content []byte
+}
+
+// isTestFile reports whether path is a test file or test fixture by the
+// conventions of the toolchains test discovery knows.
+func isTestFile(p string) bool {
+	p = filepath.ToSlash(p)
+	base := path.Base(p)
+	lower := strings.ToLower(base)
+	switch {
+	case strings.HasSuffix(base, "_test.go"),
+		strings.HasSuffix(lower, ".py") && (strings.HasPrefix(lower, "test_") || strings.HasSuffix(lower, "_test.py") || lower == "conftest.py"),
+		strings.Contains(lower, ".test.") || strings.Contains(lower, ".spec."),
+		strings.HasSuffix(base, "Test.java") || strings.HasSuffix(base, "Tests.java") || strings.HasSuffix(base, "Test.kt"),
+		strings.HasSuffix(base, "Tests.cs") || strings.HasSuffix(base, "Test.cs"):
+		return true
+	}
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		switch dir {
+		case "testdata", "tests", "__tests__", "fixtures":
+			return true
+		}
+	}
+	return strings.Contains("/"+p, "/src/test/")
+}
+
+// testHint ends coder prompts under TestHint. It names no file or framework,
+// so the coder learns only that tests are expected.
+const testHint = "Verify the new behavior with the project's existing unit-test framework, adding or updating tests the way the project already does."
+
+// targetAddsTests reports whether the target adds lines to a test file.
+func targetAddsTests(target git.DiffSnapshot) bool {
+	for _, f := range target.ChangedFiles {
+		if isTestFile(f) && target.FileStats[f].Added > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTargetTests reads the target's version of every test file it touches.
+func loadTargetTests(ctx context.Context, env *runEnv) ([]targetTestFile, error) {
+	var out []targetTestFile
+	for _, f := range env.target.ChangedFiles {
+		if !isTestFile(f) {
+			continue
+		}
+		content, err := git.ShowFile(ctx, env.baseRepo, env.commitInfo.TargetSHA, f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, targetTestFile{path: f, content: content})
+	}
+	return out, nil
+}
+
+// runTargetTests applies the target's test files onto the produced worktree,
+// runs the tests of the packages they belong to, and restores the produced
+// files. It reports false when the target touches no tests.
+func (r *Runner) runTargetTests(ctx context.Context, env *runEnv, runPath string, timeout time.Duration) (scoring.TargetTestScore, bool, error) {
+	if len(env.targetTests) == 0 {
+		return scoring.TargetTestScore{}, false, nil
+	}
+	restore, err := applyTargetTests(runPath, env.anonymizer.hideTests(env.targetTests))
+	defer restore()
+	if err != nil {
+		return scoring.TargetTestScore{}, false, err
+	}
+
+	files := make([]string, 0, len(env.targetTests))
+	for _, t := range env.targetTests {
+		files = append(files, t.path)
+	}
+	res := RunBestEffortTests(ctx, env.sandbox, runPath, timeout, TestOptions{
+		Command:      r.cfg.TestCommand,
+		Scope:        TestScopeChanged,
+		ChangedFiles: files,
+		Verbose:      true,
+	})
+	if !res.Ran {
+		return scoring.TargetTestScore{}, false, nil
+	}
+	return scoring.ScoreTargetTests(files, res.Category, res.PassedCases, res.FailedCases), true, nil
+}
+
+// applyTargetTests writes the target's test files into dir and returns a
+// function that puts back the files the coder left there.
+func applyTargetTests(dir string, tests []targetTestFile) (func(), error) {
+	type saved struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+		existed bool
+	}
+	var undo []saved
+	restore := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			s := undo[i]
+			if !s.existed {
+				_ = os.Remove(s.path)
+				continue
+			}
+			_ = os.WriteFile(s.path, s.content, s.mode)
+		}
+	}
+	for _, t := range tests {
+		p := filepath.Join(dir, filepath.FromSlash(t.path))
+		s := saved{path: p}
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return restore, err
+			}
+			s.content, s.mode, s.existed = content, info.Mode().Perm(), true
+		}
+		switch {
+		case t.content == nil && s.existed:
+			if err := os.Remove(p); err != nil {
+				return restore, err
+			}
+		case t.content != nil:
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return restore, err
+			}
+			if err := os.WriteFile(p, t.content, 0o644); err != nil {
+				return restore, fmt.Errorf("write target test %s: %w", t.path, err)
+			}
+		default:
+			continue
+		}
+		undo = append(undo, s)
+	}
+	return restore, nil
+}
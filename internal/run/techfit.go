@@ -0,0 +1,136 @@
+package run
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/feedback"
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	techFitWeight   = 0.25
+	techFitMaxTerms = 24
+)
+
+var (
+	camelBoundaryRe = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	termSplitRe     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// genericPathTerms carry no information about which subsystem changed.
+var genericPathTerms = map[string]struct{}{
+	"src": {}, "lib": {}, "pkg": {}, "cmd": {}, "internal": {}, "main": {}, "app": {}, "test": {},
+	"tests": {}, "spec": {}, "index": {}, "util": {}, "utils": {}, "common": {}, "core": {}, "the": {},
+	"and": {}, "for": {}, "changed": {}, "differs": {}, "behavior": {}, "logic": {}, "updated": {},
+	"new": {}, "introduced": {}, "usage": {},
+}
+
+// techFitSignals are the target-derived vocabulary a candidate should touch
+// on if it describes the right subsystem. Computing the fit is local and
+// cheap, so it can rank candidates before spending coder runs on them.
+type techFitSignals struct {
+	pathTerms   []string
+	intentTerms []string
+}
+
+func newTechFitSignals(target git.DiffSnapshot) techFitSignals {
+	weights := map[string]int{}
+	for _, p := range target.ChangedFiles {
+		dir, file := path.Split(p)
+		file = strings.TrimSuffix(file, path.Ext(file))
+		for _, t := range splitTerms(dir) {
+			weights[t]++
+		}
+		for _, t := range splitTerms(file) {
+			weights[t] += 2
+		}
+	}
+	pathTerms := make([]string, 0, len(weights))
+	for t := range weights {
+		pathTerms = append(pathTerms, t)
+	}
+	sort.Slice(pathTerms, func(i, j int) bool {
+		if weights[pathTerms[i]] != weights[pathTerms[j]] {
+			return weights[pathTerms[i]] > weights[pathTerms[j]]
+		}
+		return pathTerms[i] < pathTerms[j]
+	})
+	if len(pathTerms) > techFitMaxTerms {
+		pathTerms = pathTerms[:techFitMaxTerms]
+	}
+
+	seen := map[string]struct{}{}
+	intentTerms := []string{}
+	for _, intent := range feedback.InferIntents(target) {
+		for _, t := range splitTerms(intent) {
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			intentTerms = append(intentTerms, t)
+		}
+	}
+	return techFitSignals{pathTerms: pathTerms, intentTerms: intentTerms}
+}
+
+// score returns how much of the target vocabulary the candidate prompt and
+// scope hints mention, in [0,1].
+func (s techFitSignals) score(prompt string, scopeHints []string) float64 {
+	if len(s.pathTerms) == 0 && len(s.intentTerms) == 0 {
+		return 0
+	}
+	vocab := map[string]struct{}{}
+	for _, t := range splitTerms(prompt + " " + strings.Join(scopeHints, " ")) {
+		vocab[stemTerm(t)] = struct{}{}
+	}
+	coverage := func(terms []string) float64 {
+		if len(terms) == 0 {
+			return 0
+		}
+		hit := 0
+		for _, t := range terms {
+			if _, ok := vocab[stemTerm(t)]; ok {
+				hit++
+			}
+		}
+		return float64(hit) / float64(len(terms))
+	}
+	if len(s.intentTerms) == 0 {
+		return coverage(s.pathTerms)
+	}
+	if len(s.pathTerms) == 0 {
+		return coverage(s.intentTerms)
+	}
+	return clamp01(0.7*coverage(s.pathTerms) + 0.3*coverage(s.intentTerms))
+}
+
+func preScore(realism, novelty, techFit, noveltyWeight float64) float64 {
+	return (1-techFitWeight)*((1-noveltyWeight)*realism+noveltyWeight*novelty) + techFitWeight*techFit
+}
+
+func splitTerms(s string) []string {
+	s = strings.ToLower(camelBoundaryRe.ReplaceAllString(s, "$1 $2"))
+	out := []string{}
+	for _, t := range termSplitRe.Split(s, -1) {
+		if len(t) < 3 {
+			continue
+		}
+		if _, ok := genericPathTerms[t]; ok {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func stemTerm(t string) string {
+	for _, suffix := range []string{"ing", "ers", "er", "es", "s"} {
+		if len(t) > len(suffix)+3 && strings.HasSuffix(t, suffix) {
+			return strings.TrimSuffix(t, suffix)
+		}
+	}
+	return t
+}
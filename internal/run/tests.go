@@ -3,12 +3,20 @@ package run
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/igolaizola/retrospec/internal/sandbox"
 )
 
 type TestRunResult struct {
@@ -16,45 +24,244 @@ type TestRunResult struct {
 	Passed   bool   `json:"passed"`
 	Category string `json:"category"`
 	Summary  string `json:"summary"`
+	// Commands are the test commands run, each with the directory it ran
+	// in relative to the repository root.
+	Commands []string `json:"commands,omitempty"`
+	// Passed and Failed count test cases in the runners' output, where
+	// they report them.
+	PassedCases int `json:"passedCases,omitempty"`
+	FailedCases int `json:"failedCases,omitempty"`
 }
 
 type testCmd struct {
 	name string
 	args []string
-	gate string
+	// dir is the project directory relative to the repository root.
+	dir string
 }
 
-func RunBestEffortTests(ctx context.Context, repoPath string, timeout time.Duration) TestRunResult {
-	commands := []testCmd{
-		{name: "go", args: []string{"test", "./..."}, gate: "go.mod"},
-		{name: "npm", args: []string{"test"}, gate: "package.json"},
-		{name: "cargo", args: []string{"test"}, gate: "Cargo.toml"},
+func (tc testCmd) String() string {
+	dir := tc.dir
+	if dir == "" {
+		dir = "."
 	}
+	return filepath.ToSlash(dir) + ": " + strings.Join(append([]string{tc.name}, tc.args...), " ")
+}
+
+const (
+	// maxTestDepth is how deep below the root projects are looked for.
+	maxTestDepth = 4
+	// maxTestCommands bounds the projects tested per attempt in a monorepo.
+	maxTestCommands = 8
+)
 
-	runAny := false
+// testToolchain recognizes a project directory by its files and returns the
+// command that tests it. nested reports whether a project of the toolchain
+// inside another one needs its own run; a Go module does, while a Maven or
+// Gradle build already covers its modules.
+type testToolchain struct {
+	name   string
+	nested bool
+	detect func(dir string, files map[string]bool) (testCmd, bool)
+}
+
+var testToolchains = []testToolchain{
+	{name: "go", nested: true, detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		return testCmd{name: "go", args: []string{"test", "./..."}}, files["go.mod"]
+	}},
+	{name: "node", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		return testCmd{name: "npm", args: []string{"test"}}, files["package.json"] && hasNPMTestScript(filepath.Join(dir, "package.json"))
+	}},
+	{name: "rust", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		return testCmd{name: "cargo", args: []string{"test"}}, files["Cargo.toml"]
+	}},
+	{name: "python", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		ok := files["pytest.ini"] || files["conftest.py"] || files["tox.ini"] || files["setup.py"] ||
+			(files["pyproject.toml"] && fileContains(filepath.Join(dir, "pyproject.toml"), "pytest")) ||
+			(files["setup.cfg"] && fileContains(filepath.Join(dir, "setup.cfg"), "pytest"))
+		return testCmd{name: "python", args: []string{"-m", "pytest", "-q"}}, ok
+	}},
+	{name: "maven", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		return testCmd{name: "mvn", args: []string{"-q", "test"}}, files["pom.xml"]
+	}},
+	{name: "gradle", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		if !files["build.gradle"] && !files["build.gradle.kts"] && !files["settings.gradle"] && !files["settings.gradle.kts"] {
+			return testCmd{}, false
+		}
+		if files["gradlew"] {
+			return testCmd{name: "./gradlew", args: []string{"test"}}, true
+		}
+		return testCmd{name: "gradle", args: []string{"test"}}, true
+	}},
+	{name: "dotnet", detect: func(dir string, files map[string]bool) (testCmd, bool) {
+		for f := range files {
+			switch filepath.Ext(f) {
+			case ".sln", ".csproj", ".fsproj", ".vbproj":
+				return testCmd{name: "dotnet", args: []string{"test"}}, true
+			}
+		}
+		return testCmd{}, false
+	}},
+}
+
+// skipTestDirs are never searched for projects.
+var skipTestDirs = map[string]bool{
+	"node_modules": true, "vendor": true, "target": true, "build": true, "dist": true,
+	"bin": true, "obj": true, "testdata": true, "__pycache__": true, "venv": true,
+}
+
+// discoverTestCommands finds the projects of the repository, at the root
+// and in subdirectories up to maxTestDepth, and returns the command of each
+// in path order. A project nested in one of the same toolchain is skipped
+// unless the toolchain needs a run per project.
+func discoverTestCommands(repoPath string) []testCmd {
+	var out []testCmd
+	roots := map[string][]string{}
+	_ = filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(repoPath, path)
+		if rel != "." {
+			name := d.Name()
+			if strings.HasPrefix(name, ".") || skipTestDirs[name] || strings.Count(filepath.ToSlash(rel), "/") >= maxTestDepth {
+				return filepath.SkipDir
+			}
+		} else {
+			rel = ""
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+		files := map[string]bool{}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files[e.Name()] = true
+			}
+		}
+		for _, tc := range testToolchains {
+			cmd, ok := tc.detect(path, files)
+			if !ok || (!tc.nested && underAny(rel, roots[tc.name])) {
+				continue
+			}
+			roots[tc.name] = append(roots[tc.name], rel)
+			cmd.dir = rel
+			out = append(out, cmd)
+		}
+		return nil
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].dir < out[j].dir })
+	return out
+}
+
+func underAny(rel string, roots []string) bool {
+	for _, r := range roots {
+		if r == "" || strings.HasPrefix(rel+string(filepath.Separator), r+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNPMTestScript reports whether package.json defines a test script other
+// than the npm init placeholder.
+func hasNPMTestScript(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return false
+	}
+	script := pkg.Scripts["test"]
+	return script != "" && !strings.Contains(script, "no test specified")
+}
+
+func fileContains(path, s string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.Contains(data, []byte(s))
+}
+
+// TestOptions select the tests RunBestEffortTests runs.
+type TestOptions struct {
+	// Command replaces discovery and runs through sh at the root.
+	Command string
+	// Scope narrows Go tests to the packages of ChangedFiles, given
+	// relative to the repository root; empty runs all tests.
+	Scope        string
+	ChangedFiles []string
+	// Verbose makes go test list passing tests so they can be counted.
+	Verbose bool
+}
+
+// RunBestEffortTests runs the test command of each project found in the
+// repository, through sb so they can be kept off the host. The first failing
+// command decides the result.
+func RunBestEffortTests(ctx context.Context, sb sandbox.Sandbox, repoPath string, timeout time.Duration, opts TestOptions) TestRunResult {
+	var commands []testCmd
+	if strings.TrimSpace(opts.Command) != "" {
+		commands = []testCmd{{name: "sh", args: []string{"-c", opts.Command}}}
+	} else {
+		unaffected := 0
+		for _, tc := range discoverTestCommands(repoPath) {
+			if tc.name == "go" && opts.Scope != "" && opts.Scope != TestScopeAll {
+				var ok bool
+				if tc, ok = scopeGoTest(ctx, sb, repoPath, tc, opts.ChangedFiles, opts.Scope); !ok {
+					unaffected++
+					continue
+				}
+			}
+			if tc.name == "go" && opts.Verbose {
+				tc.args = append([]string{"test", "-v"}, tc.args[1:]...)
+			}
+			commands = append(commands, tc)
+		}
+		if len(commands) == 0 && unaffected > 0 {
+			return TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: "the change touches no Go package"}
+		}
+	}
+	if len(commands) > maxTestCommands {
+		commands = commands[:maxTestCommands]
+	}
+
+	var ran []string
+	missing, passed, failed := 0, 0, 0
 	for _, tc := range commands {
-		if _, err := os.Stat(filepath.Join(repoPath, tc.gate)); err != nil {
+		res := runSingleTestCommand(ctx, sb, filepath.Join(repoPath, tc.dir), timeout, tc.name, tc.args...)
+		if !res.Ran {
+			missing++
 			continue
 		}
-		runAny = true
-		res := runSingleTestCommand(ctx, repoPath, timeout, tc.name, tc.args...)
+		ran = append(ran, tc.String())
+		passed += res.PassedCases
+		failed += res.FailedCases
 		if !res.Passed {
+			res.Summary = tc.String() + ": " + res.Summary
+			res.Commands = ran
+			res.PassedCases, res.FailedCases = passed, failed
 			return res
 		}
 	}
 
-	if !runAny {
-		return TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: "no recognized test command at repository root"}
+	if len(ran) == 0 {
+		summary := "no recognized test command in the repository"
+		if missing > 0 {
+			summary = fmt.Sprintf("%d test commands found, but their toolchains are not installed", missing)
+		}
+		return TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: summary}
 	}
-	return TestRunResult{Ran: true, Passed: true, Category: "pass", Summary: "best-effort root tests passed"}
+	return TestRunResult{Ran: true, Passed: true, Category: "pass", Summary: fmt.Sprintf("best-effort tests passed (%d commands)", len(ran)), Commands: ran, PassedCases: passed, FailedCases: failed}
 }
 
-func runSingleTestCommand(ctx context.Context, repoPath string, timeout time.Duration, cmdName string, args ...string) TestRunResult {
+func runSingleTestCommand(ctx context.Context, sb sandbox.Sandbox, repoPath string, timeout time.Duration, cmdName string, args ...string) TestRunResult {
 	tctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(tctx, cmdName, args...)
-	cmd.Dir = repoPath
+	cmd := sb.Command(tctx, repoPath, cmdName, args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -65,23 +272,94 @@ func runSingleTestCommand(ctx context.Context, repoPath string, timeout time.Dur
 	if tctx.Err() == context.DeadlineExceeded {
 		return TestRunResult{Ran: true, Passed: false, Category: "timeout", Summary: fmt.Sprintf("%s timed out", cmdName)}
 	}
+	// A missing tool fails to start on the host and exits with 127 in a
+	// container; python without pytest reports the missing module.
+	var exitErr *exec.ExitError
+	if errors.Is(err, exec.ErrNotFound) || (errors.As(err, &exitErr) && exitErr.ExitCode() == 127) || strings.Contains(output, "no module named pytest") {
+		return TestRunResult{Ran: false, Passed: true, Category: "not_run", Summary: fmt.Sprintf("%s is not installed", cmdName)}
+	}
+	passed, failed := countTestCases(output)
 	if err != nil {
 		category := classifyTestFailure(output)
 		return TestRunResult{
-			Ran:      true,
-			Passed:   false,
-			Category: category,
-			Summary:  fmt.Sprintf("%s failed (%s)", cmdName, category),
+			Ran:         true,
+			Passed:      false,
+			Category:    category,
+			Summary:     fmt.Sprintf("%s failed (%s)", cmdName, category),
+			PassedCases: passed,
+			FailedCases: failed,
 		}
 	}
 
-	return TestRunResult{Ran: true, Passed: true, Category: "pass", Summary: fmt.Sprintf("%s passed", cmdName)}
+	return TestRunResult{Ran: true, Passed: true, Category: "pass", Summary: fmt.Sprintf("%s passed", cmdName), PassedCases: passed, FailedCases: failed}
+}
+
+// compileFailureMarkers are output fragments of build errors across the
+// supported toolchains: Go and Rust, Maven and Gradle, dotnet, and Python
+// import or syntax errors that stop pytest from collecting tests.
+var compileFailureMarkers = []string{
+	"compile", "build failed", "syntax error", "syntaxerror",
+	"error[e", "compilation failure", "error cs", "error fs",
+	"importerror", "modulenotfounderror", "errors during collection",
+}
+
+var (
+	// Counts in summaries like pytest's "3 passed, 1 failed", cargo's "3
+	// passed; 1 failed", Jest's "1 failed, 3 passed", and dotnet's "Failed:
+	// 1, Passed: 3". Maven reports runs and failures instead.
+	passedCountRe  = regexp.MustCompile(`(\d+) passed|passed:\s*(\d+)`)
+	failedCountRe  = regexp.MustCompile(`(\d+) failed|failed:\s*(\d+)`)
+	mavenCountRe   = regexp.MustCompile(`tests run:\s*(\d+),\s*failures:\s*(\d+),\s*errors:\s*(\d+)`)
+	goCaseResultRe = regexp.MustCompile(`(?m)^\s*--- (pass|fail):`)
+)
+
+// countTestCases counts passed and failed test cases in lowercased runner
+// output. Go only lists passing tests with -v.
+func countTestCases(output string) (passed, failed int) {
+	for _, m := range goCaseResultRe.FindAllStringSubmatch(output, -1) {
+		if m[1] == "pass" {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	if passed+failed > 0 {
+		return passed, failed
+	}
+	if m := mavenCountRe.FindAllStringSubmatch(output, -1); len(m) > 0 {
+		// The last line is the total across test classes.
+		last := m[len(m)-1]
+		run, _ := strconv.Atoi(last[1])
+		fails, _ := strconv.Atoi(last[2])
+		errs, _ := strconv.Atoi(last[3])
+		return maxInt(0, run-fails-errs), fails + errs
+	}
+	// Jest also counts suites, which are not cases.
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "test suites:") {
+			lines = append(lines, line)
+		}
+	}
+	cases := strings.Join(lines, "\n")
+	sum := func(re *regexp.Regexp) int {
+		n := 0
+		for _, m := range re.FindAllStringSubmatch(cases, -1) {
+			v, _ := strconv.Atoi(m[1] + m[2])
+			n += v
+		}
+		return n
+	}
+	return sum(passedCountRe), sum(failedCountRe)
 }
 
 func classifyTestFailure(output string) string {
+	for _, m := range compileFailureMarkers {
+		if strings.Contains(output, m) {
+			return "compilation"
+		}
+	}
 	switch {
-	case strings.Contains(output, "compile") || strings.Contains(output, "build failed") || strings.Contains(output, "syntax error"):
-		return "compilation"
 	case strings.Contains(output, "assert") || strings.Contains(output, "expected") || strings.Contains(output, "failed") || strings.Contains(output, "panic"):
 		return "unit-test"
 	default:
@@ -0,0 +1,170 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/sandbox"
+)
+
+const (
+	// TestScopeChanged runs the tests of the Go packages the patch touches.
+	TestScopeChanged = "changed"
+	// TestScopeDependents adds the packages that import them.
+	TestScopeDependents = "dependents"
+	// TestScopeAll runs every test of every project.
+	TestScopeAll = "all"
+)
+
+func testScopes() []string {
+	return []string{TestScopeChanged, TestScopeDependents, TestScopeAll}
+}
+
+// scopeGoTest narrows the go test of the module at tc.dir to the packages
+// changed holds files of, and with TestScopeDependents to the packages
+// whose code or tests import them too. It reports false when the change
+// leaves the module alone. A changed go.mod or go.sum, or a removed package,
+// keeps the full run.
+func scopeGoTest(ctx context.Context, sb sandbox.Sandbox, repoPath string, tc testCmd, changed []string, scope string) (testCmd, bool) {
+	moduleDir := filepath.Join(repoPath, tc.dir)
+	prefix := ""
+	if tc.dir != "" {
+		prefix = filepath.ToSlash(tc.dir) + "/"
+	}
+	dirs := map[string]bool{}
+	for _, f := range changed {
+		f = filepath.ToSlash(f)
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(f, prefix)
+		if ownedByNestedModule(moduleDir, rel) {
+			continue
+		}
+		if rel == "go.mod" || rel == "go.sum" {
+			return tc, true
+		}
+		dir := path.Dir(rel)
+		switch {
+		case hasGoFiles(filepath.Join(moduleDir, filepath.FromSlash(dir))):
+			dirs[dir] = true
+		case strings.HasSuffix(rel, ".go"):
+			// The package is gone and its importers may no longer build.
+			return tc, true
+		}
+	}
+	if len(dirs) == 0 {
+		return tc, false
+	}
+
+	pkgs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		if dir == "." {
+			pkgs = append(pkgs, ".")
+		} else {
+			pkgs = append(pkgs, "./"+dir)
+		}
+	}
+	if scope == TestScopeDependents {
+		if deps, ok := goDependents(ctx, sb, moduleDir, dirs); ok {
+			pkgs = deps
+		}
+	}
+	sort.Strings(pkgs)
+	tc.args = append([]string{"test"}, pkgs...)
+	return tc, true
+}
+
+// ownedByNestedModule reports whether rel lies in a Go module nested in the
+// one at moduleDir, whose own test command covers it.
+func ownedByNestedModule(moduleDir, rel string) bool {
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(moduleDir, filepath.FromSlash(dir), "go.mod")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGoFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+// goDependents lists the module's packages with go list and returns the
+// import paths of the changed packages and of every package whose code, or
+// whose tests directly, import one of them. It reports false when go list
+// fails, leaving the changed packages alone.
+func goDependents(ctx context.Context, sb sandbox.Sandbox, moduleDir string, dirs map[string]bool) ([]string, bool) {
+	module := goModulePath(filepath.Join(moduleDir, "go.mod"))
+	if module == "" {
+		return nil, false
+	}
+	changed := map[string]bool{}
+	for dir := range dirs {
+		if dir == "." {
+			changed[module] = true
+		} else {
+			changed[module+"/"+dir] = true
+		}
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	cmd := sb.Command(lctx, moduleDir, "go", "list", "-e", "-f",
+		`{{.ImportPath}} {{join .Deps " "}} {{join .TestImports " "}} {{join .XTestImports " "}}`, "./...")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, false
+	}
+	var out []string
+	sc := bufio.NewScanner(&stdout)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, f := range fields {
+			if changed[f] {
+				out = append(out, fields[0])
+				break
+			}
+		}
+	}
+	if sc.Err() != nil || len(out) == 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// goModulePath returns the module path declared in a go.mod file.
+func goModulePath(goMod string) string {
+	data, err := os.ReadFile(goMod)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "module "); ok {
+			return strings.Trim(strings.TrimSpace(rest), `"`)
+		}
+	}
+	return ""
+}
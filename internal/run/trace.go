@@ -0,0 +1,167 @@
+package run
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/retrospec/internal/generated"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/redact"
+)
+
+const (
+	traceMaxHunkChars  = 1500
+	traceMaxPatchChars = 14000
+)
+
+type TraceItem struct {
+	ID      int    `json:"id"`
+	Section string `json:"section"`
+	Text    string `json:"text"`
+	Hunks   []int  `json:"hunks"`
+	Note    string `json:"note,omitempty"`
+}
+
+type TraceHunk struct {
+	ID      int    `json:"id"`
+	Path    string `json:"path"`
+	Header  string `json:"header,omitempty"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Items   []int  `json:"items"`
+}
+
+// TraceabilityMatrix links each sentence or criterion of the best prompt to
+// the hunks of best.patch that address it. It is written to traceability.json.
+type TraceabilityMatrix struct {
+	Items []TraceItem `json:"items"`
+	Hunks []TraceHunk `json:"hunks"`
+	// Unaddressed counts items with no hunk, Untraced hunks with no item.
+	Unaddressed int    `json:"unaddressed"`
+	Untraced    int    `json:"untraced"`
+	Error       string `json:"error,omitempty"`
+}
+
+// writeTraceability builds the traceability matrix with the gap model.
+// Failures are recorded in the artifact, never fatal.
+func (r *Runner) writeTraceability(ctx context.Context, env *runEnv, prompt, patch string) {
+	matrix := r.traceability(ctx, env, prompt, patch)
+	if matrix.Error != "" {
+		r.log.Warn("traceability failed", "error", matrix.Error)
+	}
+	if err := env.artifacts.writeJSON("traceability.json", matrix); err != nil {
+		r.log.Warn("failed to write traceability.json", "error", err)
+	}
+}
+
+func (r *Runner) traceability(ctx context.Context, env *runEnv, prompt, patch string) TraceabilityMatrix {
+	var m TraceabilityMatrix
+	for _, s := range splitSections(prompt) {
+		for _, text := range splitCriteria(s.Body) {
+			m.Items = append(m.Items, TraceItem{ID: len(m.Items) + 1, Section: s.Key, Text: text, Hunks: []int{}})
+		}
+	}
+	redacted, _ := redact.Patch(patch, env.detector)
+	hunks, texts := splitHunks(redacted)
+	m.Hunks = hunks
+	if len(m.Items) == 0 || len(m.Hunks) == 0 {
+		m.Error = "nothing to trace"
+		m.Unaddressed, m.Untraced = len(m.Items), len(m.Hunks)
+		return m
+	}
+
+	itemTexts := make([]string, len(m.Items))
+	for i, it := range m.Items {
+		itemTexts[i] = it.Text
+	}
+	traceCtx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+	res, err := llm.TraceSpec(traceCtx, env.providers.gap, itemTexts, texts)
+	if err != nil {
+		m.Error = err.Error()
+		m.Unaddressed, m.Untraced = len(m.Items), len(m.Hunks)
+		return m
+	}
+	for _, l := range res.Links {
+		item := &m.Items[l.Item-1]
+		item.Note = l.Note
+		for _, h := range l.Hunks {
+			item.Hunks = append(item.Hunks, h)
+			m.Hunks[h-1].Items = append(m.Hunks[h-1].Items, l.Item)
+		}
+	}
+	for _, it := range m.Items {
+		if len(it.Hunks) == 0 {
+			m.Unaddressed++
+		}
+	}
+	for _, h := range m.Hunks {
+		if len(h.Items) == 0 {
+			m.Untraced++
+		}
+	}
+	return m
+}
+
+// splitHunks splits a patch into hunks and the text shown to the model for
+// each, trimmed so the whole patch stays within traceMaxPatchChars.
+func splitHunks(patch string) ([]TraceHunk, []string) {
+	var hunks []TraceHunk
+	var texts []string
+	budget := traceMaxPatchChars
+	for _, f := range generated.SplitPatch(patch) {
+		if f.Header == "" {
+			continue
+		}
+		var lines []string
+		flush := func(header string) {
+			if header == "" && len(lines) == 0 {
+				return
+			}
+			h := TraceHunk{ID: len(hunks) + 1, Path: f.Path, Header: header, Items: []int{}}
+			for _, l := range lines {
+				switch {
+				case strings.HasPrefix(l, "+"):
+					h.Added++
+				case strings.HasPrefix(l, "-"):
+					h.Removed++
+				}
+			}
+			text := f.Path + " " + header + "\n" + strings.Join(lines, "\n")
+			text = truncateText(text, minInt(traceMaxHunkChars, maxInt(200, budget)))
+			budget -= len(text)
+			hunks = append(hunks, h)
+			texts = append(texts, text)
+		}
+		header := ""
+		inHunk := false
+		for _, l := range f.Lines {
+			if strings.HasPrefix(l, "@@") {
+				if inHunk {
+					flush(header)
+				}
+				header, lines, inHunk = l, nil, true
+				continue
+			}
+			if inHunk {
+				lines = append(lines, l)
+			}
+		}
+		if inHunk {
+			flush(header)
+		} else {
+			// Binary, mode-only, or summarized files have no hunks.
+			lines = f.Lines
+			flush("")
+		}
+	}
+	return hunks, texts
+}
+
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "\n..."
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
+const maxTitleLength = 100
+
 var (
 	commandLineRe       = regexp.MustCompile(`(?mi)^\s*(?:\$\s*|git\s+\S+|go\s+(?:test|run|build|tool)\b|npm\s+\S+|npx\s+\S+|cargo\s+\S+|make\b|bash\b|sh\b)`) //nolint:lll
 	diffMarkerRe        = regexp.MustCompile(`(?m)^(?:diff\s+--git|@@\s|\+\+\+\s|---\s)`)                                                                      //nolint:lll
@@ -79,3 +82,32 @@ func ValidateStructuredPrompt(prompt string) error {
 	}
 	return nil
 }
+
+func ValidateTitle(title string) error {
+	trimmed := strings.TrimSpace(title)
+	if trimmed == "" {
+		return fmt.Errorf("title is empty")
+	}
+	if strings.ContainsAny(trimmed, "\n\r") {
+		return fmt.Errorf("title must be a single line")
+	}
+	if n := utf8.RuneCountInString(trimmed); n > maxTitleLength {
+		return fmt.Errorf("title exceeds max length (%d > %d)", n, maxTitleLength)
+	}
+	if strings.Contains(trimmed, "`") {
+		return fmt.Errorf("title contains inline code marker")
+	}
+	if strings.HasPrefix(trimmed, "#") {
+		return fmt.Errorf("title must not be a markdown heading")
+	}
+	if issueRefRe.MatchString(trimmed) {
+		return fmt.Errorf("title includes issue/PR references (for example #123)")
+	}
+	if compileErrRe.MatchString(trimmed) || commandLineRe.MatchString(trimmed) {
+		return fmt.Errorf("title appears to include code or command output")
+	}
+	if len(strings.Fields(trimmed)) < 2 {
+		return fmt.Errorf("title is too terse to describe a request")
+	}
+	return nil
+}
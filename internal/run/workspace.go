@@ -0,0 +1,133 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+const (
+	WorkspaceWorktree = "worktree"
+	WorkspaceShared   = "shared"
+	WorkspaceCopy     = "copy"
+)
+
+// workspaceTemplate is the checkout copied for every attempt in copy mode.
+const workspaceTemplate = "workspace-template"
+
+// prepareWorkspaces gathers what coder checkouts are filled in with and, in
+// copy mode, checks out the template every attempt's checkout is copied from.
+func (r *Runner) prepareWorkspaces(ctx context.Context, env *runEnv) error {
+	if err := r.prepareCheckoutExtras(ctx, env); err != nil {
+		return err
+	}
+	if r.cfg.Workspace != WorkspaceCopy {
+		return nil
+	}
+	path := filepath.Join(r.cfg.Workdir, workspaceTemplate)
+	if err := git.CloneTemplate(ctx, env.sealedRepo, path, env.workBase); err != nil {
+		return categorize(ErrorGit, fmt.Errorf("check out the workspace template: %w", err))
+	}
+	if !env.checkoutExtras.Empty() {
+		if err := git.FillCheckout(ctx, path, env.checkoutExtras); err != nil {
+			return categorize(ErrorGit, fmt.Errorf("fill in the workspace template: %w", err))
+		}
+	}
+	if err := git.CheckIsolation(ctx, path, env.workBase, env.commitInfo.TargetSHA, "", true); err != nil {
+		return categorize(ErrorIsolation, fmt.Errorf("workspace template could leak the target: %w", err))
+	}
+	if err := git.CheckSubmoduleIsolation(ctx, path, env.checkoutExtras.Submodules); err != nil {
+		return categorize(ErrorIsolation, fmt.Errorf("workspace template could leak the target: %w", err))
+	}
+	env.workspaceTemplate = path
+	return nil
+}
+
+// createWorkspace checks the work base out at runPath for one coder run.
+// Worktrees share the registry of the sealed repository, so they are made
+// one at a time; clones and copies are independent.
+func (r *Runner) createWorkspace(ctx context.Context, env *runEnv, runPath string) error {
+	switch r.cfg.Workspace {
+	case WorkspaceShared:
+		if err := git.CloneShared(ctx, env.sealedRepo, runPath, env.workBase); err != nil {
+			return err
+		}
+		return r.fillWorkspace(ctx, env, runPath)
+	case WorkspaceCopy:
+		// The template was filled in already.
+		return git.CopyCheckout(ctx, env.workspaceTemplate, runPath)
+	}
+	env.worktreeMu.Lock()
+	err := git.CreateWorktree(ctx, env.sealedRepo, runPath, env.workBase)
+	env.worktreeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.fillWorkspace(ctx, env, runPath)
+}
+
+func (r *Runner) fillWorkspace(ctx context.Context, env *runEnv, runPath string) error {
+	if env.checkoutExtras.Empty() {
+		return nil
+	}
+	if err := git.FillCheckout(ctx, runPath, env.checkoutExtras); err != nil {
+		return fmt.Errorf("fill in submodules and git-lfs files: %w", err)
+	}
+	return nil
+}
+
+// prepareCheckoutExtras mirrors the submodules of the parent commit and
+// fetches its git-lfs files, which coder checkouts are filled in from, unless
+// disabled. Anonymized checkouts get submodules as plain files, since their
+// commits would name the upstream projects. Without git-lfs installed, LFS
+// files keep their pointers.
+func (r *Runner) prepareCheckoutExtras(ctx context.Context, env *runEnv) error {
+	parent := env.commitInfo.ParentSHA
+	if r.cfg.Submodules {
+		subs, err := git.MirrorSubmodules(ctx, env.baseRepo, parent, filepath.Join(r.cfg.Workdir, "cache", "submodules"))
+		if err != nil {
+			return categorize(ErrorGit, fmt.Errorf("%w (--submodules=false leaves submodules empty)", err))
+		}
+		for _, s := range subs {
+			r.log.Info("mirrored submodule", "path", s.Path, "commit", s.Commit, "nested", len(s.Nested))
+		}
+		env.checkoutExtras.Submodules = subs
+		env.checkoutExtras.PlainSubmodules = env.anonymizer != nil
+	}
+	if r.cfg.LFS && git.UsesLFS(ctx, env.baseRepo, parent) {
+		if !git.LFSAvailable(ctx) {
+			r.log.Warn("repository uses git-lfs, which is not installed; coders see pointer files")
+			return nil
+		}
+		storage, err := git.FetchLFS(ctx, env.baseRepo, parent)
+		if err != nil {
+			return categorize(ErrorGit, fmt.Errorf("fetch git-lfs files (--lfs=false leaves pointer files): %w", err))
+		}
+		env.checkoutExtras.LFSStorage = storage
+	}
+	return nil
+}
+
+func (r *Runner) removeWorkspace(ctx context.Context, env *runEnv, runPath string) error {
+	switch r.cfg.Workspace {
+	case WorkspaceShared, WorkspaceCopy:
+		return os.RemoveAll(runPath)
+	}
+	env.worktreeMu.Lock()
+	defer env.worktreeMu.Unlock()
+	return git.RemoveWorktree(ctx, env.sealedRepo, runPath)
+}
+
+// workspaceNote describes the coders' checkouts for isolation.json.
+func (r *Runner) workspaceNote() string {
+	switch r.cfg.Workspace {
+	case WorkspaceShared:
+		return "coders work in clones of the sealed repository that borrow its objects and have no remote; it holds the history up to the parent commit only"
+	case WorkspaceCopy:
+		return "coders work in copies of one checkout cloned from the sealed repository, with no remote; it holds the history up to the parent commit only"
+	}
+	return "coders work in worktrees of the sealed repository, which holds the history up to the parent commit only"
+}
@@ -0,0 +1,127 @@
+// Package sandbox runs commands on behalf of the coder and the test runner,
+// either directly on the host or inside a container with the worktree mounted.
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	KindHost   = "host"
+	KindDocker = "docker"
+
+	// containerWorkdir is where the worktree is mounted in the container.
+	containerWorkdir = "/work"
+)
+
+// Sandbox builds commands that run with dir as their working directory.
+type Sandbox interface {
+	Command(ctx context.Context, dir, name string, args ...string) *exec.Cmd
+	Kind() string
+}
+
+// Host runs commands directly on the host.
+type Host struct{}
+
+func (Host) Command(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+func (Host) Kind() string { return KindHost }
+
+// Docker runs commands in a throwaway container of Image with dir mounted at
+// /work. Empty limits leave the Docker defaults.
+type Docker struct {
+	Image   string
+	CPUs    string
+	Memory  string
+	Network string
+	// Binary is the docker CLI, "docker" when empty.
+	Binary string
+}
+
+func (d Docker) Kind() string { return KindDocker }
+
+// bindMount is the --mount value binding src to dst. Unlike -v, it takes
+// paths with colons; docker reads it as CSV, so fields with commas or quotes
+// are quoted.
+func bindMount(src, dst string) string {
+	field := func(s string) string {
+		if !strings.ContainsAny(s, ",\"") {
+			return s
+		}
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "type=bind," + field("src="+src) + "," + field("dst="+dst)
+}
+
+// Command runs name inside a new container. The container is named so it can
+// be removed when ctx is done; killing the docker client alone would leave it
+// running.
+func (d Docker) Command(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	bin := d.Binary
+	if bin == "" {
+		bin = "docker"
+	}
+	container := "retrospec-" + randomID()
+	dockerArgs := []string{"run", "--rm", "--init", "--name", container,
+		"--mount", bindMount(dir, containerWorkdir), "-w", containerWorkdir}
+	if runtime.GOOS == "linux" {
+		// Keep files written to the worktree owned by the current user.
+		dockerArgs = append(dockerArgs, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()), "-e", "HOME=/tmp")
+	}
+	if d.Network != "" {
+		dockerArgs = append(dockerArgs, "--network", d.Network)
+	}
+	if d.CPUs != "" {
+		dockerArgs = append(dockerArgs, "--cpus", d.CPUs)
+	}
+	if d.Memory != "" {
+		dockerArgs = append(dockerArgs, "--memory", d.Memory)
+	}
+	dockerArgs = append(dockerArgs, d.Image, name)
+	dockerArgs = append(dockerArgs, args...)
+
+	cmd := exec.CommandContext(ctx, bin, dockerArgs...)
+	cmd.Dir = dir
+	cmd.Cancel = func() error {
+		rmCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = exec.CommandContext(rmCtx, bin, "rm", "-f", container).Run()
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 10 * time.Second
+	return cmd
+}
+
+// Check verifies that the docker CLI can reach a daemon.
+func (d Docker) Check(ctx context.Context) error {
+	bin := d.Binary
+	if bin == "" {
+		bin = "docker"
+	}
+	out, err := exec.CommandContext(ctx, bin, "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("docker is not available: %w: %s", err, msg)
+		}
+		return fmt.Errorf("docker is not available: %w", err)
+	}
+	return nil
+}
+
+func randomID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,198 @@
+package scoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Rubric rules weighted by the keywords they look for.
+const (
+	ruleProblem     = "problem statement"
+	ruleBehavior    = "desired behavior"
+	ruleConstraints = "constraints"
+	ruleAcceptance  = "acceptance criteria"
+)
+
+// RealismProfile holds the limits and keyword weights of the heuristic
+// realism rubric. CalibrateRealism fits one to a corpus of real requests, so
+// prompts written like them score near the target instead of being judged
+// against the built-in limits.
+type RealismProfile struct {
+	Language string `json:"language,omitempty"`
+	// Texts is the size of the corpus the profile was fitted to.
+	Texts          int `json:"texts,omitempty"`
+	LengthLimit    int `json:"lengthLimit"`
+	MaxPathRefs    int `json:"maxPathRefs"`
+	MaxIdentifiers int `json:"maxIdentifiers"`
+	MaxNumbers     int `json:"maxNumbers"`
+	MaxBullets     int `json:"maxBullets"`
+	MaxStepWords   int `json:"maxStepWords"`
+	// KeywordWeights is the score a rule adds when its keywords appear.
+	KeywordWeights map[string]float64 `json:"keywordWeights"`
+	// Target is the mean score the corpus was fitted to, and MeanBefore and
+	// MeanAfter its mean score with the built-in rubric and the profile.
+	Target     float64 `json:"target,omitempty"`
+	MeanBefore float64 `json:"meanBefore,omitempty"`
+	MeanAfter  float64 `json:"meanAfter,omitempty"`
+}
+
+func defaultRealismProfile(cfg RealismConfig) RealismProfile {
+	return RealismProfile{
+		LengthLimit:    2600,
+		MaxPathRefs:    cfg.MaxPathRefs,
+		MaxIdentifiers: cfg.MaxIdentifiers,
+		MaxNumbers:     12,
+		MaxBullets:     10,
+		MaxStepWords:   5,
+		KeywordWeights: map[string]float64{
+			ruleProblem:     0.06,
+			ruleBehavior:    0.06,
+			ruleConstraints: 0.07,
+			ruleAcceptance:  0.07,
+		},
+	}
+}
+
+// profile returns the rubric parameters of cfg: its profile, with rules the
+// profile does not weigh keeping their built-in weight.
+func (cfg RealismConfig) profile() RealismProfile {
+	def := defaultRealismProfile(cfg)
+	if cfg.Profile == nil {
+		return def
+	}
+	p := *cfg.Profile
+	weights := map[string]float64{}
+	for rule, w := range def.KeywordWeights {
+		weights[rule] = w
+	}
+	for rule, w := range p.KeywordWeights {
+		weights[rule] = w
+	}
+	p.KeywordWeights = weights
+	return p
+}
+
+// LoadRealismProfile reads a profile written by the calibrate command.
+func LoadRealismProfile(path string) (*RealismProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p RealismProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if p.LengthLimit <= 0 || p.MaxPathRefs < 0 || p.MaxIdentifiers < 0 || p.MaxNumbers < 0 || p.MaxBullets < 0 || p.MaxStepWords < 0 {
+		return nil, fmt.Errorf("%s: limits must be >= 0 and lengthLimit > 0", path)
+	}
+	return &p, nil
+}
+
+// CalibrateRealism fits a profile to texts of real requests. Each limit is
+// set to the 90th percentile of the corpus, so nine in ten real texts are
+// not penalized for it. The keyword weights are then scaled, in proportion
+// to how often real texts meet each rule, until the mean corpus score
+// reaches target or no weight can grow further.
+func CalibrateRealism(texts []string, cfg RealismConfig, target float64) (RealismProfile, error) {
+	var corpus []string
+	for _, t := range texts {
+		if t = strings.TrimSpace(t); t != "" {
+			corpus = append(corpus, t)
+		}
+	}
+	if len(corpus) == 0 {
+		return RealismProfile{}, fmt.Errorf("corpus is empty")
+	}
+	builtin := cfg
+	builtin.Profile = nil
+
+	kw := keywordsFor(cfg.Language)
+	var lengths, paths, idents, numbers, bullets, steps []int
+	present := map[string]float64{}
+	for _, t := range corpus {
+		lower := strings.ToLower(t)
+		lengths = append(lengths, len(t))
+		paths = append(paths, countPathRefs(t))
+		idents = append(idents, countLikelyIdentifiers(t))
+		numbers = append(numbers, len(numericRe.FindAllString(t, -1)))
+		bullets = append(bullets, len(bulletRe.FindAllString(t, -1)))
+		steps = append(steps, keywordCount(lower, kw.steps))
+		for rule, words := range map[string][]string{
+			ruleProblem:     kw.problem,
+			ruleBehavior:    kw.behavior,
+			ruleConstraints: kw.constraints,
+			ruleAcceptance:  kw.acceptance,
+		} {
+			if keywordCount(lower, words) > 0 {
+				present[rule]++
+			}
+		}
+	}
+
+	p := RealismProfile{
+		Language:       normalizeLanguage(cfg.Language),
+		Texts:          len(corpus),
+		LengthLimit:    maxInt(1, percentile90(lengths)),
+		MaxPathRefs:    percentile90(paths),
+		MaxIdentifiers: percentile90(idents),
+		MaxNumbers:     percentile90(numbers),
+		MaxBullets:     percentile90(bullets),
+		MaxStepWords:   percentile90(steps),
+		KeywordWeights: map[string]float64{},
+		Target:         target,
+	}
+	for rule := range defaultRealismProfile(cfg).KeywordWeights {
+		present[rule] /= float64(len(corpus))
+	}
+	profiled := RealismConfig{Language: cfg.Language, MaxLength: cfg.MaxLength, Profile: &p}
+	scale := func(c float64) float64 {
+		for rule, frac := range present {
+			p.KeywordWeights[rule] = math.Min(maxKeywordWeight, c*frac)
+		}
+		return meanRealism(corpus, profiled)
+	}
+	// The clamped mean grows with the scale, so bisect for the target.
+	lo, hi := 0.0, maxKeywordWeight*float64(len(corpus))
+	if scale(hi) <= target {
+		lo = hi
+	} else {
+		for i := 0; i < 40; i++ {
+			mid := (lo + hi) / 2
+			if scale(mid) < target {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+	}
+	p.MeanAfter = scale(lo)
+	p.MeanBefore = meanRealism(corpus, builtin)
+	return p, nil
+}
+
+// maxKeywordWeight caps a keyword rule at what takes the base score to 1.
+const maxKeywordWeight = 1 - RealismBase
+
+func meanRealism(texts []string, cfg RealismConfig) float64 {
+	total := 0.0
+	for _, t := range texts {
+		s, _, _ := realismRubric(t, cfg)
+		total += s
+	}
+	return total / float64(len(texts))
+}
+
+// percentile90 returns the nearest-rank 90th percentile.
+func percentile90(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	rank := int(math.Ceil(0.9*float64(len(sorted)))) - 1
+	return sorted[maxInt(0, rank)]
+}
@@ -0,0 +1,119 @@
+package scoring
+
+import (
+	"sort"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+// ExplainMaxLines caps the lines listed per file and category in a
+// TechExplanation.
+const ExplainMaxLines = 40
+
+// LineMatch is a normalized diff line, prefixed with + or -, or ~ for a
+// mode change, and how many times it counted.
+type LineMatch struct {
+	Line  string `json:"line"`
+	Count int    `json:"count"`
+}
+
+type FileExplanation struct {
+	Path string `json:"path"`
+	// Matched lines count towards both precision and recall, Missing lines
+	// only lower recall, and Extra lines only lower precision.
+	Matched []LineMatch `json:"matched,omitempty"`
+	Missing []LineMatch `json:"missing,omitempty"`
+	Extra   []LineMatch `json:"extra,omitempty"`
+	// Omitted counts lines left out by ExplainMaxLines.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// TechExplanation details which files and normalized lines of two patches
+// matched, as counted by ScoreTechSimilarity.
+type TechExplanation struct {
+	MatchedFiles []string          `json:"matchedFiles"`
+	MissingFiles []string          `json:"missingFiles"`
+	ExtraFiles   []string          `json:"extraFiles"`
+	Files        []FileExplanation `json:"files"`
+}
+
+func ExplainTech(target, produced git.DiffSnapshot, cfg TechConfig) TechExplanation {
+	var out TechExplanation
+	target, _ = cfg.filter(target)
+	produced, _ = cfg.filter(produced)
+	targetSet := toSet(target.ChangedFiles)
+	producedSet := toSet(produced.ChangedFiles)
+	for p := range targetSet {
+		if _, ok := producedSet[p]; ok {
+			out.MatchedFiles = append(out.MatchedFiles, p)
+		} else {
+			out.MissingFiles = append(out.MissingFiles, p)
+		}
+	}
+	for p := range producedSet {
+		if _, ok := targetSet[p]; !ok {
+			out.ExtraFiles = append(out.ExtraFiles, p)
+		}
+	}
+	sort.Strings(out.MatchedFiles)
+	sort.Strings(out.MissingFiles)
+	sort.Strings(out.ExtraFiles)
+
+	targetParsed := parseUnifiedDiff(target.Patch, cfg)
+	producedParsed := parseUnifiedDiff(produced.Patch, cfg)
+	paths := map[string]struct{}{}
+	for p := range targetParsed.fileLines {
+		paths[p] = struct{}{}
+	}
+	for p := range producedParsed.fileLines {
+		paths[p] = struct{}{}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		t := targetParsed.fileLines[p]
+		pr := producedParsed.fileLines[p]
+		f := FileExplanation{Path: p}
+		var matched, missing, extra []LineMatch
+		for line, n := range t {
+			common := minInt(n, pr[line])
+			if common > 0 {
+				matched = append(matched, LineMatch{Line: line, Count: common})
+			}
+			if n > common {
+				missing = append(missing, LineMatch{Line: line, Count: n - common})
+			}
+		}
+		for line, n := range pr {
+			if extraN := n - minInt(n, t[line]); extraN > 0 {
+				extra = append(extra, LineMatch{Line: line, Count: extraN})
+			}
+		}
+		f.Matched, f.Omitted = capLines(matched, f.Omitted)
+		f.Missing, f.Omitted = capLines(missing, f.Omitted)
+		f.Extra, f.Omitted = capLines(extra, f.Omitted)
+		if len(f.Matched)+len(f.Missing)+len(f.Extra) == 0 {
+			continue
+		}
+		out.Files = append(out.Files, f)
+	}
+	return out
+}
+
+func capLines(lines []LineMatch, omitted int) ([]LineMatch, int) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Count != lines[j].Count {
+			return lines[i].Count > lines[j].Count
+		}
+		return lines[i].Line < lines[j].Line
+	})
+	if len(lines) > ExplainMaxLines {
+		omitted += len(lines) - ExplainMaxLines
+		lines = lines[:ExplainMaxLines]
+	}
+	return lines, omitted
+}
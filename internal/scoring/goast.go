@@ -0,0 +1,311 @@
+package scoring
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// GoASTBlendWeight is the share of the technical score taken by the Go AST
+// comparison when both sides have Go changes to compare.
+const GoASTBlendWeight = 0.3
+
+// GoFileChange is one changed Go file. A nil side means the file did not
+// exist at that point.
+type GoFileChange struct {
+	Path   string
+	Before []byte
+	After  []byte
+}
+
+// GoFeatures are the structural changes of a patch, counted across files so
+// equivalent code moved to another file still matches.
+type GoFeatures struct {
+	Decls       map[string]int
+	Signatures  map[string]int
+	Calls       map[string]int
+	ParseErrors []string
+}
+
+type GoASTScore struct {
+	DeclSimilarity      float64  `json:"declSimilarity"`
+	SignatureSimilarity float64  `json:"signatureSimilarity"`
+	CallSimilarity      float64  `json:"callSimilarity"`
+	Score               float64  `json:"score"`
+	TargetFeatures      int      `json:"targetFeatures"`
+	ProducedFeatures    int      `json:"producedFeatures"`
+	ParseErrors         []string `json:"parseErrors,omitempty"`
+}
+
+type goDecl struct {
+	sig   string
+	body  string
+	calls map[string]struct{}
+}
+
+// ExtractGoFeatures compares each file's declarations before and after the
+// change: added, removed, and modified declarations, signature changes, and
+// added or removed call edges. Files that do not parse are skipped.
+func ExtractGoFeatures(changes []GoFileChange) GoFeatures {
+	out := GoFeatures{Decls: map[string]int{}, Signatures: map[string]int{}, Calls: map[string]int{}}
+	for _, c := range changes {
+		before, err := parseGoDecls(c.Before)
+		if err != nil {
+			out.ParseErrors = append(out.ParseErrors, c.Path)
+			continue
+		}
+		after, err := parseGoDecls(c.After)
+		if err != nil {
+			out.ParseErrors = append(out.ParseErrors, c.Path)
+			continue
+		}
+		for key, a := range after {
+			b, existed := before[key]
+			switch {
+			case !existed:
+				out.Decls["+"+key]++
+				out.Signatures["+"+key+a.sig]++
+			case a.sig != b.sig:
+				out.Decls["~"+key]++
+				out.Signatures["~"+key+a.sig]++
+			case a.body != b.body:
+				out.Decls["~"+key]++
+			}
+			for callee := range a.calls {
+				if _, ok := b.calls[callee]; !ok {
+					out.Calls["+"+key+"->"+callee]++
+				}
+			}
+			for callee := range b.calls {
+				if _, ok := a.calls[callee]; !ok {
+					out.Calls["-"+key+"->"+callee]++
+				}
+			}
+		}
+		for key, b := range before {
+			if _, ok := after[key]; ok {
+				continue
+			}
+			out.Decls["-"+key]++
+			out.Signatures["-"+key+b.sig]++
+			for callee := range b.calls {
+				out.Calls["-"+key+"->"+callee]++
+			}
+		}
+	}
+	return out
+}
+
+func (f GoFeatures) size() int {
+	return multisetCount(f.Decls) + multisetCount(f.Signatures) + multisetCount(f.Calls)
+}
+
+// ScoreGoAST compares the structural changes of two patches. The second
+// result is false when neither side has Go changes to compare.
+func ScoreGoAST(target, produced GoFeatures) (GoASTScore, bool) {
+	out := GoASTScore{
+		TargetFeatures:   target.size(),
+		ProducedFeatures: produced.size(),
+		ParseErrors:      append(append([]string(nil), target.ParseErrors...), produced.ParseErrors...),
+	}
+	if out.TargetFeatures == 0 {
+		return out, false
+	}
+	out.DeclSimilarity = weightedJaccard(target.Decls, produced.Decls)
+	out.SignatureSimilarity = weightedJaccard(target.Signatures, produced.Signatures)
+	out.CallSimilarity = weightedJaccard(target.Calls, produced.Calls)
+
+	// Categories with no changes on either side carry no signal.
+	weights := []struct {
+		w     float64
+		sim   float64
+		empty bool
+	}{
+		{0.4, out.DeclSimilarity, len(target.Decls) == 0 && len(produced.Decls) == 0},
+		{0.3, out.SignatureSimilarity, len(target.Signatures) == 0 && len(produced.Signatures) == 0},
+		{0.3, out.CallSimilarity, len(target.Calls) == 0 && len(produced.Calls) == 0},
+	}
+	var sum, total float64
+	for _, w := range weights {
+		if w.empty {
+			continue
+		}
+		sum += w.w * w.sim
+		total += w.w
+	}
+	out.Score = clamp01(safeDiv(sum, total))
+	return out, true
+}
+
+// BlendGoAST folds an AST comparison into a line-based technical score.
+func BlendGoAST(tech TechScore, ast GoASTScore) TechScore {
+	tech.LineScore = tech.Score
+	tech.GoAST = &ast
+	tech.Score = clamp01((1-GoASTBlendWeight)*tech.Score + GoASTBlendWeight*ast.Score)
+	return tech
+}
+
+func parseGoDecls(src []byte) (map[string]goDecl, error) {
+	out := map[string]goDecl{}
+	if src == nil {
+		return out, nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	imports := map[string]struct{}{}
+	for _, imp := range file.Imports {
+		name := strings.Trim(imp.Path.Value, `"`)
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		imports[name] = struct{}{}
+	}
+
+	for _, d := range file.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			key := "func " + d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				key = "func " + receiverName(d.Recv.List[0].Type) + "." + d.Name.Name
+			}
+			decl := goDecl{sig: funcSignature(fset, d.Type), calls: map[string]struct{}{}}
+			if d.Body != nil {
+				decl.body = hashString(nodeString(fset, d.Body))
+				ast.Inspect(d.Body, func(n ast.Node) bool {
+					if call, ok := n.(*ast.CallExpr); ok {
+						if name := calleeName(call.Fun, imports); name != "" {
+							decl.calls[name] = struct{}{}
+						}
+					}
+					return true
+				})
+			}
+			out[key] = decl
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					out["type "+s.Name.Name] = goDecl{sig: nodeString(fset, s.Type)}
+				case *ast.ValueSpec:
+					kind := strings.ToLower(d.Tok.String())
+					sig := ""
+					if s.Type != nil {
+						sig = nodeString(fset, s.Type)
+					}
+					body := ""
+					if len(s.Values) > 0 {
+						var parts []string
+						for _, v := range s.Values {
+							parts = append(parts, nodeString(fset, v))
+						}
+						body = hashString(strings.Join(parts, ","))
+					}
+					for _, n := range s.Names {
+						if n.Name == "_" {
+							continue
+						}
+						out[kind+" "+n.Name] = goDecl{sig: sig, body: body}
+					}
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	default:
+		return ""
+	}
+}
+
+// calleeName names a call target. Package-qualified calls keep the package,
+// method calls keep only the method since receiver variable names vary.
+func calleeName(fun ast.Expr, imports map[string]struct{}) string {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f.Name
+	case *ast.SelectorExpr:
+		if x, ok := f.X.(*ast.Ident); ok {
+			if _, isPkg := imports[x.Name]; isPkg {
+				return x.Name + "." + f.Sel.Name
+			}
+		}
+		return "." + f.Sel.Name
+	case *ast.IndexExpr:
+		return calleeName(f.X, imports)
+	case *ast.IndexListExpr:
+		return calleeName(f.X, imports)
+	default:
+		return ""
+	}
+}
+
+// funcSignature renders parameter and result types without their names, which
+// vary between equivalent implementations.
+func funcSignature(fset *token.FileSet, t *ast.FuncType) string {
+	fields := func(list *ast.FieldList) string {
+		if list == nil {
+			return ""
+		}
+		var types []string
+		for _, f := range list.List {
+			n := len(f.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				types = append(types, nodeString(fset, f.Type))
+			}
+		}
+		return strings.Join(types, ",")
+	}
+	return "(" + fields(t.Params) + ")(" + fields(t.Results) + ")"
+}
+
+// nodeString prints a node without comments and with whitespace collapsed.
+func nodeString(fset *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	if err := (&printer.Config{Mode: printer.RawFormat}).Fprint(&buf, fset, n); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+func hashString(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GoFiles returns the .go paths among files, sorted.
+func GoFiles(files []string) []string {
+	var out []string
+	for _, f := range files {
+		if strings.HasSuffix(f, ".go") {
+			out = append(out, f)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
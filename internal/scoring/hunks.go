@@ -0,0 +1,140 @@
+package scoring
+
+import "sort"
+
+// maxHunkPairs bounds the pairwise comparisons of hunk alignment; above it
+// hunks are only compared with hunks of the same file.
+const maxHunkPairs = 250000
+
+// HunkScore is the alignment of one target hunk. A hunk relocated to another
+// file or position is still matched by its lines, and MatchedPath names the
+// produced file it was matched in.
+type HunkScore struct {
+	Header        string  `json:"header"`
+	Similarity    float64 `json:"similarity"`
+	MatchedPath   string  `json:"matchedPath,omitempty"`
+	MatchedHeader string  `json:"matchedHeader,omitempty"`
+}
+
+type hunk struct {
+	file   string
+	header string
+	lines  map[string]int
+	size   int
+	// weight is the scoring weight of the file's lines.
+	weight float64
+}
+
+// weightedSize is the line count of the hunk scaled by its weight.
+func (h hunk) weightedSize() float64 {
+	return float64(h.size) * h.weight
+}
+
+type hunkPair struct {
+	target, produced int
+	sim              float64
+	sameFile         bool
+}
+
+// hunkAlignment is the result of matching target hunks to produced hunks.
+// matches maps a target hunk index to its produced hunk index.
+type hunkAlignment struct {
+	score   float64
+	sims    map[int]float64
+	matches map[int]int
+}
+
+// alignHunks matches target and produced hunks one to one, greedily taking
+// the most similar remaining pair. The score weighs each matched pair's
+// similarity by the lines of both hunks over all hunk lines, so missing and
+// extra hunks lower it.
+func alignHunks(target, produced []hunk) hunkAlignment {
+	out := hunkAlignment{sims: map[int]float64{}, matches: map[int]int{}}
+	total := 0.0
+	for _, h := range target {
+		total += h.weightedSize()
+	}
+	for _, h := range produced {
+		total += h.weightedSize()
+	}
+	if total == 0 {
+		out.score = 1
+		return out
+	}
+
+	sameFileOnly := len(target)*len(produced) > maxHunkPairs
+	var pairs []hunkPair
+	for i, t := range target {
+		for j, p := range produced {
+			if sameFileOnly && t.file != p.file {
+				continue
+			}
+			sim := weightedJaccard(t.lines, p.lines)
+			if sim == 0 {
+				continue
+			}
+			pairs = append(pairs, hunkPair{target: i, produced: j, sim: sim, sameFile: t.file == p.file})
+		}
+	}
+	sort.SliceStable(pairs, func(a, b int) bool {
+		if pairs[a].sim != pairs[b].sim {
+			return pairs[a].sim > pairs[b].sim
+		}
+		// Prefer the same file on ties so identical hunks stay in place.
+		if pairs[a].sameFile != pairs[b].sameFile {
+			return pairs[a].sameFile
+		}
+		if pairs[a].target != pairs[b].target {
+			return pairs[a].target < pairs[b].target
+		}
+		return pairs[a].produced < pairs[b].produced
+	})
+
+	usedProduced := map[int]bool{}
+	matched := 0.0
+	for _, p := range pairs {
+		if _, ok := out.matches[p.target]; ok || usedProduced[p.produced] {
+			continue
+		}
+		out.matches[p.target] = p.produced
+		out.sims[p.target] = p.sim
+		usedProduced[p.produced] = true
+		matched += p.sim * (target[p.target].weightedSize() + produced[p.produced].weightedSize())
+	}
+	out.score = clamp01(matched / total)
+	return out
+}
+
+// hunkScores returns the alignment of every target hunk of file, in patch
+// order.
+func (a hunkAlignment) hunkScores(file string, target, produced []hunk) []HunkScore {
+	var out []HunkScore
+	for i, t := range target {
+		if t.file != file {
+			continue
+		}
+		s := HunkScore{Header: t.header}
+		if j, ok := a.matches[i]; ok {
+			s.Similarity = a.sims[i]
+			s.MatchedPath = produced[j].file
+			s.MatchedHeader = produced[j].header
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// unmatchedHunks counts the produced hunks of file no target hunk matched.
+func (a hunkAlignment) unmatchedHunks(file string, produced []hunk) int {
+	used := map[int]bool{}
+	for _, j := range a.matches {
+		used[j] = true
+	}
+	n := 0
+	for j, p := range produced {
+		if p.file == file && !used[j] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package scoring
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PathWeight scales the lines of files matching Pattern.
+type PathWeight struct {
+	Pattern string  `json:"pattern"`
+	Weight  float64 `json:"weight"`
+}
+
+// MatchPath matches a slash-separated path against a glob, tried on the full
+// path and on the base name. A pattern ending in a slash matches every file
+// under a directory of that name, like vendor/.
+func MatchPath(pattern, p string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/"); ok && dir != "" {
+		return strings.HasPrefix(p, dir+"/") || strings.Contains(p, "/"+dir+"/")
+	}
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, path.Base(p))
+	return ok
+}
+
+// ParsePathWeights parses comma-separated pattern=weight pairs, like
+// "docs/=0.2,*_test.go=0.5". Weights must be between 0 and 10.
+func ParsePathWeights(s string) ([]PathWeight, error) {
+	var out []PathWeight
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not pattern=weight", item)
+		}
+		pattern := strings.TrimSpace(item[:i])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q", pattern)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(item[i+1:]), 64)
+		if err != nil || w < 0 || w > 10 {
+			return nil, fmt.Errorf("weight of %q must be a number between 0 and 10", pattern)
+		}
+		out = append(out, PathWeight{Pattern: pattern, Weight: w})
+	}
+	return out, nil
+}
+
+func mergeSorted(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, list := range [][]string{a, b} {
+		for _, s := range list {
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
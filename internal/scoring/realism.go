@@ -3,6 +3,7 @@ package scoring
 import (
 	"math"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -10,13 +11,21 @@ type RealismConfig struct {
 	MaxPathRefs    int
 	MaxIdentifiers int
 	MaxLength      int
+	Language       string
+	// Profile replaces the rubric's limits and weights, including
+	// MaxPathRefs and MaxIdentifiers; nil uses the built-in ones.
+	Profile *RealismProfile
 }
 
 type RealismResult struct {
-	HeuristicScore float64  `json:"heuristicScore"`
-	JudgeScore     float64  `json:"judgeScore"`
-	Score          float64  `json:"score"`
-	Reasons        []string `json:"reasons"`
+	HeuristicScore float64 `json:"heuristicScore"`
+	JudgeScore     float64 `json:"judgeScore"`
+	// JudgeSamples are the individual judge scores when the judge is
+	// sampled more than once; JudgeScore is their mean.
+	JudgeSamples []float64 `json:"judgeSamples,omitempty"`
+	JudgeStdDev  float64   `json:"judgeStdDev,omitempty"`
+	Score        float64   `json:"score"`
+	Reasons      []string  `json:"reasons"`
 }
 
 var (
@@ -26,98 +35,227 @@ var (
 	bulletRe     = regexp.MustCompile(`(?m)^\s*(?:[-*]|\d+\.)\s+`)
 )
 
+const DefaultLanguage = "en"
+
+type realismKeywords struct {
+	steps       []string
+	problem     []string
+	behavior    []string
+	constraints []string
+	acceptance  []string
+}
+
+var realismKeywordsByLanguage = map[string]realismKeywords{
+	"en": {
+		steps:       []string{"then", "after that", "step", "next,"},
+		problem:     []string{"problem", "motivation", "currently", "pain point", "context"},
+		behavior:    []string{"should", "must", "expected", "behavior", "outcome"},
+		constraints: []string{"non-goal", "out of scope", "do not", "avoid"},
+		acceptance:  []string{"acceptance", "test", "verify", "pass"},
+	},
+	"es": {
+		steps:       []string{"luego", "después de eso", "paso", "a continuación"},
+		problem:     []string{"problema", "motivación", "actualmente", "contexto"},
+		behavior:    []string{"debe", "debería", "esperado", "comportamiento", "resultado"},
+		constraints: []string{"fuera de alcance", "no debe", "evitar", "no objetivo"},
+		acceptance:  []string{"aceptación", "prueba", "verificar", "test"},
+	},
+	"fr": {
+		steps:       []string{"ensuite", "après cela", "étape", "puis"},
+		problem:     []string{"problème", "motivation", "actuellement", "contexte"},
+		behavior:    []string{"doit", "devrait", "attendu", "comportement", "résultat"},
+		constraints: []string{"hors périmètre", "ne pas", "éviter", "non-objectif"},
+		acceptance:  []string{"acceptation", "test", "vérifier", "valider"},
+	},
+	"de": {
+		steps:       []string{"dann", "danach", "schritt", "anschließend"},
+		problem:     []string{"problem", "motivation", "derzeit", "aktuell", "kontext"},
+		behavior:    []string{"soll", "muss", "erwartet", "verhalten", "ergebnis"},
+		constraints: []string{"nicht im umfang", "nicht ziel", "vermeiden", "keine"},
+		acceptance:  []string{"akzeptanz", "test", "prüfen", "verifizieren"},
+	},
+	"pt": {
+		steps:       []string{"depois", "em seguida", "passo", "então"},
+		problem:     []string{"problema", "motivação", "atualmente", "contexto"},
+		behavior:    []string{"deve", "deveria", "esperado", "comportamento", "resultado"},
+		constraints: []string{"fora do escopo", "não deve", "evitar", "não objetivo"},
+		acceptance:  []string{"aceitação", "teste", "verificar", "validar"},
+	},
+	"it": {
+		steps:       []string{"poi", "dopo di che", "passo", "successivamente"},
+		problem:     []string{"problema", "motivazione", "attualmente", "contesto"},
+		behavior:    []string{"deve", "dovrebbe", "atteso", "comportamento", "risultato"},
+		constraints: []string{"fuori ambito", "non deve", "evitare", "non obiettivo"},
+		acceptance:  []string{"accettazione", "test", "verificare", "validare"},
+	},
+}
+
+func SupportedLanguages() []string {
+	out := make([]string, 0, len(realismKeywordsByLanguage))
+	for lang := range realismKeywordsByLanguage {
+		out = append(out, lang)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func IsSupportedLanguage(lang string) bool {
+	_, ok := realismKeywordsByLanguage[normalizeLanguage(lang)]
+	return ok
+}
+
+func normalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return DefaultLanguage
+	}
+	return lang
+}
+
+func keywordsFor(lang string) realismKeywords {
+	if kw, ok := realismKeywordsByLanguage[normalizeLanguage(lang)]; ok {
+		return kw
+	}
+	return realismKeywordsByLanguage[DefaultLanguage]
+}
+
 func ScoreRealismHeuristic(prompt string, cfg RealismConfig) RealismResult {
+	score, reasons, _ := realismRubric(prompt, cfg)
+	return RealismResult{
+		HeuristicScore: score,
+		Reasons:        reasons,
+	}
+}
+
+// RubricCheck is one rule of the heuristic realism rubric applied to a
+// prompt, with the amount it added to or removed from the base score.
+type RubricCheck struct {
+	Rule   string  `json:"rule"`
+	Value  int     `json:"value"`
+	Delta  float64 `json:"delta"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// ExplainRealism returns the rubric checks behind ScoreRealismHeuristic.
+func ExplainRealism(prompt string, cfg RealismConfig) []RubricCheck {
+	_, _, checks := realismRubric(prompt, cfg)
+	return checks
+}
+
+// RealismBase is the heuristic realism score before any rubric check.
+const RealismBase = 0.55
+
+func realismRubric(prompt string, cfg RealismConfig) (float64, []string, []RubricCheck) {
 	text := strings.TrimSpace(prompt)
 	if text == "" {
-		return RealismResult{HeuristicScore: 0}
+		return 0, nil, nil
 	}
 
-	score := 0.55
+	score := RealismBase
 	reasons := make([]string, 0, 8)
+	var checks []RubricCheck
+	check := func(rule string, value int, delta float64, reason string) {
+		score += delta
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		checks = append(checks, RubricCheck{Rule: rule, Value: value, Delta: delta, Reason: reason})
+	}
+	kw := keywordsFor(cfg.Language)
+	lower := strings.ToLower(text)
+	p := cfg.profile()
 
 	length := len(text)
 	if cfg.MaxLength > 0 {
 		if length <= cfg.MaxLength {
-			score += 0.08
+			check("length", length, 0.08, "")
 		} else {
 			over := float64(length-cfg.MaxLength) / float64(maxInt(1, cfg.MaxLength))
-			pen := math.Min(0.25, over*0.35)
-			score -= pen
-			reasons = append(reasons, "prompt is overly long and likely too prescriptive")
+			check("length", length, -math.Min(0.25, over*0.35), "prompt is overly long and likely too prescriptive")
 		}
 	} else {
-		if length <= 2600 {
-			score += 0.03
+		if length <= p.LengthLimit {
+			check("length", length, 0.03, "")
 		} else {
-			over := float64(length-2600) / 2600.0
-			pen := math.Min(0.20, over*0.25)
-			score -= pen
-			reasons = append(reasons, "prompt is very long and may become too prescriptive")
+			over := float64(length-p.LengthLimit) / float64(maxInt(1, p.LengthLimit))
+			check("length", length, -math.Min(0.20, over*0.25), "prompt is very long and may become too prescriptive")
 		}
 	}
 
 	pathRefs := countPathRefs(text)
-	if pathRefs > cfg.MaxPathRefs {
-		score -= math.Min(0.25, float64(pathRefs-cfg.MaxPathRefs)*0.07)
-		reasons = append(reasons, "too many file path references make it look diff-driven")
+	if pathRefs > p.MaxPathRefs {
+		check("path references", pathRefs, -math.Min(0.25, float64(pathRefs-p.MaxPathRefs)*0.07), "too many file path references make it look diff-driven")
 	} else if pathRefs > 0 {
-		score += 0.02
+		check("path references", pathRefs, 0.02, "")
 	}
 
 	identifierCount := countLikelyIdentifiers(text)
-	if identifierCount > cfg.MaxIdentifiers {
-		score -= math.Min(0.25, float64(identifierCount-cfg.MaxIdentifiers)*0.02)
-		reasons = append(reasons, "identifier density is high for a high-level specification")
+	if identifierCount > p.MaxIdentifiers {
+		check("identifiers", identifierCount, -math.Min(0.25, float64(identifierCount-p.MaxIdentifiers)*0.02), "identifier density is high for a high-level specification")
 	} else {
-		score += 0.04
+		check("identifiers", identifierCount, 0.04, "")
 	}
 
 	numericCount := len(numericRe.FindAllString(text, -1))
-	if numericCount > 12 {
-		score -= 0.12
-		reasons = append(reasons, "too many exact constants can indicate overfitting")
+	if numericCount > p.MaxNumbers {
+		check("numeric constants", numericCount, -0.12, "too many exact constants can indicate overfitting")
 	}
 
 	bullets := len(bulletRe.FindAllString(text, -1))
-	if bullets > 10 {
-		score -= math.Min(0.20, float64(bullets-10)*0.02)
-		reasons = append(reasons, "excessive checklists can encode micro-diffs")
+	if bullets > p.MaxBullets {
+		check("bullets", bullets, -math.Min(0.20, float64(bullets-p.MaxBullets)*0.02), "excessive checklists can encode micro-diffs")
 	}
 
-	stepWords := keywordCount(strings.ToLower(text), []string{"then", "after that", "step", "next,"})
-	if stepWords > 5 {
-		score -= math.Min(0.15, float64(stepWords-5)*0.03)
-		reasons = append(reasons, "instruction sequence is too low-level")
+	stepWords := keywordCount(lower, kw.steps)
+	if stepWords > p.MaxStepWords {
+		check("step words", stepWords, -math.Min(0.15, float64(stepWords-p.MaxStepWords)*0.03), "instruction sequence is too low-level")
 	}
 
-	if hasAny(strings.ToLower(text), []string{"problem", "motivation", "currently", "pain point", "context"}) {
-		score += 0.06
-	} else {
-		reasons = append(reasons, "missing clear problem statement/motivation")
+	for _, k := range []struct {
+		rule     string
+		keywords []string
+		delta    float64
+		reason   string
+	}{
+		{ruleProblem, kw.problem, p.KeywordWeights[ruleProblem], "missing clear problem statement/motivation"},
+		{ruleBehavior, kw.behavior, p.KeywordWeights[ruleBehavior], "desired behavior is not explicit enough"},
+		{ruleConstraints, kw.constraints, p.KeywordWeights[ruleConstraints], "constraints or non-goals are missing"},
+		{ruleAcceptance, kw.acceptance, p.KeywordWeights[ruleAcceptance], "acceptance criteria or test expectations are missing"},
+	} {
+		if n := keywordCount(lower, k.keywords); n > 0 {
+			check(k.rule, n, k.delta, "")
+		} else {
+			check(k.rule, 0, 0, k.reason)
+		}
 	}
 
-	if hasAny(strings.ToLower(text), []string{"should", "must", "expected", "behavior", "outcome"}) {
-		score += 0.06
-	} else {
-		reasons = append(reasons, "desired behavior is not explicit enough")
-	}
+	return clamp01(score), reasons, checks
+}
 
-	if hasAny(strings.ToLower(text), []string{"non-goal", "out of scope", "do not", "avoid"}) {
-		score += 0.07
-	} else {
-		reasons = append(reasons, "constraints or non-goals are missing")
+// SetJudgeSamples records the scores of a sampled judge: JudgeScore becomes
+// their mean and JudgeStdDev their standard deviation. A single score is
+// stored as a plain JudgeScore.
+func (r *RealismResult) SetJudgeSamples(scores []float64) {
+	r.JudgeSamples, r.JudgeStdDev = nil, 0
+	if len(scores) == 0 {
+		r.JudgeScore = 0
+		return
 	}
-
-	if hasAny(strings.ToLower(text), []string{"acceptance", "test", "verify", "pass"}) {
-		score += 0.07
-	} else {
-		reasons = append(reasons, "acceptance criteria or test expectations are missing")
+	mean := 0.0
+	for _, s := range scores {
+		mean += s
 	}
-
-	return RealismResult{
-		HeuristicScore: clamp01(score),
-		Reasons:        reasons,
+	mean /= float64(len(scores))
+	r.JudgeScore = mean
+	if len(scores) == 1 {
+		return
+	}
+	variance := 0.0
+	for _, s := range scores {
+		variance += (s - mean) * (s - mean)
 	}
+	r.JudgeSamples = append([]float64(nil), scores...)
+	r.JudgeStdDev = math.Sqrt(variance / float64(len(scores)))
 }
 
 func CombineRealism(heuristic, judge float64, hasJudge bool) float64 {
@@ -166,15 +304,6 @@ func looksLikeIdentifier(tok string) bool {
 	return false
 }
 
-func hasAny(s string, keywords []string) bool {
-	for _, kw := range keywords {
-		if strings.Contains(s, kw) {
-			return true
-		}
-	}
-	return false
-}
-
 func keywordCount(s string, keywords []string) int {
 	total := 0
 	for _, kw := range keywords {
@@ -0,0 +1,112 @@
+package scoring
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/retrospec/internal/git"
+)
+
+// Rename matching modes. With exact, a target file only matches a produced
+// file of the same path. Otherwise a target and a produced file coming from
+// the same original file, through a rename on either side, are paired: with
+// source the pair counts as one file, and with path it counts by how similar
+// the two paths are.
+const (
+	RenameMatchExact  = "exact"
+	RenameMatchSource = "source"
+	RenameMatchPath   = "path"
+)
+
+// RenamePair is a target file matched with a produced file of another path
+// because both come from Source.
+type RenamePair struct {
+	Target   string  `json:"target"`
+	Produced string  `json:"produced"`
+	Source   string  `json:"source"`
+	Credit   float64 `json:"credit"`
+}
+
+// pairRenames pairs the target and produced files missing from the other
+// side that share an original path.
+func pairRenames(target, produced git.DiffSnapshot, mode string) []RenamePair {
+	if mode == RenameMatchExact {
+		return nil
+	}
+	producedSet := toSet(produced.ChangedFiles)
+	targetSet := toSet(target.ChangedFiles)
+	bySource := map[string][]string{}
+	for _, p := range produced.ChangedFiles {
+		if _, ok := targetSet[p]; !ok {
+			src := sourcePath(produced, p)
+			bySource[src] = append(bySource[src], p)
+		}
+	}
+
+	used := map[string]bool{}
+	var out []RenamePair
+	for _, t := range target.ChangedFiles {
+		if _, ok := producedSet[t]; ok {
+			continue
+		}
+		src := sourcePath(target, t)
+		best := RenamePair{}
+		for _, p := range bySource[src] {
+			if used[p] {
+				continue
+			}
+			credit := 1.0
+			if mode == RenameMatchPath {
+				credit = PathSimilarity(t, p)
+			}
+			if best.Produced == "" || credit > best.Credit {
+				best = RenamePair{Target: t, Produced: p, Source: src, Credit: credit}
+			}
+		}
+		if best.Produced != "" {
+			used[best.Produced] = true
+			out = append(out, best)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+func sourcePath(s git.DiffSnapshot, p string) string {
+	if old, ok := s.Renames[p]; ok {
+		return old
+	}
+	return p
+}
+
+// PathSimilarity is the Jaccard similarity of the directory and name parts
+// of two slash-separated paths, so internal/a/util.go and internal/b/util.go
+// score 0.6.
+func PathSimilarity(a, b string) float64 {
+	return jaccardSet(toSet(pathTokens(a)), toSet(pathTokens(b)))
+}
+
+func pathTokens(p string) []string {
+	return strings.FieldsFunc(strings.ToLower(p), func(r rune) bool {
+		return r == '/' || r == '.' || r == '_' || r == '-'
+	})
+}
+
+// renameJaccard is the file Jaccard with rename pairs counted as one file
+// each, matched by their credit.
+func renameJaccard(targetSet, producedSet map[string]struct{}, pairs []RenamePair) float64 {
+	if len(targetSet) == 0 && len(producedSet) == 0 {
+		return 1
+	}
+	inter := 0.0
+	for k := range targetSet {
+		if _, ok := producedSet[k]; ok {
+			inter++
+		}
+	}
+	union := float64(len(targetSet)+len(producedSet)) - inter - float64(len(pairs))
+	for _, p := range pairs {
+		inter += p.Credit
+	}
+	return safeDiv(inter, union)
+}
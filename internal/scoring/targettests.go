@@ -0,0 +1,49 @@
+package scoring
+
+// TargetTestBlendWeight is the share of the technical score taken by the
+// target commit's own tests run against the produced code. Passing them
+// shows the change behaves like the target, however its text differs.
+const TargetTestBlendWeight = 0.4
+
+// TargetTestScore is the outcome of the test files the target commit added
+// or changed, applied onto the produced worktree.
+type TargetTestScore struct {
+	Files    []string `json:"files"`
+	Category string   `json:"category"`
+	// Passed and Failed count test cases when the runner reports them.
+	Passed int     `json:"passed,omitempty"`
+	Failed int     `json:"failed,omitempty"`
+	Score  float64 `json:"score"`
+}
+
+// targetTestFailureCredit is the score of failing tests with no case counts:
+// they still build against the produced code, unlike a compilation failure.
+const targetTestFailureCredit = 0.25
+
+// ScoreTargetTests scores a run of the target's tests: 1 when they pass, the
+// share of passing cases when they fail with counts, and 0 when they do not
+// build or time out.
+func ScoreTargetTests(files []string, category string, passed, failed int) TargetTestScore {
+	out := TargetTestScore{Files: files, Category: category, Passed: passed, Failed: failed}
+	switch category {
+	case "pass":
+		out.Score = 1
+	case "compilation", "timeout":
+		out.Score = 0
+	default:
+		if passed+failed > 0 {
+			out.Score = safeDiv(float64(passed), float64(passed+failed))
+		} else {
+			out.Score = targetTestFailureCredit
+		}
+	}
+	return out
+}
+
+// BlendTargetTests folds the target's test outcome into a technical score.
+func BlendTargetTests(tech TechScore, t TargetTestScore) TechScore {
+	tech.BaseScore = tech.Score
+	tech.TargetTests = &t
+	tech.Score = clamp01((1-TargetTestBlendWeight)*tech.Score + TargetTestBlendWeight*t.Score)
+	return tech
+}
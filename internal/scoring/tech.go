@@ -8,6 +8,10 @@ import (
 	"github.com/igolaizola/retrospec/internal/git"
 )
 
+// MaxPerFileScores caps TechScore.PerFile. Target files and the largest
+// produced changes are kept when a coder touches more files.
+const MaxPerFileScores = 200
+
 type PerFileScore struct {
 	Path                 string  `json:"path"`
 	Similarity           float64 `json:"similarity"`
@@ -15,60 +19,153 @@ type PerFileScore struct {
 	TargetLinesRemoved   int     `json:"targetLinesRemoved"`
 	ProducedLinesAdded   int     `json:"producedLinesAdded"`
 	ProducedLinesRemoved int     `json:"producedLinesRemoved"`
+	// MatchedPath is the produced file a target file was paired with by
+	// rename matching; Similarity then compares the two.
+	MatchedPath string `json:"matchedPath,omitempty"`
+	// Hunks are the target hunks of the file with their best produced
+	// match, and UnmatchedHunks counts produced hunks left without one.
+	Hunks          []HunkScore `json:"hunks,omitempty"`
+	UnmatchedHunks int         `json:"unmatchedHunks,omitempty"`
+	// TargetMode and ProducedMode describe file mode changes, like
+	// "100644 => 100755".
+	TargetMode   string `json:"targetMode,omitempty"`
+	ProducedMode string `json:"producedMode,omitempty"`
 }
 
 type TechScore struct {
-	FileJaccard       float64        `json:"fileJaccard"`
-	DiffSimilarity    float64        `json:"diffSimilarity"`
-	LinePrecision     float64        `json:"linePrecision"`
-	LineRecall        float64        `json:"lineRecall"`
-	LineF1            float64        `json:"lineF1"`
-	Score             float64        `json:"score"`
-	PerFile           []PerFileScore `json:"perFile"`
-	TargetFiles       int            `json:"targetFiles"`
-	ProducedFiles     int            `json:"producedFiles"`
-	TargetTotalAdds   int            `json:"targetTotalAdds"`
-	TargetTotalDels   int            `json:"targetTotalDels"`
-	ProducedTotalAdds int            `json:"producedTotalAdds"`
-	ProducedTotalDels int            `json:"producedTotalDels"`
+	FileJaccard    float64 `json:"fileJaccard"`
+	DiffSimilarity float64 `json:"diffSimilarity"`
+	// HunkSimilarity is the size-weighted similarity of target hunks
+	// aligned one to one with produced hunks.
+	HunkSimilarity float64        `json:"hunkSimilarity"`
+	LinePrecision  float64        `json:"linePrecision"`
+	LineRecall     float64        `json:"lineRecall"`
+	LineF1         float64        `json:"lineF1"`
+	Score          float64        `json:"score"`
+	PerFile        []PerFileScore `json:"perFile"`
+	// PerFileOmitted counts files left out of PerFile by MaxPerFileScores.
+	PerFileOmitted int `json:"perFileOmitted,omitempty"`
+	// RenamePairs are the target and produced files of different paths
+	// matched as the same file.
+	RenamePairs []RenamePair `json:"renamePairs,omitempty"`
+	// IgnoredFiles are the files of either patch left out by
+	// TechConfig.Ignore.
+	IgnoredFiles      []string `json:"ignoredFiles,omitempty"`
+	TargetFiles       int      `json:"targetFiles"`
+	ProducedFiles     int      `json:"producedFiles"`
+	TargetTotalAdds   int      `json:"targetTotalAdds"`
+	TargetTotalDels   int      `json:"targetTotalDels"`
+	ProducedTotalAdds int      `json:"producedTotalAdds"`
+	ProducedTotalDels int      `json:"producedTotalDels"`
+	// LineScore is the line-based score before GoAST was blended in.
+	LineScore float64     `json:"lineScore,omitempty"`
+	GoAST     *GoASTScore `json:"goAst,omitempty"`
+	// BaseScore is the score before the target's tests were blended in.
+	BaseScore   float64          `json:"baseScore,omitempty"`
+	TargetTests *TargetTestScore `json:"targetTests,omitempty"`
+}
+
+// TechConfig tunes technical similarity. An empty RenameMatch uses
+// RenameMatchPath.
+type TechConfig struct {
+	RenameMatch string
+	// Ignore are path patterns of files left out of scoring entirely.
+	Ignore []string
+	// Weights scale the lines of matching files in the diff similarity,
+	// line F1, and hunk alignment; the first matching pattern applies and
+	// other files weigh 1.
+	Weights []PathWeight
+}
+
+func (c TechConfig) ignored(p string) bool {
+	for _, pattern := range c.Ignore {
+		if MatchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// weight returns the line weight of a file, 0 when it is ignored.
+func (c TechConfig) weight(p string) float64 {
+	if c.ignored(p) {
+		return 0
+	}
+	for _, w := range c.Weights {
+		if MatchPath(w.Pattern, p) {
+			return w.Weight
+		}
+	}
+	return 1
+}
+
+// filter drops the ignored files of a snapshot and returns them.
+func (c TechConfig) filter(s git.DiffSnapshot) (git.DiffSnapshot, []string) {
+	if len(c.Ignore) == 0 {
+		return s, nil
+	}
+	var kept, ignored []string
+	for _, p := range s.ChangedFiles {
+		if c.ignored(p) {
+			ignored = append(ignored, p)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	s.ChangedFiles = kept
+	return s, ignored
 }
 
 type parsedPatch struct {
 	fileLines map[string]map[string]int
-	global    map[string]int
+	// global holds the weighted counts of the lines of all files.
+	global map[string]float64
+	hunks  []hunk
 }
 
-func ScoreTechSimilarity(target, produced git.DiffSnapshot) TechScore {
+func ScoreTechSimilarity(target, produced git.DiffSnapshot, cfg TechConfig) TechScore {
+	target, targetIgnored := cfg.filter(target)
+	produced, producedIgnored := cfg.filter(produced)
 	targetSet := toSet(target.ChangedFiles)
 	producedSet := toSet(produced.ChangedFiles)
-	fileJaccard := jaccardSet(targetSet, producedSet)
+	mode := cfg.RenameMatch
+	if mode == "" {
+		mode = RenameMatchPath
+	}
+	renames := pairRenames(target, produced, mode)
+	fileJaccard := renameJaccard(targetSet, producedSet, renames)
 
-	targetParsed := parseUnifiedDiff(target.Patch)
-	producedParsed := parseUnifiedDiff(produced.Patch)
+	targetParsed := parseUnifiedDiff(target.Patch, cfg)
+	producedParsed := parseUnifiedDiff(produced.Patch, cfg)
 	diffSimilarity := weightedJaccard(targetParsed.global, producedParsed.global)
 
 	tp := multisetIntersectionCount(targetParsed.global, producedParsed.global)
 	targetN := multisetCount(targetParsed.global)
 	producedN := multisetCount(producedParsed.global)
-	precision := safeDiv(float64(tp), float64(producedN))
-	recall := safeDiv(float64(tp), float64(targetN))
+	precision := safeDiv(tp, producedN)
+	recall := safeDiv(tp, targetN)
 	f1 := safeDiv(2*precision*recall, precision+recall)
 
-	perFile := buildPerFileScores(target, produced, targetParsed, producedParsed)
+	hunks := alignHunks(targetParsed.hunks, producedParsed.hunks)
+	perFile, omitted := buildPerFileScores(target, produced, targetParsed, producedParsed, hunks, renames)
 
 	tAdds, tDels := totalAddsRemoves(target.FileStats)
 	pAdds, pDels := totalAddsRemoves(produced.FileStats)
 
-	final := clamp01(0.4*fileJaccard + 0.45*diffSimilarity + 0.15*f1)
+	final := clamp01(0.4*fileJaccard + 0.3*diffSimilarity + 0.15*hunks.score + 0.15*f1)
 
 	return TechScore{
 		FileJaccard:       fileJaccard,
 		DiffSimilarity:    diffSimilarity,
+		HunkSimilarity:    hunks.score,
 		LinePrecision:     precision,
 		LineRecall:        recall,
 		LineF1:            f1,
 		Score:             final,
 		PerFile:           perFile,
+		PerFileOmitted:    omitted,
+		RenamePairs:       renames,
+		IgnoredFiles:      mergeSorted(targetIgnored, producedIgnored),
 		TargetFiles:       len(targetSet),
 		ProducedFiles:     len(producedSet),
 		TargetTotalAdds:   tAdds,
@@ -78,7 +175,11 @@ func ScoreTechSimilarity(target, produced git.DiffSnapshot) TechScore {
 	}
 }
 
-func buildPerFileScores(target, produced git.DiffSnapshot, targetParsed, producedParsed parsedPatch) []PerFileScore {
+func buildPerFileScores(target, produced git.DiffSnapshot, targetParsed, producedParsed parsedPatch, hunks hunkAlignment, renames []RenamePair) ([]PerFileScore, int) {
+	matched := map[string]string{}
+	for _, r := range renames {
+		matched[r.Target] = r.Produced
+	}
 	pathsSet := map[string]struct{}{}
 	for _, p := range target.ChangedFiles {
 		pathsSet[p] = struct{}{}
@@ -91,12 +192,36 @@ func buildPerFileScores(target, produced git.DiffSnapshot, targetParsed, produce
 	for p := range pathsSet {
 		paths = append(paths, p)
 	}
+	omitted := 0
+	if len(paths) > MaxPerFileScores {
+		targetSet := toSet(target.ChangedFiles)
+		size := func(p string) int {
+			t, pr := target.FileStats[p], produced.FileStats[p]
+			return t.Added + t.Removed + pr.Added + pr.Removed
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			_, ti := targetSet[paths[i]]
+			_, tj := targetSet[paths[j]]
+			if ti != tj {
+				return ti
+			}
+			if si, sj := size(paths[i]), size(paths[j]); si != sj {
+				return si > sj
+			}
+			return paths[i] < paths[j]
+		})
+		omitted = len(paths) - MaxPerFileScores
+		paths = paths[:MaxPerFileScores]
+	}
 	sort.Strings(paths)
 
 	out := make([]PerFileScore, 0, len(paths))
 	for _, p := range paths {
 		tLines := targetParsed.fileLines[p]
 		pLines := producedParsed.fileLines[p]
+		if m, ok := matched[p]; ok {
+			pLines = producedParsed.fileLines[m]
+		}
 		sim := weightedJaccard(tLines, pLines)
 		t := target.FileStats[p]
 		pr := produced.FileStats[p]
@@ -107,53 +232,124 @@ func buildPerFileScores(target, produced git.DiffSnapshot, targetParsed, produce
 			TargetLinesRemoved:   t.Removed,
 			ProducedLinesAdded:   pr.Added,
 			ProducedLinesRemoved: pr.Removed,
+			MatchedPath:          matched[p],
+			Hunks:                hunks.hunkScores(p, targetParsed.hunks, producedParsed.hunks),
+			UnmatchedHunks:       hunks.unmatchedHunks(p, producedParsed.hunks),
+			TargetMode:           modeString(target.ModeChanges, p),
+			ProducedMode:         modeString(produced.ModeChanges, p),
 		})
 	}
-	return out
+	return out, omitted
 }
 
-func parseUnifiedDiff(patch string) parsedPatch {
+func parseUnifiedDiff(patch string, cfg TechConfig) parsedPatch {
 	result := parsedPatch{
 		fileLines: map[string]map[string]int{},
-		global:    map[string]int{},
+		global:    map[string]float64{},
 	}
 
-	current := ""
+	current, oldMode := "", ""
+	weight := 1.0
+	skip := false
+	// A symlink's content is its target, scored as one token per side
+	// rather than as a line of code.
+	oldLink, newLink := false, false
+	var cur *hunk
+	flush := func() {
+		if cur != nil && cur.size > 0 {
+			result.hunks = append(result.hunks, *cur)
+		}
+		cur = nil
+	}
 	for _, raw := range strings.Split(patch, "\n") {
 		line := strings.TrimRight(raw, "\r")
 		if strings.HasPrefix(line, "diff --git ") {
-			parts := strings.Split(line, " ")
-			if len(parts) >= 4 {
-				current = strings.TrimPrefix(parts[3], "b/")
+			flush()
+			oldMode = ""
+			oldLink, newLink = false, false
+			if current = git.DiffHeaderPath(line); current != "" {
+				weight = cfg.weight(current)
+				skip = cfg.ignored(current)
+				if skip {
+					continue
+				}
 				if _, ok := result.fileLines[current]; !ok {
 					result.fileLines[current] = map[string]int{}
 				}
 			}
 			continue
 		}
-		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "@@") {
+		if skip {
+			continue
+		}
+		if cur == nil {
+			if o, n := git.HeaderModes(line); o != "" || n != "" {
+				if o != "" {
+					oldLink = o == git.SymlinkMode
+				}
+				if n != "" {
+					newLink = n == git.SymlinkMode
+				}
+			}
+		}
+		// A mode change counts as one changed line of the file, so commits
+		// that mostly change permissions are not scored as empty.
+		if m, ok := strings.CutPrefix(line, "old mode "); ok {
+			oldMode = m
+			continue
+		}
+		if m, ok := strings.CutPrefix(line, "new mode "); ok && oldMode != "" {
+			addDiffLine(result, nil, current, "~", "mode "+git.ModeChange{Old: oldMode, New: m}.String(), weight)
+			continue
+		}
+		if strings.HasPrefix(line, "@@") && (oldLink || newLink) {
+			flush()
+			continue
+		}
+		if (oldLink || newLink) && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")) && !strings.HasPrefix(line, "+++ ") && !strings.HasPrefix(line, "--- ") {
+			addDiffLine(result, nil, current, line[:1], "symlink -> "+line[1:], weight)
+			continue
+		}
+		if strings.HasPrefix(line, "@@") {
+			flush()
+			cur = &hunk{file: current, header: line, lines: map[string]int{}, weight: weight}
+			continue
+		}
+		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
 			continue
 		}
 		if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
-			addDiffLine(result, current, "+", line[1:])
+			addDiffLine(result, cur, current, "+", line[1:], weight)
 			continue
 		}
 		if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
-			addDiffLine(result, current, "-", line[1:])
+			addDiffLine(result, cur, current, "-", line[1:], weight)
 			continue
 		}
 	}
+	flush()
 
 	return result
 }
 
-func addDiffLine(p parsedPatch, file, prefix, raw string) {
+func modeString(changes map[string]git.ModeChange, path string) string {
+	if m, ok := changes[path]; ok {
+		return m.String()
+	}
+	return ""
+}
+
+func addDiffLine(p parsedPatch, h *hunk, file, prefix, raw string, weight float64) {
 	normalized := normalizeLine(raw)
 	if normalized == "" {
 		return
 	}
 	key := prefix + normalized
-	p.global[key]++
+	p.global[key] += weight
+	if h != nil {
+		h.lines[key]++
+		h.size++
+	}
 	if file != "" {
 		if _, ok := p.fileLines[file]; !ok {
 			p.fileLines[file] = map[string]int{}
@@ -166,7 +362,7 @@ func normalizeLine(s string) string {
 	return strings.Join(strings.Fields(strings.TrimSpace(s)), " ")
 }
 
-func weightedJaccard(a, b map[string]int) float64 {
+func weightedJaccard[N int | float64](a, b map[string]N) float64 {
 	if len(a) == 0 && len(b) == 0 {
 		return 1
 	}
@@ -178,27 +374,26 @@ func weightedJaccard(a, b map[string]int) float64 {
 		unionKeys[k] = struct{}{}
 	}
 
-	var inter int
-	var uni int
+	var inter, uni N
 	for k := range unionKeys {
 		av := a[k]
 		bv := b[k]
-		inter += minInt(av, bv)
-		uni += maxInt(av, bv)
+		inter += min(av, bv)
+		uni += max(av, bv)
 	}
 	return safeDiv(float64(inter), float64(uni))
 }
 
-func multisetIntersectionCount(a, b map[string]int) int {
-	var n int
+func multisetIntersectionCount(a, b map[string]float64) float64 {
+	var n float64
 	for k, av := range a {
-		n += minInt(av, b[k])
+		n += min(av, b[k])
 	}
 	return n
 }
 
-func multisetCount(a map[string]int) int {
-	var n int
+func multisetCount[N int | float64](a map[string]N) N {
+	var n N
 	for _, v := range a {
 		n += v
 	}
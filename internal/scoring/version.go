@@ -0,0 +1,7 @@
+package scoring
+
+// Version identifies the scoring algorithms. Bump it whenever a change to
+// technical similarity, realism, or how they are combined changes scores, so
+// that runs scored by different versions are not compared directly. Runs
+// from before versioning report 0.
+const Version = 6
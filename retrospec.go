@@ -0,0 +1,127 @@
+// Package retrospec embeds the prompt optimization loop of the retrospec
+// command in other Go programs.
+//
+// A run needs a repository and a target commit:
+//
+//	cfg := retrospec.DefaultConfig()
+//	cfg.Repo = "https://github.com/owner/repo"
+//	cfg.Commit = "abc123"
+//	cfg.EventSinks = []retrospec.EventSink{retrospec.EventFunc(func(e retrospec.Event) {
+//		fmt.Println(e.Type, e.Iteration, e.BestFinal)
+//	})}
+//	runner, err := retrospec.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := runner.Execute(ctx)
+//
+// Artifacts are written to the workdir exactly as with the command.
+package retrospec
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/igolaizola/retrospec/internal/git"
+	"github.com/igolaizola/retrospec/internal/llm"
+	"github.com/igolaizola/retrospec/internal/run"
+	"github.com/igolaizola/retrospec/internal/scoring"
+)
+
+type (
+	Config        = run.Config
+	Runner        = run.Runner
+	Result        = run.Result
+	ScoreResult   = run.ScoreResult
+	RescoreLog    = run.RescoreLog
+	RerunLog      = run.RerunLog
+	Event         = run.Event
+	EventSink     = run.EventSink
+	EventFunc     = run.EventFunc
+	ArtifactSink  = run.ArtifactSink
+	DirSink       = run.DirSink
+	MemorySink    = run.MemorySink
+	StopCondition = run.StopCondition
+	StopState     = run.StopState
+	StopFunc      = run.StopFunc
+	ErrorCategory = run.ErrorCategory
+	Error         = run.Error
+)
+
+// Provider middleware, applied to every spec, judge, and gap call through
+// Config.Middleware.
+type (
+	Middleware   = llm.Middleware
+	ChatProvider = llm.ChatProvider
+	ChatFunc     = llm.ChatFunc
+	Role         = llm.Role
+)
+
+type (
+	TechScore     = scoring.TechScore
+	PerFileScore  = scoring.PerFileScore
+	RealismResult = scoring.RealismResult
+)
+
+// ScoringVersion is the version of the scoring formulas stamped into run
+// artifacts.
+const ScoringVersion = scoring.Version
+
+const (
+	EventRunStart       = run.EventRunStart
+	EventIterationStart = run.EventIterationStart
+	EventCandidate      = run.EventCandidate
+	EventCandidates     = run.EventCandidates
+	EventReview         = run.EventReview
+	EventCoderStart     = run.EventCoderStart
+	EventCoderTool      = run.EventCoderTool
+	EventCoderDone      = run.EventCoderDone
+	EventAttempt        = run.EventAttempt
+	EventBest           = run.EventBest
+	EventIterationEnd   = run.EventIterationEnd
+	EventControl        = run.EventControl
+	EventRunEnd         = run.EventRunEnd
+)
+
+const (
+	ErrorGit                  = run.ErrorGit
+	ErrorProvider             = run.ErrorProvider
+	ErrorValidationExhaustion = run.ErrorValidationExhaustion
+	ErrorBudgetExhausted      = run.ErrorBudgetExhausted
+	ErrorArtifact             = run.ErrorArtifact
+	ErrorConfig               = run.ErrorConfig
+	ErrorIsolation            = run.ErrorIsolation
+	ErrorCanceled             = run.ErrorCanceled
+	ErrorInternal             = run.ErrorInternal
+	ErrorUnknown              = run.ErrorUnknown
+)
+
+// DefaultConfig returns the defaults of the command-line flags.
+func DefaultConfig() Config {
+	return run.DefaultConfig()
+}
+
+// New validates cfg and returns a runner for it. The workdir is made
+// absolute, as the command does.
+func New(cfg Config) (*Runner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, &Error{Category: ErrorConfig, Err: err}
+	}
+	workdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		return nil, &Error{Category: ErrorConfig, Err: fmt.Errorf("resolve workdir: %w", err)}
+	}
+	cfg.Workdir = workdir
+	return run.NewRunner(cfg), nil
+}
+
+// ErrorCategoryOf returns why a run failed.
+func ErrorCategoryOf(err error) ErrorCategory {
+	return run.ErrorCategoryOf(err)
+}
+
+// ScoreTechSimilarity compares two unified diffs the way the loop scores
+// coder patches by default, without generated file handling.
+func ScoreTechSimilarity(targetPatch, producedPatch string) TechScore {
+	return scoring.ScoreTechSimilarity(git.ParseSnapshot(targetPatch), git.ParseSnapshot(producedPatch), scoring.TechConfig{})
+}